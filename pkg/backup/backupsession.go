@@ -32,8 +32,12 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	api_util "stash.appscode.dev/apimachinery/pkg/util"
+	"stash.appscode.dev/stash/pkg/checksum"
 	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/hooks"
+	"stash.appscode.dev/stash/pkg/progress"
 	"stash.appscode.dev/stash/pkg/status"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	core "k8s.io/api/core/v1"
@@ -224,7 +228,7 @@ func (c *BackupSessionController) startBackupProcess(backupSession *api_v1beta1.
 
 func (c *BackupSessionController) backupHost(inv invoker.BackupInvoker, targetInfo invoker.BackupTargetInfo, backupSession *api_v1beta1.BackupSession) error {
 	// If preBackup hook is specified, then execute those hooks first
-	if targetInfo.Hooks != nil && targetInfo.Hooks.PreBackup != nil {
+	if hasPreBackupHook(inv, targetInfo) {
 		err := c.executePreBackupHook(inv, targetInfo, backupSession)
 		if err != nil {
 			klog.Infof("failed to execute preBackup hook. Reason: %s", err.Error())
@@ -277,13 +281,50 @@ func (c *BackupSessionController) backup(inv invoker.BackupInvoker, targetInfo i
 		return nil, err
 	}
 
-	// init restic wrapper
-	resticWrapper, err := restic.NewResticWrapper(c.SetupOpt)
+	// init restic wrapper. the progress of the backup is reported to the BackupSession while it is running.
+	reporter := &progress.BackupReporter{
+		StashClient: c.StashClient,
+		Session:     backupSession.ObjectMeta,
+		TargetRef:   targetInfo.Target.Ref,
+		Host:        c.Host,
+		InvokerKind: inv.GetTypeMeta().Kind,
+		InvokerName: inv.GetObjectMeta().Name,
+		Metrics:     c.Metrics,
+	}
+	resticWrapper, stopProgress, err := progress.NewResticWrapper(c.SetupOpt, reporter.Report)
 	if err != nil {
 		return nil, err
 	}
+	// stop reporting the progress before the final status of the host is written
+	defer stopProgress()
 	backupOpt := util.BackupOptionsForBackupTarget(targetInfo.Target, inv.GetRetentionPolicy(), *extraOpt)
-	return resticWrapper.RunBackup(backupOpt, targetInfo.Target.Ref)
+
+	// the filesystems are frozen only while the point-in-time VolumeSnapshots are taken. restic reads the files
+	// for the whole backup, so freezing them would block the writes of the workload for as long. the annotation is
+	// rejected by the BackupConfiguration validation. this only warns about the invokers admitted before.
+	if util.IsAnnotationTrue(inv.GetObjectMeta().Annotations, util.KeyFreeze) {
+		klog.Warningf("Skipping filesystem freeze. Reason: The %q annotation is supported only for the VolumeSnapshot backups.", util.KeyFreeze)
+	}
+	_, span := tracing.Start(
+		tracing.SessionContext(backupSession.Annotations),
+		"restic backup",
+		tracing.TargetAttributes(targetInfo.Target.Ref.Kind, targetInfo.Target.Ref.Name, c.Host)...,
+	)
+	output, err := resticWrapper.RunBackup(backupOpt, targetInfo.Target.Ref)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	source, err := util.ChecksumManifestSource(inv.GetObjectMeta().Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if source != "" {
+		if err := checksum.BackupManifests(c.SetupOpt, output, targetInfo.Target.Ref, source == util.ChecksumFromLocal); err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
 }
 
 func (c *BackupSessionController) electLeaderPod(targetInfo invoker.BackupTargetInfo, invokerRef *core.ObjectReference, stopCh <-chan struct{}) error {
@@ -452,9 +493,7 @@ func (c *BackupSessionController) handleBackupFailure(backupSession *api_v1beta1
 func (c *BackupSessionController) handleBackupCompletion(inv invoker.BackupInvoker, targetInfo invoker.BackupTargetInfo, backupSession *api_v1beta1.BackupSession, backupOutput *restic.BackupOutput) error {
 	// execute hooks at the end of backup completion. no matter if the backup succeed or fail.
 	defer func() {
-		if targetInfo.Hooks != nil &&
-			targetInfo.Hooks.PostBackup != nil &&
-			targetInfo.Hooks.PostBackup.Handler != nil {
+		if hasPostBackupHook(inv, targetInfo) {
 			hookErr := c.executePostBackupHook(inv, targetInfo, backupSession)
 			if hookErr != nil {
 				klog.Infof("failed to execute postBackup hook. Reason: %v", hookErr)
@@ -569,36 +608,64 @@ func (c *BackupSessionController) setSetupOptions(repo kmapi.ObjectReference) (*
 }
 
 func (c *BackupSessionController) executePreBackupHook(inv invoker.BackupInvoker, targetInfo invoker.BackupTargetInfo, backupSession *api_v1beta1.BackupSession) error {
-	hookExecutor := stashHooks.BackupHookExecutor{
-		Config:        c.Config,
-		StashClient:   c.StashClient,
-		BackupSession: backupSession,
-		Invoker:       inv,
-		Target:        targetInfo.Target.Ref,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: c.Namespace,
-			Name:      meta.PodName(),
+	hookExecutor := hooks.BackupHookExecutor{
+		BackupHookExecutor: stashHooks.BackupHookExecutor{
+			Config:        c.Config,
+			StashClient:   c.StashClient,
+			BackupSession: backupSession,
+			Invoker:       inv,
+			Target:        targetInfo.Target.Ref,
+			ExecutorPod: kmapi.ObjectReference{
+				Namespace: c.Namespace,
+				Name:      meta.PodName(),
+			},
+			HookType: apis.PreBackupHook,
 		},
-		Hook:     targetInfo.Hooks.PreBackup,
-		HookType: apis.PreBackupHook,
+		KubeClient: c.K8sClient,
+		Host:       c.Host,
+		// the sidecar runs with the ServiceAccount of the workload
+		RequestJobHooks: true,
+	}
+	if targetInfo.Hooks != nil {
+		hookExecutor.Hook = targetInfo.Hooks.PreBackup
 	}
 	return hookExecutor.Execute()
 }
 
 func (c *BackupSessionController) executePostBackupHook(inv invoker.BackupInvoker, targetInfo invoker.BackupTargetInfo, backupSession *api_v1beta1.BackupSession) error {
-	hookExecutor := stashHooks.BackupHookExecutor{
-		Config:        c.Config,
-		StashClient:   c.StashClient,
-		BackupSession: backupSession,
-		Invoker:       inv,
-		Target:        targetInfo.Target.Ref,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: c.Namespace,
-			Name:      meta.PodName(),
+	hookExecutor := hooks.BackupHookExecutor{
+		BackupHookExecutor: stashHooks.BackupHookExecutor{
+			Config:        c.Config,
+			StashClient:   c.StashClient,
+			BackupSession: backupSession,
+			Invoker:       inv,
+			Target:        targetInfo.Target.Ref,
+			ExecutorPod: kmapi.ObjectReference{
+				Namespace: c.Namespace,
+				Name:      meta.PodName(),
+			},
+			HookType: apis.PostBackupHook,
 		},
-		Hook:            targetInfo.Hooks.PostBackup.Handler,
-		ExecutionPolicy: targetInfo.Hooks.PostBackup.ExecutionPolicy,
-		HookType:        apis.PostBackupHook,
+		KubeClient: c.K8sClient,
+		Host:       c.Host,
+		// the sidecar runs with the ServiceAccount of the workload
+		RequestJobHooks: true,
+	}
+	if targetInfo.Hooks != nil && targetInfo.Hooks.PostBackup != nil {
+		hookExecutor.Hook = targetInfo.Hooks.PostBackup.Handler
+		hookExecutor.ExecutionPolicy = targetInfo.Hooks.PostBackup.ExecutionPolicy
 	}
 	return hookExecutor.Execute()
 }
+
+func hasPreBackupHook(inv invoker.BackupInvoker, targetInfo invoker.BackupTargetInfo) bool {
+	return (targetInfo.Hooks != nil && targetInfo.Hooks.PreBackup != nil) ||
+		util.HasJobHook(inv.GetObjectMeta().Annotations, apis.PreBackupHook)
+}
+
+func hasPostBackupHook(inv invoker.BackupInvoker, targetInfo invoker.BackupTargetInfo) bool {
+	return (targetInfo.Hooks != nil &&
+		targetInfo.Hooks.PostBackup != nil &&
+		targetInfo.Hooks.PostBackup.Handler != nil) ||
+		util.HasJobHook(inv.GetObjectMeta().Annotations, apis.PostBackupHook)
+}
@@ -0,0 +1,222 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checksum
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Entry is the checksum of a single backed up file.
+type Entry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// Manifest holds the checksum of every regular file of a backed up path.
+type Manifest struct {
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Snapshot  string    `json:"snapshot"`
+	CreatedAt time.Time `json:"createdAt"`
+	Files     []Entry   `json:"files"`
+}
+
+// Mismatch describes a restored file that does not match the manifest.
+type Mismatch struct {
+	Path   string
+	Reason string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: %s", m.Path, m.Reason)
+}
+
+// FromTar calculates the checksum of every regular file of a tar archive written by "restic dump".
+// The entries of the archive are relative to the root of the snapshot.
+func FromTar(r io.Reader, root string) (*Manifest, error) {
+	m := &Manifest{
+		Path:      root,
+		CreatedAt: time.Now().UTC(),
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, Entry{
+			Path:    path.Join("/", hdr.Name),
+			Size:    hdr.Size,
+			ModTime: hdr.ModTime.UTC(),
+			SHA256:  hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+	return m, nil
+}
+
+// FromDir calculates the checksum of every regular file under root. The entries are the absolute paths of the files
+// as they are recorded in the snapshots. The files must not change while the manifest is being generated.
+func FromDir(root string) (*Manifest, error) {
+	m := &Manifest{
+		Path:      root,
+		CreatedAt: time.Now().UTC(),
+	}
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sum, err := fileSHA256(file)
+		if err != nil {
+			return err
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, Entry{
+			Path:    filepath.ToSlash(abs),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			SHA256:  sum,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Verify compares the files restored under destination with the manifest.
+// A file whose modification time differs from the snapshot has not been restored from it and is skipped.
+// If strict is true, files missing from the restored data are reported too.
+func (m *Manifest) Verify(destination string, strict bool) ([]Mismatch, error) {
+	return m.VerifyAt(func(path string) string {
+		return filepath.Join(destination, path)
+	}, strict)
+}
+
+// VerifyAt verifies the files of the manifest like Verify. locate returns where a file of the manifest has been restored to.
+func (m *Manifest) VerifyAt(locate func(path string) string, strict bool) ([]Mismatch, error) {
+	var mismatches []Mismatch
+	skipped := 0
+	for _, e := range m.Files {
+		file := locate(e.Path)
+		info, err := os.Lstat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				if strict {
+					mismatches = append(mismatches, Mismatch{Path: e.Path, Reason: "file has not been restored"})
+				}
+				continue
+			}
+			return nil, err
+		}
+		if info.ModTime().Unix() != e.ModTime.Unix() {
+			skipped++
+			continue
+		}
+		if info.Size() != e.Size {
+			mismatches = append(mismatches, Mismatch{
+				Path:   e.Path,
+				Reason: fmt.Sprintf("size mismatch, expected %d bytes but found %d bytes", e.Size, info.Size()),
+			})
+			continue
+		}
+		sum, err := fileSHA256(file)
+		if err != nil {
+			return nil, err
+		}
+		if sum != e.SHA256 {
+			mismatches = append(mismatches, Mismatch{Path: e.Path, Reason: "checksum mismatch"})
+		}
+	}
+	if skipped > 0 {
+		klog.Infof("Skipped checksum verification of %d file(s) of %s as their modification time differs from the snapshot", skipped, m.Path)
+	}
+	return mismatches, nil
+}
+
+// WriteFile writes the manifest as JSON into path.
+func (m *Manifest) WriteFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// ReadFile reads a manifest written by WriteFile.
+func ReadFile(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
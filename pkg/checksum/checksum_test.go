@@ -0,0 +1,140 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checksum
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFromTar(t *testing.T) {
+	modTime := time.Date(2021, time.March, 14, 10, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		hdr     tar.Header
+		content string
+	}{
+		{hdr: tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: modTime}},
+		{hdr: tar.Header{Name: "data/a.txt", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: modTime}, content: "hello"},
+		{hdr: tar.Header{Name: "data/link", Typeflag: tar.TypeSymlink, Linkname: "a.txt", ModTime: modTime}},
+	}
+	for _, e := range entries {
+		e.hdr.Size = int64(len(e.content))
+		if err := tw.WriteHeader(&e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := FromTar(&buf, "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Path != "/data/a.txt" || m.Files[0].Size != 5 {
+		t.Fatalf("unexpected manifest entries %+v", m.Files)
+	}
+
+	// restore the file the way restic does and verify it against the manifest
+	dest := t.TempDir()
+	file := filepath.Join(dest, "data", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := m.Verify(dest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("unexpected mismatches %v", mismatches)
+	}
+
+	if err := os.WriteFile(file, []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err = m.Verify(dest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Reason != "checksum mismatch" {
+		t.Errorf("expected a checksum mismatch, got %v", mismatches)
+	}
+}
+
+func TestFromDir(t *testing.T) {
+	modTime := time.Date(2021, time.March, 14, 10, 0, 0, 0, time.UTC)
+	root := filepath.Join(t.TempDir(), "data")
+	file := filepath.Join(root, "sub", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/a.txt", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := FromDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Path != filepath.ToSlash(file) || m.Files[0].Size != 5 || !m.Files[0].ModTime.Equal(modTime) {
+		t.Fatalf("unexpected manifest entries %+v", m.Files)
+	}
+
+	// the manifest must be the same as the one generated from the snapshot of the files
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: file[1:], Typeflag: tar.TypeReg, Mode: 0o644, Size: 5, ModTime: modTime}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fromTar, err := FromTar(&buf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fromTar.Files) != 1 || fromTar.Files[0] != m.Files[0] {
+		t.Errorf("expected %+v, found %+v", fromTar.Files, m.Files)
+	}
+}
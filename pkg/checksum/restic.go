@@ -0,0 +1,283 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checksum
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	shell "gomodules.xyz/go-sh"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Tag is added to the companion snapshots that hold the checksum manifests.
+	Tag = "stash-checksums"
+	// snapshotTagPrefix is used to tag a companion snapshot with the id of the snapshot it describes.
+	snapshotTagPrefix = "stash-snapshot="
+
+	manifestDir = "checksums"
+	restoreDir  = "checksums-restore"
+
+	// maxReportedMismatches limits the number of files reported in the host error.
+	maxReportedMismatches = 10
+)
+
+// BackupManifests generates a checksum manifest for every snapshot taken by a backup and stores each of them
+// as a companion snapshot of the same host. The companion snapshots are tagged with "stash-checksums"
+// and the id of the data snapshot so that the restore process can find them.
+// Unless local is true, the manifests are generated from the content of the snapshots, not from the live files that
+// may have changed since the backup. So, the backed up data is read back from the repository once. Otherwise, they are
+// generated from the local files, which is only accurate if the files have not changed during the backup.
+// The companion snapshots are taken with a wrapper of their own so that they do not overwrite the progress of the backup.
+func BackupManifests(setupOpt restic.SetupOptions, output *restic.BackupOutput, targetRef api_v1beta1.TargetRef, local bool) error {
+	if output == nil {
+		return nil
+	}
+	w, err := restic.NewResticWrapper(setupOpt)
+	if err != nil {
+		return err
+	}
+	for _, host := range output.BackupTargetStatus.Stats {
+		for _, snap := range host.Snapshots {
+			if snap.Name == "" || snap.Path == "" {
+				continue
+			}
+			var m *Manifest
+			if local {
+				m, err = FromDir(snap.Path)
+			} else {
+				m, err = generateFromSnapshot(setupOpt, snap.Name, snap.Path)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to generate checksum manifest for %s. Reason: %v", snap.Path, err)
+			}
+			m.Host = host.Hostname
+			m.Snapshot = snap.Name
+
+			file := filepath.Join(setupOpt.ScratchDir, manifestDir, host.Hostname, manifestFileName(snap.Path))
+			if err := m.WriteFile(file); err != nil {
+				return err
+			}
+			_, err = w.RunBackup(restic.BackupOptions{
+				Host:        host.Hostname,
+				BackupPaths: []string{file},
+				Args:        []string{"--tag", Tag, "--tag", snapshotTagPrefix + snap.Name},
+			}, targetRef)
+			_ = os.Remove(file)
+			if err != nil {
+				return fmt.Errorf("failed to backup checksum manifest for %s. Reason: %v", snap.Path, err)
+			}
+			klog.Infof("Stored checksum manifest of %d file(s) for snapshot %s", len(m.Files), snap.Name)
+		}
+	}
+	return nil
+}
+
+// generateFromSnapshot streams the content of a path of a snapshot with "restic dump" and calculates the
+// checksum of its files. The archive can be huge, so it is not collected by the shell session of the wrapper.
+func generateFromSnapshot(setupOpt restic.SetupOptions, snapshot, path string) (*Manifest, error) {
+	// the wrapper only configures the environment of the session
+	sh := shell.NewSession()
+	w, err := restic.NewResticWrapperFromShell(setupOpt, sh)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"dump", "--quiet", "--no-cache", "--archive", "tar", snapshot, path}
+	if w.GetCaPath() != "" {
+		args = append(args, "--cacert", w.GetCaPath())
+	}
+	if setupOpt.InsecureTLS {
+		args = append(args, "--insecure-tls")
+	}
+	cmd := exec.Command(restic.ResticCMD, args...)
+	cmd.Env = os.Environ()
+	for k, v := range sh.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	m, err := FromTar(stdout, path)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to dump snapshot %s. Reason: %s", snapshot, msg)
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// VerifyRestore verifies the data restored with the given options against the checksum manifests of the restored snapshots.
+// Snapshots without a manifest are not verified. An error is returned if any restored file does not match its manifest.
+// locate returns where a file has been restored to if a conflict policy has moved it. Otherwise, it can be nil.
+// The manifests are downloaded with a wrapper of their own so that they do not overwrite the progress or the summary of the restore.
+func VerifyRestore(setupOpt restic.SetupOptions, opt restic.RestoreOptions, locate func(path string) string) error {
+	w, err := restic.NewResticWrapper(setupOpt)
+	if err != nil {
+		return err
+	}
+	snapshots, err := w.ListSnapshots(nil)
+	if err != nil {
+		return err
+	}
+	if locate == nil {
+		destination := opt.Destination
+		if destination == "" {
+			destination = "/"
+		}
+		locate = func(path string) string {
+			return filepath.Join(destination, path)
+		}
+	}
+	// missing files are expected when only a subset of the files has been restored
+	strict := len(opt.Include) == 0 && len(opt.Exclude) == 0
+
+	var mismatches []Mismatch
+	for _, id := range restoredSnapshots(snapshots, opt) {
+		companion := findCompanion(snapshots, id)
+		if companion == nil {
+			klog.Infof("Skipping checksum verification of snapshot %s. Reason: no checksum manifest found", id)
+			continue
+		}
+		m, err := downloadManifest(w, *companion, setupOpt.ScratchDir)
+		if err != nil {
+			return fmt.Errorf("failed to read checksum manifest of snapshot %s. Reason: %v", id, err)
+		}
+		result, err := m.VerifyAt(locate, strict)
+		if err != nil {
+			return err
+		}
+		klog.Infof("Verified %d file(s) of snapshot %s against its checksum manifest. Mismatches: %d", len(m.Files), id, len(result))
+		mismatches = append(mismatches, result...)
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, 0, maxReportedMismatches)
+	for i := 0; i < len(mismatches) && i < maxReportedMismatches; i++ {
+		msgs = append(msgs, mismatches[i].String())
+	}
+	if len(mismatches) > maxReportedMismatches {
+		msgs = append(msgs, fmt.Sprintf("and %d more", len(mismatches)-maxReportedMismatches))
+	}
+	return fmt.Errorf("%d restored file(s) do not match the checksum manifest: %s", len(mismatches), strings.Join(msgs, "; "))
+}
+
+// restoredSnapshots returns the ids of the data snapshots restored by the restore options.
+func restoredSnapshots(snapshots []restic.Snapshot, opt restic.RestoreOptions) []string {
+	if len(opt.Snapshots) != 0 {
+		return opt.Snapshots
+	}
+	var ids []string
+	for _, path := range opt.RestorePaths {
+		// restic restores the latest snapshot of the source host that contains the path
+		var latest *restic.Snapshot
+		for i, s := range snapshots {
+			if s.Hostname != opt.SourceHost || hasTag(s, Tag) || !containsPath(s, path) {
+				continue
+			}
+			if latest == nil || s.Time.After(latest.Time) {
+				latest = &snapshots[i]
+			}
+		}
+		if latest != nil {
+			ids = append(ids, latest.ID)
+		}
+	}
+	return ids
+}
+
+// findCompanion returns the latest companion snapshot that holds the manifest of the given snapshot.
+// The snapshot ids can be either in short or in long form.
+func findCompanion(snapshots []restic.Snapshot, id string) *restic.Snapshot {
+	var companion *restic.Snapshot
+	for i, s := range snapshots {
+		if !hasTag(s, Tag) {
+			continue
+		}
+		for _, tag := range s.Tags {
+			if !strings.HasPrefix(tag, snapshotTagPrefix) {
+				continue
+			}
+			tagged := strings.TrimPrefix(tag, snapshotTagPrefix)
+			if tagged != "" && (strings.HasPrefix(id, tagged) || strings.HasPrefix(tagged, id)) {
+				if companion == nil || s.Time.After(companion.Time) {
+					companion = &snapshots[i]
+				}
+			}
+		}
+	}
+	return companion
+}
+
+func downloadManifest(w *restic.ResticWrapper, companion restic.Snapshot, scratchDir string) (*Manifest, error) {
+	if len(companion.Paths) == 0 {
+		return nil, fmt.Errorf("companion snapshot %s has no path", companion.ID)
+	}
+	dir := filepath.Join(scratchDir, restoreDir, companion.ID)
+	defer os.RemoveAll(dir)
+
+	if _, err := w.DownloadSnapshot(companion.ID, dir); err != nil {
+		return nil, err
+	}
+	return ReadFile(filepath.Join(dir, companion.Paths[0]))
+}
+
+func manifestFileName(path string) string {
+	name := strings.ReplaceAll(strings.Trim(filepath.Clean(path), "/"), "/", "_")
+	if name == "" || name == "." {
+		name = "root"
+	}
+	return name + ".json"
+}
+
+func hasTag(s restic.Snapshot, tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPath(s restic.Snapshot, path string) bool {
+	for _, p := range s.Paths {
+		if filepath.Clean(p) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
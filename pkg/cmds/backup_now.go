@@ -0,0 +1,181 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	condutil "kmodules.xyz/client-go/conditions"
+	"kmodules.xyz/client-go/meta"
+)
+
+func NewCmdBackup() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "backup",
+		Short:             "Manage backups",
+		DisableAutoGenTag: true,
+	}
+	cmd.AddCommand(NewCmdBackupNow(os.Stdout))
+	return cmd
+}
+
+func NewCmdBackupNow(out io.Writer) *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+
+		opt = options{
+			namespace:   meta.PodNamespace(),
+			invokerKind: api_v1beta1.ResourceKindBackupConfiguration,
+		}
+		waitOpt = waitOptions{
+			pollInterval: 5 * time.Second,
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:   "now",
+		Short: "Trigger a backup instantly and optionally wait for it to complete",
+		Long: fmt.Sprintf(`Trigger a backup instantly by creating a BackupSession for the given invoker.
+
+With --wait, the command streams the progress of the BackupSession and exits with
+%d if the backup succeeded, %d if it failed, %d if it was skipped and %d if the deadline was exceeded.`,
+			ExitCodeSucceeded, ExitCodeFailed, ExitCodeSkipped, ExitCodeDeadlineExceeded),
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				klog.Errorf("Could not get Kubernetes config: %s", err)
+				os.Exit(ExitCodeError)
+			}
+			opt.stashClient = cs.NewForConfigOrDie(config)
+
+			session, err := opt.createBackupSession()
+			if err != nil {
+				klog.Errorf("Failed to create BackupSession. Reason: %v", err)
+				os.Exit(ExitCodeError)
+			}
+			_, _ = fmt.Fprintf(out, "BackupSession %s/%s has been created\n", session.Namespace, session.Name)
+			if !waitOpt.wait {
+				return
+			}
+
+			code, err := waitOpt.waitForBackupSession(opt.stashClient, session, newProgressPrinter(out))
+			if err != nil {
+				klog.Errorln(err)
+			}
+			os.Exit(code)
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", "", "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", "", "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVarP(&opt.namespace, "namespace", "n", opt.namespace, "Namespace of the backup invoker")
+	cmd.Flags().StringVar(&opt.invokerName, "invoker-name", "", "Name of the backup invoker")
+	cmd.Flags().StringVar(&opt.invokerKind, "invoker-kind", opt.invokerKind, "Kind of the backup invoker")
+	cmd.Flags().BoolVar(&waitOpt.wait, "wait", waitOpt.wait, "Wait for the BackupSession to complete")
+	cmd.Flags().DurationVar(&waitOpt.timeout, "timeout", waitOpt.timeout, "Maximum time to wait for the BackupSession to complete; 0 waits forever")
+	cmd.Flags().DurationVar(&waitOpt.pollInterval, "poll-interval", waitOpt.pollInterval, "Interval between two status checks of the BackupSession")
+	_ = cmd.MarkFlagRequired("invoker-name")
+
+	return cmd
+}
+
+func (opt waitOptions) waitForBackupSession(stashClient cs.Interface, session *api_v1beta1.BackupSession, p *progressPrinter) (int, error) {
+	return opt.pollSession(func() (bool, int, error) {
+		bs, err := stashClient.StashV1beta1().BackupSessions(session.Namespace).Get(context.TODO(), session.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, ExitCodeError, err
+		}
+		printBackupSessionProgress(p, bs)
+		done, code := backupSessionExitCode(bs)
+		return done, code, nil
+	})
+}
+
+// backupSessionExitCode returns whether the BackupSession has completed and the exit code for its outcome.
+func backupSessionExitCode(bs *api_v1beta1.BackupSession) (bool, int) {
+	switch bs.Status.Phase {
+	case api_v1beta1.BackupSessionSucceeded:
+		return true, ExitCodeSucceeded
+	case api_v1beta1.BackupSessionSkipped:
+		return true, ExitCodeSkipped
+	case api_v1beta1.BackupSessionFailed:
+		if condutil.IsConditionTrue(bs.Status.Conditions, api_v1beta1.DeadlineExceeded) {
+			return true, ExitCodeDeadlineExceeded
+		}
+		return true, ExitCodeFailed
+	}
+	return false, ExitCodeSucceeded
+}
+
+func printBackupSessionProgress(p *progressPrinter, bs *api_v1beta1.BackupSession) {
+	p.print("session", fmt.Sprintf("BackupSession %s/%s: %s", bs.Namespace, bs.Name, phaseOrDefault(string(bs.Status.Phase), string(api_v1beta1.BackupSessionPending))))
+	for _, t := range bs.Status.Targets {
+		target := fmt.Sprintf("%s %s/%s", t.Ref.Kind, t.Ref.Namespace, t.Ref.Name)
+		totalHosts := "?"
+		if t.TotalHosts != nil {
+			totalHosts = fmt.Sprintf("%d", *t.TotalHosts)
+		}
+		p.print("target/"+target, fmt.Sprintf("  target %s: %s (%d/%s hosts completed)",
+			target,
+			phaseOrDefault(string(t.Phase), string(api_v1beta1.TargetBackupPending)),
+			len(t.Stats),
+			totalHosts,
+		))
+		for _, h := range t.Stats {
+			line := fmt.Sprintf("    host %s: %s", h.Hostname, h.Phase)
+			if h.Duration != "" {
+				line += fmt.Sprintf(" in %s", h.Duration)
+			}
+			if h.Error != "" {
+				line += fmt.Sprintf(". Reason: %s", h.Error)
+			}
+			p.print("host/"+target+"/"+h.Hostname, line)
+		}
+	}
+	hostProgress, _ := progress.HostProgressFromAnnotations(bs.Annotations, util.KeyBackupProgress)
+	for _, hp := range hostProgress {
+		line := fmt.Sprintf("  %s host %s: %.1f%% done, %s", hp.Target, hp.Hostname, hp.PercentDone, progress.Summary(hp))
+		p.print("progress/"+hp.Target+"/"+hp.Hostname, line)
+	}
+	for _, c := range bs.Status.Conditions {
+		if c.Status == metav1.ConditionFalse {
+			p.print("condition/"+string(c.Type), fmt.Sprintf("  condition %s is False: %s", c.Type, c.Message))
+		}
+	}
+}
+
+func phaseOrDefault(phase, def string) string {
+	if phase == "" {
+		return def
+	}
+	return phase
+}
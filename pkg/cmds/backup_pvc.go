@@ -18,13 +18,19 @@ package cmds
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
+	"strings"
 
 	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	api_util "stash.appscode.dev/apimachinery/pkg/util"
+	"stash.appscode.dev/stash/pkg/checksum"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"github.com/spf13/cobra"
@@ -54,6 +60,7 @@ type pvcOptions struct {
 	kubeConfigPath string
 	namespace      string
 	outputDir      string
+	metrics        metrics.MetricsOptions
 
 	invokerKind string
 	invokerName string
@@ -61,6 +68,7 @@ type pvcOptions struct {
 	targetRef api_v1beta1.TargetRef
 
 	backupSessionName string
+	checksumManifest  string
 }
 
 func NewCmdBackupPVC() *cobra.Command {
@@ -82,6 +90,8 @@ func NewCmdBackupPVC() *cobra.Command {
 		Short:             "Takes a backup of Persistent Volume Claim",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			defer tracing.SetupFromEnv("stash-" + cmd.Name())()
+
 			flags.EnsureRequiredFlags(cmd, "backup-dirs", "provider")
 
 			config, err := clientcmd.BuildConfigFromFlags(opt.masterURL, opt.kubeConfigPath)
@@ -98,6 +108,12 @@ func NewCmdBackupPVC() *cobra.Command {
 				return err
 			}
 
+			opt.checksumManifest, err = util.ChecksumManifestSource(inv.GetObjectMeta().Annotations)
+			if err != nil {
+				return err
+			}
+			opt.metrics.JobName = fmt.Sprintf("%s-%s-%s", strings.ToLower(opt.invokerKind), opt.namespace, opt.invokerName)
+
 			for _, targetInfo := range inv.GetTargetInfo() {
 				if targetInfo.Target != nil && targetMatched(targetInfo.Target.Ref, opt.targetRef.Kind, opt.targetRef.Name, opt.targetRef.Namespace) {
 
@@ -171,6 +187,8 @@ func NewCmdBackupPVC() *cobra.Command {
 	cmd.Flags().BoolVar(&opt.backupOpt.RetentionPolicy.DryRun, "retention-dry-run", opt.backupOpt.RetentionPolicy.DryRun, "Specify whether to test retention policy without deleting actual data")
 
 	cmd.Flags().StringVar(&opt.outputDir, "output-dir", opt.outputDir, "Directory where output.json file will be written (keep empty if you don't need to write output in file)")
+	cmd.Flags().BoolVar(&opt.metrics.Enabled, "metrics-enabled", opt.metrics.Enabled, "Specify whether to export Prometheus metrics")
+	cmd.Flags().StringVar(&opt.metrics.PushgatewayURL, "pushgateway-url", opt.metrics.PushgatewayURL, "Pushgateway URL where the backup progress will be pushed")
 
 	return cmd
 }
@@ -209,10 +227,39 @@ func (opt *pvcOptions) backupPVC(targetRef api_v1beta1.TargetRef) (*restic.Backu
 		return nil, err
 	}
 
-	// init restic wrapper
-	resticWrapper, err := restic.NewResticWrapper(opt.setupOpt)
+	// init restic wrapper. the progress of the backup is reported to the BackupSession while it is running.
+	reporter := &progress.BackupReporter{
+		StashClient: opt.stashClient,
+		Session: metav1.ObjectMeta{
+			Name:      opt.backupSessionName,
+			Namespace: opt.namespace,
+		},
+		TargetRef:   targetRef,
+		Host:        opt.backupOpt.Host,
+		InvokerKind: opt.invokerKind,
+		InvokerName: opt.invokerName,
+		Metrics:     opt.metrics,
+	}
+	resticWrapper, stopProgress, err := progress.NewResticWrapper(opt.setupOpt, reporter.Report)
 	if err != nil {
 		return nil, err
 	}
-	return resticWrapper.RunBackup(opt.backupOpt, targetRef)
+	// stop reporting the progress before the final status of the host is written
+	defer stopProgress()
+	_, span := tracing.Start(
+		tracing.SessionContext(nil),
+		"restic backup",
+		tracing.TargetAttributes(targetRef.Kind, targetRef.Name, opt.backupOpt.Host)...,
+	)
+	output, err := resticWrapper.RunBackup(opt.backupOpt, targetRef)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	if opt.checksumManifest != "" {
+		if err := checksum.BackupManifests(opt.setupOpt, output, targetRef, opt.checksumManifest == util.ChecksumFromLocal); err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
 }
@@ -75,7 +75,7 @@ func NewCmdCreateBackupSession() *cobra.Command {
 				opt.ocClient = oc_cs.NewForConfigOrDie(config)
 			}
 
-			if err = opt.createBackupSession(); err != nil {
+			if _, err = opt.createBackupSession(); err != nil {
 				klog.Fatal(err)
 			}
 		},
@@ -89,10 +89,10 @@ func NewCmdCreateBackupSession() *cobra.Command {
 	return cmd
 }
 
-func (opt *options) createBackupSession() error {
+func (opt *options) createBackupSession() (*api_v1beta1.BackupSession, error) {
 	inv, err := invoker.NewBackupInvoker(opt.stashClient, opt.invokerKind, opt.invokerName, opt.namespace)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	retryLeft := int32(0)
@@ -102,7 +102,7 @@ func (opt *options) createBackupSession() error {
 	}
 
 	session := inv.NewSession()
-	_, _, err = v1beta1_util.CreateOrPatchBackupSession(
+	bs, _, err := v1beta1_util.CreateOrPatchBackupSession(
 		context.TODO(),
 		opt.stashClient.StashV1beta1(),
 		session.ObjectMeta,
@@ -115,5 +115,5 @@ func (opt *options) createBackupSession() error {
 		},
 		metav1.PatchOptions{},
 	)
-	return err
+	return bs, err
 }
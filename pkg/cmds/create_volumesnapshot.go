@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"stash.appscode.dev/apimachinery/apis"
@@ -28,11 +29,16 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/fsfreeze"
+	"stash.appscode.dev/stash/pkg/hooks"
 	"stash.appscode.dev/stash/pkg/status"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
 	"stash.appscode.dev/stash/pkg/volumesnapshot"
 
 	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
 	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -63,6 +69,7 @@ type VSoption struct {
 	invokerName string
 
 	targetRef api_v1beta1.TargetRef
+	image     string
 }
 
 func NewCmdCreateVolumeSnapshot() *cobra.Command {
@@ -82,6 +89,8 @@ func NewCmdCreateVolumeSnapshot() *cobra.Command {
 		Short:             "Take snapshot of PersistentVolumeClaims",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			defer tracing.SetupFromEnv("stash-" + cmd.Name())()
+
 			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
 			if err != nil {
 				klog.Fatalf("Could not get Kubernetes config: %s", err)
@@ -157,13 +166,20 @@ func (opt *VSoption) createVolumeSnapshot(bsMeta metav1.ObjectMeta, inv invoker.
 	}
 
 	// If preBackup hook is specified, then execute those hooks first
-	if targetInfo.Hooks != nil && targetInfo.Hooks.PreBackup != nil {
+	hasPreBackupHandler := targetInfo.Hooks != nil && targetInfo.Hooks.PreBackup != nil
+	if hasPreBackupHandler || util.HasJobHook(inv.GetObjectMeta().Annotations, apis.PreBackupHook) {
 		klog.Infoln("Executing preBackup hooks........")
-		podName := meta.PodName()
-		if podName == "" {
-			return nil, fmt.Errorf("failed to execute preBackup hooks. Reason: POD_NAME environment variable not found")
+		if hasPreBackupHandler {
+			podName := meta.PodName()
+			if podName == "" {
+				return nil, fmt.Errorf("failed to execute preBackup hooks. Reason: POD_NAME environment variable not found")
+			}
+			err := prober.RunProbe(opt.config, targetInfo.Hooks.PreBackup, podName, opt.namespace)
+			if err != nil {
+				return nil, err
+			}
 		}
-		err := prober.RunProbe(opt.config, targetInfo.Hooks.PreBackup, podName, opt.namespace)
+		err := opt.executeBackupJobHook(bsMeta, inv, targetInfo, apis.PreBackupHook)
 		if err != nil {
 			return nil, err
 		}
@@ -182,54 +198,88 @@ func (opt *VSoption) createVolumeSnapshot(bsMeta metav1.ObjectMeta, inv invoker.
 		return nil, err
 	}
 
-	vsMeta := []metav1.ObjectMeta{}
+	// use timestamp suffix of BackupSession name as suffix of the VolumeSnapshots name
+	parts := strings.Split(bsMeta.Name, "-")
+	timestamp := parts[len(parts)-1]
 
-	// create VolumeSnapshots
-	for _, pvcName := range pvcNames {
-		// use timestamp suffix of BackupSession name as suffix of the VolumeSnapshots name
-		parts := strings.Split(bsMeta.Name, "-")
-		volumeSnapshot := opt.getVolumeSnapshotDefinition(targetInfo.Target, inv.GetObjectMeta().Namespace, pvcName, parts[len(parts)-1])
-		snapshot, err := opt.snapshotClient.SnapshotV1().VolumeSnapshots(volumeSnapshot.Namespace).Create(context.TODO(), &volumeSnapshot, metav1.CreateOptions{})
-		if err != nil {
+	groupClass, err := opt.getVolumeGroupSnapshotClass(inv, pvcNames)
+	if err != nil {
+		return nil, err
+	}
+
+	// freeze the filesystems of the PVCs until the point-in-time snapshots have been taken
+	freezer, err := opt.newFreezer(inv, pvcNames)
+	if err != nil {
+		return nil, err
+	}
+	if freezer != nil {
+		if err := freezer.Freeze(); err != nil {
 			return nil, err
 		}
-		vsMeta = append(vsMeta, snapshot.ObjectMeta)
+		defer opt.thaw(freezer)
 	}
 
-	// now wait for all the VolumeSnapshots are completed (ready to to use)
-	for i, pvcName := range pvcNames {
-		// wait until this VolumeSnapshot is ready to use
-		err = vsu.WaitUntilVolumeSnapshotReady(opt.snapshotClient, types.NamespacedName{Namespace: vsMeta[i].Namespace, Name: vsMeta[i].Name})
-		if err != nil {
-			backupOutput.BackupTargetStatus.Stats = append(backupOutput.BackupTargetStatus.Stats, api_v1beta1.HostBackupStats{
-				Hostname: pvcName,
-				Phase:    api_v1beta1.HostBackupFailed,
-				Error:    err.Error(),
-			})
-		} else {
-			backupOutput.BackupTargetStatus.Stats = append(backupOutput.BackupTargetStatus.Stats, api_v1beta1.HostBackupStats{
-				Hostname: pvcName,
-				Phase:    api_v1beta1.HostBackupSucceeded,
-				Duration: time.Since(startTime).String(),
-			})
+	var snapshots []volumesnapshot.SnapshotInfo
+	if groupClass != "" {
+		backupOutput.BackupTargetStatus.Stats, snapshots, err = opt.createVolumeGroupSnapshot(inv, targetInfo.Target, pvcNames, groupClass, timestamp, startTime, freezer)
+	} else {
+		backupOutput.BackupTargetStatus.Stats, snapshots, err = opt.createVolumeSnapshots(inv, targetInfo.Target, pvcNames, timestamp, startTime, freezer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if freezer != nil {
+		opt.thaw(freezer)
+		if freezer.Expired() {
+			return nil, fmt.Errorf("the filesystems of the target have been thawed by the watchdog after the freeze timeout %s before the VolumeSnapshots were taken. The VolumeSnapshots might not be consistent. Please, increase the timeout with the %q annotation", freezer.Deadline, util.KeyFreezeTimeout)
 		}
+		backupOutput.BackupTargetStatus.Conditions = append(backupOutput.BackupTargetStatus.Conditions, freezer.Condition(nil))
+	}
+	err = volumesnapshot.PushMetrics(opt.metrics, prometheus.Labels{
+		metrics.MetricLabelInvokerKind: inv.GetTypeMeta().Kind,
+		metrics.MetricLabelInvokerName: inv.GetObjectMeta().Name,
+		metrics.MetricsLabelNamespace:  inv.GetObjectMeta().Namespace,
+		metrics.MetricsLabelKind:       targetInfo.Target.Ref.Kind,
+		metrics.MetricsLabelName:       targetInfo.Target.Ref.Name,
+	}, snapshots)
+	if err != nil {
+		klog.Warningf("Failed to push VolumeSnapshot metrics. Reason: %v", err)
+	}
+	if err := volumesnapshot.RecordSnapshotInfo(opt.stashClient, bsMeta, snapshots); err != nil {
+		klog.Warningf("Failed to record the stats of the VolumeSnapshots. Reason: %v", err)
+	}
 
+	within, err := util.RetentionKeepWithin(inv.GetObjectMeta().Annotations)
+	if err != nil {
+		return nil, err
 	}
-	err = volumesnapshot.CleanupSnapshots(inv.GetRetentionPolicy(), backupOutput.BackupTargetStatus.Stats, bsMeta.Namespace, opt.snapshotClient)
+	reports, err := volumesnapshot.CleanupSnapshots(inv.GetRetentionPolicy(), within, backupOutput.BackupTargetStatus.Stats, bsMeta.Namespace, opt.snapshotClient)
 	if err != nil {
 		return nil, err
 	}
+	if inv.GetRetentionPolicy().DryRun {
+		if err := volumesnapshot.RecordRetentionReports(opt.stashClient, bsMeta, reports); err != nil {
+			klog.Warningf("Failed to record the result of the retention policy dry run. Reason: %v", err)
+		}
+	}
 
 	// If postBackup hook is specified, then execute those hooks after backup
-	if targetInfo.Hooks != nil &&
+	hasPostBackupHandler := targetInfo.Hooks != nil &&
 		targetInfo.Hooks.PostBackup != nil &&
-		targetInfo.Hooks.PostBackup.Handler != nil {
+		targetInfo.Hooks.PostBackup.Handler != nil
+	if hasPostBackupHandler || util.HasJobHook(inv.GetObjectMeta().Annotations, apis.PostBackupHook) {
 		klog.Infoln("Executing postBackup hooks........")
-		podName := meta.PodName()
-		if podName == "" {
-			return nil, fmt.Errorf("failed to execute postBackup hook. Reason: POD_NAME environment variable not found")
+		var err error
+		if hasPostBackupHandler {
+			podName := meta.PodName()
+			if podName == "" {
+				return nil, fmt.Errorf("failed to execute postBackup hook. Reason: POD_NAME environment variable not found")
+			}
+			err = prober.RunProbe(opt.config, targetInfo.Hooks.PostBackup.Handler, podName, opt.namespace)
+		}
+		if err == nil {
+			err = opt.executeBackupJobHook(bsMeta, inv, targetInfo, apis.PostBackupHook)
 		}
-		err := prober.RunProbe(opt.config, targetInfo.Hooks.PostBackup.Handler, podName, opt.namespace)
 		if err != nil {
 			return nil, fmt.Errorf("%w Warning: The actual backup process may be succeeded. Hence, the backup snapshots might be present in the backend even if the overall BackupSession phase is 'Failed'", err)
 		}
@@ -245,6 +295,208 @@ func (opt *VSoption) createVolumeSnapshot(bsMeta metav1.ObjectMeta, inv invoker.
 	return backupOutput, nil
 }
 
+func (opt *VSoption) executeBackupJobHook(bsMeta metav1.ObjectMeta, inv invoker.BackupInvoker, targetInfo invoker.BackupTargetInfo, hookType string) error {
+	summary := inv.GetSummary(targetInfo.Target.Ref, kmapi.ObjectReference{
+		Namespace: bsMeta.Namespace,
+		Name:      bsMeta.Name,
+	})
+	owner := metav1.NewControllerRef(&bsMeta, api_v1beta1.SchemeGroupVersion.WithKind(api_v1beta1.ResourceKindBackupSession))
+	return hooks.ExecuteJobHook(opt.kubeClient, inv, hookType, apis.DefaultHost, summary, owner)
+}
+
+// createVolumeSnapshots snapshots each PVC independently. The filesystems are thawed once the snapshots have been taken.
+func (opt *VSoption) createVolumeSnapshots(inv invoker.BackupInvoker, target *api_v1beta1.BackupTarget, pvcNames []string, timestamp string, startTime time.Time, freezer *fsfreeze.Freezer) ([]api_v1beta1.HostBackupStats, []volumesnapshot.SnapshotInfo, error) {
+	namespace := inv.GetObjectMeta().Namespace
+	vsMeta := []metav1.ObjectMeta{}
+
+	// create VolumeSnapshots
+	for _, pvcName := range pvcNames {
+		volumeSnapshot := opt.getVolumeSnapshotDefinition(target, namespace, pvcName, timestamp, snapshotAnnotations(inv))
+		snapshot, err := opt.snapshotClient.SnapshotV1().VolumeSnapshots(volumeSnapshot.Namespace).Create(context.TODO(), &volumeSnapshot, metav1.CreateOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		vsMeta = append(vsMeta, snapshot.ObjectMeta)
+	}
+	if freezer != nil {
+		names := make([]string, 0, len(vsMeta))
+		for _, m := range vsMeta {
+			names = append(names, m.Name)
+		}
+		err := volumesnapshot.WaitUntilSnapshotsTaken(opt.snapshotClient, namespace, names, freezer.Deadline)
+		opt.thaw(freezer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("VolumeSnapshots have not been taken within the freeze timeout %s. Please, increase the timeout with the %q annotation. Reason: %v", freezer.Deadline, util.KeyFreezeTimeout, err)
+		}
+	}
+
+	// now wait for all the VolumeSnapshots are completed (ready to to use). they are waited for in parallel
+	// so that the time each of them has become ready is observed.
+	readyAt := make([]time.Time, len(vsMeta))
+	errs := make([]error, len(vsMeta))
+	var wg sync.WaitGroup
+	for i := range vsMeta {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = vsu.WaitUntilVolumeSnapshotReady(opt.snapshotClient, types.NamespacedName{Namespace: vsMeta[i].Namespace, Name: vsMeta[i].Name})
+			readyAt[i] = time.Now()
+		}(i)
+	}
+	wg.Wait()
+
+	var (
+		stats     []api_v1beta1.HostBackupStats
+		snapshots []volumesnapshot.SnapshotInfo
+	)
+	for i, pvcName := range pvcNames {
+		if errs[i] != nil {
+			stats = append(stats, api_v1beta1.HostBackupStats{
+				Hostname: pvcName,
+				Phase:    api_v1beta1.HostBackupFailed,
+				Error:    errs[i].Error(),
+			})
+			continue
+		}
+		stats = append(stats, api_v1beta1.HostBackupStats{
+			Hostname: pvcName,
+			Phase:    api_v1beta1.HostBackupSucceeded,
+			Duration: readyAt[i].Sub(startTime).String(),
+		})
+		if info := opt.getSnapshotInfo(namespace, vsMeta[i].Name, pvcName, readyAt[i]); info != nil {
+			snapshots = append(snapshots, *info)
+		}
+	}
+	return stats, snapshots, nil
+}
+
+// createVolumeGroupSnapshot snapshots all the PVCs at the same instant using a VolumeGroupSnapshot.
+// The name of the VolumeGroupSnapshot is recorded along with the snapshot of each host.
+func (opt *VSoption) createVolumeGroupSnapshot(inv invoker.BackupInvoker, target *api_v1beta1.BackupTarget, pvcNames []string, className, timestamp string, startTime time.Time, freezer *fsfreeze.Freezer) ([]api_v1beta1.HostBackupStats, []volumesnapshot.SnapshotInfo, error) {
+	namespace := inv.GetObjectMeta().Namespace
+	groupOpt := volumesnapshot.GroupSnapshotOptions{
+		KubeClient:     opt.kubeClient,
+		SnapshotClient: opt.snapshotClient,
+		Namespace:      namespace,
+		Name:           meta.ValidNameWithSuffix(target.Ref.Name, timestamp),
+		ClassName:      className,
+		PVCNames:       pvcNames,
+		Annotations:    snapshotAnnotations(inv),
+	}
+	if timeOut := inv.GetTimeOut(); timeOut != nil {
+		groupOpt.Timeout = timeOut.Duration
+	}
+	if freezer != nil {
+		groupOpt.OnTaken = func() {
+			opt.thaw(freezer)
+		}
+	}
+	klog.Infof("Taking VolumeGroupSnapshot %s/%s of %d PVC(s)", groupOpt.Namespace, groupOpt.Name, len(pvcNames))
+
+	var (
+		stats     []api_v1beta1.HostBackupStats
+		snapshots []volumesnapshot.SnapshotInfo
+	)
+	members, err := groupOpt.Create()
+	// the members of the group become ready to use together with the VolumeGroupSnapshot
+	readyAt := time.Now()
+	if err != nil {
+		for _, pvcName := range pvcNames {
+			stats = append(stats, api_v1beta1.HostBackupStats{
+				Hostname: pvcName,
+				Phase:    api_v1beta1.HostBackupFailed,
+				Error:    err.Error(),
+			})
+		}
+		return stats, nil, nil
+	}
+	for _, pvcName := range pvcNames {
+		vsName, ok := members[pvcName]
+		if !ok {
+			stats = append(stats, api_v1beta1.HostBackupStats{
+				Hostname: pvcName,
+				Phase:    api_v1beta1.HostBackupFailed,
+				Error:    fmt.Sprintf("VolumeGroupSnapshot %s has no VolumeSnapshot of PVC %s", groupOpt.Name, pvcName),
+			})
+			continue
+		}
+		stats = append(stats, api_v1beta1.HostBackupStats{
+			Hostname: pvcName,
+			Phase:    api_v1beta1.HostBackupSucceeded,
+			Duration: readyAt.Sub(startTime).String(),
+		})
+		if info := opt.getSnapshotInfo(namespace, vsName, pvcName, readyAt); info != nil {
+			snapshots = append(snapshots, *info)
+		}
+	}
+	return stats, snapshots, nil
+}
+
+// newFreezer returns the Freezer of the filesystems of the PVCs if freezing has been enabled for the invoker.
+// It returns nil if the PVCs are not used by any running pod.
+func (opt *VSoption) newFreezer(inv invoker.BackupInvoker, pvcNames []string) (*fsfreeze.Freezer, error) {
+	freezeOpt, err := util.FreezeOptionsFor(inv.GetObjectMeta().Annotations)
+	if err != nil || freezeOpt == nil {
+		return nil, err
+	}
+	mounts, err := fsfreeze.MountsOfPVCs(opt.kubeClient, inv.GetObjectMeta().Namespace, pvcNames, freezeOpt.Container)
+	if err != nil {
+		return nil, err
+	}
+	if len(mounts) == 0 {
+		klog.Infoln("Skipping filesystem freeze. Reason: No running pod uses the PVCs of the target.")
+		return nil, nil
+	}
+	return &fsfreeze.Freezer{
+		Config:   opt.config,
+		Mounts:   mounts,
+		Deadline: freezeOpt.Timeout,
+	}, nil
+}
+
+// thaw thaws the filesystems. A failure is not fatal as the filesystems are thawed by the watchdog after the freeze timeout.
+func (opt *VSoption) thaw(freezer *fsfreeze.Freezer) {
+	if err := freezer.Thaw(); err != nil {
+		klog.Warningln(err)
+	}
+}
+
+// getSnapshotInfo returns the information of a ready VolumeSnapshot. The backup of the host does not fail
+// if the information can not be collected, only the stats are missing.
+func (opt *VSoption) getSnapshotInfo(namespace, name, hostname string, readyAt time.Time) *volumesnapshot.SnapshotInfo {
+	info, err := volumesnapshot.GetSnapshotInfo(opt.snapshotClient, namespace, name, hostname, readyAt)
+	if err != nil {
+		klog.Warningf("Failed to collect the stats of VolumeSnapshot %s/%s. Reason: %v", namespace, name, err)
+		return nil
+	}
+	return info
+}
+
+// getVolumeGroupSnapshotClass returns the VolumeGroupSnapshotClass to snapshot the PVCs as a group.
+// It returns an empty string if the PVCs should be snapshotted independently.
+func (opt *VSoption) getVolumeGroupSnapshotClass(inv invoker.BackupInvoker, pvcNames []string) (string, error) {
+	if len(pvcNames) < 2 {
+		return "", nil
+	}
+	className := inv.GetObjectMeta().Annotations[util.KeyVolumeGroupSnapshotClass]
+	supported, err := volumesnapshot.IsGroupSnapshotSupported(opt.snapshotClient)
+	if err != nil {
+		// the PVCs must not be snapshotted independently if a VolumeGroupSnapshotClass has been asked for explicitly
+		if className != "" {
+			return "", fmt.Errorf("unable to take a VolumeGroupSnapshot with class %q. Reason: %v", className, err)
+		}
+		klog.Warningf("Unable to take a VolumeGroupSnapshot. The PVCs will be snapshotted independently. Reason: %v", err)
+		return "", nil
+	}
+	if !supported {
+		return "", nil
+	}
+	if className != "" {
+		return className, nil
+	}
+	return volumesnapshot.DefaultGroupSnapshotClass(opt.snapshotClient)
+}
+
 func (opt *VSoption) getTargetPVCNames(targetRef api_v1beta1.TargetRef, replicas *int32) ([]string, error) {
 	var pvcList []string
 
@@ -277,11 +529,12 @@ func (opt *VSoption) getTargetPVCNames(targetRef api_v1beta1.TargetRef, replicas
 	return pvcList, nil
 }
 
-func (opt *VSoption) getVolumeSnapshotDefinition(backupTarget *api_v1beta1.BackupTarget, namespace string, pvcName string, timestamp string) (volumeSnapshot vsapi.VolumeSnapshot) {
+func (opt *VSoption) getVolumeSnapshotDefinition(backupTarget *api_v1beta1.BackupTarget, namespace string, pvcName string, timestamp string, annotations map[string]string) (volumeSnapshot vsapi.VolumeSnapshot) {
 	return vsapi.VolumeSnapshot{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-%s", pvcName, timestamp),
-			Namespace: namespace,
+			Name:        fmt.Sprintf("%s-%s", pvcName, timestamp),
+			Namespace:   namespace,
+			Annotations: annotations,
 		},
 		Spec: vsapi.VolumeSnapshotSpec{
 			VolumeSnapshotClassName: &backupTarget.VolumeSnapshotClassName,
@@ -292,6 +545,14 @@ func (opt *VSoption) getVolumeSnapshotDefinition(backupTarget *api_v1beta1.Backu
 	}
 }
 
+// snapshotAnnotations returns the annotations of the invoker that are passed to the VolumeSnapshots.
+func snapshotAnnotations(inv invoker.BackupInvoker) map[string]string {
+	if val, ok := inv.GetObjectMeta().Annotations[util.KeyUsagePolicy]; ok {
+		return map[string]string{util.KeyUsagePolicy: val}
+	}
+	return nil
+}
+
 func getPVCs(volList []corev1.Volume) []string {
 	pvcList := make([]string, 0)
 	for _, vol := range volList {
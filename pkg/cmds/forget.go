@@ -18,11 +18,17 @@ package cmds
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	cs "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1alpha1"
 	"stash.appscode.dev/stash/pkg/registry/snapshot"
+	"stash.appscode.dev/stash/pkg/retention"
+	"stash.appscode.dev/stash/pkg/volumesnapshot"
 
+	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -35,18 +41,56 @@ func NewCmdForget() *cobra.Command {
 		masterURL      string
 		kubeconfigPath string
 		repo           kmapi.ObjectReference
+		dryRun         bool
+		policy         v1alpha1.RetentionPolicy
+		within         keepWithinFlags
+		vsNamespace    string
+		pvcs           []string
 	)
 
 	cmd := &cobra.Command{
-		Use:               "forget [snapshotID ...]",
-		Short:             "Delete snapshots from a restic repository",
+		Use:   "forget [snapshotID ...]",
+		Short: "Delete snapshots from a restic repository",
+		Long: "Delete snapshots from a restic repository.\n\n" +
+			"With --dry-run, the retention policy given by the --keep-* flags is evaluated against the snapshots of the repository. " +
+			"The snapshots that would be kept and removed are printed as JSON along with the reasons. No snapshot is removed.\n\n" +
+			"With --dry-run and --volumesnapshot-namespace, the retention policy is evaluated against the existing VolumeSnapshots " +
+			"of the namespace instead, grouped by their source PVC. Use --pvc to limit the evaluation to some PVCs.",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			keepWithin, err := within.parse()
+			if err != nil {
+				return err
+			}
+			if dryRun && len(args) != 0 {
+				return fmt.Errorf("snapshot IDs can not be used with --dry-run")
+			}
+			if !dryRun && (hasRetentionRule(policy) || !keepWithin.IsEmpty()) {
+				return fmt.Errorf("retention policy flags can only be used with --dry-run")
+			}
+			if dryRun && !hasRetentionRule(policy) && keepWithin.IsEmpty() {
+				return fmt.Errorf("no retention rule specified")
+			}
+			if vsNamespace != "" && !dryRun {
+				return fmt.Errorf("--volumesnapshot-namespace can only be used with --dry-run")
+			}
+			if len(pvcs) != 0 && vsNamespace == "" {
+				return fmt.Errorf("--pvc can only be used with --volumesnapshot-namespace")
+			}
+
 			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
 			if err != nil {
 				return err
 			}
 
+			if vsNamespace != "" {
+				reports, err := volumesnapshot.EvaluateRetentionPolicy(policy, keepWithin, pvcs, vsNamespace, vscs.NewForConfigOrDie(config))
+				if err != nil {
+					return err
+				}
+				return printRetentionReports(cmd.OutOrStdout(), reports)
+			}
+
 			stashClient := cs.NewForConfigOrDie(config)
 			kubeClient := kubernetes.NewForConfigOrDie(config)
 
@@ -71,6 +115,13 @@ func NewCmdForget() *cobra.Command {
 			}
 
 			r := snapshot.NewREST(config)
+			if dryRun {
+				reports, err := r.EvaluateRetentionPolicy(opt, policy, keepWithin)
+				if err != nil {
+					return err
+				}
+				return printRetentionReports(cmd.OutOrStdout(), reports)
+			}
 			return r.ForgetSnapshotsFromBackend(opt)
 		},
 	}
@@ -79,5 +130,72 @@ func NewCmdForget() *cobra.Command {
 	cmd.Flags().StringVar(&repo.Name, "repo-name", repo.Name, "Name of the Repository CRD.")
 	cmd.Flags().StringVar(&repo.Namespace, "repo-namespace", repo.Namespace, "Namespace of the Repository CRD.")
 
+	cmd.Flags().StringVar(&vsNamespace, "volumesnapshot-namespace", vsNamespace, "Evaluate the retention policy against the VolumeSnapshots of this namespace instead of the snapshots of a repository. Requires --dry-run.")
+	cmd.Flags().StringSliceVar(&pvcs, "pvc", pvcs, "Evaluate the retention policy only against the VolumeSnapshots of these PVCs.")
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", dryRun, "Print the snapshots that would be kept and removed by the retention policy without removing them.")
+	cmd.Flags().Int64Var(&policy.KeepLast, "keep-last", policy.KeepLast, "Keep the last n snapshots.")
+	cmd.Flags().Int64Var(&policy.KeepHourly, "keep-hourly", policy.KeepHourly, "Keep the last n hourly snapshots.")
+	cmd.Flags().Int64Var(&policy.KeepDaily, "keep-daily", policy.KeepDaily, "Keep the last n daily snapshots.")
+	cmd.Flags().Int64Var(&policy.KeepWeekly, "keep-weekly", policy.KeepWeekly, "Keep the last n weekly snapshots.")
+	cmd.Flags().Int64Var(&policy.KeepMonthly, "keep-monthly", policy.KeepMonthly, "Keep the last n monthly snapshots.")
+	cmd.Flags().Int64Var(&policy.KeepYearly, "keep-yearly", policy.KeepYearly, "Keep the last n yearly snapshots.")
+	cmd.Flags().StringArrayVar(&policy.KeepTags, "keep-tag", policy.KeepTags, "Keep snapshots that have all the tags of this comma separated list.")
+	cmd.Flags().StringVar(&within.within, "keep-within", within.within, "Keep snapshots taken within this duration (i.e. 1y5m7d2h) of the latest snapshot.")
+	cmd.Flags().StringVar(&within.hourly, "keep-within-hourly", within.hourly, "Keep hourly snapshots taken within this duration of the latest snapshot.")
+	cmd.Flags().StringVar(&within.daily, "keep-within-daily", within.daily, "Keep daily snapshots taken within this duration of the latest snapshot.")
+	cmd.Flags().StringVar(&within.weekly, "keep-within-weekly", within.weekly, "Keep weekly snapshots taken within this duration of the latest snapshot.")
+	cmd.Flags().StringVar(&within.monthly, "keep-within-monthly", within.monthly, "Keep monthly snapshots taken within this duration of the latest snapshot.")
+	cmd.Flags().StringVar(&within.yearly, "keep-within-yearly", within.yearly, "Keep yearly snapshots taken within this duration of the latest snapshot.")
+
 	return cmd
 }
+
+func printRetentionReports(w io.Writer, reports []retention.Report) error {
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+type keepWithinFlags struct {
+	within, hourly, daily, weekly, monthly, yearly string
+}
+
+func (f keepWithinFlags) parse() (retention.KeepWithin, error) {
+	var within retention.KeepWithin
+	for _, flag := range []struct {
+		name  string
+		value string
+		d     *retention.Duration
+	}{
+		{"keep-within", f.within, &within.Within},
+		{"keep-within-hourly", f.hourly, &within.Hourly},
+		{"keep-within-daily", f.daily, &within.Daily},
+		{"keep-within-weekly", f.weekly, &within.Weekly},
+		{"keep-within-monthly", f.monthly, &within.Monthly},
+		{"keep-within-yearly", f.yearly, &within.Yearly},
+	} {
+		if flag.value == "" {
+			continue
+		}
+		d, err := retention.ParseDuration(flag.value)
+		if err != nil {
+			return retention.KeepWithin{}, fmt.Errorf("invalid value for flag --%s. Reason: %v", flag.name, err)
+		}
+		*flag.d = d
+	}
+	return within, nil
+}
+
+func hasRetentionRule(policy v1alpha1.RetentionPolicy) bool {
+	return policy.KeepLast > 0 ||
+		policy.KeepHourly > 0 ||
+		policy.KeepDaily > 0 ||
+		policy.KeepWeekly > 0 ||
+		policy.KeepMonthly > 0 ||
+		policy.KeepYearly > 0 ||
+		len(policy.KeepTags) > 0
+}
@@ -0,0 +1,55 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"stash.appscode.dev/stash/pkg/progress"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+func NewCmdResticProgress() *cobra.Command {
+	var progressFile string
+
+	cmd := &cobra.Command{
+		Use:               progress.CommandName + " -- <command> [args...]",
+		Short:             "Run restic and record its progress",
+		Hidden:            true,
+		DisableAutoGenTag: true,
+		Args:              cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := progress.Run(progressFile, args[0], args[1:], os.Stdout)
+			if err != nil {
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					os.Exit(exitErr.ExitCode())
+				}
+				klog.Errorln(err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&progressFile, "progress-file", progressFile, "File where the latest progress will be written")
+	_ = cmd.MarkFlagRequired("progress-file")
+
+	return cmd
+}
@@ -19,6 +19,7 @@ package cmds
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -27,6 +28,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/stash/pkg/restore"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"github.com/spf13/cobra"
@@ -54,6 +56,8 @@ func NewCmdRestore() *cobra.Command {
 		Short:             "Restore from backup",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			defer tracing.SetupFromEnv("stash-" + cmd.Name())()
+
 			// create client
 			config, err := clientcmd.BuildConfigFromFlags(opt.MasterURL, opt.KubeconfigPath)
 			if err != nil {
@@ -123,6 +127,7 @@ func NewCmdRestore() *cobra.Command {
 	cmd.Flags().StringVar(&opt.Metrics.PushgatewayURL, "pushgateway-url", opt.Metrics.PushgatewayURL, "Pushgateway URL where the metrics will be pushed")
 	cmd.Flags().StringVar(&opt.RestoreModel, "restore-model", opt.RestoreModel, "Specify whether using job or init-container to restore (default init-container)")
 
+	cmd.AddCommand(NewCmdRestoreNow(os.Stdout))
 	return cmd
 }
 
@@ -0,0 +1,195 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	condutil "kmodules.xyz/client-go/conditions"
+	"kmodules.xyz/client-go/meta"
+)
+
+// NewCmdRestoreNow creates a RestoreSession and waits for it with --wait. It is a subcommand instead of a --wait
+// flag of "stash restore" because "stash restore" is the restore executor run by the init containers and the
+// restore jobs. Its flags are generated by the operator and it never creates a RestoreSession itself.
+func NewCmdRestoreNow(out io.Writer) *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		namespace      = meta.PodNamespace()
+		manifest       string
+
+		waitOpt = waitOptions{
+			pollInterval: 5 * time.Second,
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:   "now",
+		Short: "Create a RestoreSession and optionally wait for it to complete",
+		Long: fmt.Sprintf(`Create a RestoreSession from a manifest file ("-" reads from stdin).
+
+This is the client side counterpart of "stash backup now". The parent "stash restore" command is
+the restore executor run inside the restore init containers and jobs.
+
+With --wait, the command streams the progress of the RestoreSession and exits with
+%d if the restore succeeded, %d if it failed and %d if the deadline was exceeded.`,
+			ExitCodeSucceeded, ExitCodeFailed, ExitCodeDeadlineExceeded),
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				klog.Errorf("Could not get Kubernetes config: %s", err)
+				os.Exit(ExitCodeError)
+			}
+			stashClient := cs.NewForConfigOrDie(config)
+
+			rs, err := readRestoreSession(manifest)
+			if err != nil {
+				klog.Errorf("Failed to read RestoreSession manifest. Reason: %v", err)
+				os.Exit(ExitCodeError)
+			}
+			if rs.Namespace == "" {
+				rs.Namespace = namespace
+			}
+			rs, err = stashClient.StashV1beta1().RestoreSessions(rs.Namespace).Create(context.TODO(), rs, metav1.CreateOptions{})
+			if err != nil {
+				klog.Errorf("Failed to create RestoreSession. Reason: %v", err)
+				os.Exit(ExitCodeError)
+			}
+			_, _ = fmt.Fprintf(out, "RestoreSession %s/%s has been created\n", rs.Namespace, rs.Name)
+			if !waitOpt.wait {
+				return
+			}
+
+			code, err := waitOpt.waitForRestoreSession(stashClient, rs, newProgressPrinter(out))
+			if err != nil {
+				klog.Errorln(err)
+			}
+			os.Exit(code)
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", "", "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", "", "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", namespace, "Namespace of the RestoreSession if the manifest does not specify one")
+	cmd.Flags().StringVarP(&manifest, "file", "f", "", "Path to the RestoreSession manifest")
+	cmd.Flags().BoolVar(&waitOpt.wait, "wait", waitOpt.wait, "Wait for the RestoreSession to complete")
+	cmd.Flags().DurationVar(&waitOpt.timeout, "timeout", waitOpt.timeout, "Maximum time to wait for the RestoreSession to complete; 0 waits forever")
+	cmd.Flags().DurationVar(&waitOpt.pollInterval, "poll-interval", waitOpt.pollInterval, "Interval between two status checks of the RestoreSession")
+	_ = cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func readRestoreSession(path string) (*api_v1beta1.RestoreSession, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	rs := &api_v1beta1.RestoreSession{}
+	if err := yaml.NewYAMLOrJSONDecoder(r, 4096).Decode(rs); err != nil {
+		return nil, err
+	}
+	if rs.Kind != "" && rs.Kind != api_v1beta1.ResourceKindRestoreSession {
+		return nil, fmt.Errorf("expected a %s, found %s", api_v1beta1.ResourceKindRestoreSession, rs.Kind)
+	}
+	if rs.Name == "" && rs.GenerateName == "" {
+		return nil, fmt.Errorf("RestoreSession must have either name or generateName")
+	}
+	return rs, nil
+}
+
+func (opt waitOptions) waitForRestoreSession(stashClient cs.Interface, session *api_v1beta1.RestoreSession, p *progressPrinter) (int, error) {
+	return opt.pollSession(func() (bool, int, error) {
+		rs, err := stashClient.StashV1beta1().RestoreSessions(session.Namespace).Get(context.TODO(), session.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, ExitCodeError, err
+		}
+		printRestoreSessionProgress(p, rs)
+		done, code := restoreSessionExitCode(rs)
+		return done, code, nil
+	})
+}
+
+// restoreSessionExitCode returns whether the RestoreSession has completed and the exit code for its outcome.
+func restoreSessionExitCode(rs *api_v1beta1.RestoreSession) (bool, int) {
+	switch rs.Status.Phase {
+	case api_v1beta1.RestoreSucceeded:
+		return true, ExitCodeSucceeded
+	case api_v1beta1.RestoreFailed, api_v1beta1.RestorePhaseInvalid, api_v1beta1.RestorePhaseUnknown:
+		if condutil.IsConditionTrue(rs.Status.Conditions, api_v1beta1.DeadlineExceeded) {
+			return true, ExitCodeDeadlineExceeded
+		}
+		return true, ExitCodeFailed
+	}
+	return false, ExitCodeSucceeded
+}
+
+func printRestoreSessionProgress(p *progressPrinter, rs *api_v1beta1.RestoreSession) {
+	totalHosts := "?"
+	if rs.Status.TotalHosts != nil {
+		totalHosts = fmt.Sprintf("%d", *rs.Status.TotalHosts)
+	}
+	p.print("session", fmt.Sprintf("RestoreSession %s/%s: %s (%d/%s hosts completed)",
+		rs.Namespace,
+		rs.Name,
+		phaseOrDefault(string(rs.Status.Phase), string(api_v1beta1.RestorePending)),
+		len(rs.Status.Stats),
+		totalHosts,
+	))
+	for _, h := range rs.Status.Stats {
+		line := fmt.Sprintf("  host %s: %s", h.Hostname, h.Phase)
+		if h.Duration != "" {
+			line += fmt.Sprintf(" in %s", h.Duration)
+		}
+		if h.Error != "" {
+			line += fmt.Sprintf(". Reason: %s", h.Error)
+		}
+		p.print("host/"+h.Hostname, line)
+	}
+	hostProgress, _ := progress.HostProgressFromAnnotations(rs.Annotations, util.KeyRestoreProgress)
+	for _, hp := range hostProgress {
+		line := fmt.Sprintf("  host %s: %.1f%% done, %s", hp.Hostname, hp.PercentDone, progress.Summary(hp))
+		p.print("progress/"+hp.Hostname, line)
+	}
+	for _, c := range rs.Status.Conditions {
+		if c.Status == metav1.ConditionFalse {
+			p.print("condition/"+string(c.Type), fmt.Sprintf("  condition %s is False: %s", c.Type, c.Message))
+		}
+	}
+}
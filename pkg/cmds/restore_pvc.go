@@ -18,12 +18,17 @@ package cmds
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
+	"strings"
 
 	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/restore"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"github.com/spf13/cobra"
@@ -55,6 +60,8 @@ func NewCmdRestorePVC() *cobra.Command {
 		Short:             "Takes a restore of Persistent Volume Claim",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			defer tracing.SetupFromEnv("stash-" + cmd.Name())()
+
 			flags.EnsureRequiredFlags(cmd, "restore-dirs", "provider")
 
 			config, err := clientcmd.BuildConfigFromFlags(opt.masterURL, opt.kubeConfigPath)
@@ -70,6 +77,7 @@ func NewCmdRestorePVC() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			opt.metrics.JobName = fmt.Sprintf("%s-%s-%s", strings.ToLower(opt.invokerKind), opt.namespace, opt.invokerName)
 
 			for _, targetInfo := range inv.GetTargetInfo() {
 				if targetInfo.Target != nil && targetMatched(targetInfo.Target.Ref, opt.targetRef.Kind, opt.targetRef.Name, opt.targetRef.Namespace) {
@@ -80,7 +88,7 @@ func NewCmdRestorePVC() *cobra.Command {
 					}
 
 					// run backup
-					restoreOutput, err := opt.restorePVC(targetInfo.Target.Ref)
+					restoreOutput, err := opt.restorePVC(inv, targetInfo.Target.Ref)
 					if err != nil {
 						restoreOutput = &restic.RestoreOutput{
 							RestoreTargetStatus: api_v1beta1.RestoreMemberStatus{
@@ -135,11 +143,13 @@ func NewCmdRestorePVC() *cobra.Command {
 	cmd.Flags().StringVar(&opt.StorageSecret.Name, "storage-secret-name", opt.StorageSecret.Name, "Name of the StorageSecret")
 	cmd.Flags().StringVar(&opt.StorageSecret.Namespace, "storage-secret-namespace", opt.StorageSecret.Namespace, "Namespace of the StorageSecret")
 	cmd.Flags().StringVar(&opt.outputDir, "output-dir", opt.outputDir, "Directory where output.json file will be written (keep empty if you don't need to write output in file)")
+	cmd.Flags().BoolVar(&opt.metrics.Enabled, "metrics-enabled", opt.metrics.Enabled, "Specify whether to export Prometheus metrics")
+	cmd.Flags().StringVar(&opt.metrics.PushgatewayURL, "pushgateway-url", opt.metrics.PushgatewayURL, "Pushgateway URL where the restore progress will be pushed")
 
 	return cmd
 }
 
-func (opt *pvcOptions) restorePVC(targetRef api_v1beta1.TargetRef) (*restic.RestoreOutput, error) {
+func (opt *pvcOptions) restorePVC(inv invoker.RestoreInvoker, targetRef api_v1beta1.TargetRef) (*restic.RestoreOutput, error) {
 	var err error
 	opt.setupOpt.StorageSecret, err = opt.k8sClient.CoreV1().Secrets(opt.StorageSecret.Namespace).Get(context.Background(), opt.StorageSecret.Name, metav1.GetOptions{})
 	if err != nil {
@@ -156,11 +166,13 @@ func (opt *pvcOptions) restorePVC(targetRef api_v1beta1.TargetRef) (*restic.Rest
 		return nil, err
 	}
 
-	// init restic wrapper
-	resticWrapper, err := restic.NewResticWrapper(opt.setupOpt)
-	if err != nil {
-		return nil, err
-	}
-	// Run restore
-	return resticWrapper.RunRestore(opt.restoreOpt, targetRef)
+	return restore.HostRestore{
+		StashClient: opt.stashClient,
+		Invoker:     inv,
+		TargetRef:   targetRef,
+		EventSource: eventer.EventSourceRestoreJob,
+		Metrics:     opt.metrics,
+		SetupOpt:    opt.setupOpt,
+		RestoreOpt:  opt.restoreOpt,
+	}.Run()
 }
@@ -22,14 +22,18 @@ import (
 	"strings"
 	"time"
 
+	"stash.appscode.dev/apimachinery/apis"
 	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/hooks"
 	"stash.appscode.dev/stash/pkg/resolver"
 	"stash.appscode.dev/stash/pkg/status"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
+	"stash.appscode.dev/stash/pkg/volumesnapshot"
 
 	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
 	"github.com/spf13/cobra"
@@ -38,13 +42,22 @@ import (
 	storage_api_v1 "k8s.io/api/storage/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+	kmapi "kmodules.xyz/client-go/api/v1"
 	"kmodules.xyz/client-go/meta"
 	prober "kmodules.xyz/prober/probe"
 )
 
+const (
+	consumerPodPrefix = "stash-consumer"
+	consumerContainer = "consumer"
+	consumerVolume    = "restored-data"
+	consumerMountPath = "/restored-data"
+)
+
 func NewCmdRestoreVolumeSnapshot() *cobra.Command {
 	var (
 		masterURL      string
@@ -62,6 +75,8 @@ func NewCmdRestoreVolumeSnapshot() *cobra.Command {
 		Short:             "Restore PVC from VolumeSnapshot",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			defer tracing.SetupFromEnv("stash-" + cmd.Name())()
+
 			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
 			if err != nil {
 				klog.Fatalf("Could not get Kubernetes config: %s", err)
@@ -80,7 +95,7 @@ func NewCmdRestoreVolumeSnapshot() *cobra.Command {
 
 			for _, targetInfo := range inv.GetTargetInfo() {
 				if targetInfo.Target != nil && targetMatched(targetInfo.Target.Ref, opt.targetRef.Kind, opt.targetRef.Name, opt.targetRef.Namespace) {
-					restoreOutput, err := opt.restoreVolumeSnapshot(targetInfo)
+					restoreOutput, err := opt.restoreVolumeSnapshot(inv, targetInfo)
 					if err != nil {
 						return err
 					}
@@ -104,23 +119,31 @@ func NewCmdRestoreVolumeSnapshot() *cobra.Command {
 	cmd.Flags().StringVar(&opt.targetRef.Name, "target-name", opt.targetRef.Name, "Name of the Target")
 	cmd.Flags().StringVar(&opt.targetRef.Namespace, "target-namespace", opt.targetRef.Namespace, "Namespace of the Target")
 	cmd.Flags().StringVar(&opt.targetRef.Kind, "target-kind", opt.targetRef.Kind, "Kind of the Target")
+	cmd.Flags().StringVar(&opt.image, "image", opt.image, "Image used by the pods that consume the restored volumes")
 	cmd.Flags().BoolVar(&opt.metrics.Enabled, "metrics-enabled", opt.metrics.Enabled, "Specify whether to export Prometheus metrics")
 	cmd.Flags().StringVar(&opt.metrics.PushgatewayURL, "pushgateway-url", opt.metrics.PushgatewayURL, "Pushgateway URL where the metrics will be pushed")
 	return cmd
 }
 
-func (opt *VSoption) restoreVolumeSnapshot(targetInfo invoker.RestoreTargetInfo) (*restic.RestoreOutput, error) {
+func (opt *VSoption) restoreVolumeSnapshot(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) (*restic.RestoreOutput, error) {
 	// start clock to measure the time takes to restore the volumes
 	startTime := time.Now()
 
 	// If preRestore hook is specified, then execute those hooks first
-	if targetInfo.Hooks != nil && targetInfo.Hooks.PreRestore != nil {
+	hasPreRestoreHandler := targetInfo.Hooks != nil && targetInfo.Hooks.PreRestore != nil
+	if hasPreRestoreHandler || util.HasJobHook(inv.GetObjectMeta().Annotations, apis.PreRestoreHook) {
 		klog.Infoln("Executing preRestore hooks........")
-		podName := meta.PodName()
-		if podName == "" {
-			return nil, fmt.Errorf("failed to execute preRestore hooks. Reason: POD_NAME environment variable not found")
+		if hasPreRestoreHandler {
+			podName := meta.PodName()
+			if podName == "" {
+				return nil, fmt.Errorf("failed to execute preRestore hooks. Reason: POD_NAME environment variable not found")
+			}
+			err := prober.RunProbe(opt.config, targetInfo.Hooks.PreRestore, podName, opt.namespace)
+			if err != nil {
+				return nil, err
+			}
 		}
-		err := prober.RunProbe(opt.config, targetInfo.Hooks.PreRestore, podName, opt.namespace)
+		err := opt.executeRestoreJobHook(inv, targetInfo, apis.PreRestoreHook)
 		if err != nil {
 			return nil, err
 		}
@@ -157,15 +180,37 @@ func (opt *VSoption) restoreVolumeSnapshot(targetInfo invoker.RestoreTargetInfo)
 		},
 	}
 	for i := range pvcList {
+		// restore the PVC from its own VolumeSnapshot if it refers to a VolumeGroupSnapshot
+		if pvcList[i].Namespace == "" {
+			pvcList[i].Namespace = opt.namespace
+		}
+		// VolumeSnapshots of other namespaces are bound into this namespace by the operator unless the cluster supports cross namespace data sources
+		if !util.IsAnnotationTrue(inv.GetObjectMeta().Annotations, util.KeyCrossNamespaceDataSource) {
+			volumesnapshot.ResolveCrossNamespaceDataSource(&pvcList[i])
+		}
+		err := volumesnapshot.ResolveGroupDataSource(opt.snapshotClient, &pvcList[i])
+		if err != nil {
+			if kerr.IsNotFound(err) {
+				restoreOutput.RestoreTargetStatus.Stats = append(restoreOutput.RestoreTargetStatus.Stats, api_v1beta1.HostRestoreStats{
+					Hostname: pvcList[i].Name,
+					Phase:    api_v1beta1.HostRestoreFailed,
+					Error:    fmt.Sprintf("VolumeGroupSnapshot %s/%s has no VolumeSnapshot for PVC %s", opt.namespace, pvcList[i].Spec.DataSource.Name, pvcList[i].Name),
+				})
+				// continue to process next VolumeSnapshot
+				continue
+			}
+			return nil, err
+		}
+
 		// verify that the respective VolumeSnapshot exist
-		if pvcList[i].Spec.DataSource != nil {
-			_, err := opt.snapshotClient.SnapshotV1().VolumeSnapshots(opt.namespace).Get(context.TODO(), pvcList[i].Spec.DataSource.Name, metav1.GetOptions{})
+		if source := snapshotSource(pvcList[i]); source != nil {
+			_, err := opt.snapshotClient.SnapshotV1().VolumeSnapshots(source.Namespace).Get(context.TODO(), source.Name, metav1.GetOptions{})
 			if err != nil {
 				if kerr.IsNotFound(err) { // respective VolumeSnapshot does not exist
 					restoreOutput.RestoreTargetStatus.Stats = append(restoreOutput.RestoreTargetStatus.Stats, api_v1beta1.HostRestoreStats{
 						Hostname: pvcList[i].Name,
 						Phase:    api_v1beta1.HostRestoreFailed,
-						Error:    fmt.Sprintf("VolumeSnapshot %s/%s does not exist", pvcList[i].Namespace, pvcList[i].Spec.DataSource.Name),
+						Error:    fmt.Sprintf("VolumeSnapshot %s/%s does not exist", source.Namespace, source.Name),
 					})
 					// continue to process next VolumeSnapshot
 					continue
@@ -194,6 +239,11 @@ func (opt *VSoption) restoreVolumeSnapshot(targetInfo invoker.RestoreTargetInfo)
 		createdPVCs = append(createdPVCs, *pvc)
 	}
 
+	consumerPolicy, err := util.VerificationConsumerPodPolicy(inv.GetObjectMeta().Annotations)
+	if err != nil {
+		return nil, err
+	}
+
 	// now, wait for the PVCs to be initialized from respective VolumeSnapshot
 	for i := range createdPVCs {
 		// find out the storage class that has been used in this PVC. We need to know it's binding mode to decide whether we should wait
@@ -212,8 +262,26 @@ func (opt *VSoption) restoreVolumeSnapshot(targetInfo invoker.RestoreTargetInfo)
 				return nil, err
 			}
 		}
-		// don't wait for a PVC that uses "WaitForFirstConsumer" binding mode.
-		// this PVC will be bounded when a workload will use it.
+		// don't wait for a PVC that uses "WaitForFirstConsumer" binding mode unless a consumer pod has been enabled.
+		// otherwise, this PVC will be bounded when a workload will use it.
+		if *storageClass.VolumeBindingMode == storage_api_v1.VolumeBindingWaitForFirstConsumer && consumerPolicy != nil {
+			err = opt.verifyWithConsumerPod(consumerPolicy, targetInfo, createdPVCs[i])
+			if err != nil {
+				restoreOutput.RestoreTargetStatus.Stats = append(restoreOutput.RestoreTargetStatus.Stats, api_v1beta1.HostRestoreStats{
+					Hostname: createdPVCs[i].Name,
+					Phase:    api_v1beta1.HostRestoreFailed,
+					Error:    fmt.Sprintf("failed to verify the restored volume. Reason: %v", err),
+				})
+				// continue to process next pvc
+				continue
+			}
+			restoreOutput.RestoreTargetStatus.Stats = append(restoreOutput.RestoreTargetStatus.Stats, api_v1beta1.HostRestoreStats{
+				Hostname: createdPVCs[i].Name,
+				Phase:    api_v1beta1.HostRestoreSucceeded,
+				Duration: time.Since(startTime).String(),
+			})
+			continue
+		}
 		if *storageClass.VolumeBindingMode == storage_api_v1.VolumeBindingWaitForFirstConsumer {
 			restoreOutput.RestoreTargetStatus.Stats = append(restoreOutput.RestoreTargetStatus.Stats, api_v1beta1.HostRestoreStats{
 				Hostname: createdPVCs[i].Name,
@@ -244,15 +312,22 @@ func (opt *VSoption) restoreVolumeSnapshot(targetInfo invoker.RestoreTargetInfo)
 		})
 	}
 	// If postRestore hook is specified, then execute those hooks after restore
-	if targetInfo.Hooks != nil &&
+	hasPostRestoreHandler := targetInfo.Hooks != nil &&
 		targetInfo.Hooks.PostRestore != nil &&
-		targetInfo.Hooks.PostRestore.Handler != nil {
+		targetInfo.Hooks.PostRestore.Handler != nil
+	if hasPostRestoreHandler || util.HasJobHook(inv.GetObjectMeta().Annotations, apis.PostRestoreHook) {
 		klog.Infoln("Executing postRestore hooks........")
-		podName := meta.PodName()
-		if podName == "" {
-			return nil, fmt.Errorf("failed to execute postRestore hook. Reason: POD_NAME environment variable not found")
+		var err error
+		if hasPostRestoreHandler {
+			podName := meta.PodName()
+			if podName == "" {
+				return nil, fmt.Errorf("failed to execute postRestore hook. Reason: POD_NAME environment variable not found")
+			}
+			err = prober.RunProbe(opt.config, targetInfo.Hooks.PostRestore.Handler, podName, opt.namespace)
+		}
+		if err == nil {
+			err = opt.executeRestoreJobHook(inv, targetInfo, apis.PostRestoreHook)
 		}
-		err := prober.RunProbe(opt.config, targetInfo.Hooks.PostRestore.Handler, podName, opt.namespace)
 		if err != nil {
 			return nil, fmt.Errorf("%w Warning: The actual restore process may be succeeded. Hence, the restored data might be present in the target even if the overall RestoreSession phase is 'Failed'", err)
 		}
@@ -261,3 +336,108 @@ func (opt *VSoption) restoreVolumeSnapshot(targetInfo invoker.RestoreTargetInfo)
 
 	return restoreOutput, nil
 }
+
+func (opt *VSoption) executeRestoreJobHook(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo, hookType string) error {
+	summary := inv.GetSummary(targetInfo.Target.Ref, kmapi.ObjectReference{
+		Namespace: inv.GetObjectMeta().Namespace,
+		Name:      inv.GetObjectMeta().Name,
+	})
+	return hooks.ExecuteJobHook(opt.kubeClient, inv, hookType, apis.DefaultHost, summary, inv.GetOwnerRef())
+}
+
+// snapshotSource returns the VolumeSnapshot that the PVC will be restored from. The VolumeSnapshot can be in another
+// namespace if the PVC refers to it through the dataSourceRef.
+func snapshotSource(pvc core.PersistentVolumeClaim) *types.NamespacedName {
+	if ref := pvc.Spec.DataSourceRef; ref != nil && ref.Namespace != nil && *ref.Namespace != "" {
+		return &types.NamespacedName{Namespace: *ref.Namespace, Name: ref.Name}
+	}
+	if pvc.Spec.DataSource != nil {
+		return &types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Spec.DataSource.Name}
+	}
+	return nil
+}
+
+// verifyWithConsumerPod schedules a short-lived pod that mounts the restored PVC so that it gets bound to a volume
+// provisioned from the VolumeSnapshot. The pod honors the node selector, affinity and tolerations of the restore target.
+// If a probe has been specified, it is executed by the pod against the restored data.
+func (opt *VSoption) verifyWithConsumerPod(policy *util.ConsumerPodPolicy, targetInfo invoker.RestoreTargetInfo, pvc core.PersistentVolumeClaim) error {
+	if opt.image == "" {
+		return fmt.Errorf("no image has been specified for the consumer pod")
+	}
+	probe := policy.Probe
+	if probe == "" {
+		probe = "true"
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			// a pod left behind by a previous attempt must not make the verification fail with AlreadyExists
+			GenerateName: meta.ValidNameWithPrefix(consumerPodPrefix, pvc.Name) + "-",
+			Namespace:    pvc.Namespace,
+		},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name:    consumerContainer,
+					Image:   opt.image,
+					Command: []string{"sh", "-c", probe},
+					VolumeMounts: []core.VolumeMount{
+						{
+							Name:      consumerVolume,
+							MountPath: consumerMountPath,
+							ReadOnly:  true,
+						},
+					},
+				},
+			},
+			Volumes: []core.Volume{
+				{
+					Name: consumerVolume,
+					VolumeSource: core.VolumeSource{
+						PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{
+							ClaimName: pvc.Name,
+							ReadOnly:  true,
+						},
+					},
+				},
+			},
+			RestartPolicy: core.RestartPolicyNever,
+		},
+	}
+	if podSettings := targetInfo.RuntimeSettings.Pod; podSettings != nil {
+		pod.Spec.NodeSelector = podSettings.NodeSelector
+		pod.Spec.Affinity = podSettings.Affinity
+		pod.Spec.Tolerations = podSettings.Tolerations
+		pod.Spec.ImagePullSecrets = podSettings.ImagePullSecrets
+		pod.Spec.SecurityContext = podSettings.SecurityContext
+	}
+
+	pod, err := opt.kubeClient.CoreV1().Pods(pod.Namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	klog.Infof("Created consumer pod %s/%s for PVC %s", pod.Namespace, pod.Name, pvc.Name)
+	defer func() {
+		err := opt.kubeClient.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			klog.Warningf("Failed to delete consumer pod %s/%s. Reason: %v", pod.Namespace, pod.Name, err)
+		}
+	}()
+
+	phase, err := util.WaitUntilPodCompleted(opt.kubeClient, pod.ObjectMeta, policy.Timeout)
+	if err != nil {
+		return fmt.Errorf("consumer pod %s/%s did not complete within %s. Last phase: %q", pod.Namespace, pod.Name, policy.Timeout, phase)
+	}
+	if phase == core.PodFailed {
+		return fmt.Errorf("probe %q failed in consumer pod %s/%s", probe, pod.Namespace, pod.Name)
+	}
+
+	claim, err := opt.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(context.TODO(), pvc.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if claim.Status.Phase != core.ClaimBound {
+		return fmt.Errorf("PVC %s/%s is %s even after being consumed", claim.Namespace, claim.Name, claim.Status.Phase)
+	}
+	return nil
+}
@@ -51,6 +51,7 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(NewCmdSnapshots())
 	rootCmd.AddCommand(NewCmdForget())
 	rootCmd.AddCommand(NewCmdCreateBackupSession())
+	rootCmd.AddCommand(NewCmdBackup())
 	rootCmd.AddCommand(NewCmdRestore())
 	rootCmd.AddCommand(NewCmdRunBackup())
 
@@ -63,6 +64,7 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(NewCmdRestoreVolumeSnapshot())
 
 	rootCmd.AddCommand(NewCmdRunHook())
+	rootCmd.AddCommand(NewCmdResticProgress())
 
 	return rootCmd
 }
@@ -28,6 +28,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/stash/pkg/backup"
 	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"github.com/spf13/cobra"
@@ -56,6 +57,8 @@ func NewCmdRunBackup() *cobra.Command {
 		Short:             "Take backup of workload paths",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			defer tracing.SetupFromEnv("stash-" + cmd.Name())()
+
 			config, err := clientcmd.BuildConfigFromFlags(opt.MasterURL, opt.KubeconfigPath)
 			if err != nil {
 				klog.Fatalf("Could not get Kubernetes config: %s", err)
@@ -27,6 +27,8 @@ import (
 	stashHooks "stash.appscode.dev/apimachinery/pkg/hooks"
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
+	"stash.appscode.dev/stash/pkg/hooks"
+	"stash.appscode.dev/stash/pkg/tracing"
 
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -70,6 +72,8 @@ func NewCmdRunHook() *cobra.Command {
 		Short:             "Execute Backup or Restore Hooks",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			defer tracing.SetupFromEnv("stash-" + cmd.Name())()
+
 			config, err := clientcmd.BuildConfigFromFlags(opt.masterURL, opt.kubeConfigPath)
 			if err != nil {
 				klog.Fatalf("Could not get Kubernetes config: %s", err)
@@ -135,22 +139,30 @@ func (opt *hookOptions) executeBackupHook() error {
 		)
 	}
 
-	hookExecutor := stashHooks.BackupHookExecutor{
-		Config:      opt.config,
-		StashClient: opt.stashClient,
-		Invoker:     inv,
-		Target:      targetInfo.Target.Ref,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: opt.targetRef.Namespace,
+	hookExecutor := hooks.BackupHookExecutor{
+		BackupHookExecutor: stashHooks.BackupHookExecutor{
+			Config:      opt.config,
+			StashClient: opt.stashClient,
+			Invoker:     inv,
+			Target:      targetInfo.Target.Ref,
+			ExecutorPod: kmapi.ObjectReference{
+				Namespace: opt.targetRef.Namespace,
+			},
 		},
+		KubeClient: opt.kubeClient,
+		Host:       opt.hostname,
 	}
 	if opt.hookType == apis.PreBackupHook {
-		hookExecutor.Hook = targetInfo.Hooks.PreBackup
 		hookExecutor.HookType = apis.PreBackupHook
+		if targetInfo.Hooks != nil {
+			hookExecutor.Hook = targetInfo.Hooks.PreBackup
+		}
 	} else {
-		hookExecutor.Hook = targetInfo.Hooks.PostBackup.Handler
-		hookExecutor.ExecutionPolicy = targetInfo.Hooks.PostBackup.ExecutionPolicy
 		hookExecutor.HookType = apis.PostBackupHook
+		if targetInfo.Hooks != nil && targetInfo.Hooks.PostBackup != nil {
+			hookExecutor.Hook = targetInfo.Hooks.PostBackup.Handler
+			hookExecutor.ExecutionPolicy = targetInfo.Hooks.PostBackup.ExecutionPolicy
+		}
 	}
 	hookExecutor.ExecutorPod.Name, err = opt.getHookExecutorPodName(targetInfo.Target.Ref)
 	if err != nil {
@@ -190,22 +202,30 @@ func (opt *hookOptions) executeRestoreHook() error {
 		)
 	}
 
-	hookExecutor := stashHooks.RestoreHookExecutor{
-		Config:  opt.config,
-		Invoker: inv,
-		Target:  targetInfo.Target.Ref,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: opt.targetRef.Namespace,
+	hookExecutor := hooks.RestoreHookExecutor{
+		RestoreHookExecutor: stashHooks.RestoreHookExecutor{
+			Config:  opt.config,
+			Invoker: inv,
+			Target:  targetInfo.Target.Ref,
+			ExecutorPod: kmapi.ObjectReference{
+				Namespace: opt.targetRef.Namespace,
+			},
 		},
+		KubeClient: opt.kubeClient,
+		Host:       opt.hostname,
 	}
 
 	if opt.hookType == apis.PreRestoreHook {
-		hookExecutor.Hook = targetInfo.Hooks.PreRestore
 		hookExecutor.HookType = apis.PreRestoreHook
+		if targetInfo.Hooks != nil {
+			hookExecutor.Hook = targetInfo.Hooks.PreRestore
+		}
 	} else {
-		hookExecutor.Hook = targetInfo.Hooks.PostRestore.Handler
-		hookExecutor.ExecutionPolicy = targetInfo.Hooks.PostRestore.ExecutionPolicy
 		hookExecutor.HookType = apis.PostRestoreHook
+		if targetInfo.Hooks != nil && targetInfo.Hooks.PostRestore != nil {
+			hookExecutor.Hook = targetInfo.Hooks.PostRestore.Handler
+			hookExecutor.ExecutionPolicy = targetInfo.Hooks.PostRestore.ExecutionPolicy
+		}
 	}
 	hookExecutor.ExecutorPod.Name, err = opt.getHookExecutorPodName(targetInfo.Target.Ref)
 	if err != nil {
@@ -26,7 +26,10 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/stash/pkg/controller"
+	"stash.appscode.dev/stash/pkg/notification"
+	"stash.appscode.dev/stash/pkg/tracing"
 
+	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
 	"github.com/spf13/pflag"
 	crd_cs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/client-go/kubernetes"
@@ -53,6 +56,11 @@ type ExtraOptions struct {
 	BackupJobPSPNames       []string
 	RestoreJobPSPNames      []string
 	PushgatewayURL          string
+	DisablePushgateway      bool
+	OTLPEndpoint            string
+	OTLPInsecure            bool
+	TraceSampleRatio        float64
+	NotificationHosts       []string
 }
 
 func NewExtraOptions() *ExtraOptions {
@@ -66,6 +74,8 @@ func NewExtraOptions() *ExtraOptions {
 		QPS:            100,
 		Burst:          100,
 		ResyncPeriod:   10 * time.Minute,
+
+		TraceSampleRatio: 1,
 	}
 }
 
@@ -88,6 +98,13 @@ func (s *ExtraOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.StringSliceVar(&s.RestoreJobPSPNames, "restore-job-psp", s.RestoreJobPSPNames, "Name of the PSPs for restore job. Use comma to separate multiple PSP names.")
 
 	fs.StringVar(&s.PushgatewayURL, "pushgateway-url", s.PushgatewayURL, "URL of the Prometheus pushgateway where backup metrics will be pushed.")
+	fs.BoolVar(&s.DisablePushgateway, "disable-pushgateway", s.DisablePushgateway, "If true, backup and restore jobs will not push metrics to the Prometheus pushgateway. The operator serves the same metrics from its /metrics endpoint, except the restore metrics of the RestoreBatches.")
+
+	fs.StringVar(&s.OTLPEndpoint, "otlp-endpoint", s.OTLPEndpoint, "host:port of the OpenTelemetry collector where the traces will be exported using OTLP/gRPC. Tracing is disabled if empty.")
+	fs.BoolVar(&s.OTLPInsecure, "otlp-insecure", s.OTLPInsecure, "If true, the traces are exported without TLS.")
+	fs.Float64Var(&s.TraceSampleRatio, "trace-sample-ratio", s.TraceSampleRatio, "Ratio of the BackupSessions and RestoreSessions that are traced.")
+
+	fs.StringSliceVar(&s.NotificationHosts, "notification-allowed-hosts", s.NotificationHosts, "Hosts the notifiers of the user namespaces may deliver to. An entry starting with a dot allows its subdomains. The notifiers of the user namespaces are disabled if empty. They are never delivered to the loopback, link-local or private addresses.")
 }
 
 func (s *ExtraOptions) ApplyTo(cfg *controller.Config) error {
@@ -121,6 +138,9 @@ func (s *ExtraOptions) ApplyTo(cfg *controller.Config) error {
 	if cfg.AppCatalogClient, err = appcatalog_cs.NewForConfig(cfg.ClientConfig); err != nil {
 		return err
 	}
+	if cfg.SnapshotClient, err = vscs.NewForConfig(cfg.ClientConfig); err != nil {
+		return err
+	}
 
 	// if cluster has OpenShift DeploymentConfig then generate OcClient
 	if discovery.IsPreferredAPIResource(cfg.KubeClient.Discovery(), ocapps.GroupVersion.String(), apis.KindDeploymentConfig) {
@@ -129,7 +149,18 @@ func (s *ExtraOptions) ApplyTo(cfg *controller.Config) error {
 		}
 	}
 
-	metrics.SetPushgatewayURL(s.PushgatewayURL)
+	tracing.SetOptions(tracing.Options{
+		Endpoint:    s.OTLPEndpoint,
+		Insecure:    s.OTLPInsecure,
+		SampleRatio: s.TraceSampleRatio,
+	})
+
+	notification.SetAllowedHosts(s.NotificationHosts)
+
+	// without a pushgateway, the metrics are only served from the /metrics endpoint of the operator
+	if !s.DisablePushgateway {
+		metrics.SetPushgatewayURL(s.PushgatewayURL)
+	}
 	return nil
 }
 
@@ -142,5 +173,8 @@ func (s *ExtraOptions) Validate() []error {
 	if s.StashImageTag == "" {
 		errs = append(errs, fmt.Errorf("--image-tag must be specified"))
 	}
+	if s.TraceSampleRatio < 0 || s.TraceSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("--trace-sample-ratio must be between 0 and 1"))
+	}
 	return errs
 }
@@ -22,7 +22,9 @@ import (
 	"net"
 
 	"stash.appscode.dev/apimachinery/apis/repositories/v1alpha1"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	"stash.appscode.dev/stash/pkg/controller"
+	"stash.appscode.dev/stash/pkg/history"
 	"stash.appscode.dev/stash/pkg/server"
 
 	"github.com/spf13/pflag"
@@ -33,6 +35,7 @@ import (
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
 	"k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/kube-openapi/pkg/common"
 	"kmodules.xyz/client-go/tools/clientcmd"
 )
 
@@ -108,12 +111,12 @@ func (o StashOptions) Config() (*server.StashConfig, error) {
 		"/apis/admission.stash.appscode.com/v1beta1/backupconfigurationvalidators",
 	}
 
-	serverConfig.OpenAPIConfig = genericapiserver.DefaultOpenAPIConfig(v1alpha1.GetOpenAPIDefinitions, openapinamer.NewDefinitionNamer(server.Scheme))
+	serverConfig.OpenAPIConfig = genericapiserver.DefaultOpenAPIConfig(getOpenAPIDefinitions, openapinamer.NewDefinitionNamer(server.Scheme))
 	serverConfig.OpenAPIConfig.Info.Title = "stash-webhook-server"
 	serverConfig.OpenAPIConfig.Info.Version = v1alpha1.SchemeGroupVersion.Version
 	serverConfig.OpenAPIConfig.IgnorePrefixes = ignorePrefixes
 
-	serverConfig.OpenAPIV3Config = genericapiserver.DefaultOpenAPIV3Config(v1alpha1.GetOpenAPIDefinitions, openapinamer.NewDefinitionNamer(server.Scheme))
+	serverConfig.OpenAPIV3Config = genericapiserver.DefaultOpenAPIV3Config(getOpenAPIDefinitions, openapinamer.NewDefinitionNamer(server.Scheme))
 	serverConfig.OpenAPIV3Config.Info.Title = "stash-webhook-server"
 	serverConfig.OpenAPIV3Config.Info.Version = v1alpha1.SchemeGroupVersion.Version
 	serverConfig.OpenAPIV3Config.IgnorePrefixes = ignorePrefixes
@@ -143,3 +146,18 @@ func (o StashOptions) Run(stopCh <-chan struct{}) error {
 
 	return s.Run(stopCh)
 }
+
+// getOpenAPIDefinitions adds the definitions of the BackupHistory types to the generated ones.
+// The definitions of the stash types referred to by the BackupHistory are added as well.
+func getOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	defs := v1alpha1.GetOpenAPIDefinitions(ref)
+	for name, def := range api_v1beta1.GetOpenAPIDefinitions(ref) {
+		if _, ok := defs[name]; !ok {
+			defs[name] = def
+		}
+	}
+	for name, def := range history.GetOpenAPIDefinitions(ref) {
+		defs[name] = def
+	}
+	return defs
+}
@@ -0,0 +1,131 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// Exit codes returned by the commands that wait for a BackupSession or RestoreSession to complete.
+const (
+	ExitCodeSucceeded        = 0
+	ExitCodeFailed           = 1
+	ExitCodeSkipped          = 2
+	ExitCodeDeadlineExceeded = 3
+	ExitCodeError            = 4
+)
+
+type waitOptions struct {
+	wait         bool
+	timeout      time.Duration
+	pollInterval time.Duration
+}
+
+// progressPrinter prints a line only when it differs from the previously printed line for the same key.
+type progressPrinter struct {
+	out  io.Writer
+	last map[string]string
+}
+
+func newProgressPrinter(out io.Writer) *progressPrinter {
+	return &progressPrinter{
+		out:  out,
+		last: map[string]string{},
+	}
+}
+
+func (p *progressPrinter) print(key, line string) {
+	if p.last[key] == line {
+		return
+	}
+	p.last[key] = line
+	_, _ = fmt.Fprintf(p.out, "%s %s\n", time.Now().Format(time.RFC3339), line)
+}
+
+// maxPollBackoff is the longest wait between two status checks after the API server has failed repeatedly.
+const maxPollBackoff = time.Minute
+
+// pollSession calls check every pollInterval until it reports completion. Transient errors of check are
+// retried with an exponential backoff. It returns ExitCodeDeadlineExceeded when the timeout expires
+// before the session has completed, or ExitCodeError if the session could not be checked since the last error.
+func (opt waitOptions) pollSession(check func() (bool, int, error)) (int, error) {
+	ctx := context.Background()
+	if opt.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.timeout)
+		defer cancel()
+	}
+
+	var lastErr error
+	backoff := opt.errorBackoff()
+	for {
+		done, code, err := check()
+		delay := opt.pollInterval
+		switch {
+		case err != nil && !isTransient(err):
+			return ExitCodeError, err
+		case err != nil:
+			lastErr = err
+			delay = backoff.Step()
+			klog.Warningf("Failed to check the session, retrying in %s. Reason: %v", delay, err)
+		case done:
+			return code, nil
+		default:
+			lastErr = nil
+			backoff = opt.errorBackoff()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if lastErr != nil {
+				return ExitCodeError, fmt.Errorf("timed out after %s waiting for the session to complete. Last error: %v", opt.timeout, lastErr)
+			}
+			return ExitCodeDeadlineExceeded, fmt.Errorf("timed out after %s waiting for the session to complete", opt.timeout)
+		case <-timer.C:
+		}
+	}
+}
+
+func (opt waitOptions) errorBackoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: opt.pollInterval,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      maxPollBackoff,
+	}
+}
+
+// isTransient returns false for the errors that retrying does not resolve, i.e. the session has been deleted
+// or the user is not allowed to read it.
+func isTransient(err error) bool {
+	return !(kerr.IsNotFound(err) ||
+		kerr.IsForbidden(err) ||
+		kerr.IsUnauthorized(err) ||
+		kerr.IsBadRequest(err) ||
+		kerr.IsInvalid(err))
+}
@@ -0,0 +1,123 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+var deadlineExceeded = []kmapi.Condition{{Type: api_v1beta1.DeadlineExceeded, Status: metav1.ConditionTrue}}
+
+func TestBackupSessionExitCode(t *testing.T) {
+	cases := []struct {
+		phase      api_v1beta1.BackupSessionPhase
+		conditions []kmapi.Condition
+		done       bool
+		code       int
+	}{
+		{phase: api_v1beta1.BackupSessionPending, done: false, code: ExitCodeSucceeded},
+		{phase: api_v1beta1.BackupSessionRunning, done: false, code: ExitCodeSucceeded},
+		{phase: api_v1beta1.BackupSessionSucceeded, done: true, code: ExitCodeSucceeded},
+		{phase: api_v1beta1.BackupSessionSkipped, done: true, code: ExitCodeSkipped},
+		{phase: api_v1beta1.BackupSessionFailed, done: true, code: ExitCodeFailed},
+		{phase: api_v1beta1.BackupSessionFailed, conditions: deadlineExceeded, done: true, code: ExitCodeDeadlineExceeded},
+	}
+	for _, c := range cases {
+		bs := &api_v1beta1.BackupSession{}
+		bs.Status.Phase = c.phase
+		bs.Status.Conditions = c.conditions
+		done, code := backupSessionExitCode(bs)
+		if done != c.done || code != c.code {
+			t.Errorf("expected done %v and exit code %d for phase %s, found %v and %d", c.done, c.code, c.phase, done, code)
+		}
+	}
+}
+
+func TestRestoreSessionExitCode(t *testing.T) {
+	cases := []struct {
+		phase      api_v1beta1.RestorePhase
+		conditions []kmapi.Condition
+		done       bool
+		code       int
+	}{
+		{phase: api_v1beta1.RestorePending, done: false, code: ExitCodeSucceeded},
+		{phase: api_v1beta1.RestoreRunning, done: false, code: ExitCodeSucceeded},
+		{phase: api_v1beta1.RestoreSucceeded, done: true, code: ExitCodeSucceeded},
+		{phase: api_v1beta1.RestoreFailed, done: true, code: ExitCodeFailed},
+		{phase: api_v1beta1.RestorePhaseInvalid, done: true, code: ExitCodeFailed},
+		{phase: api_v1beta1.RestorePhaseUnknown, done: true, code: ExitCodeFailed},
+		{phase: api_v1beta1.RestoreFailed, conditions: deadlineExceeded, done: true, code: ExitCodeDeadlineExceeded},
+	}
+	for _, c := range cases {
+		rs := &api_v1beta1.RestoreSession{}
+		rs.Status.Phase = c.phase
+		rs.Status.Conditions = c.conditions
+		done, code := restoreSessionExitCode(rs)
+		if done != c.done || code != c.code {
+			t.Errorf("expected done %v and exit code %d for phase %s, found %v and %d", c.done, c.code, c.phase, done, code)
+		}
+	}
+}
+
+func TestPollSession(t *testing.T) {
+	transient := errors.New("connection refused")
+	notFound := kerr.NewNotFound(schema.GroupResource{Group: "stash.appscode.com", Resource: "backupsessions"}, "sample")
+	cases := []struct {
+		name    string
+		timeout time.Duration
+		// results are returned by the checks in order. the last one is repeated.
+		results   []error
+		completed bool
+		code      int
+		expectErr bool
+	}{
+		{name: "completed", results: []error{nil}, completed: true, code: ExitCodeFailed},
+		{name: "transient errors are retried", results: []error{transient, transient, nil}, completed: true, code: ExitCodeFailed},
+		{name: "session deleted", results: []error{notFound}, code: ExitCodeError, expectErr: true},
+		{name: "deadline", timeout: 50 * time.Millisecond, results: []error{nil}, code: ExitCodeDeadlineExceeded, expectErr: true},
+		{name: "deadline while failing", timeout: 50 * time.Millisecond, results: []error{nil, transient}, code: ExitCodeError, expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := 0
+			opt := waitOptions{timeout: c.timeout, pollInterval: time.Millisecond}
+			code, err := opt.pollSession(func() (bool, int, error) {
+				result := c.results[len(c.results)-1]
+				if calls < len(c.results) {
+					result = c.results[calls]
+				}
+				calls++
+				if result != nil {
+					return false, ExitCodeError, result
+				}
+				return c.completed && calls >= len(c.results), ExitCodeFailed, nil
+			})
+			if code != c.code || (err != nil) != c.expectErr {
+				t.Errorf("expected exit code %d and error %v, found %d and %v", c.code, c.expectErr, code, err)
+			}
+		})
+	}
+}
@@ -24,6 +24,7 @@ import (
 	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/stash/pkg/status"
+	"stash.appscode.dev/stash/pkg/tracing"
 
 	"github.com/spf13/cobra"
 	"gomodules.xyz/flags"
@@ -47,6 +48,8 @@ func NewCmdUpdateStatus() *cobra.Command {
 		Short:             "Update status of Repository, Backup/Restore Session",
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			defer tracing.SetupFromEnv("stash-" + cmd.Name())()
+
 			flags.EnsureRequiredFlags(cmd, "namespace", "output-dir")
 
 			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
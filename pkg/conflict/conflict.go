@@ -0,0 +1,331 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conflict
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/util"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// SiblingSuffix is appended to a restored path to get its sibling directory for the Sibling policy.
+const SiblingSuffix = ".restored"
+
+// Stats is the number of files of a host handled by the conflict policy.
+type Stats struct {
+	Target   string              `json:"target,omitempty"`
+	Hostname string              `json:"hostname"`
+	Policy   util.ConflictPolicy `json:"policy"`
+	// Restored is the number of files that did not exist in the target.
+	Restored    int64 `json:"restored"`
+	Overwritten int64 `json:"overwritten"`
+	Skipped     int64 `json:"skipped"`
+	Deleted     int64 `json:"deleted"`
+}
+
+// Summary returns a human readable summary of the stats.
+func (s Stats) Summary() string {
+	return fmt.Sprintf("%d restored, %d overwritten, %d skipped and %d deleted file(s) with %s policy",
+		s.Restored, s.Overwritten, s.Skipped, s.Deleted, s.Policy)
+}
+
+// Restorer enforces a conflict policy with the flags of restic restore. SkipExisting and OverwriteIfNewer are
+// "--overwrite never" and "--overwrite if-newer". Mirror and Sibling restore every path of the snapshots on its own
+// using the "<snapshot>:<path>" syntax of restic. Mirror restores the path in place with "--delete" and Sibling
+// restores it into the sibling directory.
+type Restorer struct {
+	Policy util.ConflictPolicy
+	// Roots are the restored paths of the snapshots. They are only known for Mirror and Sibling.
+	Roots []string
+	// Destination is the directory the snapshots would be restored into without the policy.
+	Destination string
+	// Parts are the restic restores enforcing the policy.
+	Parts []restic.RestoreOptions
+	// ProgressFile is the progress file of the restic wrapper. The summaries of the restores are read next to it.
+	ProgressFile string
+}
+
+// NewRestorer returns a Restorer enforcing the policy on the restore options. It returns nil for the Overwrite
+// policy as this is how restic restores. The restored snapshots are listed with w to find the restored paths.
+// The restic wrapper must have been created by progress.NewResticWrapper with the same scratch directory.
+func NewRestorer(w *restic.ResticWrapper, policy util.ConflictPolicy, opt restic.RestoreOptions, scratchDir string) (*Restorer, error) {
+	r := &Restorer{
+		Policy:       policy,
+		Destination:  opt.Destination,
+		ProgressFile: filepath.Join(scratchDir, progress.DefaultFileName),
+	}
+	if r.Destination == "" {
+		r.Destination = "/"
+	}
+	switch policy {
+	case util.ConflictPolicyOverwrite:
+		return nil, nil
+	case util.ConflictPolicySkipExisting:
+		r.Parts = []restic.RestoreOptions{withArgs(opt, "--overwrite", "never", "--verbose=2")}
+		return r, nil
+	case util.ConflictPolicyOverwriteIfNewer:
+		r.Parts = []restic.RestoreOptions{withArgs(opt, "--overwrite", "if-newer", "--verbose=2")}
+		return r, nil
+	}
+
+	// restore every path of the snapshots on its own, so that the target of each can be chosen
+	type source struct {
+		snapshot string
+		paths    []string
+		args     []string
+	}
+	var sources []source
+	if len(opt.Snapshots) != 0 {
+		snapshots, err := w.ListSnapshots(opt.Snapshots)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range snapshots {
+			sources = append(sources, source{snapshot: s.ID, paths: s.Paths})
+		}
+	} else {
+		for _, path := range opt.RestorePaths {
+			// the latest snapshot of the path is selected the same way restic does without the policy
+			args := []string{"--path", path}
+			if opt.SourceHost != "" {
+				args = append(args, "--host", opt.SourceHost)
+			}
+			sources = append(sources, source{snapshot: "latest", paths: []string{path}, args: args})
+		}
+	}
+	for _, src := range sources {
+		for _, root := range src.paths {
+			root = filepath.Clean(root)
+			includes, excludes, ok := rebase(opt.Include, opt.Exclude, root)
+			if !ok {
+				klog.Infof("Skipping restore of %s. Reason: it is not selected by the include and exclude patterns", root)
+				continue
+			}
+			part := withArgs(opt, append(src.args, "--verbose=2")...)
+			part.Snapshots = []string{src.snapshot + ":" + root}
+			part.RestorePaths = nil
+			part.SourceHost = ""
+			part.Include = includes
+			part.Exclude = excludes
+			part.Destination = filepath.Join(r.Destination, root)
+			if policy == util.ConflictPolicyMirror {
+				part.Args = append(part.Args, "--delete")
+			} else {
+				part.Destination += SiblingSuffix
+			}
+			r.Roots = append(r.Roots, root)
+			r.Parts = append(r.Parts, part)
+		}
+	}
+	if len(r.Parts) == 0 {
+		return nil, fmt.Errorf("no path to restore has been found")
+	}
+	return r, nil
+}
+
+func withArgs(opt restic.RestoreOptions, args ...string) restic.RestoreOptions {
+	opt.Args = append(append([]string{}, opt.Args...), args...)
+	return opt
+}
+
+// rebase returns the include and exclude patterns for the restore of root with the "<snapshot>:<path>" syntax,
+// where restic matches the patterns against the paths relative to root. Relative patterns are kept as they are.
+// Absolute patterns are compared literally with root. It returns false if nothing of root would be restored.
+func rebase(includes, excludes []string, root string) ([]string, []string, bool) {
+	all := len(includes) == 0
+	var rebasedIncludes []string
+	for _, p := range includes {
+		rebased, covers, inside := rebasePattern(p, root)
+		if covers {
+			all = true
+			break
+		}
+		if inside {
+			rebasedIncludes = append(rebasedIncludes, rebased)
+		}
+	}
+	if all {
+		rebasedIncludes = nil
+	} else if len(rebasedIncludes) == 0 {
+		return nil, nil, false
+	}
+	var rebasedExcludes []string
+	for _, p := range excludes {
+		rebased, covers, inside := rebasePattern(p, root)
+		if covers {
+			return nil, nil, false
+		}
+		if inside {
+			rebasedExcludes = append(rebasedExcludes, rebased)
+		}
+	}
+	return rebasedIncludes, rebasedExcludes, true
+}
+
+// rebasePattern rebases a pattern to root. covers is true if the pattern selects root as a whole
+// and inside is true if it selects a part of root.
+func rebasePattern(pattern, root string) (rebased string, covers, inside bool) {
+	if !filepath.IsAbs(pattern) {
+		return pattern, false, true
+	}
+	p := filepath.Clean(pattern)
+	if p == root || p == "/" || strings.HasPrefix(root, p+"/") {
+		return "", true, false
+	}
+	if root == "/" {
+		return p, false, true
+	}
+	if strings.HasPrefix(p, root+"/") {
+		return strings.TrimPrefix(p, root), false, true
+	}
+	return "", false, false
+}
+
+// Restore runs the restic restores enforcing the policy and returns the stats taken from their summaries.
+func (r *Restorer) Restore(w *restic.ResticWrapper, targetRef api_v1beta1.TargetRef) (*restic.RestoreOutput, Stats, error) {
+	// the summaries of earlier restores of the same run are not ours
+	before, err := progress.ReadRestoreSummaries(r.ProgressFile)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+	startTime := time.Now()
+	var output *restic.RestoreOutput
+	for _, part := range r.Parts {
+		out, err := w.RunRestore(part, targetRef)
+		if err != nil {
+			return nil, Stats{}, err
+		}
+		if output == nil {
+			output = out
+		}
+	}
+	for i := range output.RestoreTargetStatus.Stats {
+		output.RestoreTargetStatus.Stats[i].Duration = time.Since(startTime).String()
+	}
+
+	summaries, err := progress.ReadRestoreSummaries(r.ProgressFile)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+	if len(summaries)-len(before) != len(r.Parts) {
+		klog.Warningf("Found %d restic restore summaries for %d restores. The conflict stats may be incomplete", len(summaries)-len(before), len(r.Parts))
+	}
+	return output, r.stats(summaries[len(before):]), nil
+}
+
+// stats sums up the summaries of the restic restores. restic counts the overwritten files as restored.
+func (r *Restorer) stats(summaries []progress.RestoreSummary) Stats {
+	stats := Stats{Policy: r.Policy}
+	for _, s := range summaries {
+		restored := int64(s.FilesRestored) - int64(s.FilesUpdated)
+		if restored < 0 {
+			restored = 0
+		}
+		stats.Restored += restored
+		stats.Overwritten += int64(s.FilesUpdated)
+		stats.Skipped += int64(s.FilesSkipped)
+		stats.Deleted += int64(s.FilesDeleted)
+	}
+	return stats
+}
+
+// Locate returns where a file of the snapshot has been restored to.
+func (r *Restorer) Locate(path string) string {
+	if r.Policy == util.ConflictPolicySibling {
+		// use the longest root containing the path
+		root := ""
+		for _, p := range r.Roots {
+			if (path == p || p == "/" || strings.HasPrefix(path, p+"/")) && len(p) > len(root) {
+				root = p
+			}
+		}
+		if root != "" {
+			path = filepath.Join(root+SiblingSuffix, strings.TrimPrefix(path, root))
+		}
+	}
+	return filepath.Join(r.Destination, path)
+}
+
+// Record stores the stats of the host in the annotations of the restore invoker and writes an event.
+func Record(stashClient cs.Interface, inv invoker.RestoreInvoker, eventSource string, s Stats) error {
+	update := func(annotations map[string]string) map[string]string {
+		annotations, err := Upsert(annotations, s)
+		if err != nil {
+			klog.Errorln(err)
+		}
+		return annotations
+	}
+	if err := util.UpdateRestoreInvokerAnnotations(stashClient, inv, update); err != nil {
+		return err
+	}
+	return inv.CreateEvent(
+		core.EventTypeNormal,
+		eventSource,
+		eventer.EventReasonRestoreConflictsResolved,
+		fmt.Sprintf("Restored host %q: %s", s.Hostname, s.Summary()),
+	)
+}
+
+// FromAnnotations returns the conflict stats of the hosts of a restore invoker.
+func FromAnnotations(annotations map[string]string) ([]Stats, error) {
+	val, ok := annotations[util.KeyRestoreConflicts]
+	if !ok {
+		return nil, nil
+	}
+	var stats []Stats
+	if err := json.Unmarshal([]byte(val), &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Upsert adds or replaces the stats of the host of a target in the annotations.
+func Upsert(annotations map[string]string, s Stats) (map[string]string, error) {
+	stats, _ := FromAnnotations(annotations)
+	found := false
+	for i := range stats {
+		if stats[i].Target == s.Target && stats[i].Hostname == s.Hostname {
+			stats[i] = s
+			found = true
+		}
+	}
+	if !found {
+		stats = append(stats, s)
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return annotations, err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[util.KeyRestoreConflicts] = string(data)
+	return annotations, nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conflict
+
+import (
+	"reflect"
+	"testing"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/util"
+)
+
+func TestNewRestorer(t *testing.T) {
+	opt := restic.RestoreOptions{
+		Host:         "host-0",
+		SourceHost:   "host-0",
+		RestorePaths: []string{"/data", "/logs"},
+		Destination:  "/restore",
+		Exclude:      []string{"*.tmp", "/logs"},
+		Args:         []string{"--no-lock"},
+	}
+	cases := []struct {
+		policy util.ConflictPolicy
+		parts  []restic.RestoreOptions
+	}{
+		{
+			policy: util.ConflictPolicyOverwrite,
+		},
+		{
+			policy: util.ConflictPolicySkipExisting,
+			parts: []restic.RestoreOptions{{
+				Host:         "host-0",
+				SourceHost:   "host-0",
+				RestorePaths: []string{"/data", "/logs"},
+				Destination:  "/restore",
+				Exclude:      []string{"*.tmp", "/logs"},
+				Args:         []string{"--no-lock", "--overwrite", "never", "--verbose=2"},
+			}},
+		},
+		{
+			policy: util.ConflictPolicyOverwriteIfNewer,
+			parts: []restic.RestoreOptions{{
+				Host:         "host-0",
+				SourceHost:   "host-0",
+				RestorePaths: []string{"/data", "/logs"},
+				Destination:  "/restore",
+				Exclude:      []string{"*.tmp", "/logs"},
+				Args:         []string{"--no-lock", "--overwrite", "if-newer", "--verbose=2"},
+			}},
+		},
+		{
+			policy: util.ConflictPolicyMirror,
+			parts: []restic.RestoreOptions{{
+				Host:        "host-0",
+				Snapshots:   []string{"latest:/data"},
+				Destination: "/restore/data",
+				Exclude:     []string{"*.tmp"},
+				Args:        []string{"--no-lock", "--path", "/data", "--host", "host-0", "--verbose=2", "--delete"},
+			}},
+		},
+		{
+			policy: util.ConflictPolicySibling,
+			parts: []restic.RestoreOptions{{
+				Host:        "host-0",
+				Snapshots:   []string{"latest:/data"},
+				Destination: "/restore/data.restored",
+				Exclude:     []string{"*.tmp"},
+				Args:        []string{"--no-lock", "--path", "/data", "--host", "host-0", "--verbose=2"},
+			}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			r, err := NewRestorer(nil, c.policy, opt, "/tmp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.parts == nil {
+				if r != nil {
+					t.Errorf("expected no restorer, found %+v", r)
+				}
+				return
+			}
+			if !reflect.DeepEqual(r.Parts, c.parts) {
+				t.Errorf("expected restores %+v, found %+v", c.parts, r.Parts)
+			}
+		})
+	}
+	if !reflect.DeepEqual(opt.Args, []string{"--no-lock"}) {
+		t.Errorf("expected the restore options to be left alone, found %v", opt.Args)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	cases := []struct {
+		name     string
+		includes []string
+		excludes []string
+		root     string
+		// expected
+		rebasedIncludes []string
+		rebasedExcludes []string
+		ok              bool
+	}{
+		{name: "no patterns", root: "/data", ok: true},
+		{name: "relative patterns are kept", includes: []string{"*.sql"}, excludes: []string{"cache"}, root: "/data", rebasedIncludes: []string{"*.sql"}, rebasedExcludes: []string{"cache"}, ok: true},
+		{name: "patterns under root", includes: []string{"/data/db"}, excludes: []string{"/data/db/tmp"}, root: "/data", rebasedIncludes: []string{"/db"}, rebasedExcludes: []string{"/db/tmp"}, ok: true},
+		{name: "include of root", includes: []string{"/data", "/other"}, root: "/data", ok: true},
+		{name: "include of a parent", includes: []string{"/var"}, root: "/var/lib/data", ok: true},
+		{name: "include of another path", includes: []string{"/other"}, root: "/data", ok: false},
+		{name: "exclude of root", excludes: []string{"/data"}, root: "/data", ok: false},
+		{name: "exclude of another path", excludes: []string{"/data2/x"}, root: "/data", ok: true},
+		{name: "root of the file system", includes: []string{"/data"}, root: "/", rebasedIncludes: []string{"/data"}, ok: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			includes, excludes, ok := rebase(c.includes, c.excludes, c.root)
+			if ok != c.ok {
+				t.Fatalf("expected ok %v, found %v", c.ok, ok)
+			}
+			if !reflect.DeepEqual(includes, c.rebasedIncludes) || !reflect.DeepEqual(excludes, c.rebasedExcludes) {
+				t.Errorf("expected includes %v and excludes %v, found %v and %v", c.rebasedIncludes, c.rebasedExcludes, includes, excludes)
+			}
+		})
+	}
+}
+
+func TestLocate(t *testing.T) {
+	cases := []struct {
+		policy   util.ConflictPolicy
+		path     string
+		expected string
+	}{
+		{policy: util.ConflictPolicyMirror, path: "/data/a", expected: "/restore/data/a"},
+		{policy: util.ConflictPolicySibling, path: "/data/a", expected: "/restore/data.restored/a"},
+		{policy: util.ConflictPolicySibling, path: "/data/db/a", expected: "/restore/data/db.restored/a"},
+		{policy: util.ConflictPolicySibling, path: "/database/a", expected: "/restore/database/a"},
+	}
+	for _, c := range cases {
+		r := &Restorer{Policy: c.policy, Roots: []string{"/data", "/data/db"}, Destination: "/restore"}
+		if found := r.Locate(c.path); found != c.expected {
+			t.Errorf("expected %s to be located at %s with %s policy, found %s", c.path, c.expected, c.policy, found)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	r := &Restorer{Policy: util.ConflictPolicyMirror}
+	stats := r.stats([]progress.RestoreSummary{
+		{FilesRestored: 5, FilesUpdated: 2, FilesSkipped: 3, FilesDeleted: 1},
+		{FilesRestored: 1, FilesDeleted: 2},
+	})
+	expected := Stats{Policy: util.ConflictPolicyMirror, Restored: 4, Overwritten: 2, Skipped: 3, Deleted: 3}
+	if stats != expected {
+		t.Errorf("expected stats %+v, found %+v", expected, stats)
+	}
+}
@@ -107,9 +107,54 @@ func (c *StashController) validateBackupConfiguration(bc *api_v1beta1.BackupConf
 			return err
 		}
 	}
+	if err := c.validateBackupDependencies(bc); err != nil {
+		return err
+	}
+	if err := validateBackupFromSnapshot(bc); err != nil {
+		return err
+	}
+	if err := validateBackupAnnotations(bc); err != nil {
+		return err
+	}
 	return c.validateAgainstUsagePolicy(bc.Spec.Repository, bc.Namespace)
 }
 
+// validateBackupAnnotations rejects the invalid values of the annotations that configure the backup,
+// so that they are not found out only when a BackupSession has completed.
+func validateBackupAnnotations(bc *api_v1beta1.BackupConfiguration) error {
+	if _, err := util.BackupHistoryRecords(bc.Annotations); err != nil {
+		return err
+	}
+	if _, err := util.RetentionKeepWithin(bc.Annotations); err != nil {
+		return err
+	}
+	if _, err := util.ChecksumManifestSource(bc.Annotations); err != nil {
+		return err
+	}
+	if err := util.ValidateJobHooks(bc.Annotations); err != nil {
+		return err
+	}
+	verification, err := util.VerificationPolicy(bc.Annotations)
+	if err != nil {
+		return err
+	}
+	if verification != nil {
+		// the backed up files are restored into scratch PVCs. the data of a Task can only be restored by its own Function.
+		if bc.Spec.Driver == api_v1beta1.VolumeSnapshotter || bc.Spec.Target == nil || bc.Spec.Task.Name != "" {
+			return fmt.Errorf("restore verification is supported only for the workload volumes backed up by the %s driver", api_v1beta1.ResticSnapshotter)
+		}
+	}
+	freeze, err := util.FreezeOptionsFor(bc.Annotations)
+	if err != nil {
+		return err
+	}
+	if freeze != nil && bc.Spec.Driver != api_v1beta1.VolumeSnapshotter {
+		// restic reads the files for the whole backup. freezing them would block the writes of the workload for as long.
+		return fmt.Errorf("annotation %q is supported only with the %s driver", util.KeyFreeze, api_v1beta1.VolumeSnapshotter)
+	}
+	return nil
+}
+
 func (c *StashController) initBackupConfigurationWatcher() {
 	c.bcInformer = c.stashInformerFactory.Stash().V1beta1().BackupConfigurations().Informer()
 	c.bcQueue = queue.New(api_v1beta1.ResourceKindBackupConfiguration, c.MaxNumRequeues, c.NumThreads, c.runBackupConfigurationProcessor)
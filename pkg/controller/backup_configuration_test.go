@@ -0,0 +1,78 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+)
+
+func TestValidateBackupAnnotations(t *testing.T) {
+	claim := `{"spec":{"resources":{"requests":{"storage":"1Gi"}}}}`
+	cases := []struct {
+		name        string
+		driver      api_v1beta1.Snapshotter
+		target      *api_v1beta1.BackupTarget
+		task        string
+		annotations map[string]string
+		expectErr   bool
+	}{
+		{name: "no annotations", expectErr: false},
+		{name: "backup history records", annotations: map[string]string{util.KeyBackupHistoryRecords: "10"}, expectErr: false},
+		{name: "no backup history", annotations: map[string]string{util.KeyBackupHistoryRecords: "0"}, expectErr: false},
+		{name: "negative backup history records", annotations: map[string]string{util.KeyBackupHistoryRecords: "-1"}, expectErr: true},
+		{name: "invalid backup history records", annotations: map[string]string{util.KeyBackupHistoryRecords: "ten"}, expectErr: true},
+		{name: "keep within", annotations: map[string]string{util.KeyKeepWithin: "1y2m", util.KeyKeepWithinDaily: "7d"}, expectErr: false},
+		{name: "invalid keep within", annotations: map[string]string{util.KeyKeepWithin: "7 days"}, expectErr: true},
+		{name: "empty keep within hourly", annotations: map[string]string{util.KeyKeepWithinHourly: ""}, expectErr: true},
+		{name: "keep within yearly without unit", annotations: map[string]string{util.KeyKeepWithinYearly: "2"}, expectErr: true},
+		{name: "checksum manifest from local files", annotations: map[string]string{util.KeyChecksumManifest: "local"}, expectErr: false},
+		{name: "invalid checksum manifest", annotations: map[string]string{util.KeyChecksumManifest: "remote"}, expectErr: true},
+		{name: "freeze with VolumeSnapshotter", driver: api_v1beta1.VolumeSnapshotter, annotations: map[string]string{util.KeyFreeze: "true"}, expectErr: false},
+		{name: "freeze with restic", driver: api_v1beta1.ResticSnapshotter, annotations: map[string]string{util.KeyFreeze: "true"}, expectErr: true},
+		{name: "freeze with the default driver", annotations: map[string]string{util.KeyFreeze: "true"}, expectErr: true},
+		{name: "freeze disabled with restic", driver: api_v1beta1.ResticSnapshotter, annotations: map[string]string{util.KeyFreeze: "false"}, expectErr: false},
+		{name: "invalid freeze", driver: api_v1beta1.VolumeSnapshotter, annotations: map[string]string{util.KeyFreeze: "yes please"}, expectErr: true},
+		{
+			name:   "verify workload volumes",
+			target: &api_v1beta1.BackupTarget{},
+			annotations: map[string]string{
+				util.KeyVerifyEvery:               "3",
+				util.KeyVerifyVolumeClaimTemplate: claim,
+			},
+			expectErr: false,
+		},
+		{name: "verify Task target", target: &api_v1beta1.BackupTarget{}, task: "mysql-backup-8.0.14", annotations: map[string]string{util.KeyVerifyEvery: "3", util.KeyVerifyVolumeClaimTemplate: claim}, expectErr: true},
+		{name: "verify VolumeSnapshot", driver: api_v1beta1.VolumeSnapshotter, target: &api_v1beta1.BackupTarget{}, annotations: map[string]string{util.KeyVerifyInterval: "24h", util.KeyVerifyVolumeClaimTemplate: claim}, expectErr: true},
+		{name: "verify without target", annotations: map[string]string{util.KeyVerifyInterval: "24h", util.KeyVerifyVolumeClaimTemplate: claim}, expectErr: true},
+		{name: "invalid verify every", target: &api_v1beta1.BackupTarget{}, annotations: map[string]string{util.KeyVerifyEvery: "0", util.KeyVerifyVolumeClaimTemplate: claim}, expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bc := &api_v1beta1.BackupConfiguration{}
+			bc.Annotations = c.annotations
+			bc.Spec.Driver = c.driver
+			bc.Spec.Target = c.target
+			bc.Spec.Task.Name = c.task
+			if err := validateBackupAnnotations(bc); (err != nil) != c.expectErr {
+				t.Errorf("expected error %v, found %v", c.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	condutil "kmodules.xyz/client-go/conditions"
+)
+
+const (
+	// BackupDependenciesSatisfied indicates whether the BackupConfigurations referenced by the
+	// invoker have a fresh successful BackupSession.
+	BackupDependenciesSatisfied = "BackupDependenciesSatisfied"
+
+	WaitingForBackupDependencies     = "WaitingForBackupDependencies"
+	SuccessfullyResolvedDependencies = "SuccessfullyResolvedDependencies"
+)
+
+type dependencyState int
+
+const (
+	dependencySatisfied dependencyState = iota
+	dependencyPending
+	dependencyFailed
+)
+
+type dependencyResult struct {
+	state  dependencyState
+	reason string
+	err    error
+}
+
+// checkBackupDependencies evaluates the dependencies of the invoker. It returns the overall state
+// along with a human readable reason when the dependencies are not satisfied. The dependencies are
+// evaluated once per reconciliation, so that the skip and the wait decisions agree with each other.
+func (r *backupSessionReconciler) checkBackupDependencies() (dependencyState, string, error) {
+	if r.dependencies == nil {
+		state, reason, err := r.evaluateBackupDependencies()
+		r.dependencies = &dependencyResult{state: state, reason: reason, err: err}
+	}
+	return r.dependencies.state, r.dependencies.reason, r.dependencies.err
+}
+
+func (r *backupSessionReconciler) evaluateBackupDependencies() (dependencyState, string, error) {
+	invMeta := r.invoker.GetObjectMeta()
+	deps, err := util.BackupDependencies(invMeta.Annotations, invMeta.Namespace)
+	if err != nil || len(deps) == 0 {
+		return dependencySatisfied, "", err
+	}
+	freshness, err := util.DependencyFreshness(invMeta.Annotations)
+	if err != nil {
+		return dependencySatisfied, "", err
+	}
+
+	sessionCreated := r.session.GetObjectMeta().CreationTimestamp.Time
+	windowStart := sessionCreated.Add(-freshness)
+
+	var waitingFor []string
+	for _, dep := range deps {
+		state, reason, err := r.ctrl.evaluateBackupDependency(dep, windowStart)
+		if err != nil {
+			return dependencySatisfied, "", err
+		}
+		switch state {
+		case dependencyFailed:
+			return dependencyFailed, fmt.Sprintf("Skipped taking backup. Reason: dependency BackupConfiguration %s/%s %s.",
+				dep.Namespace,
+				dep.Name,
+				reason,
+			), nil
+		case dependencyPending:
+			waitingFor = append(waitingFor, fmt.Sprintf("%s/%s (%s)", dep.Namespace, dep.Name, reason))
+		}
+	}
+
+	if len(waitingFor) == 0 {
+		return dependencySatisfied, "", nil
+	}
+
+	// don't wait longer than the freshness window. any success after that would not be fresh for this session.
+	if time.Since(sessionCreated) > freshness {
+		return dependencyFailed, fmt.Sprintf("Skipped taking backup. Reason: dependencies did not succeed within %s: %s.",
+			freshness,
+			strings.Join(waitingFor, ", "),
+		), nil
+	}
+	return dependencyPending, fmt.Sprintf("Waiting for dependencies: %s.", strings.Join(waitingFor, ", ")), nil
+}
+
+func (c *StashController) evaluateBackupDependency(dep kmapi.ObjectReference, windowStart time.Time) (dependencyState, string, error) {
+	_, err := c.bcLister.BackupConfigurations(dep.Namespace).Get(dep.Name)
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return dependencyFailed, "does not exist", nil
+		}
+		return dependencySatisfied, "", err
+	}
+
+	sessions, err := c.backupSessionLister.BackupSessions(dep.Namespace).List(labels.SelectorFromSet(map[string]string{
+		apis.LabelInvokerType: api_v1beta1.ResourceKindBackupConfiguration,
+		apis.LabelInvokerName: dep.Name,
+	}))
+	if err != nil {
+		return dependencySatisfied, "", err
+	}
+
+	// keep the latest BackupSession first
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreationTimestamp.After(sessions[j].CreationTimestamp.Time)
+	})
+
+	for _, s := range sessions {
+		if s.CreationTimestamp.Time.Before(windowStart) {
+			break
+		}
+		if s.Status.Phase == api_v1beta1.BackupSessionSucceeded {
+			return dependencySatisfied, "", nil
+		}
+	}
+
+	for _, s := range sessions {
+		if s.CreationTimestamp.Time.Before(windowStart) {
+			break
+		}
+		switch s.Status.Phase {
+		case "", api_v1beta1.BackupSessionPending, api_v1beta1.BackupSessionRunning:
+			return dependencyPending, fmt.Sprintf("BackupSession %s is %q", s.Name, phaseOrPending(s.Status.Phase)), nil
+		case api_v1beta1.BackupSessionFailed:
+			if s.Spec.RetryLeft > 0 && !alreadyRetried(s) {
+				return dependencyPending, fmt.Sprintf("BackupSession %s has failed and will be retried", s.Name), nil
+			}
+			return dependencyFailed, fmt.Sprintf("has failed in BackupSession %s", s.Name), nil
+		case api_v1beta1.BackupSessionSkipped:
+			return dependencyFailed, fmt.Sprintf("has skipped BackupSession %s", s.Name), nil
+		}
+	}
+	return dependencyPending, "no BackupSession found within the freshness window", nil
+}
+
+func phaseOrPending(phase api_v1beta1.BackupSessionPhase) api_v1beta1.BackupSessionPhase {
+	if phase == "" {
+		return api_v1beta1.BackupSessionPending
+	}
+	return phase
+}
+
+func (r *backupSessionReconciler) setBackupDependenciesSatisfiedCondition(satisfied bool, reason string) error {
+	cond := kmapi.Condition{
+		Type:               BackupDependenciesSatisfied,
+		Status:             metav1.ConditionTrue,
+		Reason:             SuccessfullyResolvedDependencies,
+		Message:            "All dependencies have a successful BackupSession within the freshness window.",
+		LastTransitionTime: metav1.Now(),
+	}
+	if !satisfied {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = WaitingForBackupDependencies
+		cond.Message = reason
+	}
+
+	// avoid updating the status on every requeue when nothing has changed
+	_, cur := condutil.GetCondition(r.session.GetConditions(), BackupDependenciesSatisfied)
+	if cur != nil && cur.Status == cond.Status && cur.Message == cond.Message {
+		return nil
+	}
+	return r.session.UpdateStatus(&api_v1beta1.BackupSessionStatus{
+		Conditions: []kmapi.Condition{cond},
+	})
+}
+
+// validateBackupDependencies makes sure the dependency references of a BackupConfiguration
+// are well-formed and that they don't form a cycle with the existing BackupConfigurations.
+func (c *StashController) validateBackupDependencies(bc *api_v1beta1.BackupConfiguration) error {
+	deps, err := util.BackupDependencies(bc.Annotations, bc.Namespace)
+	if err != nil {
+		return err
+	}
+	if _, err := util.DependencyFreshness(bc.Annotations); err != nil {
+		return err
+	}
+	if len(deps) == 0 {
+		return nil
+	}
+
+	graph := map[string][]string{}
+	configs, err := c.bcLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		cfgDeps, err := util.BackupDependencies(cfg.Annotations, cfg.Namespace)
+		if err != nil {
+			// ignore the invalid ones. they have been rejected already.
+			continue
+		}
+		graph[dependencyKey(cfg.Namespace, cfg.Name)] = dependencyKeys(cfgDeps)
+	}
+	self := dependencyKey(bc.Namespace, bc.Name)
+	graph[self] = dependencyKeys(deps)
+
+	if cycle := findDependencyCycle(graph, self); cycle != nil {
+		return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// findDependencyCycle returns the path of a cycle reachable from start, or nil if there is none.
+func findDependencyCycle(graph map[string][]string, start string) []string {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	state := map[string]int{}
+	var path []string
+
+	var visit func(node string) []string
+	visit = func(node string) []string {
+		state[node] = inProgress
+		path = append(path, node)
+		for _, next := range graph[node] {
+			switch state[next] {
+			case inProgress:
+				for i := range path {
+					if path[i] == next {
+						return append(append([]string{}, path[i:]...), next)
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = done
+		return nil
+	}
+	return visit(start)
+}
+
+func dependencyKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func dependencyKeys(refs []kmapi.ObjectReference) []string {
+	keys := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		keys = append(keys, dependencyKey(ref.Namespace, ref.Name))
+	}
+	return keys
+}
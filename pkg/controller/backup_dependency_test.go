@@ -0,0 +1,134 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	stash_listers_v1beta1 "stash.appscode.dev/apimachinery/client/listers/stash/v1beta1"
+
+	"gomodules.xyz/pointer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+func TestFindDependencyCycle(t *testing.T) {
+	cases := []struct {
+		name     string
+		graph    map[string][]string
+		start    string
+		expected []string
+	}{
+		{name: "no dependency", graph: map[string][]string{"demo/a": nil}, start: "demo/a"},
+		{name: "chain", graph: map[string][]string{"demo/a": {"demo/b"}, "demo/b": {"demo/c"}}, start: "demo/a"},
+		{name: "diamond", graph: map[string][]string{"demo/a": {"demo/b", "demo/c"}, "demo/b": {"demo/d"}, "demo/c": {"demo/d"}}, start: "demo/a"},
+		{name: "self", graph: map[string][]string{"demo/a": {"demo/a"}}, start: "demo/a", expected: []string{"demo/a", "demo/a"}},
+		{name: "cycle through start", graph: map[string][]string{"demo/a": {"demo/b"}, "demo/b": {"db/c"}, "db/c": {"demo/a"}}, start: "demo/a", expected: []string{"demo/a", "demo/b", "db/c", "demo/a"}},
+		{name: "cycle further down", graph: map[string][]string{"demo/a": {"demo/b"}, "demo/b": {"demo/c"}, "demo/c": {"demo/b"}}, start: "demo/a", expected: []string{"demo/b", "demo/c", "demo/b"}},
+		{name: "missing invoker", graph: map[string][]string{"demo/a": {"demo/missing"}}, start: "demo/a"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if cycle := findDependencyCycle(c.graph, c.start); !reflect.DeepEqual(cycle, c.expected) {
+				t.Errorf("expected cycle %v, found %v", c.expected, cycle)
+			}
+		})
+	}
+}
+
+func TestEvaluateBackupDependency(t *testing.T) {
+	now := time.Now()
+	windowStart := now.Add(-time.Hour)
+	session := func(name string, age time.Duration, phase api_v1beta1.BackupSessionPhase) *api_v1beta1.BackupSession {
+		return &api_v1beta1.BackupSession{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "demo",
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+				Labels: map[string]string{
+					apis.LabelInvokerType: api_v1beta1.ResourceKindBackupConfiguration,
+					apis.LabelInvokerName: "db",
+				},
+			},
+			Status: api_v1beta1.BackupSessionStatus{Phase: phase},
+		}
+	}
+	failed := func(name string, age time.Duration, retryLeft int32, retried bool) *api_v1beta1.BackupSession {
+		s := session(name, age, api_v1beta1.BackupSessionFailed)
+		s.Spec.RetryLeft = retryLeft
+		s.Status.Retried = pointer.BoolP(retried)
+		return s
+	}
+
+	cases := []struct {
+		name     string
+		invoker  bool
+		sessions []*api_v1beta1.BackupSession
+		state    dependencyState
+		reason   string
+	}{
+		{name: "missing invoker", state: dependencyFailed, reason: "does not exist"},
+		{name: "fresh success", invoker: true, sessions: []*api_v1beta1.BackupSession{session("db-1", 10*time.Minute, api_v1beta1.BackupSessionSucceeded)}, state: dependencySatisfied},
+		{
+			name:    "older success within the window",
+			invoker: true,
+			sessions: []*api_v1beta1.BackupSession{
+				session("db-2", 10*time.Minute, api_v1beta1.BackupSessionFailed),
+				session("db-1", 30*time.Minute, api_v1beta1.BackupSessionSucceeded),
+			},
+			state: dependencySatisfied,
+		},
+		{name: "stale success", invoker: true, sessions: []*api_v1beta1.BackupSession{session("db-1", 2*time.Hour, api_v1beta1.BackupSessionSucceeded)}, state: dependencyPending, reason: "no BackupSession found within the freshness window"},
+		{name: "no session", invoker: true, state: dependencyPending, reason: "no BackupSession found within the freshness window"},
+		{name: "running", invoker: true, sessions: []*api_v1beta1.BackupSession{session("db-1", time.Minute, api_v1beta1.BackupSessionRunning)}, state: dependencyPending, reason: `BackupSession db-1 is "Running"`},
+		{name: "not started", invoker: true, sessions: []*api_v1beta1.BackupSession{session("db-1", time.Minute, "")}, state: dependencyPending, reason: `BackupSession db-1 is "Pending"`},
+		{name: "failed with retry", invoker: true, sessions: []*api_v1beta1.BackupSession{failed("db-1", time.Minute, 1, false)}, state: dependencyPending, reason: "will be retried"},
+		{name: "failed and retried", invoker: true, sessions: []*api_v1beta1.BackupSession{failed("db-1", time.Minute, 1, true)}, state: dependencyFailed, reason: "has failed in BackupSession db-1"},
+		{name: "failed", invoker: true, sessions: []*api_v1beta1.BackupSession{failed("db-1", time.Minute, 0, false)}, state: dependencyFailed, reason: "has failed in BackupSession db-1"},
+		{name: "skipped", invoker: true, sessions: []*api_v1beta1.BackupSession{session("db-1", time.Minute, api_v1beta1.BackupSessionSkipped)}, state: dependencyFailed, reason: "has skipped BackupSession db-1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			bsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if c.invoker {
+				_ = bcIndexer.Add(&api_v1beta1.BackupConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "demo"}})
+			}
+			for _, s := range c.sessions {
+				_ = bsIndexer.Add(s)
+			}
+			ctrl := &StashController{
+				bcLister:            stash_listers_v1beta1.NewBackupConfigurationLister(bcIndexer),
+				backupSessionLister: stash_listers_v1beta1.NewBackupSessionLister(bsIndexer),
+			}
+
+			state, reason, err := ctrl.evaluateBackupDependency(kmapi.ObjectReference{Namespace: "demo", Name: "db"}, windowStart)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != c.state || !strings.Contains(reason, c.reason) {
+				t.Errorf("expected state %d with reason %q, found %d with reason %q", c.state, c.reason, state, reason)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -30,7 +31,10 @@ import (
 	stashHooks "stash.appscode.dev/apimachinery/pkg/hooks"
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
+	"stash.appscode.dev/stash/pkg/eventer"
 	"stash.appscode.dev/stash/pkg/executor"
+	"stash.appscode.dev/stash/pkg/history"
+	"stash.appscode.dev/stash/pkg/progress"
 	"stash.appscode.dev/stash/pkg/scheduler"
 	"stash.appscode.dev/stash/pkg/util"
 
@@ -61,6 +65,9 @@ type backupSessionReconciler struct {
 	session *invoker.BackupSessionHandler
 	invoker invoker.BackupInvoker
 	key     string
+
+	// dependencies caches the evaluation of the backup dependencies for the current reconciliation
+	dependencies *dependencyResult
 }
 
 func (c *StashController) NewBackupSessionWebhook() hooks.AdmissionHook {
@@ -120,6 +127,7 @@ func (c *StashController) processBackupSessionEvent(key string) error {
 		apis.ObjectNamespace, backupSession.Namespace,
 	)
 	logger.V(4).Info("Received Sync/Add/Update event")
+	backupSession = c.ensureBackupSessionTrace(logger, backupSession)
 
 	r := backupSessionReconciler{
 		ctrl:    c,
@@ -127,7 +135,10 @@ func (c *StashController) processBackupSessionEvent(key string) error {
 		session: invoker.NewBackupSessionHandler(c.stashClient, backupSession),
 		key:     key,
 	}
-	err = r.reconcile()
+	inProgress := backupSession.Status.Phase == "" ||
+		backupSession.Status.Phase == api_v1beta1.BackupSessionPending ||
+		backupSession.Status.Phase == api_v1beta1.BackupSessionRunning
+	err = traceReconcile(api_v1beta1.ResourceKindBackupSession, backupSession.ObjectMeta, inProgress, r.reconcile)
 	if err != nil {
 		r.logger.Error(err, "Failed to reconcile")
 	}
@@ -140,6 +151,11 @@ func (r *backupSessionReconciler) reconcile() error {
 	if err != nil {
 		return err
 	}
+	r.sendNotifications()
+
+	if err := r.runRequestedJobHooks(); err != nil {
+		return err
+	}
 
 	if r.isAlreadyInFinalPhase() {
 		if r.isBackupFailed() && r.shouldRetry() {
@@ -149,6 +165,11 @@ func (r *backupSessionReconciler) reconcile() error {
 			return r.requeueAfterRetryDelay()
 		}
 
+		// restore the backed up data into scratch volumes to make sure that it can be restored
+		if r.shouldVerifyBackup() {
+			return r.verifyBackup()
+		}
+
 		r.logger.V(4).Info("Skipping processing event",
 			apis.KeyReason, fmt.Sprintf("Backup has been completed already with phase %q", r.session.GetStatus().Phase),
 		)
@@ -166,16 +187,25 @@ func (r *backupSessionReconciler) reconcile() error {
 		}
 
 		if r.shouldExecuteGlobalPostBackupHook() {
-			if err := r.executeGlobalPostBackupHook(); err != nil {
-				condErr := conditions.SetGlobalPostBackupHookSucceededConditionToFalse(r.session, err)
-				if condErr != nil {
-					return condErr
-				}
+			// the hook is executed without blocking the worker. wait for it to complete.
+			if completed, err := r.executeGlobalPostBackupHook(); err != nil || !completed {
+				return err
 			}
 		}
 
+		// remove the VolumeSnapshots and the temporary PVCs used to backup the targets from VolumeSnapshot
+		r.cleanupSnapshotBackups()
+
+		// the progress of the hosts is outdated once the session has completed
+		if err := progress.ClearBackupProgress(r.ctrl.stashClient, r.session.GetObjectMeta()); err != nil {
+			return err
+		}
+
 		// cleanup old BackupSession according to backupHistoryLimit
 		if !r.isBackupHistoryCleaned() {
+			// keep a durable record of the session before it gets removed along with its stats.
+			// a failure to record it must not keep the old sessions from being cleaned up.
+			r.recordBackupHistory()
 			if err := r.cleanupBackupHistory(); err != nil {
 				condErr := conditions.SetBackupHistoryCleanedConditionToFalse(r.session, err)
 				if condErr != nil {
@@ -193,6 +223,8 @@ func (r *backupSessionReconciler) reconcile() error {
 			}
 			// this was the last step of backup. so, log indicating the completion.
 			r.logBackupCompletion()
+			// let the workload controller resume a rollout that may be waiting for this backup
+			r.notifyRolloutTargets()
 		}
 		return nil
 	}
@@ -212,8 +244,11 @@ func (r *backupSessionReconciler) reconcile() error {
 		if err := conditions.SetBackupSkippedConditionToTrue(r.session, skippingReason); err != nil {
 			return err
 		}
+		// a rollout waiting for this backup must not wait for a session that will never run
+		r.notifyRolloutTargets()
 		// cleanup old BackupSession according to backupHistoryLimit
 		if !r.isBackupHistoryCleaned() {
+			r.recordBackupHistory()
 			if err := r.cleanupBackupHistory(); err != nil {
 				return conditions.SetBackupHistoryCleanedConditionToFalse(r.session, err)
 			}
@@ -221,9 +256,22 @@ func (r *backupSessionReconciler) reconcile() error {
 		return nil
 	}
 
+	if r.isBackupPending() {
+		waitingReason, err := r.checkIfBackupShouldWaitForDependencies()
+		if err != nil {
+			return err
+		}
+		if waitingReason != "" {
+			r.logger.Info("Keeping backup pending", apis.KeyReason, waitingReason)
+			r.requeue(requeueTimeInterval)
+			return nil
+		}
+	}
+
 	if r.shouldExecuteGlobalPreBackupHook() {
-		if err := r.executeGlobalPreBackupHook(); err != nil {
-			return conditions.SetGlobalPreBackupHookSucceededConditionToFalse(r.session, err)
+		// the hook is executed without blocking the worker. wait for it to complete.
+		if completed, err := r.executeGlobalPreBackupHook(); err != nil || !completed || r.globalPreBackupHookFailed() {
+			return err
 		}
 	}
 
@@ -254,7 +302,23 @@ func (r *backupSessionReconciler) reconcile() error {
 				continue
 			}
 
-			if err := r.ensureBackupExecutor(targetInfo, i); err != nil {
+			err = r.ensureBackupExecutor(targetInfo, i)
+			if errors.Is(err, executor.ErrSnapshotNotReady) {
+				if !r.isSnapshotDeadlineExceeded() {
+					r.logger.Info("Waiting for the VolumeSnapshot of the target to be ready to use",
+						apis.KeyTargetKind, targetInfo.Target.Ref.Kind,
+						apis.KeyTargetName, targetInfo.Target.Ref.Name,
+						apis.KeyTargetNamespace, targetInfo.Target.Ref.Namespace,
+					)
+					if err := r.setTargetBackupPending(targetInfo.Target.Ref); err != nil {
+						return err
+					}
+					shouldRequeue = true
+					continue
+				}
+				err = fmt.Errorf("VolumeSnapshot of the target is not ready to use within the timeout %s", r.invoker.GetTimeOut().Duration)
+			}
+			if err != nil {
 				r.logger.Error(err, "Failed to ensure backup executor",
 					apis.KeyTargetKind, targetInfo.Target.Ref.Kind,
 					apis.KeyTargetName, targetInfo.Target.Ref.Name,
@@ -304,6 +368,16 @@ func (r *backupSessionReconciler) requeueAfterTimeOut() error {
 	return nil
 }
 
+// isSnapshotDeadlineExceeded returns true if the timeout of the invoker has passed while waiting for the
+// VolumeSnapshot of a target that is backed up from VolumeSnapshot. The session deadline is not set yet at this point.
+func (r *backupSessionReconciler) isSnapshotDeadlineExceeded() bool {
+	timeOut := r.invoker.GetTimeOut()
+	if timeOut == nil {
+		return false
+	}
+	return time.Now().After(r.session.GetObjectMeta().CreationTimestamp.Add(timeOut.Duration))
+}
+
 func (r *backupSessionReconciler) isDeadlineExceeded() bool {
 	if r.isBackupRunning() &&
 		r.isDeadlineSet() &&
@@ -370,6 +444,11 @@ func (r *backupSessionReconciler) ensureBackupExecutor(targetInfo invoker.Backup
 		if err != nil {
 			return err
 		}
+	case executor.TypeSnapshotBackupJob:
+		backupExecutor, err = r.ctrl.newSnapshotBackupJob(r.invoker, r.session, idx)
+		if err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unable to identify backup executor entity")
 	}
@@ -539,6 +618,46 @@ func (r *backupSessionReconciler) isBackupHistoryCleaned() bool {
 	return condutil.HasCondition(r.session.GetConditions(), api_v1beta1.BackupHistoryCleaned)
 }
 
+// recordBackupHistory adds the completed session to the durable backup history of the invoker.
+// Unlike the BackupSessions, the history is not limited by the BackupHistoryLimit. A failure is reported
+// through a warning event only, as the history must not hold up the cleanup of the BackupSessions.
+func (r *backupSessionReconciler) recordBackupHistory() {
+	invokerRef := r.session.GetBackupSession().Spec.Invoker
+	historyName := history.ConfigMapName(history.Name(invokerRef.Kind, invokerRef.Name))
+	dropped, err := r.writeBackupHistory()
+	if err != nil {
+		r.logger.Error(err, "Failed to record backup history")
+		eventer.CreateEventWithLog(
+			r.ctrl.kubeClient,
+			eventer.EventSourceBackupSessionController,
+			r.session.GetBackupSession(),
+			core.EventTypeWarning,
+			eventer.EventReasonBackupHistoryFailed,
+			fmt.Sprintf("Failed to record the session in the backup history ConfigMap %s. Reason: %v", historyName, err),
+		)
+		return
+	}
+	if dropped > 0 {
+		eventer.CreateEventWithLog(
+			r.ctrl.kubeClient,
+			eventer.EventSourceBackupSessionController,
+			r.session.GetBackupSession(),
+			core.EventTypeWarning,
+			eventer.EventReasonBackupHistoryTruncated,
+			fmt.Sprintf("Dropped the %d oldest backup history entries to fit the history in ConfigMap %s. Reduce the number of records to keep.",
+				dropped, historyName),
+		)
+	}
+}
+
+func (r *backupSessionReconciler) writeBackupHistory() (int, error) {
+	records, err := util.BackupHistoryRecords(r.invoker.GetObjectMeta().Annotations)
+	if err != nil || records == 0 {
+		return 0, err
+	}
+	return history.Record(r.ctrl.kubeClient, r.session.GetBackupSession(), records)
+}
+
 // cleanupBackupHistory deletes old BackupSessions and theirs associate resources according to BackupHistoryLimit
 func (r *backupSessionReconciler) cleanupBackupHistory() error {
 	// default history limit is 1
@@ -581,6 +700,10 @@ func (r *backupSessionReconciler) cleanupBackupHistory() error {
 	// delete the BackupSession that does not fit within the history limit
 	for i := int(historyLimit); i < len(bsList); i++ {
 		if invoker.IsBackupCompleted(bsList[i].Status.Phase) && !(bsList[i].Name == lastCompletedSession && historyLimit > 0) {
+			if notificationPending(bsList[i]) || verificationPending(bsList[i]) {
+				// the notification state and the verification are kept with the session. it will be deleted by a later cleanup.
+				continue
+			}
 			err = r.ctrl.stashClient.StashV1beta1().BackupSessions(r.session.GetObjectMeta().Namespace).Delete(context.TODO(), bsList[i].Name, meta.DeleteInBackground())
 			if err != nil && !(kerr.IsNotFound(err) || kerr.IsGone(err)) {
 				return err
@@ -598,6 +721,9 @@ func backupExecutorType(inv invoker.BackupInvoker, targetInfo invoker.BackupTarg
 	if inv.GetDriver() == api_v1beta1.VolumeSnapshotter {
 		return executor.TypeCSISnapshooter
 	}
+	if isBackupFromSnapshot(inv, targetInfo) {
+		return executor.TypeSnapshotBackupJob
+	}
 	return executor.TypeBackupJob
 }
 
@@ -651,7 +777,7 @@ func (r *backupSessionReconciler) shouldExecuteGlobalPostBackupHook() bool {
 	return false
 }
 
-func (r *backupSessionReconciler) executeGlobalPostBackupHook() error {
+func (r *backupSessionReconciler) executeGlobalPostBackupHook() (bool, error) {
 	summary := r.invoker.GetSummary(api_v1beta1.TargetRef{}, kmapi.ObjectReference{
 		Namespace: r.session.GetObjectMeta().Namespace,
 		Name:      r.session.GetObjectMeta().Name,
@@ -659,16 +785,6 @@ func (r *backupSessionReconciler) executeGlobalPostBackupHook() error {
 	summary.Status.Phase = string(r.session.GetStatus().Phase)
 	summary.Status.Duration = r.session.GetStatus().SessionDuration
 
-	hookExecutor := stashHooks.HookExecutor{
-		Config: r.ctrl.clientConfig,
-		Hook:   r.invoker.GetGlobalHooks().PostBackup.Handler,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: meta.PodNamespace(),
-			Name:      meta.PodName(),
-		},
-		Summary: summary,
-	}
-
 	executionPolicy := r.invoker.GetGlobalHooks().PostBackup.ExecutionPolicy
 	if executionPolicy == "" {
 		executionPolicy = api_v1beta1.ExecuteAlways
@@ -680,12 +796,10 @@ func (r *backupSessionReconciler) executeGlobalPostBackupHook() error {
 			executionPolicy,
 			summary.Status.Phase,
 		)
-		return conditions.SetGlobalPostBackupHookSucceededConditionToTrueWithMsg(r.session, reason)
+		return true, conditions.SetGlobalPostBackupHookSucceededConditionToTrueWithMsg(r.session, reason)
 	}
-	if err := hookExecutor.Execute(); err != nil {
-		return err
-	}
-	return conditions.SetGlobalPostBackupHookSucceededConditionToTrue(r.session)
+
+	return r.executeGlobalBackupHook(apis.PostBackupHook, r.invoker.GetGlobalHooks().PostBackup.Handler, summary)
 }
 
 func (r *backupSessionReconciler) shouldExecuteGlobalPreBackupHook() bool {
@@ -696,23 +810,15 @@ func (r *backupSessionReconciler) shouldExecuteGlobalPreBackupHook() bool {
 	return false
 }
 
-func (r *backupSessionReconciler) executeGlobalPreBackupHook() error {
-	hookExecutor := stashHooks.HookExecutor{
-		Config: r.ctrl.clientConfig,
-		Hook:   r.invoker.GetGlobalHooks().PreBackup,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: meta.PodNamespace(),
-			Name:      meta.PodName(),
-		},
-		Summary: r.invoker.GetSummary(api_v1beta1.TargetRef{}, kmapi.ObjectReference{
+func (r *backupSessionReconciler) executeGlobalPreBackupHook() (bool, error) {
+	return r.executeGlobalBackupHook(
+		apis.PreBackupHook,
+		r.invoker.GetGlobalHooks().PreBackup,
+		r.invoker.GetSummary(api_v1beta1.TargetRef{}, kmapi.ObjectReference{
 			Namespace: r.session.GetObjectMeta().Namespace,
 			Name:      r.session.GetObjectMeta().Name,
 		}),
-	}
-	if err := hookExecutor.Execute(); err != nil {
-		return err
-	}
-	return conditions.SetGlobalPreBackupHookSucceededConditionToTrue(r.session)
+	)
 }
 
 func (r *backupSessionReconciler) checkIfBackupShouldBeSkipped() (string, error) {
@@ -736,9 +842,35 @@ func (r *backupSessionReconciler) checkIfBackupShouldBeSkipped() (string, error)
 			runningBS.Status.Phase,
 		), nil
 	}
+
+	// Skip taking backup if any of the dependencies has failed
+	if r.isBackupPending() {
+		state, reason, err := r.checkBackupDependencies()
+		if err != nil {
+			return "", err
+		}
+		if state == dependencyFailed {
+			return reason, nil
+		}
+	}
 	return "", nil
 }
 
+func (r *backupSessionReconciler) checkIfBackupShouldWaitForDependencies() (string, error) {
+	state, reason, err := r.checkBackupDependencies()
+	if err != nil {
+		return "", err
+	}
+	if state == dependencyPending {
+		return reason, r.setBackupDependenciesSatisfiedCondition(false, reason)
+	}
+	deps, err := util.BackupDependencies(r.invoker.GetObjectMeta().Annotations, r.invoker.GetObjectMeta().Namespace)
+	if err != nil || len(deps) == 0 {
+		return "", err
+	}
+	return "", r.setBackupDependenciesSatisfiedCondition(true, "")
+}
+
 func (r *backupSessionReconciler) checkIfBackupShouldBePending(targetRef api_v1beta1.TargetRef) (string, error) {
 	// Keep backup pending if the target is not in next in order
 	if r.invoker.GetExecutionOrder() == api_v1beta1.Sequential &&
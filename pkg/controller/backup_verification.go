@@ -0,0 +1,434 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/resolver"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"gomodules.xyz/pointer"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	condutil "kmodules.xyz/client-go/conditions"
+	meta_util "kmodules.xyz/client-go/meta"
+	ofst "kmodules.xyz/offshoot-api/api/v1"
+)
+
+const (
+	// BackupVerified indicates whether the backed up data of a BackupSession has been restored and verified successfully.
+	BackupVerified = "Verified"
+
+	reasonVerificationRunning   = "RestoreVerificationRunning"
+	reasonVerificationSucceeded = "RestoreVerificationSucceeded"
+	reasonVerificationFailed    = "RestoreVerificationFailed"
+
+	verificationRequeueInterval = 30 * time.Second
+
+	verificationDue     = "Due"
+	verificationSkipped = "Skipped"
+)
+
+// shouldVerifyBackup returns true if the restore verification of a succeeded BackupSession
+// has to be started, is still running or has not been cleaned up yet.
+func (r *backupSessionReconciler) shouldVerifyBackup() bool {
+	if r.session.GetStatus().Phase != api_v1beta1.BackupSessionSucceeded ||
+		r.invoker.GetTypeMeta().Kind != api_v1beta1.ResourceKindBackupConfiguration {
+		return false
+	}
+
+	_, cond := condutil.GetCondition(r.session.GetConditions(), BackupVerified)
+	if cond != nil {
+		if cond.Status == metav1.ConditionUnknown {
+			return true
+		}
+		// verification has been completed. we still have to cleanup if the restore is left behind.
+		_, err := r.ctrl.restoreSessionLister.RestoreSessions(r.session.GetObjectMeta().Namespace).Get(r.verificationRestoreSessionName())
+		return err == nil
+	}
+
+	// the decision is made once per BackupSession. so, the retained old sessions do not count again.
+	if decision, ok := r.session.GetObjectMeta().Annotations[util.KeyVerificationDecision]; ok {
+		return decision == verificationDue
+	}
+	annotations := r.invoker.GetObjectMeta().Annotations
+	_, every := annotations[util.KeyVerifyEvery]
+	_, interval := annotations[util.KeyVerifyInterval]
+	return every || interval
+}
+
+// verificationPending returns true if the restore verification of a BackupSession is due or still running.
+// The verification RestoreSession and its scratch PVCs are owned by the BackupSession. So, the BackupSession
+// must not be deleted by the history cleanup before the verification has completed.
+func verificationPending(bs *api_v1beta1.BackupSession) bool {
+	if _, cond := condutil.GetCondition(bs.Status.Conditions, BackupVerified); cond != nil {
+		return cond.Status == metav1.ConditionUnknown
+	}
+	return bs.Annotations[util.KeyVerificationDecision] == verificationDue
+}
+
+func (r *backupSessionReconciler) verifyBackup() error {
+	_, cond := condutil.GetCondition(r.session.GetConditions(), BackupVerified)
+	if cond == nil {
+		policy, err := util.VerificationPolicy(r.invoker.GetObjectMeta().Annotations)
+		if err != nil {
+			return r.setBackupVerifiedToFalse(fmt.Sprintf("Invalid restore verification policy. Reason: %v", err))
+		}
+		due, err := r.isVerificationDue(*policy)
+		if err != nil {
+			return err
+		}
+		if err := r.recordVerificationDecision(due); err != nil {
+			return err
+		}
+		if !due {
+			return nil
+		}
+		if err := r.setBackupVerifiedToUnknown(); err != nil {
+			return err
+		}
+		_, cond = condutil.GetCondition(r.session.GetConditions(), BackupVerified)
+	}
+
+	if cond.Status != metav1.ConditionUnknown {
+		return r.cleanupVerification()
+	}
+	return r.checkVerification()
+}
+
+// isVerificationDue records the current BackupSession in the verification history of the invoker
+// and returns whether it should be verified.
+func (r *backupSessionReconciler) isVerificationDue(policy util.RestoreVerificationPolicy) (bool, error) {
+	session := r.session.GetObjectMeta()
+	due := false
+	_, err := v1beta1_util.TryUpdateBackupConfiguration(
+		context.TODO(),
+		r.ctrl.stashClient.StashV1beta1(),
+		r.invoker.GetObjectMeta(),
+		func(in *api_v1beta1.BackupConfiguration) *api_v1beta1.BackupConfiguration {
+			state := util.VerificationState(in.Annotations)
+			if state.LastSession == session.Name {
+				// already decided for this session
+				due = state.LastVerifiedSession == session.Name
+				return in
+			}
+			now := metav1.Now()
+			due = policy.IsVerificationDue(state, now.Time)
+			state.LastSession = session.Name
+			if due {
+				state.LastVerifiedSession = session.Name
+				state.LastVerificationTime = now
+				state.SessionsSinceVerification = 0
+			} else {
+				state.SessionsSinceVerification++
+			}
+			in.Annotations, _ = util.UpsertVerificationState(in.Annotations, state)
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	return due, err
+}
+
+// recordVerificationDecision records on the BackupSession whether it has been picked for verification.
+func (r *backupSessionReconciler) recordVerificationDecision(due bool) error {
+	decision := verificationSkipped
+	if due {
+		decision = verificationDue
+	}
+	_, err := v1beta1_util.TryUpdateBackupSession(
+		context.TODO(),
+		r.ctrl.stashClient.StashV1beta1(),
+		r.session.GetObjectMeta(),
+		func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+			in.Annotations = meta_util.OverwriteKeys(in.Annotations, map[string]string{
+				util.KeyVerificationDecision: decision,
+			})
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	return err
+}
+
+// checkVerification creates the verification RestoreSession if it does not exist yet
+// and records its result on the BackupSession once it has completed.
+func (r *backupSessionReconciler) checkVerification() error {
+	namespace := r.session.GetObjectMeta().Namespace
+	rs, err := r.ctrl.restoreSessionLister.RestoreSessions(namespace).Get(r.verificationRestoreSessionName())
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	if kerr.IsNotFound(err) {
+		return r.startVerification()
+	}
+
+	switch rs.Status.Phase {
+	case api_v1beta1.RestoreSucceeded:
+		if err := r.setBackupVerifiedToTrue(); err != nil {
+			return err
+		}
+		return r.cleanupVerification()
+	case api_v1beta1.RestoreFailed, api_v1beta1.RestorePhaseInvalid, api_v1beta1.RestorePhaseUnknown:
+		msg := fmt.Sprintf("RestoreSession %s/%s has completed with phase %q.", rs.Namespace, rs.Name, rs.Status.Phase)
+		for _, c := range rs.Status.Conditions {
+			if c.Status == metav1.ConditionFalse {
+				msg += fmt.Sprintf(" %s: %s", c.Type, c.Message)
+			}
+		}
+		if err := r.setBackupVerifiedToFalse(msg); err != nil {
+			return err
+		}
+		return r.cleanupVerification()
+	}
+	r.requeue(verificationRequeueInterval)
+	return nil
+}
+
+func (r *backupSessionReconciler) startVerification() error {
+	policy, err := util.VerificationPolicy(r.invoker.GetObjectMeta().Annotations)
+	if err == nil && policy == nil {
+		err = fmt.Errorf("restore verification has been disabled")
+	}
+	if err != nil {
+		return r.setBackupVerifiedToFalse(fmt.Sprintf("Invalid restore verification policy. Reason: %v", err))
+	}
+
+	rs, err := r.newVerificationRestoreSession(*policy)
+	if err != nil {
+		return r.setBackupVerifiedToFalse(fmt.Sprintf("Failed to verify backup. Reason: %v", err))
+	}
+	_, err = r.ctrl.stashClient.StashV1beta1().RestoreSessions(rs.Namespace).Create(context.TODO(), rs, metav1.CreateOptions{})
+	if err != nil && !kerr.IsAlreadyExists(err) {
+		return err
+	}
+	if err == nil {
+		r.logger.Info("Started restore verification", "RestoreSession", rs.Name)
+		eventer.CreateEventWithLog(
+			r.ctrl.kubeClient,
+			eventer.EventSourceBackupSessionController,
+			r.session.GetBackupSession(),
+			core.EventTypeNormal,
+			eventer.EventReasonBackupVerificationStarted,
+			fmt.Sprintf("Restoring the backed up data into scratch volumes through RestoreSession %s/%s", rs.Namespace, rs.Name),
+		)
+	}
+	r.requeue(verificationRequeueInterval)
+	return nil
+}
+
+// newVerificationRestoreSession builds a RestoreSession that restores the snapshots taken by the current
+// BackupSession into scratch PVCs. Each host of the backup is restored into its own replica of the PVC template.
+func (r *backupSessionReconciler) newVerificationRestoreSession(policy util.RestoreVerificationPolicy) (*api_v1beta1.RestoreSession, error) {
+	if r.invoker.GetDriver() != api_v1beta1.ResticSnapshotter {
+		return nil, fmt.Errorf("restore verification is supported only for %q driver", api_v1beta1.ResticSnapshotter)
+	}
+	targetInfo := r.invoker.GetTargetInfo()[0]
+	if targetInfo.Target == nil || targetInfo.Task.Name != "" {
+		return nil, fmt.Errorf("restore verification is supported only for the workload volumes")
+	}
+
+	var hosts []api_v1beta1.HostBackupStats
+	for _, t := range r.session.GetTargetStatus() {
+		if invoker.TargetMatched(t.Ref, targetInfo.Target.Ref) {
+			hosts = append(hosts, t.Stats...)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no snapshot has been taken by this backup")
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Hostname < hosts[j].Hostname
+	})
+
+	// the restore job is named after the host ordinal. map each of the backed up host to an ordinal.
+	rules := make([]api_v1beta1.Rule, 0, len(hosts))
+	for i, h := range hosts {
+		rule := api_v1beta1.Rule{
+			TargetHosts: []string{fmt.Sprintf("host-%d", i)},
+			SourceHost:  h.Hostname,
+		}
+		for _, s := range h.Snapshots {
+			rule.Snapshots = append(rule.Snapshots, s.Name)
+		}
+		rules = append(rules, rule)
+	}
+
+	bs := r.session.GetBackupSession()
+	owner := metav1.NewControllerRef(bs, api_v1beta1.SchemeGroupVersion.WithKind(api_v1beta1.ResourceKindBackupSession))
+
+	volumeName := meta_util.NameWithSuffix(bs.Name, "scratch", 55)
+	claim := *policy.VolumeClaimTemplate.DeepCopy()
+	claim.Name = volumeName + "-${POD_ORDINAL}"
+	claim.Namespace = bs.Namespace
+	claim.OwnerReferences = append(claim.OwnerReferences, *owner)
+
+	// mount the scratch volume at the backed up paths so that the snapshots are restored into it
+	var mounts []core.VolumeMount
+	for _, m := range targetInfo.Target.VolumeMounts {
+		mounts = append(mounts, core.VolumeMount{
+			Name:      volumeName,
+			MountPath: m.MountPath,
+			SubPath:   m.Name,
+		})
+	}
+	if len(mounts) == 0 {
+		for i, p := range targetInfo.Target.Paths {
+			mounts = append(mounts, core.VolumeMount{
+				Name:      volumeName,
+				MountPath: p,
+				SubPath:   fmt.Sprintf("path-%d", i),
+			})
+		}
+	}
+
+	rs := &api_v1beta1.RestoreSession{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            r.verificationRestoreSessionName(),
+			Namespace:       bs.Namespace,
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: api_v1beta1.RestoreSessionSpec{
+			Driver:     api_v1beta1.ResticSnapshotter,
+			Repository: r.invoker.GetRepoRef(),
+			TimeOut:    policy.Timeout,
+			RestoreTargetSpec: api_v1beta1.RestoreTargetSpec{
+				Target: &api_v1beta1.RestoreTarget{
+					VolumeMounts:         mounts,
+					Replicas:             pointer.Int32P(int32(len(hosts))),
+					VolumeClaimTemplates: []ofst.PersistentVolumeClaim{claim},
+					Rules:                rules,
+				},
+				RuntimeSettings: targetInfo.RuntimeSettings,
+				TempDir:         targetInfo.TempDir,
+			},
+		},
+	}
+	if policy.Hook != nil {
+		rs.Spec.Hooks = &api_v1beta1.RestoreHooks{
+			PostRestore: &api_v1beta1.PostRestoreHook{
+				Handler:         policy.Hook,
+				ExecutionPolicy: api_v1beta1.ExecuteOnSuccess,
+			},
+		}
+	}
+	return rs, nil
+}
+
+// cleanupVerification deletes the verification RestoreSession along with the scratch PVCs.
+// The restore jobs are garbage collected with the RestoreSession.
+func (r *backupSessionReconciler) cleanupVerification() error {
+	namespace := r.session.GetObjectMeta().Namespace
+	rs, err := r.ctrl.restoreSessionLister.RestoreSessions(namespace).Get(r.verificationRestoreSessionName())
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if rs.DeletionTimestamp != nil {
+		return nil
+	}
+
+	if rs.Spec.Target != nil {
+		replicas := int32(1)
+		if rs.Spec.Target.Replicas != nil {
+			replicas = *rs.Spec.Target.Replicas
+		}
+		for ordinal := int32(0); ordinal < replicas; ordinal++ {
+			pvcList, err := resolver.VolumeTemplateOptions{
+				Ordinal:         int(ordinal),
+				VolumeTemplates: rs.Spec.Target.VolumeClaimTemplates,
+			}.Resolve()
+			if err != nil {
+				return err
+			}
+			for _, pvc := range pvcList {
+				err := r.ctrl.kubeClient.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), pvc.Name, metav1.DeleteOptions{})
+				if err != nil && !kerr.IsNotFound(err) {
+					return err
+				}
+			}
+		}
+	}
+
+	deletePolicy := metav1.DeletePropagationBackground
+	err = r.ctrl.stashClient.StashV1beta1().RestoreSessions(namespace).Delete(context.TODO(), rs.Name, metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	r.logger.Info("Cleaned up restore verification", "RestoreSession", rs.Name)
+	return nil
+}
+
+func (r *backupSessionReconciler) verificationRestoreSessionName() string {
+	return meta_util.NameWithSuffix(r.session.GetObjectMeta().Name, "verify")
+}
+
+func (r *backupSessionReconciler) setBackupVerifiedToUnknown() error {
+	return r.setBackupVerifiedCondition(metav1.ConditionUnknown, reasonVerificationRunning, "Restore verification is running.")
+}
+
+func (r *backupSessionReconciler) setBackupVerifiedToTrue() error {
+	eventer.CreateEventWithLog(
+		r.ctrl.kubeClient,
+		eventer.EventSourceBackupSessionController,
+		r.session.GetBackupSession(),
+		core.EventTypeNormal,
+		eventer.EventReasonBackupVerificationSucceeded,
+		"Backed up data has been restored and verified successfully.",
+	)
+	return r.setBackupVerifiedCondition(metav1.ConditionTrue, reasonVerificationSucceeded, "Backed up data has been restored and verified successfully.")
+}
+
+func (r *backupSessionReconciler) setBackupVerifiedToFalse(msg string) error {
+	eventer.CreateEventWithLog(
+		r.ctrl.kubeClient,
+		eventer.EventSourceBackupSessionController,
+		r.session.GetBackupSession(),
+		core.EventTypeWarning,
+		eventer.EventReasonBackupVerificationFailed,
+		msg,
+	)
+	return r.setBackupVerifiedCondition(metav1.ConditionFalse, reasonVerificationFailed, msg)
+}
+
+func (r *backupSessionReconciler) setBackupVerifiedCondition(status metav1.ConditionStatus, reason, msg string) error {
+	return r.session.UpdateStatus(&api_v1beta1.BackupSessionStatus{
+		Conditions: []kmapi.Condition{
+			{
+				Type:               BackupVerified,
+				Status:             status,
+				Reason:             reason,
+				Message:            msg,
+				LastTransitionTime: metav1.Now(),
+			},
+		},
+	})
+}
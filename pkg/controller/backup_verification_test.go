@@ -0,0 +1,55 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+func TestVerificationPending(t *testing.T) {
+	cases := []struct {
+		name       string
+		decision   string
+		conditions []kmapi.Condition
+		expected   bool
+	}{
+		{name: "not verified", expected: false},
+		{name: "skipped", decision: verificationSkipped, expected: false},
+		{name: "due", decision: verificationDue, expected: true},
+		{name: "running", decision: verificationDue, conditions: []kmapi.Condition{{Type: BackupVerified, Status: metav1.ConditionUnknown}}, expected: true},
+		{name: "succeeded", decision: verificationDue, conditions: []kmapi.Condition{{Type: BackupVerified, Status: metav1.ConditionTrue}}, expected: false},
+		{name: "failed", decision: verificationDue, conditions: []kmapi.Condition{{Type: BackupVerified, Status: metav1.ConditionFalse}}, expected: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bs := &api_v1beta1.BackupSession{}
+			if c.decision != "" {
+				bs.Annotations = map[string]string{util.KeyVerificationDecision: c.decision}
+			}
+			bs.Status.Conditions = c.conditions
+			if pending := verificationPending(bs); pending != c.expected {
+				t.Errorf("expected pending %v, found %v", c.expected, pending)
+			}
+		})
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"stash.appscode.dev/stash/pkg/eventer"
 	"stash.appscode.dev/stash/pkg/util"
 
+	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
 	crd_cs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
@@ -65,6 +66,7 @@ type Config struct {
 	StashClient      cs.Interface
 	CRDClient        crd_cs.Interface
 	AppCatalogClient appcatalog_cs.Interface
+	SnapshotClient   vscs.Interface
 }
 
 func NewConfig(clientConfig *rest.Config) *Config {
@@ -93,6 +95,7 @@ func (c *Config) New() (*StashController, error) {
 		stashClient:          c.StashClient,
 		crdClient:            c.CRDClient,
 		appCatalogClient:     c.AppCatalogClient,
+		snapshotClient:       c.SnapshotClient,
 		kubeInformerFactory:  informerFactory,
 		stashInformerFactory: stashinformers.NewSharedInformerFactory(c.StashClient, c.ResyncPeriod),
 		ocInformerFactory:    oc_informers.NewSharedInformerFactory(c.OcClient, c.ResyncPeriod),
@@ -144,5 +147,8 @@ func (c *Config) New() (*StashController, error) {
 	ctrl.initBackupSessionWatcher()
 	ctrl.initRestoreSessionWatcher()
 
+	ctrl.initNotificationWatcher()
+	ctrl.initRestorePreviewQueue()
+
 	return ctrl, nil
 }
@@ -25,12 +25,14 @@ import (
 	stash_listers_v1beta1 "stash.appscode.dev/apimachinery/client/listers/stash/v1beta1"
 	"stash.appscode.dev/apimachinery/pkg/docker"
 
+	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
 	crd_cs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	apps_listers "k8s.io/client-go/listers/apps/v1"
 	batch_listers "k8s.io/client-go/listers/batch/v1"
+	core_listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
@@ -53,6 +55,7 @@ type StashController struct {
 	stashClient      cs.Interface
 	crdClient        crd_cs.Interface
 	appCatalogClient appcatalog_cs.Interface
+	snapshotClient   vscs.Interface
 	recorder         record.EventRecorder
 	mapper           discovery.ResourceMapper
 
@@ -104,6 +107,14 @@ type StashController struct {
 	dcQueue    *queue.Worker
 	dcInformer cache.SharedIndexInformer
 	dcLister   oc_listers.DeploymentConfigLister
+
+	// Notification
+	notifierInformer  cache.SharedIndexInformer
+	notifierLister    core_listers.SecretLister
+	notificationQueue *queue.Worker
+
+	// Restore preview
+	restorePreviewQueue *queue.Worker
 }
 
 func (c *StashController) Run(stopCh <-chan struct{}) {
@@ -177,6 +188,10 @@ func (c *StashController) RunInformers(stopCh <-chan struct{}) {
 	c.bcQueue.Run(stopCh)
 	c.backupSessionQueue.Run(stopCh)
 	c.restoreSessionQueue.Run(stopCh)
+	c.notificationQueue.Run(stopCh)
+	c.restorePreviewQueue.Run(stopCh)
+
+	go c.runRPOMonitor(stopCh)
 
 	<-stopCh
 	klog.Infoln("Stopping Stash controller")
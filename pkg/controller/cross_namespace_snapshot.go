@@ -0,0 +1,139 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/rbac"
+	"stash.appscode.dev/stash/pkg/resolver"
+	"stash.appscode.dev/stash/pkg/util"
+	"stash.appscode.dev/stash/pkg/volumesnapshot"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// crossNamespaceSnapshots returns the VolumeSnapshots of other namespaces that the PVCs of the restore target refer to.
+func crossNamespaceSnapshots(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) ([]types.NamespacedName, error) {
+	if targetInfo.Target == nil || len(targetInfo.Target.VolumeClaimTemplates) == 0 {
+		return nil, nil
+	}
+	replicas := int32(1)
+	if targetInfo.Target.Replicas != nil {
+		replicas = *targetInfo.Target.Replicas
+	}
+
+	var pvcs []core.PersistentVolumeClaim
+	for ordinal := int32(0); ordinal < replicas; ordinal++ {
+		r := resolver.VolumeTemplateOptions{
+			Ordinal:         int(ordinal),
+			VolumeTemplates: targetInfo.Target.VolumeClaimTemplates,
+		}
+		claims, err := r.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		pvcs = append(pvcs, claims...)
+	}
+	return volumesnapshot.CrossNamespaceSources(pvcs, inv.GetObjectMeta().Namespace), nil
+}
+
+// ensureCrossNamespaceSnapshots makes the VolumeSnapshots of other namespaces available to the restore job.
+// The usage policy of each VolumeSnapshot must allow the namespace of the restore invoker.
+func (c *StashController) ensureCrossNamespaceSnapshots(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo, rbacOptions *rbac.Options) error {
+	sources, err := crossNamespaceSnapshots(inv, targetInfo)
+	if err != nil || len(sources) == 0 {
+		return err
+	}
+
+	namespace, err := c.kubeClient.CoreV1().Namespaces().Get(context.TODO(), inv.GetObjectMeta().Namespace, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, src := range sources {
+		vs, err := c.snapshotClient.SnapshotV1().VolumeSnapshots(src.Namespace).Get(context.TODO(), src.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		allowed, err := volumesnapshot.UsageAllowed(vs, namespace)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("namespace %q is not allowed to restore VolumeSnapshot %q of %q namespace. Please, check the %q annotation of the VolumeSnapshot", namespace.Name, src.Name, src.Namespace, util.KeyUsagePolicy)
+		}
+	}
+
+	// the restored PVCs refer to the VolumeSnapshots of other namespaces directly. so, the restore job only needs to read them
+	// and the namespace of each VolumeSnapshot must grant the reference.
+	if util.IsAnnotationTrue(inv.GetObjectMeta().Annotations, util.KeyCrossNamespaceDataSource) {
+		dc, err := dynamic.NewForConfig(c.clientConfig)
+		if err != nil {
+			return err
+		}
+		for _, src := range sources {
+			err = volumesnapshot.EnsureReferenceGrant(dc, src, namespace.Name, inv.GetLabels())
+			if err != nil {
+				return err
+			}
+		}
+		rbacOptions.SetCrossNamespaceSnapshots(sources)
+		return nil
+	}
+
+	for _, src := range sources {
+		err = volumesnapshot.EnsureCrossNamespaceBinding(c.snapshotClient, src, namespace.Name, inv.GetOwnerRef(), inv.GetLabels())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCrossNamespaceSnapshotReferences removes the ReferenceGrants or the bindings that were created by
+// ensureCrossNamespaceSnapshots for the VolumeSnapshots of other namespaces.
+func (c *StashController) deleteCrossNamespaceSnapshotReferences(inv invoker.RestoreInvoker, sources []types.NamespacedName) error {
+	if len(sources) == 0 {
+		return nil
+	}
+	namespace := inv.GetObjectMeta().Namespace
+
+	if util.IsAnnotationTrue(inv.GetObjectMeta().Annotations, util.KeyCrossNamespaceDataSource) {
+		dc, err := dynamic.NewForConfig(c.clientConfig)
+		if err != nil {
+			return err
+		}
+		for _, src := range sources {
+			if err := volumesnapshot.DeleteReferenceGrant(dc, src, namespace); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, src := range sources {
+		if err := volumesnapshot.DeleteCrossNamespaceBinding(c.snapshotClient, src, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
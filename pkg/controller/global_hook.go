@@ -0,0 +1,134 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	hook_util "stash.appscode.dev/stash/pkg/hooks"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"kmodules.xyz/client-go/meta"
+	prober "kmodules.xyz/prober/api/v1"
+)
+
+// stepGlobalHook executes a global hook in the operator without blocking the worker. The attempts are recorded in
+// the annotations of the session using updateAnnotations. While the hook has not completed, the returned result tells
+// when the session should be reconciled again. Once it has completed, the recorded attempts are removed.
+func (c *StashController) stepGlobalHook(
+	key string,
+	hookType string,
+	handler *prober.Handler,
+	summary *api_v1beta1.Summary,
+	sessionAnnotations map[string]string,
+	invokerAnnotations map[string]string,
+	updateAnnotations func(transform func(map[string]string) map[string]string) error,
+) (hook_util.GlobalHookResult, error) {
+	states := hook_util.GlobalHookStatesFromAnnotations(sessionAnnotations)
+	state, recorded := states[hookType]
+	result := hook_util.GlobalHook{
+		Key:      key,
+		HookType: hookType,
+		TraceCtx: tracing.SessionContext(sessionAnnotations),
+		Config:   c.clientConfig,
+		Handler:  handler,
+		ExecutorPod: kmapi.ObjectReference{
+			Namespace: meta.PodNamespace(),
+			Name:      meta.PodName(),
+		},
+		Summary:     summary,
+		Annotations: invokerAnnotations,
+		State:       state,
+	}.Step(time.Now())
+
+	var newState *hook_util.GlobalHookState
+	switch {
+	case result.Completed && recorded:
+	case result.State != nil:
+		newState = result.State
+	default:
+		return result, nil
+	}
+	err := updateAnnotations(func(in map[string]string) map[string]string {
+		return hook_util.UpsertGlobalHookState(in, hookType, newState)
+	})
+	return result, err
+}
+
+// executeGlobalBackupHook executes a global hook of the BackupSession. It returns true once the hook has completed
+// and its condition has been set. Otherwise, the BackupSession is requeued to check on the hook later.
+func (r *backupSessionReconciler) executeGlobalBackupHook(hookType string, handler *prober.Handler, summary *api_v1beta1.Summary) (bool, error) {
+	session := r.session.GetObjectMeta()
+	result, err := r.ctrl.stepGlobalHook(
+		api_v1beta1.ResourceKindBackupSession+"/"+r.key,
+		hookType,
+		handler,
+		summary,
+		session.Annotations,
+		r.invoker.GetObjectMeta().Annotations,
+		func(transform func(map[string]string) map[string]string) error {
+			_, err := v1beta1_util.TryUpdateBackupSession(
+				context.TODO(),
+				r.ctrl.stashClient.StashV1beta1(),
+				session,
+				func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+					in.Annotations = transform(in.Annotations)
+					return in
+				},
+				metav1.UpdateOptions{},
+			)
+			return err
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	if !result.Completed {
+		r.requeue(result.RequeueAfter)
+		return false, nil
+	}
+	return true, hook_util.SetGlobalBackupHookCondition(r.session, hookType, result.Exec, result.Err)
+}
+
+// executeGlobalRestoreHook executes a global hook of the restore invoker. It returns true once the hook has completed
+// and its condition has been set. Otherwise, the restore invoker is requeued to check on the hook later.
+func (r *restoreInvokerReconciler) executeGlobalRestoreHook(hookType string, handler *prober.Handler, summary *api_v1beta1.Summary) (bool, error) {
+	result, err := r.ctrl.stepGlobalHook(
+		r.invoker.GetTypeMeta().Kind+"/"+r.key,
+		hookType,
+		handler,
+		summary,
+		r.invoker.GetObjectMeta().Annotations,
+		r.invoker.GetObjectMeta().Annotations,
+		func(transform func(map[string]string) map[string]string) error {
+			return util.UpdateRestoreInvokerAnnotations(r.ctrl.stashClient, r.invoker, transform)
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	if !result.Completed {
+		return false, r.requeue(result.RequeueAfter)
+	}
+	return true, hook_util.SetGlobalRestoreHookCondition(r.invoker, hookType, result.Exec, result.Err)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	hook_util "stash.appscode.dev/stash/pkg/hooks"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// runRequestedJobHooks creates the Job hooks requested by the sidecar in the annotations of the BackupSession.
+// The handled requests are removed from the annotations even if they have been rejected, so that the sidecar
+// fails on the timeout of the hook instead of the operator retrying them forever.
+func (r *backupSessionReconciler) runRequestedJobHooks() error {
+	session := r.session.GetObjectMeta()
+	reqs := hook_util.JobHookRequestsFromAnnotations(session.Annotations)
+	if len(reqs) == 0 {
+		return nil
+	}
+	handled, err := hook_util.RequestedJobHooks{
+		KubeClient:  r.ctrl.kubeClient,
+		Requests:    reqs,
+		HookTypes:   []string{apis.PreBackupHook, apis.PostBackupHook},
+		SessionName: session.Name,
+		Namespace:   session.Namespace,
+		Annotations: r.invoker.GetObjectMeta().Annotations,
+		Labels:      r.invoker.GetLabels(),
+		Owner:       metav1.NewControllerRef(&session, api_v1beta1.SchemeGroupVersion.WithKind(api_v1beta1.ResourceKindBackupSession)),
+	}.Run()
+	if err != nil {
+		r.logger.Error(err, "Failed to run the requested Job hooks")
+	}
+	_, updateErr := v1beta1_util.TryUpdateBackupSession(
+		context.TODO(),
+		r.ctrl.stashClient.StashV1beta1(),
+		session,
+		func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+			in.Annotations = removeJobHookRequests(in.Annotations, handled)
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	return updateErr
+}
+
+// runRequestedJobHooks creates the Job hooks requested by the restore init-container in the annotations of the
+// restore invoker. It follows the same rules as the one of the BackupSession.
+func (r *restoreInvokerReconciler) runRequestedJobHooks() error {
+	invMeta := r.invoker.GetObjectMeta()
+	reqs := hook_util.JobHookRequestsFromAnnotations(invMeta.Annotations)
+	if len(reqs) == 0 {
+		return nil
+	}
+	handled, err := hook_util.RequestedJobHooks{
+		KubeClient:  r.ctrl.kubeClient,
+		Requests:    reqs,
+		HookTypes:   []string{apis.PreRestoreHook, apis.PostRestoreHook},
+		SessionName: invMeta.Name,
+		Namespace:   invMeta.Namespace,
+		Annotations: invMeta.Annotations,
+		Labels:      r.invoker.GetLabels(),
+		Owner:       r.invoker.GetOwnerRef(),
+	}.Run()
+	if err != nil {
+		r.logger.Error(err, "Failed to run the requested Job hooks")
+	}
+	return util.UpdateRestoreInvokerAnnotations(r.ctrl.stashClient, r.invoker, func(in map[string]string) map[string]string {
+		return removeJobHookRequests(in, handled)
+	})
+}
+
+func removeJobHookRequests(annotations map[string]string, names []string) map[string]string {
+	for _, name := range names {
+		annotations = hook_util.UpsertJobHookRequest(annotations, name, nil)
+	}
+	return annotations
+}
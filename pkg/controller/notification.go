@@ -0,0 +1,233 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/notification"
+	"stash.appscode.dev/stash/pkg/util"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core_informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	core_listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	meta_util "kmodules.xyz/client-go/meta"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+// initNotificationWatcher watches the notifier Secrets and starts the queue that delivers the notifications.
+// The notifications are delivered by their own workers, so that a slow notifier never blocks the reconciliation of the sessions.
+func (c *StashController) initNotificationWatcher() {
+	c.notifierInformer = c.kubeInformerFactory.InformerFor(&core.Secret{}, func(client kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+		return core_informers.NewFilteredSecretInformer(
+			client,
+			core.NamespaceAll,
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			func(options *metav1.ListOptions) {
+				options.LabelSelector = notification.LabelNotifier
+			},
+		)
+	})
+	c.notifierLister = core_listers.NewSecretLister(c.notifierInformer.GetIndexer())
+	c.notificationQueue = queue.New("Notification", c.MaxNumRequeues, c.NumThreads, c.runNotificationDispatcher)
+}
+
+// notificationKey returns the key of the session in the notification queue.
+func notificationKey(kind, key string) string {
+	return kind + "/" + key
+}
+
+// sendNotifications queues the BackupSession for the notification workers if its current phase has not been notified yet.
+func (r *backupSessionReconciler) sendNotifications() {
+	if notificationPending(r.session.GetBackupSession()) {
+		r.ctrl.notificationQueue.GetQueue().Add(notificationKey(api_v1beta1.ResourceKindBackupSession, r.key))
+	}
+}
+
+// sendNotifications queues the restore invoker for the notification workers if its current phase has not been notified yet.
+func (r *restoreInvokerReconciler) sendNotifications() {
+	state := notification.StateFromAnnotations(r.invoker.GetObjectMeta().Annotations, util.KeyNotificationState)
+	if state.HasPendingWork(string(r.invoker.GetStatus().Phase)) {
+		r.ctrl.notificationQueue.GetQueue().Add(notificationKey(r.invoker.GetTypeMeta().Kind, r.key))
+	}
+}
+
+// notificationPending returns true if the current phase of the BackupSession has not been notified yet or
+// a delivery is waiting for a retry. Such a session is kept by the history cleanup.
+func notificationPending(bs *api_v1beta1.BackupSession) bool {
+	state := notification.StateFromAnnotations(bs.Annotations, util.KeyNotificationState)
+	return state.HasPendingWork(string(bs.Status.Phase))
+}
+
+func (c *StashController) runNotificationDispatcher(key string) error {
+	kind, objKey, _ := strings.Cut(key, "/")
+	namespace, name, err := cache.SplitMetaNamespaceKey(objKey)
+	if err != nil {
+		return err
+	}
+	logger := klog.NewKlogr().WithValues(
+		apis.ObjectKind, kind,
+		apis.ObjectName, name,
+		apis.ObjectNamespace, namespace,
+	)
+
+	var retryAfter time.Duration
+	switch kind {
+	case api_v1beta1.ResourceKindBackupSession:
+		retryAfter, err = c.dispatchBackupSessionNotifications(logger, namespace, name)
+	case api_v1beta1.ResourceKindRestoreSession:
+		retryAfter, err = c.dispatchRestoreSessionNotifications(logger, namespace, name)
+	default:
+		logger.Info("Skipping notification", apis.KeyReason, fmt.Sprintf("kind %q is not supported", kind))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if retryAfter > 0 {
+		c.notificationQueue.GetQueue().AddAfter(key, retryAfter)
+	}
+	return nil
+}
+
+// dispatchBackupSessionNotifications notifies the notifiers that select the invoker about the current phase of the BackupSession.
+// Failures are logged only. They must not interrupt the backup.
+func (c *StashController) dispatchBackupSessionNotifications(logger klog.Logger, namespace, name string) (time.Duration, error) {
+	bs, err := c.backupSessionLister.BackupSessions(namespace).Get(name)
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	state := notification.StateFromAnnotations(bs.Annotations, util.KeyNotificationState)
+	if !state.HasPendingWork(string(bs.Status.Phase)) {
+		return 0, nil
+	}
+	inv, err := invoker.NewBackupSessionHandler(c.stashClient, bs).GetInvoker()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	newState, retryAfter, ok := c.dispatchNotifications(logger, state, notification.NewBackupEvent(bs, now), inv.GetObjectMeta().Labels, now)
+	if !ok || reflect.DeepEqual(newState, state) {
+		return retryAfter, nil
+	}
+	_, err = v1beta1_util.TryUpdateBackupSession(
+		context.TODO(),
+		c.stashClient.StashV1beta1(),
+		bs.ObjectMeta,
+		func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+			in.Annotations = notification.UpsertState(in.Annotations, util.KeyNotificationState, newState)
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		logger.Error(err, "Failed to record notification state")
+		return 0, err
+	}
+	return retryAfter, nil
+}
+
+// dispatchRestoreSessionNotifications notifies the notifiers that select the RestoreSession about its current phase.
+func (c *StashController) dispatchRestoreSessionNotifications(logger klog.Logger, namespace, name string) (time.Duration, error) {
+	rs, err := c.restoreSessionLister.RestoreSessions(namespace).Get(name)
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	state := notification.StateFromAnnotations(rs.Annotations, util.KeyNotificationState)
+	if !state.HasPendingWork(string(rs.Status.Phase)) {
+		return 0, nil
+	}
+
+	now := time.Now()
+	event := notification.NewRestoreEvent(
+		api_v1beta1.ResourceKindRestoreSession,
+		rs.Name,
+		rs.Namespace,
+		rs.Status.Phase,
+		rs.Status.SessionDuration,
+		invoker.NewRestoreSessionInvoker(c.kubeClient, c.stashClient, rs).GetStatus().TargetStatus,
+		now,
+	)
+	newState, retryAfter, ok := c.dispatchNotifications(logger, state, event, rs.Labels, now)
+	if !ok || reflect.DeepEqual(newState, state) {
+		return retryAfter, nil
+	}
+	_, err = v1beta1_util.TryUpdateRestoreSession(
+		context.TODO(),
+		c.stashClient.StashV1beta1(),
+		rs.ObjectMeta,
+		func(in *api_v1beta1.RestoreSession) *api_v1beta1.RestoreSession {
+			in.Annotations = notification.UpsertState(in.Annotations, util.KeyNotificationState, newState)
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		logger.Error(err, "Failed to record notification state")
+		return 0, err
+	}
+	return retryAfter, nil
+}
+
+// dispatchNotifications sends the event to the notifiers of the namespace and the cluster scoped notifiers.
+// It returns false if the notifiers could not be listed, so that the state is left untouched.
+func (c *StashController) dispatchNotifications(logger klog.Logger, state notification.State, event notification.Event, invokerLabels map[string]string, now time.Time) (notification.State, time.Duration, bool) {
+	notifiers, err := notification.ListNotifiers(c.notifierLister, event.Namespace, meta_util.PodNamespace())
+	if err != nil {
+		logger.Error(err, "Failed to list notifiers")
+		if notifiers == nil {
+			return state, 0, false
+		}
+	}
+
+	state, retryAfter := notification.Dispatch(state, event, invokerLabels, notifiers, (*notification.Notifier).Send, now)
+	for _, d := range state.Deliveries {
+		switch {
+		case d.Delivered && d.LastAttempt.Time.Equal(now):
+			logger.V(4).Info("Notification has been delivered", apis.KeyReason, fmt.Sprintf("notifier %s, phase %s", d.Notifier, state.Phase))
+		case d.Error != "" && d.LastAttempt.Time.Equal(now):
+			logger.Info("Failed to deliver notification",
+				apis.KeyReason, d.Error,
+				"notifier", d.Notifier,
+				"attempts", d.Attempts,
+				"gaveUp", d.GaveUp,
+			)
+		}
+	}
+	return state, retryAfter, true
+}
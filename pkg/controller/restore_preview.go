@@ -0,0 +1,314 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/preview"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/util"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+const (
+	// RestorePreviewed indicates whether the files that would be restored into a target have been listed.
+	RestorePreviewed = "RestorePreviewed"
+
+	RestorePreviewInProgress  = "RestorePreviewInProgress"
+	RestorePreviewSucceeded   = "RestorePreviewSucceeded"
+	RestorePreviewFailed      = "RestorePreviewFailed"
+	RestorePreviewUnsupported = "RestorePreviewUnsupported"
+)
+
+// initRestorePreviewQueue starts the queue that lists the files of the previewed restore invokers.
+// Listing the snapshots of a large repository may take long, so it is not done by the reconcilers.
+func (c *StashController) initRestorePreviewQueue() {
+	c.restorePreviewQueue = queue.New("RestorePreview", c.MaxNumRequeues, c.NumThreads, c.runRestorePreview)
+}
+
+// ensureRestorePreview queues the targets of a restore invoker annotated with util.KeyRestorePreview for
+// the preview workers. The targets that can not be previewed are rejected right away. The result is published
+// as the RestorePreviewed condition of the target and in the util.KeyRestorePreviewResult annotation.
+// Neither the hooks nor the restore executors are run.
+func (r *restoreInvokerReconciler) ensureRestorePreview() error {
+	pending := false
+	for _, targetInfo := range r.invoker.GetTargetInfo() {
+		var tref *api_v1beta1.TargetRef
+		if targetInfo.Target != nil {
+			tref = &targetInfo.Target.Ref
+		}
+		_, cond, err := r.invoker.GetCondition(tref, RestorePreviewed)
+		if err != nil {
+			return err
+		}
+		if cond != nil {
+			pending = pending || cond.Reason == RestorePreviewInProgress
+			continue
+		}
+
+		cond = &kmapi.Condition{
+			Type:               RestorePreviewed,
+			Status:             metav1.ConditionUnknown,
+			Reason:             RestorePreviewInProgress,
+			Message:            "Listing the files that would be restored.",
+			LastTransitionTime: metav1.Now(),
+		}
+		if err := r.ctrl.checkRestorePreviewSupport(r.invoker, targetInfo); err != nil {
+			cond.Status = metav1.ConditionFalse
+			cond.Reason = RestorePreviewUnsupported
+			cond.Message = fmt.Sprintf("Can not preview the restore. Reason: %v", err)
+		} else {
+			pending = true
+		}
+		if err := r.invoker.SetCondition(tref, *cond); err != nil {
+			return err
+		}
+		if cond.Reason == RestorePreviewUnsupported {
+			if err := r.invoker.CreateEvent(core.EventTypeWarning, eventer.EventSourceRestoreSessionController, eventer.EventReasonRestorePreviewed, cond.Message); err != nil {
+				r.logger.Error(err, "Failed to write event")
+			}
+		}
+	}
+	if pending {
+		r.ctrl.restorePreviewQueue.GetQueue().Add(restorePreviewKey(r.invoker.GetTypeMeta().Kind, r.key))
+	}
+	return nil
+}
+
+// restorePreviewKey returns the key of the restore invoker in the preview queue.
+func restorePreviewKey(kind, key string) string {
+	return kind + "/" + key
+}
+
+// checkRestorePreviewSupport returns an error if the target of the restore invoker can not be previewed.
+func (c *StashController) checkRestorePreviewSupport(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) error {
+	if inv.GetDriver() != api_v1beta1.ResticSnapshotter {
+		return fmt.Errorf("preview is not supported for %s driver", inv.GetDriver())
+	}
+	if targetInfo.Target == nil {
+		return fmt.Errorf("preview requires a restore target")
+	}
+	repository, err := inv.GetRepository()
+	if err != nil {
+		return err
+	}
+	return preview.Supported(repository)
+}
+
+// runRestorePreview lists the files of the targets of a restore invoker whose preview is in progress.
+func (c *StashController) runRestorePreview(key string) error {
+	kind, objKey, _ := strings.Cut(key, "/")
+	namespace, name, err := cache.SplitMetaNamespaceKey(objKey)
+	if err != nil {
+		return err
+	}
+	inv, err := invoker.NewRestoreInvoker(c.kubeClient, c.stashClient, kind, name, namespace)
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !util.IsAnnotationTrue(inv.GetObjectMeta().Annotations, util.KeyRestorePreview) {
+		return nil
+	}
+	logger := klog.NewKlogr().WithValues(
+		apis.ObjectKind, kind,
+		apis.ObjectName, name,
+		apis.ObjectNamespace, namespace,
+	)
+
+	for _, targetInfo := range inv.GetTargetInfo() {
+		if targetInfo.Target == nil {
+			continue
+		}
+		tref := &targetInfo.Target.Ref
+		_, cond, err := inv.GetCondition(tref, RestorePreviewed)
+		if err != nil {
+			return err
+		}
+		if cond == nil || cond.Reason != RestorePreviewInProgress {
+			continue
+		}
+
+		newCond := kmapi.Condition{
+			Type:               RestorePreviewed,
+			Status:             metav1.ConditionTrue,
+			Reason:             RestorePreviewSucceeded,
+			LastTransitionTime: metav1.Now(),
+		}
+		hosts, err := c.previewTarget(inv, targetInfo)
+		if err != nil {
+			newCond.Status = metav1.ConditionFalse
+			newCond.Reason = RestorePreviewFailed
+			newCond.Message = fmt.Sprintf("Failed to preview the restore. Reason: %v", err)
+		} else {
+			result := preview.Result{
+				Target: progress.TargetKey(*tref),
+				Hosts:  hosts,
+			}
+			err = util.UpdateRestoreInvokerAnnotations(c.stashClient, inv, func(annotations map[string]string) map[string]string {
+				annotations, err := preview.Upsert(annotations, result)
+				if err != nil {
+					logger.Error(err, "Failed to record the restore preview")
+				}
+				return annotations
+			})
+			if err != nil {
+				return err
+			}
+			newCond.Message = previewMessage(hosts)
+			if _, _, failed := preview.Totals(hosts); failed > 0 {
+				newCond.Status = metav1.ConditionFalse
+				newCond.Reason = RestorePreviewFailed
+			}
+		}
+		if err := inv.SetCondition(tref, newCond); err != nil {
+			return err
+		}
+
+		eventType := core.EventTypeNormal
+		if newCond.Status != metav1.ConditionTrue {
+			eventType = core.EventTypeWarning
+		}
+		if err := inv.CreateEvent(eventType, eventer.EventSourceRestoreSessionController, eventer.EventReasonRestorePreviewed, newCond.Message); err != nil {
+			logger.Error(err, "Failed to write event")
+		}
+	}
+	return nil
+}
+
+// previewMessage returns a bounded summary of the preview of the hosts. The details are in the annotation.
+func previewMessage(hosts []preview.HostPreview) string {
+	files, size, failed := preview.Totals(hosts)
+	msg := fmt.Sprintf("%d files (%s) would be restored into %d host(s).",
+		files,
+		resource.NewQuantity(int64(size), resource.BinarySI).String(),
+		len(hosts)-failed,
+	)
+	if failed > 0 {
+		msg += fmt.Sprintf(" Failed to list the files of %d host(s).", failed)
+	}
+	return msg + fmt.Sprintf(" See annotation %q for the details.", util.KeyRestorePreviewResult)
+}
+
+func (c *StashController) previewTarget(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) ([]preview.HostPreview, error) {
+	hosts, err := c.getRestoreHosts(targetInfo.Target)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := inv.GetRepository()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := c.kubeClient.CoreV1().Secrets(repository.Namespace).Get(context.TODO(), repository.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	pit, err := util.PointInTimeFor(inv.GetObjectMeta().Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	return preview.Run(preview.Options{
+		Repository:  repository,
+		Secret:      secret,
+		Rules:       targetInfo.Target.Rules,
+		Hosts:       hosts,
+		PointInTime: pit,
+	})
+}
+
+// getRestoreHosts returns the names of the hosts that restore into the target. They follow util.GetHostName().
+func (c *StashController) getRestoreHosts(t *api_v1beta1.RestoreTarget) ([]string, error) {
+	indexed := func(n int32) []string {
+		hosts := make([]string, 0, n)
+		for i := int32(0); i < n; i++ {
+			if t.Alias != "" {
+				hosts = append(hosts, fmt.Sprintf("%s-%d", t.Alias, i))
+			} else {
+				hosts = append(hosts, fmt.Sprintf("host-%d", i))
+			}
+		}
+		return hosts
+	}
+	single := func() []string {
+		if t.Alias != "" {
+			return []string{t.Alias}
+		}
+		return []string{apis.DefaultHost}
+	}
+
+	if len(t.VolumeClaimTemplates) != 0 {
+		if t.Replicas != nil {
+			return indexed(*t.Replicas), nil
+		}
+		return single(), nil
+	}
+
+	switch t.Ref.Kind {
+	case apis.KindStatefulSet:
+		replicas, err := c.getTotalHostForRestic(t.Ref)
+		if err != nil {
+			return nil, err
+		}
+		return indexed(*replicas), nil
+	case apis.KindDaemonSet:
+		dmn, err := c.kubeClient.AppsV1().DaemonSets(t.Ref.Namespace).Get(context.TODO(), t.Ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector, err := metav1.LabelSelectorAsSelector(dmn.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		pods, err := c.kubeClient.CoreV1().Pods(t.Ref.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, err
+		}
+		var hosts []string
+		for _, pod := range pods.Items {
+			if pod.Spec.NodeName == "" {
+				continue
+			}
+			if t.Alias != "" {
+				hosts = append(hosts, fmt.Sprintf("%s-%s", t.Alias, pod.Spec.NodeName))
+			} else {
+				hosts = append(hosts, pod.Spec.NodeName)
+			}
+		}
+		return hosts, nil
+	default:
+		return single(), nil
+	}
+}
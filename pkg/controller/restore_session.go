@@ -29,6 +29,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/stash/pkg/executor"
+	"stash.appscode.dev/stash/pkg/progress"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"gomodules.xyz/pointer"
@@ -95,9 +96,30 @@ func (c *StashController) validateRestoreSession(rs *api_v1beta1.RestoreSession)
 			return err
 		}
 	}
+	if err := validateRestoreAnnotations(rs.Annotations); err != nil {
+		return err
+	}
 	return c.validateAgainstUsagePolicy(rs.Spec.Repository, rs.Namespace)
 }
 
+func validateRestoreAnnotations(annotations map[string]string) error {
+	if _, err := util.PointInTimeFor(annotations); err != nil {
+		return err
+	}
+	if err := util.ValidateJobHooks(annotations); err != nil {
+		return err
+	}
+	policy, err := util.ConflictPolicyFor(annotations)
+	if err != nil {
+		return err
+	}
+	if policy.KeepsExistingFiles() && util.IsAnnotationTrue(annotations, util.KeyChecksumManifest) {
+		// the existing files kept by the policy would be reported as mismatches
+		return fmt.Errorf("annotation %q can not be used with the %s conflict policy", util.KeyChecksumManifest, policy)
+	}
+	return nil
+}
+
 func (c *StashController) NewRestoreSessionMutator() hooks.AdmissionHook {
 	return webhook.NewGenericWebhook(
 		schema.GroupVersionResource{
@@ -158,6 +180,7 @@ func (c *StashController) processRestoreSessionEvent(key string) error {
 		apis.ObjectNamespace, restoreSession.Namespace,
 	)
 	logger.V(4).Info("Received Sync/Add/Update event")
+	restoreSession = c.ensureRestoreSessionTrace(logger, restoreSession)
 
 	r := restoreInvokerReconciler{
 		ctrl:    c,
@@ -172,7 +195,10 @@ func (c *StashController) processRestoreSessionEvent(key string) error {
 		return err
 	}
 
-	err = r.reconcile()
+	inProgress := restoreSession.Status.Phase == "" ||
+		restoreSession.Status.Phase == api_v1beta1.RestorePending ||
+		restoreSession.Status.Phase == api_v1beta1.RestoreRunning
+	err = traceReconcile(api_v1beta1.ResourceKindRestoreSession, restoreSession.ObjectMeta, inProgress, r.reconcile)
 	if err != nil {
 		r.logger.Error(err, "Failed to reconcile")
 	}
@@ -200,6 +226,11 @@ func (r *restoreInvokerReconciler) reconcile() error {
 	if err := r.invoker.AddFinalizer(); err != nil {
 		return err
 	}
+	r.sendNotifications()
+
+	if err := r.runRequestedJobHooks(); err != nil {
+		return err
+	}
 
 	if r.isAlreadyInFinalPhase() {
 		r.logger.V(4).Info("Skipping processing event",
@@ -215,14 +246,17 @@ func (r *restoreInvokerReconciler) reconcile() error {
 		}
 
 		if r.shouldExecuteGlobalPostRestoreHook() {
-			if err := r.executeGlobalPostRestoreHook(); err != nil {
-				condErr := conditions.SetGlobalPostRestoreHookSucceededConditionToFalse(r.invoker, err)
-				if condErr != nil {
-					return condErr
-				}
+			// the hook is executed without blocking the worker. wait for it to complete.
+			if completed, err := r.executeGlobalPostRestoreHook(); err != nil || !completed {
+				return err
 			}
 		}
 
+		// the progress of the hosts is outdated once the restore has completed
+		if err := progress.ClearRestoreProgress(r.ctrl.stashClient, r.invoker); err != nil {
+			return err
+		}
+
 		if !restoreMetricsPushed(r.invoker.GetStatus().Conditions) {
 			if err := r.sendRestoreMetrics(); err != nil {
 				condErr := conditions.SetRestoreMetricsPushedConditionToFalse(r.invoker, err)
@@ -253,9 +287,15 @@ func (r *restoreInvokerReconciler) reconcile() error {
 		}
 	}
 
+	// only list the files that would be restored. the target is left untouched.
+	if util.IsAnnotationTrue(invMeta.Annotations, util.KeyRestorePreview) {
+		return r.ensureRestorePreview()
+	}
+
 	if r.shouldExecuteGlobalPreRestoreHook() {
-		if err := r.executeGlobalPreRestoreHook(); err != nil {
-			return conditions.SetGlobalPreRestoreHookSucceededConditionToFalse(r.invoker, err)
+		// the hook is executed without blocking the worker. wait for it to complete.
+		if completed, err := r.executeGlobalPreRestoreHook(); err != nil || !completed || r.globalPreRestoreHookFailed() {
+			return err
 		}
 	}
 
@@ -454,38 +494,27 @@ func (r *restoreInvokerReconciler) shouldExecuteGlobalPostRestoreHook() bool {
 	return false
 }
 
-func (r *restoreInvokerReconciler) executeGlobalPostRestoreHook() error {
-	hookExecutor := stashHooks.HookExecutor{
-		Config: r.ctrl.clientConfig,
-		Hook:   r.invoker.GetGlobalHooks().PostRestore.Handler,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: meta.PodNamespace(),
-			Name:      meta.PodName(),
-		},
-		Summary: r.invoker.GetSummary(api_v1beta1.TargetRef{}, kmapi.ObjectReference{
-			Namespace: r.invoker.GetObjectMeta().Namespace,
-			Name:      r.invoker.GetObjectMeta().Name,
-		}),
-	}
+func (r *restoreInvokerReconciler) executeGlobalPostRestoreHook() (bool, error) {
+	summary := r.invoker.GetSummary(api_v1beta1.TargetRef{}, kmapi.ObjectReference{
+		Namespace: r.invoker.GetObjectMeta().Namespace,
+		Name:      r.invoker.GetObjectMeta().Name,
+	})
 
 	executionPolicy := r.invoker.GetGlobalHooks().PostRestore.ExecutionPolicy
 	if executionPolicy == "" {
 		executionPolicy = api_v1beta1.ExecuteAlways
 	}
 
-	if !stashHooks.IsAllowedByExecutionPolicy(executionPolicy, hookExecutor.Summary) {
+	if !stashHooks.IsAllowedByExecutionPolicy(executionPolicy, summary) {
 		reason := fmt.Sprintf("Skipping executing %s. Reason: executionPolicy is %q but phase is %q.",
 			apis.PostRestoreHook,
 			executionPolicy,
-			hookExecutor.Summary.Status.Phase,
+			summary.Status.Phase,
 		)
-		return conditions.SetGlobalPostRestoreHookSucceededConditionToTrueWithMsg(r.invoker, reason)
+		return true, conditions.SetGlobalPostRestoreHookSucceededConditionToTrueWithMsg(r.invoker, reason)
 	}
 
-	if err := hookExecutor.Execute(); err != nil {
-		return err
-	}
-	return conditions.SetGlobalPostRestoreHookSucceededConditionToTrue(r.invoker)
+	return r.executeGlobalRestoreHook(apis.PostRestoreHook, r.invoker.GetGlobalHooks().PostRestore.Handler, summary)
 }
 
 func (r *restoreInvokerReconciler) shouldExecuteGlobalPreRestoreHook() bool {
@@ -496,23 +525,15 @@ func (r *restoreInvokerReconciler) shouldExecuteGlobalPreRestoreHook() bool {
 	return false
 }
 
-func (r *restoreInvokerReconciler) executeGlobalPreRestoreHook() error {
-	hookExecutor := stashHooks.HookExecutor{
-		Config: r.ctrl.clientConfig,
-		Hook:   r.invoker.GetGlobalHooks().PreRestore,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: meta.PodNamespace(),
-			Name:      meta.PodName(),
-		},
-		Summary: r.invoker.GetSummary(api_v1beta1.TargetRef{}, kmapi.ObjectReference{
+func (r *restoreInvokerReconciler) executeGlobalPreRestoreHook() (bool, error) {
+	return r.executeGlobalRestoreHook(
+		apis.PreRestoreHook,
+		r.invoker.GetGlobalHooks().PreRestore,
+		r.invoker.GetSummary(api_v1beta1.TargetRef{}, kmapi.ObjectReference{
 			Namespace: r.invoker.GetObjectMeta().Namespace,
 			Name:      r.invoker.GetObjectMeta().Name,
 		}),
-	}
-	if err := hookExecutor.Execute(); err != nil {
-		return err
-	}
-	return conditions.SetGlobalPreRestoreHookSucceededConditionToTrue(r.invoker)
+	)
 }
 
 func (r *restoreInvokerReconciler) targetRestoreInitiated(targetRef api_v1beta1.TargetRef) bool {
@@ -706,6 +727,11 @@ func (c *StashController) newVolumeSnapshotRestorer(inv invoker.RestoreInvoker,
 		return nil, err
 	}
 
+	err = c.ensureCrossNamespaceSnapshots(inv, targetInfo, rbacOptions)
+	if err != nil {
+		return nil, err
+	}
+
 	re := &executor.CSISnapshotRestorer{
 		KubeClient:  c.kubeClient,
 		RBACOptions: rbacOptions,
@@ -781,6 +807,16 @@ func (r *restoreInvokerReconciler) cleanupRestoreInvokerOffshoots(invokerRef *co
 		if err != nil {
 			return err
 		}
+		// the references to the VolumeSnapshots of other namespaces would be left behind if they could not be resolved.
+		// so, the cleanup is retried instead of being skipped.
+		snapshots, err := crossNamespaceSnapshots(r.invoker, targetInfo)
+		if err != nil {
+			return fmt.Errorf("failed to resolve the VolumeSnapshots of other namespaces. Reason: %v", err)
+		}
+		rbacOptions.SetCrossNamespaceSnapshots(snapshots)
+		if err := r.ctrl.deleteCrossNamespaceSnapshotReferences(r.invoker, snapshots); err != nil {
+			return err
+		}
 
 		if err := rbacOptions.EnsureRBACResourcesDeleted(); err != nil {
 			return err
@@ -0,0 +1,48 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"stash.appscode.dev/stash/pkg/util"
+)
+
+func TestValidateRestoreAnnotations(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expectErr   bool
+	}{
+		{name: "no annotations", expectErr: false},
+		{name: "invalid restore time", annotations: map[string]string{util.KeyRestoreTime: "yesterday"}, expectErr: true},
+		{name: "invalid conflict policy", annotations: map[string]string{util.KeyConflictPolicy: "Merge"}, expectErr: true},
+		{name: "checksum with Overwrite", annotations: map[string]string{util.KeyChecksumManifest: "true"}, expectErr: false},
+		{name: "checksum with Mirror", annotations: map[string]string{util.KeyChecksumManifest: "true", util.KeyConflictPolicy: "Mirror"}, expectErr: false},
+		{name: "checksum with Sibling", annotations: map[string]string{util.KeyChecksumManifest: "true", util.KeyConflictPolicy: "Sibling"}, expectErr: false},
+		{name: "checksum with SkipExisting", annotations: map[string]string{util.KeyChecksumManifest: "true", util.KeyConflictPolicy: "SkipExisting"}, expectErr: true},
+		{name: "checksum with OverwriteIfNewer", annotations: map[string]string{util.KeyChecksumManifest: "true", util.KeyConflictPolicy: "OverwriteIfNewer"}, expectErr: true},
+		{name: "checksum disabled with SkipExisting", annotations: map[string]string{util.KeyChecksumManifest: "false", util.KeyConflictPolicy: "SkipExisting"}, expectErr: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := validateRestoreAnnotations(c.annotations); (err != nil) != c.expectErr {
+				t.Errorf("expected error %v, found %v", c.expectErr, err)
+			}
+		})
+	}
+}
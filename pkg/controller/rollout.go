@@ -0,0 +1,445 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/scheduler"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"gomodules.xyz/pointer"
+	appsv1 "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	meta_util "kmodules.xyz/client-go/meta"
+	wapi "kmodules.xyz/webhook-runtime/apis/workload/v1"
+	wcs "kmodules.xyz/webhook-runtime/client/workload/v1"
+)
+
+// rolloutBackupInvokers returns the BackupConfigurations targeting the workload that opted in for pre-rollout backups.
+func (c *StashController) rolloutBackupInvokers(w *wapi.Workload) ([]*api_v1beta1.BackupConfiguration, error) {
+	tref := api_v1beta1.TargetRef{
+		APIVersion: w.APIVersion,
+		Kind:       w.Kind,
+		Name:       w.Name,
+		Namespace:  w.Namespace,
+	}
+	backupConfigs, err := c.bcLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var result []*api_v1beta1.BackupConfiguration
+	for _, bc := range backupConfigs {
+		if bc.DeletionTimestamp == nil &&
+			!bc.Spec.Paused &&
+			util.IsAnnotationTrue(bc.Annotations, util.KeyBackupOnRollout) &&
+			util.IsBackupTarget(bc.Spec.Target, tref, bc.Namespace) {
+			result = append(result, bc)
+		}
+	}
+	return result, nil
+}
+
+// applicationTemplateHash calculates a hash of the application containers of a pod template.
+// Containers injected by Stash are ignored so that injecting or removing them does not count as a rollout.
+func applicationTemplateHash(template core.PodTemplateSpec) string {
+	var containers []core.Container
+	for _, c := range template.Spec.InitContainers {
+		if c.Name != apis.StashInitContainer {
+			containers = append(containers, c)
+		}
+	}
+	for _, c := range template.Spec.Containers {
+		if c.Name != apis.StashContainer {
+			containers = append(containers, c)
+		}
+	}
+	hash := fnv.New64a()
+	meta_util.DeepHashObject(hash, containers)
+	return strconv.FormatUint(hash.Sum64(), 10)
+}
+
+// triggerBackupOnRollout creates an instant BackupSession for every BackupConfiguration that opted in for
+// pre-rollout backups when the application containers of the workload have changed since the last time.
+// It also resumes a rollout that has been held by the webhook once those BackupSessions have succeeded.
+func (c *StashController) triggerBackupOnRollout(logger klog.Logger, w *wapi.Workload) error {
+	if w.Kind != apis.KindDeployment && w.Kind != apis.KindStatefulSet {
+		return nil
+	}
+
+	_, held := w.Annotations[util.KeyRolloutHeld]
+	if held && !isRolloutHeld(w) {
+		// the rollout has been resumed by the user. forget about the hold.
+		return c.patchWorkloadAnnotations(w, map[string]interface{}{
+			util.KeyRolloutHeld:           nil,
+			util.KeyRolloutBackupSessions: nil,
+			util.KeyRolloutHeldPartition:  nil,
+			util.KeyRolloutHoldPartition:  nil,
+		})
+	}
+	if held && w.Annotations[util.KeyRolloutBackupSessions] != "" {
+		return c.resumeRolloutIfBackupCompleted(logger, w)
+	}
+
+	invokers, err := c.rolloutBackupInvokers(w)
+	if err != nil {
+		return err
+	}
+
+	hash := applicationTemplateHash(w.Spec.Template)
+	lastHash, found := w.Annotations[util.KeyRolloutTemplateHash]
+	if len(invokers) == 0 || lastHash == hash {
+		// nothing to backup. make sure we are not holding the rollout.
+		if held {
+			return c.resumeRollout(logger, w)
+		}
+		return nil
+	}
+	if !found && !held {
+		// first time we see this workload. just remember the current state of the pod template.
+		return c.patchWorkloadAnnotations(w, map[string]interface{}{
+			util.KeyRolloutTemplateHash: hash,
+		})
+	}
+
+	var sessions []string
+	for _, bc := range invokers {
+		s := scheduler.InstantScheduler{
+			StashClient: c.stashClient,
+			Invoker:     invoker.NewBackupConfigurationInvoker(c.stashClient, bc),
+		}
+		bs, err := s.EnsureSession()
+		if err != nil {
+			return err
+		}
+		sessions = append(sessions, fmt.Sprintf("%s/%s", bs.Namespace, bs.Name))
+		logger.Info("Triggered pre-rollout backup",
+			apis.KeyInvokerKind, api_v1beta1.ResourceKindBackupConfiguration,
+			apis.KeyInvokerName, bc.Name,
+			apis.KeyInvokerNamespace, bc.Namespace,
+		)
+	}
+	eventer.CreateEventWithLog(
+		c.kubeClient,
+		eventer.EventSourceWorkloadController,
+		w.Object,
+		core.EventTypeNormal,
+		eventer.EventReasonRolloutBackupTriggered,
+		fmt.Sprintf("Pod template has changed. Triggered BackupSession(s): %s", strings.Join(sessions, ", ")),
+	)
+	return c.patchWorkloadAnnotations(w, map[string]interface{}{
+		util.KeyRolloutTemplateHash:   hash,
+		util.KeyRolloutBackupSessions: strings.Join(sessions, ","),
+	})
+}
+
+// rolloutAction is what is done with a held rollout according to the phases of its pre-rollout BackupSessions.
+type rolloutAction int
+
+const (
+	rolloutWait rolloutAction = iota
+	rolloutResume
+	rolloutReportFailure
+)
+
+// rolloutDecision decides what to do with a held rollout. heldState is the value of util.KeyRolloutHeld.
+// It returns the index of the failed session along with rolloutReportFailure.
+func rolloutDecision(heldState string, phases []api_v1beta1.BackupSessionPhase) (rolloutAction, int) {
+	for i, phase := range phases {
+		switch phase {
+		case api_v1beta1.BackupSessionSucceeded:
+			continue
+		case api_v1beta1.BackupSessionFailed, api_v1beta1.BackupSessionSkipped:
+			if heldState == string(api_v1beta1.BackupSessionFailed) {
+				// the failure has been reported already
+				return rolloutWait, -1
+			}
+			return rolloutReportFailure, i
+		default:
+			// backup is still running
+			return rolloutWait, -1
+		}
+	}
+	return rolloutResume, -1
+}
+
+// resumeRolloutIfBackupCompleted resumes a held rollout once all the pre-rollout BackupSessions have succeeded.
+// If any of them has failed, the rollout is kept on hold and a warning event is written so that the user can decide.
+func (c *StashController) resumeRolloutIfBackupCompleted(logger klog.Logger, w *wapi.Workload) error {
+	var refs []string
+	var phases []api_v1beta1.BackupSessionPhase
+	for _, ref := range strings.Split(w.Annotations[util.KeyRolloutBackupSessions], ",") {
+		ns, name, err := splitNamespacedName(ref)
+		if err != nil {
+			return err
+		}
+		bs, err := c.backupSessionLister.BackupSessions(ns).Get(name)
+		if err != nil {
+			if kerr.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		refs = append(refs, ref)
+		phases = append(phases, bs.Status.Phase)
+	}
+
+	action, failed := rolloutDecision(w.Annotations[util.KeyRolloutHeld], phases)
+	switch action {
+	case rolloutResume:
+		return c.resumeRollout(logger, w)
+	case rolloutReportFailure:
+		eventer.CreateEventWithLog(
+			c.kubeClient,
+			eventer.EventSourceWorkloadController,
+			w.Object,
+			core.EventTypeWarning,
+			eventer.EventReasonRolloutBackupFailed,
+			fmt.Sprintf("Rollout is kept on hold because BackupSession %s is %q. Resume the rollout manually to proceed.", refs[failed], phases[failed]),
+		)
+		return c.patchWorkloadAnnotations(w, map[string]interface{}{
+			util.KeyRolloutHeld: string(api_v1beta1.BackupSessionFailed),
+		})
+	default:
+		return nil
+	}
+}
+
+func (c *StashController) resumeRollout(logger klog.Logger, w *wapi.Workload) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				util.KeyRolloutHeld:           nil,
+				util.KeyRolloutBackupSessions: nil,
+				util.KeyRolloutHeldPartition:  nil,
+				util.KeyRolloutHoldPartition:  nil,
+			},
+		},
+	}
+	switch w.Kind {
+	case apis.KindDeployment:
+		patch["spec"] = map[string]interface{}{
+			"paused": false,
+		}
+	case apis.KindStatefulSet:
+		// restore the partition the StatefulSet had before it was held
+		var partition interface{}
+		if v := w.Annotations[util.KeyRolloutHeldPartition]; v != "" {
+			p, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid value of annotation %q. Reason: %v", util.KeyRolloutHeldPartition, err)
+			}
+			partition = p
+		}
+		patch["spec"] = map[string]interface{}{
+			"updateStrategy": map[string]interface{}{
+				"rollingUpdate": map[string]interface{}{
+					"partition": partition,
+				},
+			},
+		}
+	}
+	if err := c.patchWorkload(w, patch); err != nil {
+		return err
+	}
+	logger.Info("Resumed rollout after pre-rollout backup")
+	eventer.CreateEventWithLog(
+		c.kubeClient,
+		eventer.EventSourceWorkloadController,
+		w.Object,
+		core.EventTypeNormal,
+		eventer.EventReasonRolloutResumed,
+		"Pre-rollout backup has succeeded. Resumed the rollout.",
+	)
+	return nil
+}
+
+// holdRolloutForBackup is called from the mutating webhook. It holds the rollout of a workload whose application
+// containers have been changed when any of its BackupConfigurations asked to hold the rollout until the backup succeeds.
+// A Deployment is paused. The partition of a StatefulSet is raised to its replicas, so that no pod is updated.
+// The StatefulSets using the OnDelete update strategy are not held as they are not rolled out by the controller.
+func (c *StashController) holdRolloutForBackup(oldW, w *wapi.Workload) error {
+	if oldW == nil {
+		return nil
+	}
+	if _, held := w.Annotations[util.KeyRolloutHeld]; held || isRolloutPaused(w) {
+		return nil
+	}
+	var ss *appsv1.StatefulSet
+	switch obj := w.Object.(type) {
+	case *appsv1.Deployment:
+	case *appsv1.StatefulSet:
+		if obj.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+			return nil
+		}
+		ss = obj
+	default:
+		return nil
+	}
+	if applicationTemplateHash(oldW.Spec.Template) == applicationTemplateHash(w.Spec.Template) {
+		return nil
+	}
+
+	invokers, err := c.rolloutBackupInvokers(w)
+	if err != nil {
+		return err
+	}
+	hold := false
+	for _, bc := range invokers {
+		if util.IsAnnotationTrue(bc.Annotations, util.KeyHoldRolloutForBackup) {
+			hold = true
+			break
+		}
+	}
+	if !hold {
+		return nil
+	}
+
+	if w.Annotations == nil {
+		w.Annotations = map[string]string{}
+	}
+	w.Annotations[util.KeyRolloutHeld] = "true"
+	delete(w.Annotations, util.KeyRolloutBackupSessions)
+	delete(w.Annotations, util.KeyRolloutHeldPartition)
+	delete(w.Annotations, util.KeyRolloutHoldPartition)
+	if ss != nil {
+		if ru := ss.Spec.UpdateStrategy.RollingUpdate; ru != nil && ru.Partition != nil {
+			w.Annotations[util.KeyRolloutHeldPartition] = strconv.Itoa(int(*ru.Partition))
+		}
+		w.Annotations[util.KeyRolloutHoldPartition] = strconv.Itoa(int(statefulSetReplicas(ss)))
+	}
+	if err := wcs.ApplyWorkload(w.Object, w); err != nil {
+		return err
+	}
+	switch obj := w.Object.(type) {
+	case *appsv1.Deployment:
+		obj.Spec.Paused = true
+	case *appsv1.StatefulSet:
+		obj.Spec.UpdateStrategy.Type = appsv1.RollingUpdateStatefulSetStrategyType
+		if obj.Spec.UpdateStrategy.RollingUpdate == nil {
+			obj.Spec.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{}
+		}
+		obj.Spec.UpdateStrategy.RollingUpdate.Partition = pointer.Int32P(statefulSetReplicas(obj))
+	}
+	return nil
+}
+
+// isRolloutPaused returns true if the rollout of the workload is paused. A StatefulSet is paused when its
+// partition does not let any pod to be updated.
+func isRolloutPaused(w *wapi.Workload) bool {
+	switch obj := w.Object.(type) {
+	case *appsv1.Deployment:
+		return obj.Spec.Paused
+	case *appsv1.StatefulSet:
+		ru := obj.Spec.UpdateStrategy.RollingUpdate
+		return obj.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType &&
+			ru != nil && ru.Partition != nil && *ru.Partition >= statefulSetReplicas(obj)
+	}
+	return false
+}
+
+// isRolloutHeld returns true if the rollout of a workload held by Stash has not been resumed by the user.
+// The partition of a StatefulSet is compared against the one set by Stash instead of its replicas,
+// so that scaling up the StatefulSet while it is held does not count as resuming the rollout.
+func isRolloutHeld(w *wapi.Workload) bool {
+	ss, ok := w.Object.(*appsv1.StatefulSet)
+	if !ok {
+		return isRolloutPaused(w)
+	}
+	hold, err := strconv.ParseInt(w.Annotations[util.KeyRolloutHoldPartition], 10, 32)
+	if err != nil {
+		// held before the partition set by Stash has been recorded
+		return isRolloutPaused(w)
+	}
+	ru := ss.Spec.UpdateStrategy.RollingUpdate
+	return ss.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType &&
+		ru != nil && ru.Partition != nil && int64(*ru.Partition) == hold
+}
+
+func statefulSetReplicas(ss *appsv1.StatefulSet) int32 {
+	if ss.Spec.Replicas == nil {
+		return 1
+	}
+	return *ss.Spec.Replicas
+}
+
+func (c *StashController) patchWorkloadAnnotations(w *wapi.Workload, annotations map[string]interface{}) error {
+	return c.patchWorkload(w, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+}
+
+func (c *StashController) patchWorkload(w *wapi.Workload, patch map[string]interface{}) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	switch w.Kind {
+	case apis.KindDeployment:
+		_, err = c.kubeClient.AppsV1().Deployments(w.Namespace).Patch(context.TODO(), w.Name, types.MergePatchType, data, metav1.PatchOptions{})
+	case apis.KindStatefulSet:
+		_, err = c.kubeClient.AppsV1().StatefulSets(w.Namespace).Patch(context.TODO(), w.Name, types.MergePatchType, data, metav1.PatchOptions{})
+	default:
+		return fmt.Errorf("patching %s is not supported", w.Kind)
+	}
+	return err
+}
+
+func splitNamespacedName(ref string) (string, string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid reference %q. expected format: <namespace>/<name>", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// notifyRolloutTargets requeues the Deployments and StatefulSets backed up by this session so that
+// a rollout held for a pre-rollout backup gets resumed without waiting for the next resync.
+func (r *backupSessionReconciler) notifyRolloutTargets() {
+	for _, targetInfo := range r.invoker.GetTargetInfo() {
+		if targetInfo.Target == nil {
+			continue
+		}
+		ref := targetInfo.Target.Ref
+		if ref.Kind != apis.KindDeployment && ref.Kind != apis.KindStatefulSet {
+			continue
+		}
+		if err := r.ctrl.sendEventToWorkloadQueue(ref.Kind, ref.Namespace, ref.Name); err != nil {
+			r.logger.Error(err, "Failed to requeue target workload",
+				apis.KeyTargetKind, ref.Kind,
+				apis.KeyTargetName, ref.Name,
+				apis.KeyTargetNamespace, ref.Namespace,
+			)
+		}
+	}
+}
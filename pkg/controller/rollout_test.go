@@ -0,0 +1,145 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"gomodules.xyz/pointer"
+	appsv1 "k8s.io/api/apps/v1"
+	wapi "kmodules.xyz/webhook-runtime/apis/workload/v1"
+)
+
+func TestRolloutDecision(t *testing.T) {
+	cases := []struct {
+		name       string
+		heldState  string
+		phases     []api_v1beta1.BackupSessionPhase
+		wantAction rolloutAction
+		wantFailed int
+	}{
+		{
+			name:       "all succeeded",
+			heldState:  "true",
+			phases:     []api_v1beta1.BackupSessionPhase{api_v1beta1.BackupSessionSucceeded, api_v1beta1.BackupSessionSucceeded},
+			wantAction: rolloutResume,
+			wantFailed: -1,
+		},
+		{
+			name:       "still running",
+			heldState:  "true",
+			phases:     []api_v1beta1.BackupSessionPhase{api_v1beta1.BackupSessionSucceeded, api_v1beta1.BackupSessionRunning},
+			wantAction: rolloutWait,
+			wantFailed: -1,
+		},
+		{
+			name:       "failed",
+			heldState:  "true",
+			phases:     []api_v1beta1.BackupSessionPhase{api_v1beta1.BackupSessionSucceeded, api_v1beta1.BackupSessionFailed},
+			wantAction: rolloutReportFailure,
+			wantFailed: 1,
+		},
+		{
+			name:       "failure reported already",
+			heldState:  string(api_v1beta1.BackupSessionFailed),
+			phases:     []api_v1beta1.BackupSessionPhase{api_v1beta1.BackupSessionSkipped},
+			wantAction: rolloutWait,
+			wantFailed: -1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			action, failed := rolloutDecision(c.heldState, c.phases)
+			if action != c.wantAction || failed != c.wantFailed {
+				t.Errorf("expected (%v, %d), found (%v, %d)", c.wantAction, c.wantFailed, action, failed)
+			}
+		})
+	}
+}
+
+func TestIsRolloutPaused(t *testing.T) {
+	statefulSet := func(strategy appsv1.StatefulSetUpdateStrategyType, partition *int32) *wapi.Workload {
+		ss := &appsv1.StatefulSet{}
+		ss.Spec.Replicas = pointer.Int32P(3)
+		ss.Spec.UpdateStrategy.Type = strategy
+		if partition != nil {
+			ss.Spec.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{Partition: partition}
+		}
+		return &wapi.Workload{Object: ss}
+	}
+	cases := []struct {
+		name string
+		w    *wapi.Workload
+		want bool
+	}{
+		{name: "paused deployment", w: &wapi.Workload{Object: &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Paused: true}}}, want: true},
+		{name: "running deployment", w: &wapi.Workload{Object: &appsv1.Deployment{}}},
+		{name: "statefulset without partition", w: statefulSet(appsv1.RollingUpdateStatefulSetStrategyType, nil)},
+		{name: "statefulset with lower partition", w: statefulSet(appsv1.RollingUpdateStatefulSetStrategyType, pointer.Int32P(1))},
+		{name: "held statefulset", w: statefulSet(appsv1.RollingUpdateStatefulSetStrategyType, pointer.Int32P(3)), want: true},
+		{name: "on delete statefulset", w: statefulSet(appsv1.OnDeleteStatefulSetStrategyType, pointer.Int32P(3))},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRolloutPaused(c.w); got != c.want {
+				t.Errorf("expected %v, found %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestIsRolloutHeld(t *testing.T) {
+	statefulSet := func(replicas int32, partition *int32, holdPartition string) *wapi.Workload {
+		ss := &appsv1.StatefulSet{}
+		ss.Spec.Replicas = pointer.Int32P(replicas)
+		ss.Spec.UpdateStrategy.Type = appsv1.RollingUpdateStatefulSetStrategyType
+		if partition != nil {
+			ss.Spec.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{Partition: partition}
+		}
+		w := &wapi.Workload{Object: ss}
+		if holdPartition != "" {
+			w.Annotations = map[string]string{util.KeyRolloutHoldPartition: holdPartition}
+		}
+		return w
+	}
+	cases := []struct {
+		name string
+		w    *wapi.Workload
+		want bool
+	}{
+		{name: "held deployment", w: &wapi.Workload{Object: &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Paused: true}}}, want: true},
+		{name: "resumed deployment", w: &wapi.Workload{Object: &appsv1.Deployment{}}},
+		{name: "held statefulset", w: statefulSet(3, pointer.Int32P(3), "3"), want: true},
+		{name: "held statefulset scaled up", w: statefulSet(5, pointer.Int32P(3), "3"), want: true},
+		{name: "held statefulset scaled down", w: statefulSet(2, pointer.Int32P(3), "3"), want: true},
+		{name: "partition lowered by the user", w: statefulSet(3, pointer.Int32P(0), "3")},
+		{name: "partition removed by the user", w: statefulSet(3, nil, "3")},
+		{name: "held by an older version", w: statefulSet(3, pointer.Int32P(3), ""), want: true},
+		{name: "scaled up while held by an older version", w: statefulSet(5, pointer.Int32P(3), "")},
+		{name: "invalid hold partition", w: statefulSet(3, pointer.Int32P(3), "three"), want: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRolloutHeld(c.w); got != c.want {
+				t.Errorf("expected %v, found %v", c.want, got)
+			}
+		})
+	}
+}
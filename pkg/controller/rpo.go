@@ -0,0 +1,119 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/rpo"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	condutil "kmodules.xyz/client-go/conditions"
+)
+
+const rpoCheckInterval = time.Minute
+
+// runRPOMonitor periodically checks the BackupConfigurations against their RPO. Unlike the queues, it does not
+// depend on any event, so a suspended or broken CronJob that creates no BackupSession is still noticed.
+func (c *StashController) runRPOMonitor(stopCh <-chan struct{}) {
+	wait.Until(c.checkBackupStaleness, rpoCheckInterval, stopCh)
+}
+
+func (c *StashController) checkBackupStaleness() {
+	backupConfigs, err := c.bcLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list BackupConfigurations. Reason: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, bc := range backupConfigs {
+		if bc.DeletionTimestamp != nil {
+			continue
+		}
+		if err := c.ensureBackupStaleCondition(bc, now); err != nil {
+			klog.Errorf("failed to check RPO of BackupConfiguration %s/%s. Reason: %v", bc.Namespace, bc.Name, err)
+		}
+	}
+}
+
+// ensureBackupStaleCondition sets the BackupStale condition of a BackupConfiguration. The status is only
+// updated when the condition changes, and an event is emitted when a backup becomes stale or recovers.
+func (c *StashController) ensureBackupStaleCondition(bc *api_v1beta1.BackupConfiguration, now time.Time) error {
+	status, monitored, err := rpo.ForBackupConfiguration(bc, c.backupSessionLister, c.repoLister, now)
+	if err != nil {
+		return err
+	}
+	_, existing := condutil.GetCondition(bc.Status.Conditions, rpo.BackupStale)
+
+	if !monitored {
+		if existing == nil {
+			return nil
+		}
+		_, err = v1beta1_util.UpdateBackupConfigurationStatus(
+			context.TODO(),
+			c.stashClient.StashV1beta1(),
+			bc.ObjectMeta,
+			func(in *api_v1beta1.BackupConfigurationStatus) (types.UID, *api_v1beta1.BackupConfigurationStatus) {
+				in.Conditions = condutil.RemoveCondition(in.Conditions, rpo.BackupStale)
+				return bc.UID, in
+			},
+			metav1.UpdateOptions{},
+		)
+		return err
+	}
+
+	cond := rpo.Condition(status)
+	if existing != nil && existing.Status == cond.Status && existing.Reason == cond.Reason && existing.Message == cond.Message {
+		return nil
+	}
+	if err := invoker.NewBackupConfigurationInvoker(c.stashClient, bc).SetCondition(nil, cond); err != nil {
+		return err
+	}
+
+	wasStale := existing != nil && existing.Status == metav1.ConditionTrue
+	switch {
+	case status.Stale && !wasStale:
+		eventer.CreateEventWithLog(
+			c.kubeClient,
+			eventer.EventSourceBackupConfigurationController,
+			bc,
+			core.EventTypeWarning,
+			eventer.EventReasonBackupStale,
+			cond.Message,
+		)
+	case !status.Stale && wasStale:
+		eventer.CreateEventWithLog(
+			c.kubeClient,
+			eventer.EventSourceBackupConfigurationController,
+			bc,
+			core.EventTypeNormal,
+			eventer.EventReasonBackupWithinRPO,
+			cond.Message,
+		)
+	}
+	return nil
+}
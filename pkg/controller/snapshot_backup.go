@@ -0,0 +1,79 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/executor"
+	"stash.appscode.dev/stash/pkg/util"
+)
+
+// isBackupFromSnapshot returns true if a PVC target of the Restic driver should be backed up from a VolumeSnapshot.
+func isBackupFromSnapshot(inv invoker.BackupInvoker, targetInfo invoker.BackupTargetInfo) bool {
+	return inv.GetDriver() == api_v1beta1.ResticSnapshotter &&
+		targetInfo.Target != nil &&
+		targetInfo.Target.Ref.Kind == apis.KindPersistentVolumeClaim &&
+		util.IsAnnotationTrue(inv.GetObjectMeta().Annotations, util.KeyBackupFromSnapshot)
+}
+
+// validateBackupFromSnapshot rejects util.KeyBackupFromSnapshot on the BackupConfigurations it does not apply to.
+// The workload targets are backed up by the sidecar as the paths of the workload do not map to whole volumes.
+func validateBackupFromSnapshot(bc *api_v1beta1.BackupConfiguration) error {
+	if !util.IsAnnotationTrue(bc.Annotations, util.KeyBackupFromSnapshot) {
+		return nil
+	}
+	if bc.Spec.Driver == api_v1beta1.VolumeSnapshotter {
+		return fmt.Errorf("annotation %q is not supported for %s driver", util.KeyBackupFromSnapshot, bc.Spec.Driver)
+	}
+	if bc.Spec.Target == nil || bc.Spec.Target.Ref.Kind != apis.KindPersistentVolumeClaim {
+		return fmt.Errorf("annotation %q is only supported for %s target", util.KeyBackupFromSnapshot, apis.KindPersistentVolumeClaim)
+	}
+	return nil
+}
+
+func (c *StashController) newSnapshotBackupJob(inv invoker.BackupInvoker, session *invoker.BackupSessionHandler, index int) (*executor.SnapshotBackupJob, error) {
+	backupJob, err := c.newBackupJob(inv, session, index)
+	if err != nil {
+		return nil, err
+	}
+	return &executor.SnapshotBackupJob{
+		BackupJob:      *backupJob,
+		SnapshotClient: c.snapshotClient,
+	}, nil
+}
+
+// cleanupSnapshotBackups deletes the VolumeSnapshots and the temporary PVCs of the targets that were backed up from VolumeSnapshot.
+// They are owned by the BackupSession. So, they are garbage collected with the BackupSession if the cleanup fails here.
+func (r *backupSessionReconciler) cleanupSnapshotBackups() {
+	for i, targetInfo := range r.invoker.GetTargetInfo() {
+		if !isBackupFromSnapshot(r.invoker, targetInfo) {
+			continue
+		}
+		err := executor.CleanupSnapshotBackup(r.ctrl.kubeClient, r.ctrl.snapshotClient, r.session.GetObjectMeta().Namespace, r.session.GetObjectMeta().Name, i)
+		if err != nil {
+			r.logger.Error(err, "Failed to cleanup the VolumeSnapshot and the temporary PVC",
+				apis.KeyTargetKind, targetInfo.Target.Ref.Kind,
+				apis.KeyTargetName, targetInfo.Target.Ref.Name,
+				apis.KeyTargetNamespace, targetInfo.Target.Ref.Namespace,
+			)
+		}
+	}
+}
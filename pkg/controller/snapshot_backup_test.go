@@ -0,0 +1,62 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateBackupFromSnapshot(t *testing.T) {
+	cases := []struct {
+		name    string
+		driver  api_v1beta1.Snapshotter
+		kind    string
+		wantErr bool
+	}{
+		{name: "pvc", driver: api_v1beta1.ResticSnapshotter, kind: apis.KindPersistentVolumeClaim},
+		{name: "default driver", kind: apis.KindPersistentVolumeClaim},
+		{name: "deployment", driver: api_v1beta1.ResticSnapshotter, kind: apis.KindDeployment, wantErr: true},
+		{name: "statefulset", driver: api_v1beta1.ResticSnapshotter, kind: apis.KindStatefulSet, wantErr: true},
+		{name: "volume snapshotter", driver: api_v1beta1.VolumeSnapshotter, kind: apis.KindPersistentVolumeClaim, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bc := &api_v1beta1.BackupConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{util.KeyBackupFromSnapshot: "true"},
+				},
+				Spec: api_v1beta1.BackupConfigurationSpec{
+					Driver: c.driver,
+					BackupConfigurationTemplateSpec: api_v1beta1.BackupConfigurationTemplateSpec{
+						Target: &api_v1beta1.BackupTarget{
+							Ref: api_v1beta1.TargetRef{Kind: c.kind, Name: "sample"},
+						},
+					},
+				},
+			}
+			if err := validateBackupFromSnapshot(bc); (err != nil) != c.wantErr {
+				t.Errorf("expected error: %v, found %v", c.wantErr, err)
+			}
+		})
+	}
+}
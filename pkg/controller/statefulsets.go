@@ -72,7 +72,10 @@ func (c *StashController) NewStatefulSetWebhook() hooks.AdmissionHook {
 						apis.ObjectNamespace, w.Namespace,
 					),
 				}
-				err := r.reconcile(apis.CallerWebhook)
+				if err := r.reconcile(apis.CallerWebhook); err != nil {
+					return w, err
+				}
+				err := c.holdRolloutForBackup(oldObj.(*wapi.Workload), w)
 				return w, err
 			},
 		},
@@ -143,6 +146,11 @@ func (c *StashController) processStatefulSetEvent(key string) error {
 			return err
 		}
 
+		if err := c.triggerBackupOnRollout(logger, w); err != nil {
+			logger.Error(err, "Failed to trigger pre-rollout backup")
+			return err
+		}
+
 		// if the workload does not have any stash sidecar/init-container then
 		// delete respective ConfigMapLock and RBAC stuffs if exist
 		if err := c.ensureUnnecessaryConfigMapLockDeleted(w); err != nil {
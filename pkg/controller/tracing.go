@@ -0,0 +1,95 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	meta_util "kmodules.xyz/client-go/meta"
+)
+
+// ensureBackupSessionTrace starts the trace of a new BackupSession and records it in the annotations of the session.
+// The BackupSession is returned unchanged if the trace could not be recorded, tracing must not block the backup.
+func (c *StashController) ensureBackupSessionTrace(logger klog.Logger, bs *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+	if !tracing.Enabled() || bs.Status.Phase != "" || bs.Annotations[util.KeyTraceContext] != "" {
+		return bs
+	}
+	tp := tracing.NewSessionTrace(api_v1beta1.ResourceKindBackupSession, bs.Namespace, bs.Name)
+	out, err := v1beta1_util.TryUpdateBackupSession(
+		context.TODO(),
+		c.stashClient.StashV1beta1(),
+		bs.ObjectMeta,
+		func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+			in.Annotations = meta_util.OverwriteKeys(in.Annotations, tracing.Annotations(tp))
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		logger.Error(err, "Failed to record trace context")
+		return bs
+	}
+	return out
+}
+
+// ensureRestoreSessionTrace starts the trace of a new RestoreSession and records it in the annotations of the session.
+func (c *StashController) ensureRestoreSessionTrace(logger klog.Logger, rs *api_v1beta1.RestoreSession) *api_v1beta1.RestoreSession {
+	if !tracing.Enabled() || rs.Status.Phase != "" || rs.Annotations[util.KeyTraceContext] != "" {
+		return rs
+	}
+	tp := tracing.NewSessionTrace(api_v1beta1.ResourceKindRestoreSession, rs.Namespace, rs.Name)
+	out, err := v1beta1_util.TryUpdateRestoreSession(
+		context.TODO(),
+		c.stashClient.StashV1beta1(),
+		rs.ObjectMeta,
+		func(in *api_v1beta1.RestoreSession) *api_v1beta1.RestoreSession {
+			in.Annotations = meta_util.OverwriteKeys(in.Annotations, tracing.Annotations(tp))
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		logger.Error(err, "Failed to record trace context")
+		return rs
+	}
+	return out
+}
+
+// traceReconcile runs the reconciliation of a session in a span of the trace of the session.
+// The reconciliations of the completed sessions are not traced as they are triggered by the periodic resync mostly.
+func traceReconcile(kind string, meta metav1.ObjectMeta, inProgress bool, reconcile func() error) error {
+	if !inProgress || meta.Annotations[util.KeyTraceContext] == "" {
+		return reconcile()
+	}
+	_, span := tracing.Start(
+		tracing.SessionContext(meta.Annotations),
+		"Reconcile "+kind,
+		tracing.KeySessionKind.String(kind),
+		tracing.KeyNamespace.String(meta.Namespace),
+		tracing.KeySessionName.String(meta.Name),
+	)
+	err := reconcile()
+	tracing.End(span, err)
+	return err
+}
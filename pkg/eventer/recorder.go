@@ -41,6 +41,7 @@ const (
 	EventSourceWorkloadController            = "Workload Controller"
 	EventSourceBackupSidecar                 = "Backup Sidecar"
 	EventSourceRestoreInitContainer          = "Restore Init-Container"
+	EventSourceRestoreJob                    = "Restore Job"
 	EventSourceBackupTriggeringCronJob       = "Backup Triggering CronJob"
 	EventSourceStatusUpdater                 = "Status Updater"
 
@@ -51,8 +52,12 @@ const (
 	EventReasonHostBackupSucceded = "Host Backup Succeeded"
 	EventReasonHostBackupFailed   = "Host Backup Failed"
 	// Restore Events
-	EventReasonHostRestoreSucceeded = "Host Restore Succeeded"
-	EventReasonHostRestoreFailed    = "Host Restore Failed"
+	EventReasonHostRestoreSucceeded     = "Host Restore Succeeded"
+	EventReasonHostRestoreFailed        = "Host Restore Failed"
+	EventReasonHostRestoreProgress      = "Host Restore Progress"
+	EventReasonRestorePreviewed         = "Restore Previewed"
+	EventReasonSnapshotsSelected        = "Snapshots Selected"
+	EventReasonRestoreConflictsResolved = "Restore Conflicts Resolved"
 
 	// Sidecar Events
 	EventReasonSidecarInjectionFailed               = "Sidecar Injection Failed"
@@ -67,6 +72,24 @@ const (
 	EventReasonInitContainerDeletionSucceeded  = "Init-Container Deletion Succeeded"
 
 	EventReasonWorkloadControllerTriggeringFailed = "Failed To Trigger Workload Controller"
+
+	// Rollout Events
+	EventReasonRolloutBackupTriggered = "Pre-Rollout Backup Triggered"
+	EventReasonRolloutBackupFailed    = "Pre-Rollout Backup Failed"
+	EventReasonRolloutResumed         = "Rollout Resumed"
+
+	// Restore verification Events
+	EventReasonBackupVerificationStarted   = "Backup Verification Started"
+	EventReasonBackupVerificationSucceeded = "Backup Verification Succeeded"
+	EventReasonBackupVerificationFailed    = "Backup Verification Failed"
+
+	// RPO monitoring Events
+	EventReasonBackupStale     = "Backup Stale"
+	EventReasonBackupWithinRPO = "Backup Within RPO"
+
+	// Backup history Events
+	EventReasonBackupHistoryTruncated = "Backup History Truncated"
+	EventReasonBackupHistoryFailed    = "Backup History Failed"
 )
 
 func NewEventRecorder(client kubernetes.Interface, component string) record.EventRecorder {
@@ -28,6 +28,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/stash/pkg/rbac"
 	"stash.appscode.dev/stash/pkg/resolver"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	core "k8s.io/api/core/v1"
@@ -82,6 +83,7 @@ func (e *BackupJob) Ensure() (runtime.Object, kutil.VerbType, error) {
 		imagePullSecrets:   e.ImagePullSecrets,
 		runtimeSettings:    runtimeSettings,
 		backOffLimit:       0,
+		env:                tracing.EnvVars(tracing.SessionContext(e.Session.GetObjectMeta().Annotations)),
 	}
 	if runtimeSettings.Pod != nil && runtimeSettings.Pod.PodAnnotations != nil {
 		job.podAnnotations = runtimeSettings.Pod.PodAnnotations
@@ -42,22 +42,26 @@ const (
 	TypeSidecar             Type = "Sidecar"
 	TypeInitContainer       Type = "InitContainer"
 	TypeBackupJob           Type = "BackupJob"
+	TypeSnapshotBackupJob   Type = "SnapshotBackupJob"
 	TypeRestoreJob          Type = "RestoreJob"
 	TypeCSISnapshooter      Type = "CSIVolumeSnapshooter"
 	TypeCSISnapshotRestorer Type = "CSIVolumeRestorer"
 )
 
 type jobOptions struct {
-	kubeClient         kubernetes.Interface
-	meta               metav1.ObjectMeta
-	owner              *metav1.OwnerReference
-	podSpec            core.PodSpec
-	podLabels          map[string]string
-	podAnnotations     map[string]string
-	imagePullSecrets   []core.LocalObjectReference
-	serviceAccountName string
-	runtimeSettings    ofst.RuntimeSettings
-	backOffLimit       int32
+	kubeClient            kubernetes.Interface
+	meta                  metav1.ObjectMeta
+	owner                 *metav1.OwnerReference
+	podSpec               core.PodSpec
+	podLabels             map[string]string
+	podAnnotations        map[string]string
+	imagePullSecrets      []core.LocalObjectReference
+	serviceAccountName    string
+	runtimeSettings       ofst.RuntimeSettings
+	backOffLimit          int32
+	activeDeadlineSeconds *int64
+	// env is injected into every container of the pod, i.e. to pass the trace context
+	env []core.EnvVar
 }
 
 func (opt *jobOptions) ensure() (runtime.Object, kutil.VerbType, error) {
@@ -74,6 +78,9 @@ func (opt *jobOptions) ensure() (runtime.Object, kutil.VerbType, error) {
 			in.Spec.Template.Spec.ImagePullSecrets = core_util.MergeLocalObjectReferences(in.Spec.Template.Spec.ImagePullSecrets, opt.imagePullSecrets)
 			in.Spec.Template.Spec.ServiceAccountName = opt.serviceAccountName
 			in.Spec.BackoffLimit = &opt.backOffLimit
+			if opt.activeDeadlineSeconds != nil {
+				in.Spec.ActiveDeadlineSeconds = opt.activeDeadlineSeconds
+			}
 			return in
 		},
 		metav1.PatchOptions{},
@@ -84,6 +91,10 @@ func (opt *jobOptions) upsertPodSpec(cur core.PodSpec) core.PodSpec {
 	cur.Volumes = core_util.UpsertVolume(cur.Volumes, opt.podSpec.Volumes...)
 	cur.InitContainers = core_util.UpsertContainers(cur.InitContainers, opt.podSpec.InitContainers)
 	cur.Containers = core_util.UpsertContainers(cur.Containers, opt.podSpec.Containers)
+	if len(opt.env) > 0 {
+		cur.InitContainers = upsertEnvVars(cur.InitContainers, opt.env)
+		cur.Containers = upsertEnvVars(cur.Containers, opt.env)
+	}
 	if opt.podSpec.RestartPolicy != "" {
 		cur.RestartPolicy = opt.podSpec.RestartPolicy
 	}
@@ -92,3 +103,10 @@ func (opt *jobOptions) upsertPodSpec(cur core.PodSpec) core.PodSpec {
 	}
 	return cur
 }
+
+func upsertEnvVars(containers []core.Container, env []core.EnvVar) []core.Container {
+	for i := range containers {
+		containers[i].Env = core_util.UpsertEnvVars(containers[i].Env, env...)
+	}
+	return containers
+}
@@ -0,0 +1,183 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/stash/pkg/util"
+
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	kutil "kmodules.xyz/client-go"
+	core_util "kmodules.xyz/client-go/core/v1"
+	ofst "kmodules.xyz/offshoot-api/api/v1"
+)
+
+// HookJob runs the pod template of a Job hook to completion.
+// The Env variables are injected into every container of the template before the variables of the container itself.
+// The Job runs with ServiceAccountName, or the default ServiceAccount of the namespace if it is empty, whatever the template sets.
+type HookJob struct {
+	KubeClient         kubernetes.Interface
+	Meta               metav1.ObjectMeta
+	Owner              *metav1.OwnerReference
+	Template           core.PodTemplateSpec
+	Env                []core.EnvVar
+	ServiceAccountName string
+	Timeout            time.Duration
+}
+
+func (e *HookJob) Ensure() (runtime.Object, kutil.VerbType, error) {
+	spec := e.Template.Spec
+	spec.InitContainers = e.injectEnv(spec.InitContainers)
+	spec.Containers = e.injectEnv(spec.Containers)
+	// the exit status of the hook is decided by the first attempt
+	spec.RestartPolicy = core.RestartPolicyNever
+	// the Job must not run as a ServiceAccount chosen by the template
+	spec.ServiceAccountName = ""
+	spec.DeprecatedServiceAccount = ""
+	// round up, so that a fraction of a second does not become no time at all
+	deadline := int64(math.Ceil(e.Timeout.Seconds()))
+
+	job := jobOptions{
+		kubeClient:            e.KubeClient,
+		meta:                  e.Meta,
+		owner:                 e.Owner,
+		podSpec:               spec,
+		podLabels:             e.Template.Labels,
+		podAnnotations:        e.Template.Annotations,
+		imagePullSecrets:      spec.ImagePullSecrets,
+		serviceAccountName:    e.ServiceAccountName,
+		runtimeSettings:       ofst.RuntimeSettings{Pod: podRuntimeSettings(spec)},
+		backOffLimit:          0,
+		activeDeadlineSeconds: &deadline,
+	}
+	return job.ensure()
+}
+
+// Run creates the Job and waits for it to complete. An error is returned if the Job fails
+// or does not complete within the timeout. The Job is deleted if it times out.
+func (e *HookJob) Run() error {
+	_, _, err := e.Ensure()
+	if err != nil {
+		return err
+	}
+	timedOut, err := e.wait(e.Timeout)
+	if timedOut {
+		if delErr := e.delete(); delErr != nil {
+			klog.Errorf("Failed to delete hook Job %s/%s. Reason: %v", e.Meta.Namespace, e.Meta.Name, delErr)
+		}
+	}
+	return err
+}
+
+// Wait waits for a Job created by the operator to complete. An error is returned if the Job fails or does not
+// complete within the timeout. The Job is not deleted as the caller is not allowed to. Its deadline stops it anyway.
+func (e *HookJob) Wait(timeout time.Duration) error {
+	_, err := e.wait(timeout)
+	return err
+}
+
+// wait waits for the Job to complete. It reports whether the Job has timed out along with the error.
+func (e *HookJob) wait(timeout time.Duration) (bool, error) {
+	klog.Infof("Waiting for hook Job %s/%s to complete", e.Meta.Namespace, e.Meta.Name)
+
+	job, err := util.WaitUntilJobCompleted(e.KubeClient, e.Meta, timeout)
+	if err != nil {
+		return true, fmt.Errorf("hook Job %s/%s did not complete within %s", e.Meta.Namespace, e.Meta.Name, timeout)
+	}
+	if util.IsJobSucceeded(job) {
+		return false, nil
+	}
+	return false, fmt.Errorf("hook Job %s/%s failed. Reason: %s", e.Meta.Namespace, e.Meta.Name, e.failureReason(job))
+}
+
+func (e *HookJob) injectEnv(containers []core.Container) []core.Container {
+	out := make([]core.Container, 0, len(containers))
+	for _, c := range containers {
+		env := make([]core.EnvVar, len(e.Env))
+		copy(env, e.Env)
+		c.Env = core_util.UpsertEnvVars(env, c.Env...)
+		out = append(out, c)
+	}
+	return out
+}
+
+// failureReason reports the exit status of the failed containers of the Job pods.
+// It falls back to the message of the failure condition of the Job.
+func (e *HookJob) failureReason(job *batch.Job) string {
+	var reasons []string
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err == nil {
+		pods, err := e.KubeClient.CoreV1().Pods(job.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+		if err == nil {
+			for _, pod := range pods.Items {
+				for _, status := range pod.Status.ContainerStatuses {
+					t := status.State.Terminated
+					if t == nil || t.ExitCode == 0 {
+						continue
+					}
+					reason := fmt.Sprintf("container %s exited with code %d", status.Name, t.ExitCode)
+					if msg := strings.TrimSpace(t.Message); msg != "" {
+						reason = fmt.Sprintf("%s: %s", reason, msg)
+					}
+					reasons = append(reasons, reason)
+				}
+			}
+		}
+	}
+	if len(reasons) != 0 {
+		return strings.Join(reasons, "; ")
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batch.JobFailed && cond.Status == core.ConditionTrue {
+			return cond.Message
+		}
+	}
+	return "unknown"
+}
+
+func (e *HookJob) delete() error {
+	policy := metav1.DeletePropagationBackground
+	return e.KubeClient.BatchV1().Jobs(e.Meta.Namespace).Delete(context.TODO(), e.Meta.Name, metav1.DeleteOptions{PropagationPolicy: &policy})
+}
+
+// podRuntimeSettings keeps the pod level settings of the template that are not merged by jobOptions.
+func podRuntimeSettings(spec core.PodSpec) *ofst.PodRuntimeSettings {
+	return &ofst.PodRuntimeSettings{
+		NodeSelector:                 spec.NodeSelector,
+		AutomountServiceAccountToken: spec.AutomountServiceAccountToken,
+		NodeName:                     spec.NodeName,
+		SecurityContext:              spec.SecurityContext,
+		Affinity:                     spec.Affinity,
+		SchedulerName:                spec.SchedulerName,
+		Tolerations:                  spec.Tolerations,
+		PriorityClassName:            spec.PriorityClassName,
+		Priority:                     spec.Priority,
+		RuntimeClassName:             spec.RuntimeClassName,
+		EnableServiceLinks:           spec.EnableServiceLinks,
+		TopologySpreadConstraints:    spec.TopologySpreadConstraints,
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func newHookJob(client *fake.Clientset, timeout time.Duration) *HookJob {
+	return &HookJob{
+		KubeClient: client,
+		Meta:       metav1.ObjectMeta{Name: "pre-backup-hook", Namespace: "demo"},
+		Template: core.PodTemplateSpec{
+			Spec: core.PodSpec{
+				// overridden by the ServiceAccount of the HookJob
+				ServiceAccountName: "admin",
+				Containers: []core.Container{{
+					Name:  "hook",
+					Image: "busybox",
+					Env:   []core.EnvVar{{Name: "TARGET_HOST", Value: "overridden"}},
+				}},
+			},
+		},
+		Env: []core.EnvVar{
+			{Name: "TARGET_HOST", Value: "host-0"},
+			{Name: "HOOK_TYPE", Value: "preBackup"},
+		},
+		ServiceAccountName: "stash-hook",
+		Timeout:            timeout,
+	}
+}
+
+// completeJobs makes the fake client create the Jobs in the given state.
+func completeJobs(client *fake.Clientset, condition batch.JobConditionType, message string) {
+	client.PrependReactor("create", "jobs", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		job := action.(clienttesting.CreateAction).GetObject().(*batch.Job)
+		job.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": job.Name}}
+		job.Status.Conditions = append(job.Status.Conditions, batch.JobCondition{
+			Type:    condition,
+			Status:  core.ConditionTrue,
+			Message: message,
+		})
+		return false, nil, nil
+	})
+}
+
+func TestHookJobEnsure(t *testing.T) {
+	cases := []struct {
+		timeout  time.Duration
+		deadline int64
+	}{
+		{timeout: 30 * time.Minute, deadline: 1800},
+		{timeout: 1500 * time.Millisecond, deadline: 2},
+		{timeout: 300 * time.Millisecond, deadline: 1},
+	}
+	for _, c := range cases {
+		client := fake.NewSimpleClientset()
+		obj, _, err := newHookJob(client, c.timeout).Ensure()
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := obj.(*batch.Job)
+		if job.Spec.ActiveDeadlineSeconds == nil || *job.Spec.ActiveDeadlineSeconds != c.deadline {
+			t.Errorf("expected deadline %ds for timeout %s, found %v", c.deadline, c.timeout, job.Spec.ActiveDeadlineSeconds)
+		}
+		spec := job.Spec.Template.Spec
+		if spec.RestartPolicy != core.RestartPolicyNever || spec.ServiceAccountName != "stash-hook" {
+			t.Errorf("unexpected pod spec %+v", spec)
+		}
+		env := map[string]string{}
+		for _, e := range spec.Containers[0].Env {
+			env[e.Name] = e.Value
+		}
+		if env["TARGET_HOST"] != "overridden" || env["HOOK_TYPE"] != "preBackup" {
+			t.Errorf("expected the session variables to be injected before the variables of the container, found %v", env)
+		}
+	}
+}
+
+func TestHookJobRun(t *testing.T) {
+	failedPod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pre-backup-hook-x", Namespace: "demo", Labels: map[string]string{"job-name": "pre-backup-hook"}},
+		Status: core.PodStatus{
+			ContainerStatuses: []core.ContainerStatus{
+				{Name: "hook", State: core.ContainerState{Terminated: &core.ContainerStateTerminated{ExitCode: 3, Message: "database is locked\n"}}},
+				{Name: "sidecar", State: core.ContainerState{Terminated: &core.ContainerStateTerminated{ExitCode: 0}}},
+			},
+		},
+	}
+	cases := []struct {
+		name      string
+		condition batch.JobConditionType
+		message   string
+		pods      []runtime.Object
+		timeout   time.Duration
+		// expected
+		expectErr string
+		deleted   bool
+	}{
+		{name: "succeeded", condition: batch.JobComplete, timeout: time.Minute},
+		{name: "failed container", condition: batch.JobFailed, message: "BackoffLimitExceeded", pods: []runtime.Object{failedPod}, timeout: time.Minute, expectErr: "container hook exited with code 3: database is locked"},
+		{name: "failed job", condition: batch.JobFailed, message: "Job was active longer than specified deadline", timeout: time.Minute, expectErr: "Job was active longer than specified deadline"},
+		{name: "timed out", timeout: 200 * time.Millisecond, expectErr: "did not complete within 200ms", deleted: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(c.pods...)
+			if c.condition != "" {
+				completeJobs(client, c.condition, c.message)
+			}
+			err := newHookJob(client, c.timeout).Run()
+			if c.expectErr == "" && err != nil {
+				t.Fatalf("expected no error, found %v", err)
+			}
+			if c.expectErr != "" && (err == nil || !strings.Contains(err.Error(), c.expectErr)) {
+				t.Fatalf("expected error %q, found %v", c.expectErr, err)
+			}
+			_, err = client.BatchV1().Jobs("demo").Get(context.TODO(), "pre-backup-hook", metav1.GetOptions{})
+			if deleted := kerr.IsNotFound(err); deleted != c.deleted {
+				t.Errorf("expected the Job to be deleted %v, found %v", c.deleted, deleted)
+			}
+		})
+	}
+}
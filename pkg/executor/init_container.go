@@ -27,6 +27,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/stash/pkg/rbac"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"gomodules.xyz/flags"
@@ -207,6 +208,9 @@ func (e *InitContainer) newRestoreInitContainer() core.Container {
 		},
 	}
 
+	// the init-container joins the trace recorded in the annotations of the invoker using the tracing options of the operator
+	initContainer.Env = append(initContainer.Env, tracing.OptionEnvVars()...)
+
 	// mount tmp volume
 	initContainer.VolumeMounts = util.UpsertTmpVolumeMount(initContainer.VolumeMounts)
 
@@ -29,6 +29,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/stash/pkg/rbac"
 	"stash.appscode.dev/stash/pkg/resolver"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"gomodules.xyz/flags"
@@ -213,6 +214,7 @@ func (e *RestoreJob) ensureJob(jobMeta metav1.ObjectMeta, podSpec core.PodSpec)
 		imagePullSecrets:   e.ImagePullSecrets,
 		runtimeSettings:    runtimeSettings,
 		backOffLimit:       0,
+		env:                tracing.EnvVars(tracing.SessionContext(e.Invoker.GetObjectMeta().Annotations)),
 	}
 	if runtimeSettings.Pod != nil && runtimeSettings.Pod.PodAnnotations != nil {
 		job.podAnnotations = runtimeSettings.Pod.PodAnnotations
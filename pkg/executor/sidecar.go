@@ -28,6 +28,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/stash/pkg/rbac"
+	"stash.appscode.dev/stash/pkg/tracing"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"gomodules.xyz/flags"
@@ -207,6 +208,9 @@ func (e *Sidecar) newBackupSidecar() core.Container {
 		},
 	}
 
+	// the tracing options are passed by the operator only. the trace context is read from the session.
+	sidecar.Env = append(sidecar.Env, tracing.OptionEnvVars()...)
+
 	// mount tmp volume
 	sidecar.VolumeMounts = util.UpsertTmpVolumeMount(sidecar.VolumeMounts)
 
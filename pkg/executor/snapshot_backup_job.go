@@ -0,0 +1,217 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/tracing"
+
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	kutil "kmodules.xyz/client-go"
+	core_util "kmodules.xyz/client-go/core/v1"
+	metautil "kmodules.xyz/client-go/meta"
+)
+
+// SnapshotBackupJob backs up a PVC from a CSI VolumeSnapshot instead of the live volume.
+// It takes a VolumeSnapshot of the target PVC, provisions a temporary PVC from it and runs the backup job
+// of the target Task against the temporary PVC. The temporary PVC and the VolumeSnapshot are removed
+// by CleanupSnapshotBackup once the BackupSession completes.
+type SnapshotBackupJob struct {
+	BackupJob
+	SnapshotClient vscs.Interface
+}
+
+// ErrSnapshotNotReady is returned by SnapshotBackupJob.Ensure while the VolumeSnapshot of the target is not ready to use.
+// Neither the temporary PVC nor the job is created yet. The caller should call Ensure again later.
+var ErrSnapshotNotReady = errors.New("VolumeSnapshot is not ready to use yet")
+
+func (e *SnapshotBackupJob) Ensure() (runtime.Object, kutil.VerbType, error) {
+	targetInfo := e.Invoker.GetTargetInfo()[e.Index]
+	runtimeSettings := targetInfo.RuntimeSettings
+	targetRef := targetInfo.Target.Ref
+
+	namespace := e.Session.GetObjectMeta().Namespace
+	if targetRef.Kind != apis.KindPersistentVolumeClaim {
+		return nil, kutil.VerbUnchanged, fmt.Errorf("backup from VolumeSnapshot is only supported for %s target", apis.KindPersistentVolumeClaim)
+	}
+	if targetRef.Namespace != "" && targetRef.Namespace != namespace {
+		return nil, kutil.VerbUnchanged, fmt.Errorf("backup from VolumeSnapshot requires the target PVC to be in the namespace of the BackupSession")
+	}
+
+	ownerBackupSession := metav1.NewControllerRef(e.Session.GetBackupSession(), api_v1beta1.SchemeGroupVersion.WithKind(api_v1beta1.ResourceKindBackupSession))
+	name := SnapshotBackupResourceName(e.Session.GetObjectMeta().Name, e.Index)
+
+	source, err := e.KubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), targetRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, kutil.VerbUnchanged, err
+	}
+	if err := e.ensureVolumeSnapshot(name, source, targetInfo.Target.VolumeSnapshotClassName, ownerBackupSession); err != nil {
+		return nil, kutil.VerbUnchanged, err
+	}
+	ready, err := e.isSnapshotReady(namespace, name)
+	if err != nil {
+		return nil, kutil.VerbUnchanged, err
+	}
+	if !ready {
+		return nil, kutil.VerbUnchanged, ErrSnapshotNotReady
+	}
+	// the job pod waits for the PVC to be provisioned from the VolumeSnapshot and bound
+	if err := e.ensureTemporaryPVC(name, source, ownerBackupSession); err != nil {
+		return nil, kutil.VerbUnchanged, err
+	}
+
+	jobMeta := metav1.ObjectMeta{
+		Name:      e.getBackupJobName(),
+		Namespace: namespace,
+		Labels:    e.Invoker.GetLabels(),
+	}
+
+	err = e.RBACOptions.EnsureBackupJobRBAC()
+	if err != nil {
+		return nil, kutil.VerbUnchanged, err
+	}
+	podSpec, err := e.resolveTask(jobMeta, ownerBackupSession)
+	if err != nil {
+		return nil, kutil.VerbUnchanged, err
+	}
+	// mount the temporary PVC in place of the target PVC
+	for i := range podSpec.Volumes {
+		if podSpec.Volumes[i].PersistentVolumeClaim != nil && podSpec.Volumes[i].PersistentVolumeClaim.ClaimName == source.Name {
+			podSpec.Volumes[i].PersistentVolumeClaim.ClaimName = name
+			podSpec.Volumes[i].PersistentVolumeClaim.ReadOnly = true
+		}
+	}
+
+	job := &jobOptions{
+		kubeClient:         e.KubeClient,
+		meta:               jobMeta,
+		owner:              ownerBackupSession,
+		podSpec:            podSpec,
+		podLabels:          e.Invoker.GetLabels(),
+		serviceAccountName: e.RBACOptions.GetServiceAccountName(),
+		imagePullSecrets:   e.ImagePullSecrets,
+		runtimeSettings:    runtimeSettings,
+		backOffLimit:       0,
+		env:                tracing.EnvVars(tracing.SessionContext(e.Session.GetObjectMeta().Annotations)),
+	}
+	if runtimeSettings.Pod != nil && runtimeSettings.Pod.PodAnnotations != nil {
+		job.podAnnotations = runtimeSettings.Pod.PodAnnotations
+	}
+	return job.ensure()
+}
+
+func (e *SnapshotBackupJob) ensureVolumeSnapshot(name string, source *core.PersistentVolumeClaim, className string, owner *metav1.OwnerReference) error {
+	_, err := e.SnapshotClient.SnapshotV1().VolumeSnapshots(source.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil || !kerr.IsNotFound(err) {
+		return err
+	}
+	vs := &vsapi.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: source.Namespace,
+			Labels:    e.Invoker.GetLabels(),
+		},
+		Spec: vsapi.VolumeSnapshotSpec{
+			Source: vsapi.VolumeSnapshotSource{
+				PersistentVolumeClaimName: &source.Name,
+			},
+		},
+	}
+	if className != "" {
+		vs.Spec.VolumeSnapshotClassName = &className
+	}
+	core_util.EnsureOwnerReference(&vs.ObjectMeta, owner)
+	_, err = e.SnapshotClient.SnapshotV1().VolumeSnapshots(source.Namespace).Create(context.TODO(), vs, metav1.CreateOptions{})
+	return err
+}
+
+// isSnapshotReady returns true if the VolumeSnapshot is ready to use.
+// It fails if the snapshot controller reports an error for the VolumeSnapshot.
+func (e *SnapshotBackupJob) isSnapshotReady(namespace, name string) (bool, error) {
+	vs, err := e.SnapshotClient.SnapshotV1().VolumeSnapshots(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if vs.Status == nil {
+		return false, nil
+	}
+	if vs.Status.Error != nil && vs.Status.Error.Message != nil {
+		return false, fmt.Errorf("failed to take VolumeSnapshot %s/%s. Reason: %s", namespace, name, *vs.Status.Error.Message)
+	}
+	return vs.Status.ReadyToUse != nil && *vs.Status.ReadyToUse, nil
+}
+
+func (e *SnapshotBackupJob) ensureTemporaryPVC(name string, source *core.PersistentVolumeClaim, owner *metav1.OwnerReference) error {
+	_, err := e.KubeClient.CoreV1().PersistentVolumeClaims(source.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil || !kerr.IsNotFound(err) {
+		return err
+	}
+	apiGroup := vsapi.GroupName
+	pvc := &core.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: source.Namespace,
+			Labels:    e.Invoker.GetLabels(),
+		},
+		Spec: core.PersistentVolumeClaimSpec{
+			AccessModes:      source.Spec.AccessModes,
+			StorageClassName: source.Spec.StorageClassName,
+			VolumeMode:       source.Spec.VolumeMode,
+			Resources:        source.Spec.Resources,
+			DataSource: &core.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     name,
+			},
+		},
+	}
+	core_util.EnsureOwnerReference(&pvc.ObjectMeta, owner)
+	_, err = e.KubeClient.CoreV1().PersistentVolumeClaims(source.Namespace).Create(context.TODO(), pvc, metav1.CreateOptions{})
+	return err
+}
+
+// SnapshotBackupResourceName returns the name of the VolumeSnapshot and the temporary PVC
+// used to backup the target with the given index of a BackupSession.
+func SnapshotBackupResourceName(sessionName string, index int) string {
+	return metautil.ValidNameWithPrefixNSuffix(apis.PrefixStashVolumeSnapshot, sessionName, strconv.Itoa(index))
+}
+
+// CleanupSnapshotBackup deletes the temporary PVC and the VolumeSnapshot used to backup a target of a BackupSession.
+func CleanupSnapshotBackup(kubeClient kubernetes.Interface, snapshotClient vscs.Interface, namespace, sessionName string, index int) error {
+	name := SnapshotBackupResourceName(sessionName, index)
+	err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	err = snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
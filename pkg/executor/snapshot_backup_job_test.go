@@ -0,0 +1,64 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"strings"
+	"testing"
+
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	vs_fake "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsSnapshotReady(t *testing.T) {
+	ready, notReady := true, false
+	message := "failed to take snapshot of the volume: quota exceeded"
+	cases := []struct {
+		name   string
+		status *vsapi.VolumeSnapshotStatus
+		ready  bool
+		err    string
+	}{
+		{name: "ready", status: &vsapi.VolumeSnapshotStatus{ReadyToUse: &ready}, ready: true},
+		{name: "failed", status: &vsapi.VolumeSnapshotStatus{ReadyToUse: &notReady, Error: &vsapi.VolumeSnapshotError{Message: &message}}, err: message},
+		{name: "not ready", status: &vsapi.VolumeSnapshotStatus{ReadyToUse: &notReady}},
+		{name: "no status"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			vs := &vsapi.VolumeSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Name: "stash-vs-backup-0", Namespace: "demo"},
+				Status:     c.status,
+			}
+			e := &SnapshotBackupJob{SnapshotClient: vs_fake.NewSimpleClientset(vs)}
+			ready, err := e.isSnapshotReady(vs.Namespace, vs.Name)
+			if ready != c.ready {
+				t.Errorf("expected ready %v, found %v", c.ready, ready)
+			}
+			if c.err == "" {
+				if err != nil {
+					t.Errorf("expected no error, found %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error containing %q, found %v", c.err, err)
+			}
+		})
+	}
+}
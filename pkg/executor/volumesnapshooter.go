@@ -26,6 +26,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/stash/pkg/rbac"
+	"stash.appscode.dev/stash/pkg/tracing"
 
 	"gomodules.xyz/flags"
 	core "k8s.io/api/core/v1"
@@ -74,6 +75,7 @@ func (e *CSISnapshooter) Ensure() (runtime.Object, kutil.VerbType, error) {
 		serviceAccountName: e.RBACOptions.GetServiceAccountName(),
 		runtimeSettings:    runtimeSettings,
 		backOffLimit:       0,
+		env:                tracing.EnvVars(tracing.SessionContext(e.Session.GetObjectMeta().Annotations)),
 	}
 	if runtimeSettings.Pod != nil && runtimeSettings.Pod.PodAnnotations != nil {
 		job.podAnnotations = runtimeSettings.Pod.PodAnnotations
@@ -26,6 +26,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/stash/pkg/rbac"
+	"stash.appscode.dev/stash/pkg/tracing"
 
 	"gomodules.xyz/flags"
 	core "k8s.io/api/core/v1"
@@ -73,6 +74,7 @@ func (e *CSISnapshotRestorer) Ensure() (runtime.Object, kutil.VerbType, error) {
 		serviceAccountName: e.RBACOptions.GetServiceAccountName(),
 		runtimeSettings:    runtimeSettings,
 		backOffLimit:       0,
+		env:                tracing.EnvVars(tracing.SessionContext(e.Invoker.GetObjectMeta().Annotations)),
 	}
 	if runtimeSettings.Pod != nil && runtimeSettings.Pod.PodAnnotations != nil {
 		job.podAnnotations = runtimeSettings.Pod.PodAnnotations
@@ -101,6 +103,7 @@ func (e *CSISnapshotRestorer) getJobTemplate() *core.PodTemplateSpec {
 			"--target-name=" + targetInfo.Target.Ref.Name,
 			"--target-namespace=" + targetInfo.Target.Ref.Namespace,
 			"--target-kind=" + targetInfo.Target.Ref.Kind,
+			"--image=" + e.Image.ToContainerImage(),
 			"--metrics-enabled=true",
 			"--pushgateway-url=" + metrics.GetPushgatewayURL(),
 			fmt.Sprintf("--use-kubeapiserver-fqdn-for-aks=%v", clientcmd.UseKubeAPIServerFQDNForAKS()),
@@ -0,0 +1,411 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"strings"
+	"time"
+
+	api "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	stash_listers "stash.appscode.dev/apimachinery/client/listers/stash/v1alpha1"
+	stash_listers_v1beta1 "stash.appscode.dev/apimachinery/client/listers/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/metrics"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/rpo"
+	"stash.appscode.dev/stash/pkg/util"
+	"stash.appscode.dev/stash/pkg/volumesnapshot"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const namespace = "stash_appscode_com"
+
+var (
+	sessionLabels = []string{metrics.MetricsLabelNamespace, metrics.MetricLabelInvokerKind, metrics.MetricLabelInvokerName}
+	targetLabels  = append(sessionLabels, metrics.MetricsLabelKind, metrics.MetricsLabelName)
+	hostLabels    = append(targetLabels, metrics.MetricLabelHostname)
+	repoLabels    = []string{metrics.MetricsLabelNamespace, metrics.MetricsLabelRepository}
+	vsLabels      = append(append([]string{}, hostLabels...),
+		volumesnapshot.MetricsLabelVolumeSnapshot, volumesnapshot.MetricsLabelVolumeSnapshotContent, volumesnapshot.MetricsLabelSnapshotHandle)
+)
+
+var (
+	backupSessionSuccess         = desc("backupsession", "success", "Indicates whether the last completed backup session of an invoker has succeeded or not", sessionLabels)
+	backupSessionDuration        = desc("backupsession", "duration_seconds", "Indicates total time taken to complete the last backup session of an invoker", sessionLabels)
+	backupSessionTargetCount     = desc("backupsession", "target_count_total", "Indicates the total number of target that was backed up in the last backup session", sessionLabels)
+	backupSessionLastSuccessTime = desc("backupsession", "last_success_time_seconds", "Indicates the time when the last successful backup session of an invoker has completed", sessionLabels)
+	backupTargetSuccess          = desc("backupsession", "target_success", "Indicates whether the backup for a target has succeeded or not", targetLabels)
+	backupTargetHostCount        = desc("backupsession", "target_host_count_total", "Indicates the total number of hosts that was backed up for this target", targetLabels)
+	hostBackupSuccess            = desc("backupsession", "host_backup_success", "Indicates whether the backup for a host succeeded or not", hostLabels)
+	hostBackupDuration           = desc("backupsession", "host_backup_duration_seconds", "Indicates total time taken to complete the backup process for a host", hostLabels)
+	hostDataSize                 = desc("backupsession", "host_data_size_bytes", "Total size of the target data to backup for a host (in bytes)", hostLabels)
+	hostDataUploaded             = desc("backupsession", "host_data_uploaded_bytes", "Amount of data uploaded to the repository for a host (in bytes)", hostLabels)
+	hostDataProcessingTime       = desc("backupsession", "host_data_processing_time_seconds", "Total time taken to process the target data for a host", hostLabels)
+	hostFilesTotal               = desc("backupsession", "host_files_total", "Total number of files that has been backed up for a host", hostLabels)
+	hostFilesNew                 = desc("backupsession", "host_files_new", "Total number of new files that has been created since last backup for a host", hostLabels)
+	hostFilesModified            = desc("backupsession", "host_files_modified", "Total number of files that has been modified since last backup for a host", hostLabels)
+	hostFilesUnmodified          = desc("backupsession", "host_files_unmodified", "Total number of files that has not been changed since last backup for a host", hostLabels)
+	hostVolumeSnapshotSize       = desc("backupsession", "host_volume_snapshot_restore_size_bytes", "Minimum size of the volume required to restore the VolumeSnapshot of a host", vsLabels)
+	hostVolumeSnapshotReadyIn    = desc("backupsession", "host_volume_snapshot_ready_seconds", "Time taken by the VolumeSnapshot of a host to become ready to use", vsLabels)
+
+	restoreSessionSuccess    = desc("restoresession", "success", "Indicates whether the restore session has succeeded or not", sessionLabels)
+	restoreSessionDuration   = desc("restoresession", "duration_seconds", "Indicates the total time taken to complete the restore session", sessionLabels)
+	restoreTargetSuccess     = desc("restoresession", "target_success", "Indicates whether the restore for a target has succeeded or not", targetLabels)
+	restoreTargetHostCount   = desc("restoresession", "target_host_count_total", "Indicates the total number of hosts that was restored for this restore target", targetLabels)
+	hostRestoreSuccess       = desc("restoresession", "host_restore_success", "Indicates whether the restore process was succeeded for a host", hostLabels)
+	hostRestoreDuration      = desc("restoresession", "host_restore_duration_seconds", "Indicates the total time taken to complete the restore process for a host", hostLabels)
+	repositoryIntegrity      = desc("repository", "integrity", "Result of repository integrity check after last backup", repoLabels)
+	repositorySize           = desc("repository", "size_bytes", "Indicates size of repository after last backup (in bytes)", repoLabels)
+	repositorySnapshotCount  = desc("repository", "snapshot_count", "Indicates number of snapshots stored in the repository", repoLabels)
+	repositorySnapshotsClean = desc("repository", "snapshot_cleaned", "Indicates number of old snapshots cleaned up according to retention policy on last backup session", repoLabels)
+
+	backupConfigRPO         = desc("backupconfiguration", "rpo_seconds", "Indicates the recovery point objective of a BackupConfiguration", sessionLabels)
+	backupConfigStaleness   = desc("backupconfiguration", "last_success_age_seconds", "Indicates the time passed since the last successful backup of a BackupConfiguration", sessionLabels)
+	backupConfigBackupStale = desc("backupconfiguration", "backup_stale", "Indicates whether the last successful backup of a BackupConfiguration is older than its RPO", sessionLabels)
+)
+
+// the progress gauges have the same names as the ones pushed by the backup and restore jobs.
+var (
+	backupProgress  = newProgressDescs("stash_backup_host_progress")
+	restoreProgress = newProgressDescs("stash_restore_host_progress")
+)
+
+func desc(subsystem, name, help string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labels, nil)
+}
+
+type progressDescs struct {
+	percent, bytesDone, bytesTotal, filesDone, filesTotal *prometheus.Desc
+}
+
+func newProgressDescs(prefix string) progressDescs {
+	d := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prefix+"_"+name, help, hostLabels, nil)
+	}
+	return progressDescs{
+		percent:    d("percent", "Percentage of the data that has been processed"),
+		bytesDone:  d("bytes_done", "Amount of data that has been processed in bytes"),
+		bytesTotal: d("bytes_total", "Total amount of data to process in bytes"),
+		filesDone:  d("files_done", "Number of files that have been processed"),
+		filesTotal: d("files_total", "Total number of files to process"),
+	}
+}
+
+func (p progressDescs) all() []*prometheus.Desc {
+	return []*prometheus.Desc{p.percent, p.bytesDone, p.bytesTotal, p.filesDone, p.filesTotal}
+}
+
+// Collector derives the backup, restore and repository metrics from the status of the
+// BackupSessions, RestoreSessions and Repositories found in the informer cache.
+// The metrics are computed on every scrape, so the series of the deleted objects are dropped automatically.
+// If the BackupConfigurationLister is set, the age of the last successful backup is reported against the RPO.
+// The progress of the running sessions and the stats of the VolumeSnapshots are derived from the annotations
+// maintained by the backup and restore jobs, so that they are available without the Pushgateway.
+type Collector struct {
+	BackupConfigurationLister stash_listers_v1beta1.BackupConfigurationLister
+	BackupSessionLister       stash_listers_v1beta1.BackupSessionLister
+	RestoreSessionLister      stash_listers_v1beta1.RestoreSessionLister
+	RepositoryLister          stash_listers.RepositoryLister
+}
+
+var _ prometheus.Collector = &Collector{}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		backupSessionSuccess, backupSessionDuration, backupSessionTargetCount, backupSessionLastSuccessTime,
+		backupTargetSuccess, backupTargetHostCount,
+		hostBackupSuccess, hostBackupDuration, hostDataSize, hostDataUploaded, hostDataProcessingTime,
+		hostFilesTotal, hostFilesNew, hostFilesModified, hostFilesUnmodified,
+		hostVolumeSnapshotSize, hostVolumeSnapshotReadyIn,
+		restoreSessionSuccess, restoreSessionDuration, restoreTargetSuccess, restoreTargetHostCount,
+		hostRestoreSuccess, hostRestoreDuration,
+		repositoryIntegrity, repositorySize, repositorySnapshotCount, repositorySnapshotsClean,
+		backupConfigRPO, backupConfigStaleness, backupConfigBackupStale,
+	} {
+		ch <- d
+	}
+	for _, d := range append(backupProgress.all(), restoreProgress.all()...) {
+		ch <- d
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	backupSessions, err := c.BackupSessionLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list BackupSessions. Reason: %v", err)
+	} else {
+		collectBackupSessions(ch, backupSessions)
+	}
+
+	restoreSessions, err := c.RestoreSessionLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list RestoreSessions. Reason: %v", err)
+	} else {
+		collectRestoreSessions(ch, restoreSessions)
+	}
+
+	repositories, err := c.RepositoryLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list Repositories. Reason: %v", err)
+	} else {
+		collectRepositories(ch, repositories)
+	}
+
+	if c.BackupConfigurationLister != nil {
+		backupConfigs, err := c.BackupConfigurationLister.List(labels.Everything())
+		if err != nil {
+			klog.Errorf("failed to list BackupConfigurations. Reason: %v", err)
+		} else {
+			c.collectStaleness(ch, backupConfigs)
+		}
+	}
+}
+
+// collectStaleness reports the age of the last successful backup of every BackupConfiguration whose RPO is monitored.
+func (c *Collector) collectStaleness(ch chan<- prometheus.Metric, backupConfigs []*api_v1beta1.BackupConfiguration) {
+	now := time.Now()
+	for _, bc := range backupConfigs {
+		status, monitored, err := rpo.ForBackupConfiguration(bc, c.BackupSessionLister, c.RepositoryLister, now)
+		if err != nil {
+			klog.Errorf("failed to check RPO of BackupConfiguration %s/%s. Reason: %v", bc.Namespace, bc.Name, err)
+			continue
+		}
+		if !monitored {
+			continue
+		}
+		bl := []string{bc.Namespace, api_v1beta1.ResourceKindBackupConfiguration, bc.Name}
+		gauge(ch, backupConfigRPO, status.Threshold.Seconds(), bl...)
+		gauge(ch, backupConfigStaleness, status.Age.Seconds(), bl...)
+		gauge(ch, backupConfigBackupStale, boolToFloat(status.Stale), bl...)
+	}
+}
+
+type invokerKey struct {
+	namespace string
+	kind      string
+	name      string
+}
+
+func (k invokerKey) labels() []string {
+	return []string{k.namespace, k.kind, k.name}
+}
+
+// collectBackupSessions reports the last completed and the last succeeded BackupSession of every invoker.
+// The sessions that are still running do not replace the result of the previous session.
+func collectBackupSessions(ch chan<- prometheus.Metric, sessions []*api_v1beta1.BackupSession) {
+	lastCompleted := map[invokerKey]*api_v1beta1.BackupSession{}
+	lastSucceeded := map[invokerKey]*api_v1beta1.BackupSession{}
+	for _, s := range sessions {
+		key := invokerKey{namespace: s.Namespace, kind: s.Spec.Invoker.Kind, name: s.Spec.Invoker.Name}
+		switch s.Status.Phase {
+		case api_v1beta1.BackupSessionRunning:
+			collectProgress(ch, backupProgress, key, s.Annotations, util.KeyBackupProgress)
+		case api_v1beta1.BackupSessionSucceeded:
+			if newer(s, lastSucceeded[key]) {
+				lastSucceeded[key] = s
+			}
+			fallthrough
+		case api_v1beta1.BackupSessionFailed:
+			if newer(s, lastCompleted[key]) {
+				lastCompleted[key] = s
+			}
+		}
+	}
+
+	for key, s := range lastSucceeded {
+		if d, err := time.ParseDuration(s.Status.SessionDuration); err == nil {
+			completedAt := s.CreationTimestamp.Add(d)
+			gauge(ch, backupSessionLastSuccessTime, float64(completedAt.Unix()), key.labels()...)
+		}
+	}
+
+	for key, s := range lastCompleted {
+		gauge(ch, backupSessionSuccess, boolToFloat(s.Status.Phase == api_v1beta1.BackupSessionSucceeded), key.labels()...)
+		gauge(ch, backupSessionTargetCount, float64(len(s.Status.Targets)), key.labels()...)
+		if d, err := time.ParseDuration(s.Status.SessionDuration); err == nil {
+			gauge(ch, backupSessionDuration, d.Seconds(), key.labels()...)
+		}
+		snapshots, err := volumesnapshot.SnapshotInfoFromAnnotations(s.Annotations)
+		if err != nil {
+			klog.Errorf("failed to read the VolumeSnapshot stats of BackupSession %s/%s. Reason: %v", s.Namespace, s.Name, err)
+		}
+
+		for _, target := range s.Status.Targets {
+			tl := append(key.labels(), target.Ref.Kind, target.Ref.Name)
+			gauge(ch, backupTargetSuccess, boolToFloat(target.Phase == api_v1beta1.TargetBackupSucceeded), tl...)
+			if target.TotalHosts != nil {
+				gauge(ch, backupTargetHostCount, float64(*target.TotalHosts), tl...)
+			}
+
+			for _, host := range target.Stats {
+				hl := append(tl, host.Hostname)
+				collectBackupHost(ch, host, hl)
+				collectVolumeSnapshots(ch, snapshots, host.Hostname, hl)
+			}
+		}
+	}
+}
+
+func collectBackupHost(ch chan<- prometheus.Metric, host api_v1beta1.HostBackupStats, hl []string) {
+	succeeded := host.Phase == api_v1beta1.HostBackupSucceeded && host.Error == ""
+	gauge(ch, hostBackupSuccess, boolToFloat(succeeded), hl...)
+	if !succeeded {
+		return
+	}
+	if d, err := time.ParseDuration(host.Duration); err == nil {
+		gauge(ch, hostBackupDuration, d.Seconds(), hl...)
+	}
+
+	var (
+		dataSize, uploaded, processingTime         float64
+		totalFiles, newFiles, modified, unmodified float64
+	)
+	for _, snap := range host.Snapshots {
+		if v, err := sizeToBytes(snap.TotalSize); err == nil {
+			dataSize += v
+		}
+		if v, err := sizeToBytes(snap.Uploaded); err == nil {
+			uploaded += v
+		}
+		if v, err := timeToSeconds(snap.ProcessingTime); err == nil {
+			processingTime += v
+		}
+		if snap.FileStats.TotalFiles != nil {
+			totalFiles += float64(*snap.FileStats.TotalFiles)
+		}
+		if snap.FileStats.NewFiles != nil {
+			newFiles += float64(*snap.FileStats.NewFiles)
+		}
+		if snap.FileStats.ModifiedFiles != nil {
+			modified += float64(*snap.FileStats.ModifiedFiles)
+		}
+		if snap.FileStats.UnmodifiedFiles != nil {
+			unmodified += float64(*snap.FileStats.UnmodifiedFiles)
+		}
+	}
+	gauge(ch, hostDataSize, dataSize, hl...)
+	gauge(ch, hostDataUploaded, uploaded, hl...)
+	gauge(ch, hostDataProcessingTime, processingTime, hl...)
+	gauge(ch, hostFilesTotal, totalFiles, hl...)
+	gauge(ch, hostFilesNew, newFiles, hl...)
+	gauge(ch, hostFilesModified, modified, hl...)
+	gauge(ch, hostFilesUnmodified, unmodified, hl...)
+}
+
+// collectVolumeSnapshots reports the restore size and the readiness time of the VolumeSnapshots taken from a host.
+func collectVolumeSnapshots(ch chan<- prometheus.Metric, snapshots []volumesnapshot.SnapshotInfo, hostname string, hl []string) {
+	for _, i := range snapshots {
+		if i.Hostname != hostname {
+			continue
+		}
+		vl := append(append([]string{}, hl...), i.VolumeSnapshot, i.Content, i.SnapshotHandle)
+		gauge(ch, hostVolumeSnapshotSize, float64(i.RestoreSize), vl...)
+		gauge(ch, hostVolumeSnapshotReadyIn, i.ReadyIn.Duration.Seconds(), vl...)
+	}
+}
+
+// collectProgress reports the progress of the hosts of a running session recorded in the given annotation.
+func collectProgress(ch chan<- prometheus.Metric, descs progressDescs, key invokerKey, annotations map[string]string, annotation string) {
+	hosts, err := progress.HostProgressFromAnnotations(annotations, annotation)
+	if err != nil {
+		klog.Errorf("failed to read the progress of %s %s/%s. Reason: %v", key.kind, key.namespace, key.name, err)
+		return
+	}
+	for _, p := range hosts {
+		kind, name, found := strings.Cut(p.Target, "/")
+		if !found {
+			continue
+		}
+		hl := append(key.labels(), kind, name, p.Hostname)
+		gauge(ch, descs.percent, p.PercentDone, hl...)
+		gauge(ch, descs.bytesDone, float64(p.BytesDone), hl...)
+		gauge(ch, descs.bytesTotal, float64(p.BytesTotal), hl...)
+		gauge(ch, descs.filesDone, float64(p.FilesDone), hl...)
+		gauge(ch, descs.filesTotal, float64(p.FilesTotal), hl...)
+	}
+}
+
+// collectRestoreSessions reports every completed RestoreSession. A RestoreSession is a one-off
+// operation, so it is its own invoker.
+func collectRestoreSessions(ch chan<- prometheus.Metric, sessions []*api_v1beta1.RestoreSession) {
+	for _, s := range sessions {
+		key := invokerKey{namespace: s.Namespace, kind: api_v1beta1.ResourceKindRestoreSession, name: s.Name}
+		if s.Status.Phase == api_v1beta1.RestoreRunning {
+			collectProgress(ch, restoreProgress, key, s.Annotations, util.KeyRestoreProgress)
+			continue
+		}
+		if s.Status.Phase != api_v1beta1.RestoreSucceeded && s.Status.Phase != api_v1beta1.RestoreFailed {
+			continue
+		}
+		gauge(ch, restoreSessionSuccess, boolToFloat(s.Status.Phase == api_v1beta1.RestoreSucceeded), key.labels()...)
+		if d, err := time.ParseDuration(s.Status.SessionDuration); err == nil {
+			gauge(ch, restoreSessionDuration, d.Seconds(), key.labels()...)
+		}
+		if s.Spec.Target == nil {
+			continue
+		}
+
+		tl := append(key.labels(), s.Spec.Target.Ref.Kind, s.Spec.Target.Ref.Name)
+		gauge(ch, restoreTargetSuccess, boolToFloat(s.Status.Phase == api_v1beta1.RestoreSucceeded), tl...)
+		if s.Status.TotalHosts != nil {
+			gauge(ch, restoreTargetHostCount, float64(*s.Status.TotalHosts), tl...)
+		}
+		for _, host := range s.Status.Stats {
+			hl := append(tl, host.Hostname)
+			succeeded := host.Phase == api_v1beta1.HostRestoreSucceeded && host.Error == ""
+			gauge(ch, hostRestoreSuccess, boolToFloat(succeeded), hl...)
+			if d, err := time.ParseDuration(host.Duration); succeeded && err == nil {
+				gauge(ch, hostRestoreDuration, d.Seconds(), hl...)
+			}
+		}
+	}
+}
+
+func collectRepositories(ch chan<- prometheus.Metric, repositories []*api.Repository) {
+	for _, repo := range repositories {
+		if repo.Status.LastBackupTime == nil {
+			continue
+		}
+		rl := []string{repo.Namespace, repo.Name}
+		if repo.Status.Integrity != nil {
+			gauge(ch, repositoryIntegrity, boolToFloat(*repo.Status.Integrity), rl...)
+		}
+		if v, err := sizeToBytes(repo.Status.TotalSize); err == nil {
+			gauge(ch, repositorySize, v, rl...)
+		}
+		gauge(ch, repositorySnapshotCount, float64(repo.Status.SnapshotCount), rl...)
+		gauge(ch, repositorySnapshotsClean, float64(repo.Status.SnapshotsRemovedOnLastCleanup), rl...)
+	}
+}
+
+func newer(s, than *api_v1beta1.BackupSession) bool {
+	return than == nil || than.CreationTimestamp.Before(&s.CreationTimestamp)
+}
+
+func gauge(ch chan<- prometheus.Metric, d *prometheus.Desc, v float64, labelValues ...string) {
+	ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labelValues...)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
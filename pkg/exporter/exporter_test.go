@@ -0,0 +1,235 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSizeToBytes(t *testing.T) {
+	testCases := []struct {
+		size      string
+		expected  float64
+		expectErr bool
+	}{
+		{size: "512 B", expected: 512},
+		{size: "1.5 KiB", expected: 1536},
+		{size: "2 GiB", expected: 2 * (1 << 30)},
+		{size: "", expectErr: true},
+		{size: "many MiB", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.size, func(t *testing.T) {
+			v, err := sizeToBytes(tc.size)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if v != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, v)
+			}
+		})
+	}
+}
+
+func TestTimeToSeconds(t *testing.T) {
+	testCases := map[string]float64{
+		"40":      40,
+		"3:20":    200,
+		"1:05:10": 3910,
+	}
+	for in, expected := range testCases {
+		v, err := timeToSeconds(in)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", in, err)
+		}
+		if v != expected {
+			t.Errorf("%q: expected %v, got %v", in, expected, v)
+		}
+	}
+}
+
+func TestCollectBackupSessions(t *testing.T) {
+	now := time.Now()
+	session := func(name string, phase api_v1beta1.BackupSessionPhase, age time.Duration) *api_v1beta1.BackupSession {
+		return &api_v1beta1.BackupSession{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "demo", CreationTimestamp: metav1.NewTime(now.Add(-age))},
+			Spec: api_v1beta1.BackupSessionSpec{
+				Invoker: api_v1beta1.BackupInvokerRef{Kind: api_v1beta1.ResourceKindBackupConfiguration, Name: "mysql-backup"},
+			},
+			Status: api_v1beta1.BackupSessionStatus{Phase: phase, SessionDuration: "30s"},
+		}
+	}
+
+	testCases := []struct {
+		description     string
+		sessions        []*api_v1beta1.BackupSession
+		expectedSuccess float64
+		expectedSeries  bool
+	}{
+		{
+			description:     "Latest session failed",
+			sessions:        []*api_v1beta1.BackupSession{session("a", api_v1beta1.BackupSessionSucceeded, 2*time.Hour), session("b", api_v1beta1.BackupSessionFailed, time.Hour)},
+			expectedSuccess: 0,
+			expectedSeries:  true,
+		},
+		{
+			description:     "Running session keeps the previous result",
+			sessions:        []*api_v1beta1.BackupSession{session("a", api_v1beta1.BackupSessionSucceeded, 2*time.Hour), session("b", api_v1beta1.BackupSessionRunning, time.Minute)},
+			expectedSuccess: 1,
+			expectedSeries:  true,
+		},
+		{
+			description: "No completed session",
+			sessions:    []*api_v1beta1.BackupSession{session("a", api_v1beta1.BackupSessionRunning, time.Minute)},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ch := make(chan prometheus.Metric, 100)
+			collectBackupSessions(ch, tc.sessions)
+			close(ch)
+
+			found := false
+			for m := range ch {
+				if m.Desc() != backupSessionSuccess {
+					continue
+				}
+				found = true
+				var out dto.Metric
+				if err := m.Write(&out); err != nil {
+					t.Fatal(err)
+				}
+				if v := out.GetGauge().GetValue(); v != tc.expectedSuccess {
+					t.Errorf("expected success %v, got %v", tc.expectedSuccess, v)
+				}
+			}
+			if found != tc.expectedSeries {
+				t.Errorf("expected series: %v, found: %v", tc.expectedSeries, found)
+			}
+		})
+	}
+}
+
+func TestCollectFromAnnotations(t *testing.T) {
+	now := time.Now()
+	invoker := api_v1beta1.BackupInvokerRef{Kind: api_v1beta1.ResourceKindBackupConfiguration, Name: "pvc-backup"}
+	running := &api_v1beta1.BackupSession{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "running",
+			Namespace:         "demo",
+			CreationTimestamp: metav1.NewTime(now),
+			Annotations: map[string]string{
+				util.KeyBackupProgress: `[{"target":"PersistentVolumeClaim/data","hostname":"data","bytesDone":512,"bytesTotal":1024,"filesDone":1,"filesTotal":2,"percentDone":50,"updatedAt":"now"}]`,
+			},
+		},
+		Spec:   api_v1beta1.BackupSessionSpec{Invoker: invoker},
+		Status: api_v1beta1.BackupSessionStatus{Phase: api_v1beta1.BackupSessionRunning},
+	}
+	succeeded := &api_v1beta1.BackupSession{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "succeeded",
+			Namespace:         "demo",
+			CreationTimestamp: metav1.NewTime(now.Add(-time.Hour)),
+			Annotations: map[string]string{
+				util.KeyVolumeSnapshotStats: `[{"hostname":"data","volumeSnapshot":"data-1","volumeSnapshotContent":"content-1","restoreSize":2048,"readyIn":"30s"},` +
+					`{"hostname":"other","volumeSnapshot":"other-1","readyIn":"10s"}]`,
+			},
+		},
+		Spec: api_v1beta1.BackupSessionSpec{Invoker: invoker},
+		Status: api_v1beta1.BackupSessionStatus{
+			Phase: api_v1beta1.BackupSessionSucceeded,
+			Targets: []api_v1beta1.BackupTargetStatus{{
+				Ref:   api_v1beta1.TargetRef{Kind: "PersistentVolumeClaim", Name: "data"},
+				Stats: []api_v1beta1.HostBackupStats{{Hostname: "data", Phase: api_v1beta1.HostBackupSucceeded}},
+			}},
+		},
+	}
+	restoring := &api_v1beta1.RestoreSession{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "restore",
+			Namespace: "demo",
+			Annotations: map[string]string{
+				util.KeyRestoreProgress: `[{"target":"PersistentVolumeClaim/data","hostname":"data","percentDone":25,"updatedAt":"now"}]`,
+			},
+		},
+		Status: api_v1beta1.RestoreSessionStatus{Phase: api_v1beta1.RestoreRunning},
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	collectBackupSessions(ch, []*api_v1beta1.BackupSession{running, succeeded})
+	collectRestoreSessions(ch, []*api_v1beta1.RestoreSession{restoring})
+	close(ch)
+
+	type series struct {
+		desc   *prometheus.Desc
+		labels string
+	}
+	values := map[series]float64{}
+	for m := range ch {
+		var out dto.Metric
+		if err := m.Write(&out); err != nil {
+			t.Fatal(err)
+		}
+		var labels string
+		for _, l := range out.GetLabel() {
+			labels += l.GetName() + "=" + l.GetValue() + ","
+		}
+		values[series{m.Desc(), labels}] = out.GetGauge().GetValue()
+	}
+
+	session := "invoker_kind=BackupConfiguration,invoker_name=pvc-backup,namespace=demo,"
+	backupHost := "hostname=data,invoker_kind=BackupConfiguration,invoker_name=pvc-backup,kind=PersistentVolumeClaim,name=data,namespace=demo,"
+	restoreHost := "hostname=data,invoker_kind=RestoreSession,invoker_name=restore,kind=PersistentVolumeClaim,name=data,namespace=demo,"
+	snapshot := "hostname=data,invoker_kind=BackupConfiguration,invoker_name=pvc-backup,kind=PersistentVolumeClaim,name=data,namespace=demo," +
+		"snapshot_handle=,volume_snapshot=data-1,volume_snapshot_content=content-1,"
+	expected := map[series]float64{
+		{backupProgress.percent, backupHost}:      50,
+		{backupProgress.bytesDone, backupHost}:    512,
+		{backupProgress.bytesTotal, backupHost}:   1024,
+		{backupProgress.filesDone, backupHost}:    1,
+		{backupProgress.filesTotal, backupHost}:   2,
+		{restoreProgress.percent, restoreHost}:    25,
+		{hostVolumeSnapshotSize, snapshot}:        2048,
+		{hostVolumeSnapshotReadyIn, snapshot}:     30,
+		{backupSessionSuccess, session}:           1,
+		{hostBackupSuccess, backupHost}:           1,
+		{restoreProgress.filesTotal, restoreHost}: 0,
+	}
+	for s, v := range expected {
+		found, ok := values[s]
+		if !ok {
+			t.Errorf("expected series %s{%s}", s.desc, s.labels)
+			continue
+		}
+		if found != v {
+			t.Errorf("expected %s{%s} to be %v, found %v", s.desc, s.labels, v, found)
+		}
+	}
+	for s := range values {
+		if (s.desc == hostVolumeSnapshotSize || s.desc == hostVolumeSnapshotReadyIn) && s.labels != snapshot {
+			t.Errorf("expected only the VolumeSnapshots of the backed up hosts, found %s{%s}", s.desc, s.labels)
+		}
+	}
+}
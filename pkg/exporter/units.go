@@ -0,0 +1,61 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"fmt"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"TiB", 1 << 40},
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+// sizeToBytes converts a size reported by restic (i.e. "1.5 GiB") into bytes.
+func sizeToBytes(size string) (float64, error) {
+	size = strings.TrimSpace(size)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(size, u.suffix) {
+			var v float64
+			if _, err := fmt.Sscanf(strings.TrimSpace(strings.TrimSuffix(size, u.suffix)), "%f", &v); err != nil {
+				return 0, fmt.Errorf("invalid size %q. Reason: %v", size, err)
+			}
+			return v * u.bytes, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q", size)
+}
+
+// timeToSeconds converts a processing time reported by restic (i.e. "1:05:10", "3:20" or "40") into seconds.
+func timeToSeconds(t string) (float64, error) {
+	var seconds float64
+	for _, part := range strings.Split(t, ":") {
+		var v uint64
+		if _, err := fmt.Sscanf(part, "%d", &v); err != nil {
+			return 0, fmt.Errorf("invalid processing time %q. Reason: %v", t, err)
+		}
+		seconds = seconds*60 + float64(v)
+	}
+	return seconds, nil
+}
@@ -0,0 +1,294 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsfreeze
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"kmodules.xyz/client-go/tools/exec"
+)
+
+const (
+	// FilesystemFrozen is the condition of a target that records for how long its filesystems have been frozen.
+	FilesystemFrozen kmapi.ConditionType = "FilesystemFrozen"
+
+	FilesystemFrozenAndThawed  = "FilesystemFrozenAndThawed"
+	FilesystemThawedByWatchdog = "FilesystemThawedByWatchdog"
+	FailedToFreezeFilesystem   = "FailedToFreezeFilesystem"
+
+	// watchdogExpired is printed by the thaw script if the watchdog has already thawed the filesystems
+	watchdogExpired = "watchdog-expired"
+)
+
+// freezeScript starts a watchdog that thaws the filesystems once the deadline passes and then freezes them.
+// The watchdog runs inside the container, so the filesystems are thawed even if Stash crashes. If any of
+// the filesystems can not be frozen, the frozen ones are thawed. The PID of the watchdog is printed on success.
+const freezeScript = `deadline=$1; shift
+( sleep "$deadline"; for p in "$@"; do fsfreeze --unfreeze "$p"; done ) >/dev/null 2>&1 </dev/null &
+watchdog=$!
+for p in "$@"; do
+  if ! fsfreeze --freeze "$p"; then
+    for q in "$@"; do fsfreeze --unfreeze "$q" 2>/dev/null; done
+    kill "$watchdog" 2>/dev/null
+    exit 1
+  fi
+done
+echo "$watchdog"`
+
+// thawScript stops the watchdog and thaws the filesystems. A filesystem that is not frozen anymore is not an error.
+// If the watchdog is not running anymore, it has thawed the filesystems already and that is reported on the output.
+const thawScript = `watchdog=$1; shift
+kill "$watchdog" 2>/dev/null || echo "` + watchdogExpired + `"
+rc=0
+for p in "$@"; do
+  if ! out=$(fsfreeze --unfreeze "$p" 2>&1); then
+    case "$out" in
+      *"Invalid argument"*) ;;
+      *) echo "$out" >&2; rc=1 ;;
+    esac
+  fi
+done
+exit $rc`
+
+// Mounts are the mount paths of a container of a pod that are frozen together.
+type Mounts struct {
+	Pod       *core.Pod
+	Container string
+	Paths     []string
+	watchdog  string
+}
+
+func (m Mounts) String() string {
+	return fmt.Sprintf("%s of container %s of pod %s/%s", strings.Join(m.Paths, ", "), m.Container, m.Pod.Namespace, m.Pod.Name)
+}
+
+// Freezer freezes the filesystems of the mounts with fsfreeze executed in the respective containers.
+// The containers need the fsfreeze binary and the privilege to run it.
+type Freezer struct {
+	Config *rest.Config
+	Mounts []Mounts
+	// Deadline is the maximum duration the filesystems are kept frozen.
+	Deadline time.Duration
+
+	mu       sync.Mutex
+	frozen   []Mounts
+	frozenAt time.Time
+	duration time.Duration
+	thawErr  error
+	// expired is set if the watchdog has thawed the filesystems before Thaw was called
+	expired bool
+}
+
+// Freeze freezes the filesystems of all the mounts. If any of them fails, the frozen ones are thawed.
+func (f *Freezer) Freeze() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deadline := strconv.Itoa(int(f.Deadline.Seconds()))
+	f.frozenAt = time.Now()
+	for _, m := range f.Mounts {
+		args := append([]string{"sh", "-c", freezeScript, "sh", deadline}, m.Paths...)
+		out, err := exec.ExecIntoPod(f.Config, m.Pod, exec.Container(m.Container), exec.Command(args...))
+		if err != nil {
+			thawErr := f.thaw()
+			return errors.NewAggregate([]error{fmt.Errorf("failed to freeze %s. Reason: %v", m, err), thawErr})
+		}
+		m.watchdog = strings.TrimSpace(out)
+		f.frozen = append(f.frozen, m)
+		klog.Infof("Froze %s for at most %s", m, f.Deadline)
+	}
+	return nil
+}
+
+// Thaw thaws the frozen filesystems. It can be called multiple times, the filesystems are thawed only once.
+// A nil Freezer does nothing.
+func (f *Freezer) Thaw() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.thaw()
+}
+
+func (f *Freezer) thaw() error {
+	if len(f.frozen) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, m := range f.frozen {
+		args := append([]string{"sh", "-c", thawScript, "sh", m.watchdog}, m.Paths...)
+		out, err := exec.ExecIntoPod(f.Config, m.Pod, exec.Container(m.Container), exec.Command(args...))
+		if strings.Contains(out, watchdogExpired) {
+			f.expired = true
+			klog.Warningf("%s has been thawed by the watchdog after %s", m, f.Deadline)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to thaw %s. It will be thawed after %s. Reason: %v", m, f.Deadline, err))
+			continue
+		}
+		klog.Infof("Thawed %s", m)
+	}
+	f.duration = time.Since(f.frozenAt)
+	if f.duration >= f.Deadline {
+		f.expired = true
+	}
+	f.frozen = nil
+	f.thawErr = errors.NewAggregate(errs)
+	return f.thawErr
+}
+
+// Expired returns true if the freeze timeout passed before the filesystems were thawed. The watchdog has thawed
+// them then, so whatever has been taken after the timeout is not consistent.
+func (f *Freezer) Expired() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.expired
+}
+
+// Condition returns the FilesystemFrozen condition of the target. It records the freeze duration
+// once the filesystems have been thawed, or the reason if they could not be frozen or have been
+// thawed by the watchdog.
+func (f *Freezer) Condition(freezeErr error) kmapi.Condition {
+	if freezeErr != nil {
+		return kmapi.Condition{
+			Type:               FilesystemFrozen,
+			Status:             metav1.ConditionFalse,
+			Reason:             FailedToFreezeFilesystem,
+			Message:            fmt.Sprintf("Failed to freeze the filesystems of the target. Reason: %v", freezeErr),
+			LastTransitionTime: metav1.Now(),
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var paths []string
+	for _, m := range f.Mounts {
+		paths = append(paths, m.String())
+	}
+	msg := fmt.Sprintf("Froze %s for %s.", strings.Join(paths, "; "), f.duration.Round(time.Millisecond))
+	if f.thawErr != nil {
+		msg = fmt.Sprintf("%s Warning: %v", msg, f.thawErr)
+	}
+	if f.expired {
+		return kmapi.Condition{
+			Type:               FilesystemFrozen,
+			Status:             metav1.ConditionFalse,
+			Reason:             FilesystemThawedByWatchdog,
+			Message:            fmt.Sprintf("%s The filesystems have been thawed by the watchdog after the freeze timeout %s.", msg, f.Deadline),
+			LastTransitionTime: metav1.Now(),
+		}
+	}
+	return kmapi.Condition{
+		Type:               FilesystemFrozen,
+		Status:             metav1.ConditionTrue,
+		Reason:             FilesystemFrozenAndThawed,
+		Message:            msg,
+		LastTransitionTime: metav1.Now(),
+	}
+}
+
+// MountsOfVolumes returns the mount paths of the given volumes of a pod grouped by the container that freezes them.
+// If a container has been specified, it must mount all the volumes. Otherwise, the first container mounting
+// a volume is used for it. The Stash sidecar is never used.
+func MountsOfVolumes(pod *core.Pod, volumes []string, container string) ([]Mounts, error) {
+	paths := map[string]sets.Set[string]{}
+	for _, vol := range volumes {
+		found := false
+		for _, c := range pod.Spec.Containers {
+			if c.Name == apis.StashContainer || (container != "" && c.Name != container) {
+				continue
+			}
+			for _, vm := range c.VolumeMounts {
+				if vm.Name != vol {
+					continue
+				}
+				if paths[c.Name] == nil {
+					paths[c.Name] = sets.New[string]()
+				}
+				paths[c.Name].Insert(vm.MountPath)
+				found = true
+				break
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			if container != "" {
+				return nil, fmt.Errorf("container %s of pod %s/%s does not mount volume %s", container, pod.Namespace, pod.Name, vol)
+			}
+			return nil, fmt.Errorf("no container of pod %s/%s mounts volume %s", pod.Namespace, pod.Name, vol)
+		}
+	}
+
+	mounts := make([]Mounts, 0, len(paths))
+	for c, p := range paths {
+		mounts = append(mounts, Mounts{Pod: pod, Container: c, Paths: sets.List(p)})
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Container < mounts[j].Container })
+	return mounts, nil
+}
+
+// MountsOfPVCs returns the mount paths of the PVCs in the running pods of the namespace that use them.
+// A PVC that is not used by any running pod has nothing to freeze.
+func MountsOfPVCs(kubeClient kubernetes.Interface, namespace string, pvcNames []string, container string) ([]Mounts, error) {
+	pods, err := kubeClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	claims := sets.New[string](pvcNames...)
+
+	var mounts []Mounts
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != core.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		var volumes []string
+		for _, vol := range pod.Spec.Volumes {
+			if vol.PersistentVolumeClaim != nil && claims.Has(vol.PersistentVolumeClaim.ClaimName) {
+				volumes = append(volumes, vol.Name)
+			}
+		}
+		if len(volumes) == 0 {
+			continue
+		}
+		m, err := MountsOfVolumes(pod, volumes, container)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m...)
+	}
+	return mounts, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsfreeze
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMountsOfVolumes(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql-0", Namespace: "demo"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name: "mysql",
+					VolumeMounts: []core.VolumeMount{
+						{Name: "data", MountPath: "/var/lib/mysql"},
+						{Name: "config", MountPath: "/etc/mysql"},
+					},
+				},
+				{
+					Name: "freezer",
+					VolumeMounts: []core.VolumeMount{
+						{Name: "data", MountPath: "/data"},
+						{Name: "logs", MountPath: "/logs"},
+					},
+				},
+				{
+					Name:         apis.StashContainer,
+					VolumeMounts: []core.VolumeMount{{Name: "cache", MountPath: "/cache"}},
+				},
+			},
+		},
+	}
+
+	testCases := []struct {
+		description string
+		volumes     []string
+		container   string
+		expected    map[string][]string
+		expectErr   bool
+	}{
+		{
+			description: "First container mounting the volume",
+			volumes:     []string{"data", "config", "logs"},
+			expected:    map[string][]string{"mysql": {"/etc/mysql", "/var/lib/mysql"}, "freezer": {"/logs"}},
+		},
+		{
+			description: "Specified container",
+			volumes:     []string{"data", "logs"},
+			container:   "freezer",
+			expected:    map[string][]string{"freezer": {"/data", "/logs"}},
+		},
+		{
+			description: "Specified container does not mount the volume",
+			volumes:     []string{"config"},
+			container:   "freezer",
+			expectErr:   true,
+		},
+		{
+			description: "Stash sidecar is never used",
+			volumes:     []string{"cache"},
+			expectErr:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mounts, err := MountsOfVolumes(pod, tc.volumes, tc.container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if tc.expectErr {
+				return
+			}
+			got := map[string][]string{}
+			for _, m := range mounts {
+				got[m.Container] = m.Paths
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestCondition(t *testing.T) {
+	pod := &core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "mysql-0", Namespace: "demo"}}
+	f := &Freezer{
+		Mounts:   []Mounts{{Pod: pod, Container: "mysql", Paths: []string{"/var/lib/mysql"}}},
+		Deadline: time.Minute,
+	}
+	if cond := f.Condition(nil); cond.Status != metav1.ConditionTrue || cond.Reason != FilesystemFrozenAndThawed {
+		t.Errorf("expected the filesystems to be frozen and thawed, got %+v", cond)
+	}
+
+	f.expired = true
+	if !f.Expired() {
+		t.Errorf("expected the freeze to be expired")
+	}
+	if cond := f.Condition(nil); cond.Status != metav1.ConditionFalse || cond.Reason != FilesystemThawedByWatchdog {
+		t.Errorf("expected the filesystems to be thawed by the watchdog, got %+v", cond)
+	}
+}
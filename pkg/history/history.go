@@ -0,0 +1,244 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	condutil "kmodules.xyz/client-go/conditions"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+const (
+	// LabelBackupHistory marks the ConfigMaps holding the backup history of the invokers.
+	LabelBackupHistory = api_v1beta1.StashKey + "/backup-history"
+
+	configMapPrefix = "stash-backup-history-"
+	dataKey         = "history.json"
+	// maxDataSize keeps the ConfigMap well below the 1MiB limit of the objects. The oldest entries
+	// are dropped to fit, regardless of the number of entries to keep.
+	maxDataSize = 900 * 1024
+	// hashLength is the length of the hash that replaces the end of a name that is too long.
+	hashLength = 10
+)
+
+// ConfigMapName returns the name of the ConfigMap holding a BackupHistory. A name that does not fit
+// in an object name is truncated and made unique again with a hash of the full name.
+func ConfigMapName(historyName string) string {
+	name := configMapPrefix + historyName
+	if len(name) <= validation.DNS1123SubdomainMaxLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(historyName))
+	hash := hex.EncodeToString(sum[:])[:hashLength]
+	return strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-hashLength-1], "-.") + "-" + hash
+}
+
+// Name returns the name of the BackupHistory of an invoker, i.e. "backupconfiguration-sample".
+func Name(invokerKind, invokerName string) string {
+	return strings.ToLower(invokerKind) + "-" + invokerName
+}
+
+// Selector selects the ConfigMaps holding the backup history of the invokers.
+func Selector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{LabelBackupHistory: "true"})
+}
+
+// NewEntry builds the history entry of a completed BackupSession.
+func NewEntry(session *api_v1beta1.BackupSession) Entry {
+	entry := Entry{
+		Session:   session.Name,
+		Phase:     session.Status.Phase,
+		StartTime: session.CreationTimestamp,
+		Duration:  session.Status.SessionDuration,
+		Message:   sessionMessage(session),
+	}
+	for _, target := range session.Status.Targets {
+		te := TargetEntry{
+			Kind:  target.Ref.Kind,
+			Name:  target.Ref.Name,
+			Phase: target.Phase,
+		}
+		for _, host := range target.Stats {
+			he := HostEntry{
+				Hostname: host.Hostname,
+				Phase:    host.Phase,
+				Duration: host.Duration,
+				Error:    host.Error,
+			}
+			for _, snap := range host.Snapshots {
+				he.Snapshots = append(he.Snapshots, SnapshotEntry{
+					Name:      snap.Name,
+					Path:      snap.Path,
+					TotalSize: snap.TotalSize,
+					Uploaded:  snap.Uploaded,
+				})
+			}
+			te.Hosts = append(te.Hosts, he)
+		}
+		entry.Targets = append(entry.Targets, te)
+	}
+	return entry
+}
+
+// sessionMessage returns the reason a session has been skipped or failed.
+func sessionMessage(session *api_v1beta1.BackupSession) string {
+	conditions := session.Status.Conditions
+	switch session.Status.Phase {
+	case api_v1beta1.BackupSessionSkipped:
+		if _, cond := condutil.GetCondition(conditions, api_v1beta1.BackupSkipped); cond != nil {
+			return cond.Message
+		}
+	case api_v1beta1.BackupSessionFailed:
+		for _, condType := range []string{api_v1beta1.DeadlineExceeded, api_v1beta1.BackupDisrupted} {
+			if condutil.IsConditionTrue(conditions, condType) {
+				_, cond := condutil.GetCondition(conditions, condType)
+				return cond.Message
+			}
+		}
+		for _, cond := range conditions {
+			if cond.Status == metav1.ConditionFalse {
+				return cond.Message
+			}
+		}
+	}
+	return ""
+}
+
+// Record adds the entry of a completed BackupSession to the backup history of its invoker, keeping at most
+// the given number of entries. Recording the same session again replaces its entry. The ConfigMap is not owned
+// by the invoker, so the history survives the deletion and the re-creation of the invoker. It returns the number of
+// entries dropped to fit the history in the ConfigMap.
+func Record(kubeClient kubernetes.Interface, session *api_v1beta1.BackupSession, records int) (int, error) {
+	invokerRef := session.Spec.Invoker
+	meta := metav1.ObjectMeta{
+		Name:      ConfigMapName(Name(invokerRef.Kind, invokerRef.Name)),
+		Namespace: session.Namespace,
+	}
+	entry := NewEntry(session)
+
+	var (
+		dropped      int
+		transformErr error
+	)
+	_, _, err := core_util.CreateOrPatchConfigMap(context.TODO(), kubeClient, meta, func(in *core.ConfigMap) *core.ConfigMap {
+		in.Labels = map[string]string{
+			LabelBackupHistory:    "true",
+			apis.LabelInvokerType: invokerRef.Kind,
+			apis.LabelInvokerName: invokerRef.Name,
+		}
+		entries, err := decode(in.Data[dataKey])
+		if err != nil {
+			transformErr = err
+			return in
+		}
+		var data string
+		data, dropped, err = encode(insert(entries, entry, records))
+		if err != nil {
+			transformErr = err
+			return in
+		}
+		if in.Data == nil {
+			in.Data = map[string]string{}
+		}
+		in.Data[dataKey] = data
+		return in
+	}, metav1.PatchOptions{})
+	if transformErr != nil {
+		return 0, transformErr
+	}
+	return dropped, err
+}
+
+// FromConfigMap returns the BackupHistory stored in a ConfigMap.
+func FromConfigMap(cm *core.ConfigMap) (*BackupHistory, error) {
+	entries, err := decode(cm.Data[dataKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the backup history of ConfigMap %s/%s. Reason: %v", cm.Namespace, cm.Name, err)
+	}
+	invokerRef := api_v1beta1.BackupInvokerRef{
+		APIGroup: api_v1beta1.SchemeGroupVersion.Group,
+		Kind:     cm.Labels[apis.LabelInvokerType],
+		Name:     cm.Labels[apis.LabelInvokerName],
+	}
+	return &BackupHistory{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              Name(invokerRef.Kind, invokerRef.Name),
+			Namespace:         cm.Namespace,
+			UID:               cm.UID,
+			ResourceVersion:   cm.ResourceVersion,
+			CreationTimestamp: cm.CreationTimestamp,
+			Labels:            cm.Labels,
+		},
+		Invoker: invokerRef,
+		Entries: entries,
+	}, nil
+}
+
+// insert puts the entry at the front of the entries, replacing an existing entry of the same session,
+// and drops the oldest entries beyond the limit.
+func insert(entries []Entry, entry Entry, limit int) []Entry {
+	out := make([]Entry, 0, len(entries)+1)
+	out = append(out, entry)
+	for _, e := range entries {
+		if e.Session != entry.Session {
+			out = append(out, e)
+		}
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func decode(data string) ([]Entry, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var entries []Entry
+	err := json.Unmarshal([]byte(data), &entries)
+	return entries, err
+}
+
+// encode serializes the entries, dropping the oldest ones until they fit in the ConfigMap.
+// It returns the number of dropped entries.
+func encode(entries []Entry) (string, int, error) {
+	total := len(entries)
+	for {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(data) <= maxDataSize || len(entries) <= 1 {
+			return string(data), total - len(entries), nil
+		}
+		entries = entries[:len(entries)-len(entries)/10-1]
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+func sessions(names ...string) []Entry {
+	entries := make([]Entry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, Entry{Session: name})
+	}
+	return entries
+}
+
+func sessionNames(entries []Entry) string {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Session)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestInsert(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []Entry
+		entry   string
+		limit   int
+		want    string
+	}{
+		{name: "empty history", entry: "s1", limit: 3, want: "s1"},
+		{name: "latest first", entries: sessions("s2", "s1"), entry: "s3", limit: 3, want: "s3,s2,s1"},
+		{name: "oldest dropped", entries: sessions("s3", "s2", "s1"), entry: "s4", limit: 3, want: "s4,s3,s2"},
+		{name: "recorded again", entries: sessions("s3", "s2", "s1"), entry: "s3", limit: 3, want: "s3,s2,s1"},
+		{name: "limit lowered", entries: sessions("s3", "s2", "s1"), entry: "s4", limit: 1, want: "s4"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := sessionNames(insert(c.entries, Entry{Session: c.entry}, c.limit))
+			if got != c.want {
+				t.Errorf("expected %q, found %q", c.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeDropsOldestToFit(t *testing.T) {
+	var entries []Entry
+	for i := 0; i < 2000; i++ {
+		entries = append(entries, Entry{Session: fmt.Sprintf("s%d", i), Message: strings.Repeat("x", 1000)})
+	}
+	data, dropped, err := encode(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped == 0 {
+		t.Errorf("expected some entries to be dropped")
+	}
+	if len(data) > maxDataSize {
+		t.Fatalf("expected at most %d bytes, found %d", maxDataSize, len(data))
+	}
+	decoded, err := decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) == 0 || decoded[0].Session != "s0" {
+		t.Errorf("expected the latest entries to be kept")
+	}
+	if len(decoded)+dropped != len(entries) {
+		t.Errorf("expected %d entries to be dropped, found %d", len(entries)-len(decoded), dropped)
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	session := &api_v1beta1.BackupSession{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample-1234"},
+		Status: api_v1beta1.BackupSessionStatus{
+			Phase:           api_v1beta1.BackupSessionFailed,
+			SessionDuration: "1m0s",
+			Conditions: []kmapi.Condition{
+				{Type: api_v1beta1.BackupExecutorEnsured, Status: metav1.ConditionTrue},
+				{Type: api_v1beta1.DeadlineExceeded, Status: metav1.ConditionTrue, Message: "timed out"},
+			},
+			Targets: []api_v1beta1.BackupTargetStatus{{
+				Ref:   api_v1beta1.TargetRef{Kind: "Deployment", Name: "app"},
+				Phase: api_v1beta1.TargetBackupFailed,
+				Stats: []api_v1beta1.HostBackupStats{{
+					Hostname:  "host-0",
+					Phase:     api_v1beta1.HostBackupFailed,
+					Error:     "permission denied",
+					Snapshots: []api_v1beta1.SnapshotStats{{Name: "5d3f2a1b", Path: "/data"}},
+				}},
+			}},
+		},
+	}
+	entry := NewEntry(session)
+	if entry.Message != "timed out" {
+		t.Errorf("expected the deadline to be recorded as the reason, found %q", entry.Message)
+	}
+	if len(entry.Targets) != 1 || len(entry.Targets[0].Hosts) != 1 {
+		t.Fatalf("expected a single target with a single host, found %+v", entry.Targets)
+	}
+	host := entry.Targets[0].Hosts[0]
+	if host.Error != "permission denied" || len(host.Snapshots) != 1 || host.Snapshots[0].Name != "5d3f2a1b" {
+		t.Errorf("unexpected host entry %+v", host)
+	}
+}
+
+func TestConfigMapName(t *testing.T) {
+	short := Name(api_v1beta1.ResourceKindBackupConfiguration, "sample")
+	if name := ConfigMapName(short); name != "stash-backup-history-backupconfiguration-sample" {
+		t.Errorf("expected the name to be kept, found %s", name)
+	}
+
+	long := Name(api_v1beta1.ResourceKindBackupConfiguration, strings.Repeat("a", 253))
+	other := Name(api_v1beta1.ResourceKindBackupConfiguration, strings.Repeat("a", 252)+"b")
+	name := ConfigMapName(long)
+	if len(name) > 253 {
+		t.Errorf("expected at most 253 characters, found %d", len(name))
+	}
+	if name != ConfigMapName(long) {
+		t.Errorf("expected the name to be stable")
+	}
+	if name == ConfigMapName(other) {
+		t.Errorf("expected different names for different invokers, found %s for both", name)
+	}
+}
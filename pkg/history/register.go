@@ -0,0 +1,33 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	repov1alpha1 "stash.appscode.dev/apimachinery/apis/repositories/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AddToScheme registers the BackupHistory types with the group version of the Snapshots
+// so that they are served by the same aggregated API server.
+func AddToScheme(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(repov1alpha1.SchemeGroupVersion,
+		&BackupHistory{},
+		&BackupHistoryList{},
+	)
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ResourceKindBackupHistory     = "BackupHistory"
+	ResourceSingularBackupHistory = "backuphistory"
+	ResourcePluralBackupHistory   = "backuphistories"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupHistory is a compact record of the completed BackupSessions of an invoker. Unlike the BackupSessions,
+// which are cleaned up according to the backup history limit, the entries are retained up to a configurable count.
+type BackupHistory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Invoker refers to the BackupConfiguration or BackupBatch the history belongs to
+	Invoker api_v1beta1.BackupInvokerRef `json:"invoker"`
+	// Entries holds the completed sessions, the latest one first
+	Entries []Entry `json:"entries"`
+}
+
+// Entry records the result of a single BackupSession.
+type Entry struct {
+	Session   string                         `json:"session"`
+	Phase     api_v1beta1.BackupSessionPhase `json:"phase"`
+	StartTime metav1.Time                    `json:"startTime"`
+	Duration  string                         `json:"duration,omitempty"`
+	// Message explains why the session has been skipped or failed
+	Message string        `json:"message,omitempty"`
+	Targets []TargetEntry `json:"targets,omitempty"`
+}
+
+// TargetEntry records the result of the backup of a target.
+type TargetEntry struct {
+	Kind  string                  `json:"kind"`
+	Name  string                  `json:"name"`
+	Phase api_v1beta1.TargetPhase `json:"phase,omitempty"`
+	Hosts []HostEntry             `json:"hosts,omitempty"`
+}
+
+// HostEntry records the result of the backup of a host of a target.
+type HostEntry struct {
+	Hostname  string                      `json:"hostname"`
+	Phase     api_v1beta1.HostBackupPhase `json:"phase,omitempty"`
+	Duration  string                      `json:"duration,omitempty"`
+	Error     string                      `json:"error,omitempty"`
+	Snapshots []SnapshotEntry             `json:"snapshots,omitempty"`
+}
+
+// SnapshotEntry records a snapshot taken for a host.
+type SnapshotEntry struct {
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	TotalSize string `json:"totalSize,omitempty"`
+	Uploaded  string `json:"uploaded,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupHistoryList is a list of BackupHistories.
+type BackupHistoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupHistory `json:"items"`
+}
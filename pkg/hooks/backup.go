@@ -0,0 +1,128 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"context"
+	"fmt"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/apimachinery/pkg/conditions"
+	stashHooks "stash.appscode.dev/apimachinery/pkg/hooks"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	cutil "kmodules.xyz/client-go/conditions"
+)
+
+// BackupHookExecutor executes a backup hook along with the Job hook specified for it in the invoker annotations.
+// The handler of the hook, if any, is executed first and then the Job hook. They are retried together according
+// to the hook settings of the invoker and report to the same hook execution condition of the BackupSession.
+type BackupHookExecutor struct {
+	stashHooks.BackupHookExecutor
+	KubeClient kubernetes.Interface
+	Host       string
+	// RequestJobHooks makes the operator create the Job hook, as the executor is not allowed to create Jobs.
+	RequestJobHooks bool
+}
+
+func (e *BackupHookExecutor) Execute() error {
+	annotations := e.Invoker.GetObjectMeta().Annotations
+	jobHook, err := util.JobHookFor(annotations, e.HookType)
+	if err != nil {
+		return err
+	}
+	if e.Hook == nil && jobHook == nil {
+		return nil
+	}
+	settings, err := util.HookSettingsFor(annotations, e.HookType)
+	if err != nil {
+		return err
+	}
+
+	summary := e.Invoker.GetSummary(e.Target, kmapi.ObjectReference{
+		Namespace: e.BackupSession.Namespace,
+		Name:      e.BackupSession.Name,
+	})
+	session := invoker.NewBackupSessionHandler(e.StashClient, e.BackupSession)
+
+	if !stashHooks.IsAllowedByExecutionPolicy(e.ExecutionPolicy, summary) {
+		reason := skipReason(e.HookType, e.ExecutionPolicy, summary)
+		klog.Infoln(reason)
+		return conditions.SetPostBackupHookExecutionSucceededToTrueWithMsg(session, e.Target, reason)
+	}
+
+	if cond := e.executionCondition(session); cond != nil {
+		klog.Infof("Skipping executing %s. Reason: It has been executed already....", e.HookType)
+		if cond.Status == metav1.ConditionFalse {
+			return fmt.Errorf("%s hook failed to execute. Reason: %s", e.HookType, cond.Message)
+		}
+		return nil
+	}
+
+	runner := hookRunner{
+		config:      e.Config,
+		kubeClient:  e.KubeClient,
+		handler:     e.Hook,
+		executorPod: e.ExecutorPod,
+		jobHook:     jobHook,
+		settings:    settings,
+		hookType:    e.HookType,
+		host:        e.Host,
+		labels:      e.Invoker.GetLabels(),
+		owner:       metav1.NewControllerRef(e.BackupSession, api_v1beta1.SchemeGroupVersion.WithKind(api_v1beta1.ResourceKindBackupSession)),
+		traceCtx:    tracing.SessionContext(e.BackupSession.Annotations),
+	}
+	if e.RequestJobHooks {
+		runner.updateRequests = func(transform func(map[string]string) map[string]string) error {
+			_, err := v1beta1_util.TryUpdateBackupSession(
+				context.TODO(),
+				e.StashClient.StashV1beta1(),
+				e.BackupSession.ObjectMeta,
+				func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+					in.Annotations = transform(in.Annotations)
+					return in
+				},
+				metav1.UpdateOptions{},
+			)
+			return err
+		}
+	}
+	exec, err := runner.run(summary)
+	if err != nil {
+		condErr := setBackupHookCondition(session, e.Target, e.HookType, exec, err)
+		return errors.NewAggregate([]error{err, condErr})
+	}
+	return setBackupHookCondition(session, e.Target, e.HookType, exec, nil)
+}
+
+func (e *BackupHookExecutor) executionCondition(session *invoker.BackupSessionHandler) *kmapi.Condition {
+	condType := api_v1beta1.PostBackupHookExecutionSucceeded
+	if e.HookType == apis.PreBackupHook {
+		condType = api_v1beta1.PreBackupHookExecutionSucceeded
+	}
+	_, cond := cutil.GetCondition(session.GetTargetConditions(e.Target), condType)
+	return cond
+}
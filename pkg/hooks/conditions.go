@@ -0,0 +1,136 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"fmt"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+type hookConditionInfo struct {
+	condType      kmapi.ConditionType
+	succeedReason string
+	failedReason  string
+	name          string
+}
+
+var targetHookConditions = map[string]hookConditionInfo{
+	apis.PreBackupHook: {
+		condType:      api_v1beta1.PreBackupHookExecutionSucceeded,
+		succeedReason: api_v1beta1.SuccessfullyExecutedPreBackupHook,
+		failedReason:  api_v1beta1.FailedToExecutePreBackupHook,
+		name:          "preBackup hook",
+	},
+	apis.PostBackupHook: {
+		condType:      api_v1beta1.PostBackupHookExecutionSucceeded,
+		succeedReason: api_v1beta1.SuccessfullyExecutedPostBackupHook,
+		failedReason:  api_v1beta1.FailedToExecutePostBackupHook,
+		name:          "postBackup hook",
+	},
+	apis.PreRestoreHook: {
+		condType:      api_v1beta1.PreRestoreHookExecutionSucceeded,
+		succeedReason: api_v1beta1.SuccessfullyExecutedPreRestoreHook,
+		failedReason:  api_v1beta1.FailedToExecutePreRestoreHook,
+		name:          "preRestore hook",
+	},
+	apis.PostRestoreHook: {
+		condType:      api_v1beta1.PostRestoreHookExecutionSucceeded,
+		succeedReason: api_v1beta1.SuccessfullyExecutedPostRestoreHook,
+		failedReason:  api_v1beta1.FailedToExecutePostRestoreHook,
+		name:          "postRestore hook",
+	},
+}
+
+var globalHookConditions = map[string]hookConditionInfo{
+	apis.PreBackupHook: {
+		condType:      api_v1beta1.GlobalPreBackupHookSucceeded,
+		succeedReason: api_v1beta1.GlobalPreBackupHookExecutedSuccessfully,
+		failedReason:  api_v1beta1.GlobalPreBackupHookExecutionFailed,
+		name:          "global PreBackup hook",
+	},
+	apis.PostBackupHook: {
+		condType:      api_v1beta1.GlobalPostBackupHookSucceeded,
+		succeedReason: api_v1beta1.GlobalPostBackupHookExecutedSuccessfully,
+		failedReason:  api_v1beta1.GlobalPostBackupHookExecutionFailed,
+		name:          "global PostBackup hook",
+	},
+	apis.PreRestoreHook: {
+		condType:      api_v1beta1.GlobalPreRestoreHookSucceeded,
+		succeedReason: api_v1beta1.GlobalPreRestoreHookExecutedSuccessfully,
+		failedReason:  api_v1beta1.GlobalPreRestoreHookExecutionFailed,
+		name:          "global PreRestore hook",
+	},
+	apis.PostRestoreHook: {
+		condType:      api_v1beta1.GlobalPostRestoreHookSucceeded,
+		succeedReason: api_v1beta1.GlobalPostRestoreHookExecutedSuccessfully,
+		failedReason:  api_v1beta1.GlobalPostRestoreHookExecutionFailed,
+		name:          "global PostRestore hook",
+	},
+}
+
+// condition returns the hook execution condition that records the attempts and the duration of the execution.
+func (info hookConditionInfo) condition(exec Execution, hookErr error) kmapi.Condition {
+	if hookErr != nil {
+		return kmapi.Condition{
+			Type:               info.condType,
+			Status:             metav1.ConditionFalse,
+			Reason:             info.failedReason,
+			Message:            fmt.Sprintf("Failed to execute %s. Reason: %v. %s.", info.name, hookErr, exec),
+			LastTransitionTime: metav1.Now(),
+		}
+	}
+	return kmapi.Condition{
+		Type:               info.condType,
+		Status:             metav1.ConditionTrue,
+		Reason:             info.succeedReason,
+		Message:            fmt.Sprintf("Successfully executed %s. %s.", info.name, exec),
+		LastTransitionTime: metav1.Now(),
+	}
+}
+
+func setBackupHookCondition(session *invoker.BackupSessionHandler, target api_v1beta1.TargetRef, hookType string, exec Execution, hookErr error) error {
+	return session.UpdateStatus(&api_v1beta1.BackupSessionStatus{
+		Targets: []api_v1beta1.BackupTargetStatus{
+			{
+				Ref:        target,
+				Conditions: []kmapi.Condition{targetHookConditions[hookType].condition(exec, hookErr)},
+			},
+		},
+	})
+}
+
+func setRestoreHookCondition(inv invoker.RestoreInvoker, hookType string, exec Execution, hookErr error) error {
+	return inv.SetCondition(nil, targetHookConditions[hookType].condition(exec, hookErr))
+}
+
+// SetGlobalBackupHookCondition sets the execution condition of a global backup hook of the BackupSession.
+func SetGlobalBackupHookCondition(session *invoker.BackupSessionHandler, hookType string, exec Execution, hookErr error) error {
+	return session.UpdateStatus(&api_v1beta1.BackupSessionStatus{
+		Conditions: []kmapi.Condition{globalHookConditions[hookType].condition(exec, hookErr)},
+	})
+}
+
+// SetGlobalRestoreHookCondition sets the execution condition of a global restore hook of the restore invoker.
+func SetGlobalRestoreHookCondition(inv invoker.RestoreInvoker, hookType string, exec Execution, hookErr error) error {
+	return inv.SetCondition(nil, globalHookConditions[hookType].condition(exec, hookErr))
+}
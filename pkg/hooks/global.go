@@ -0,0 +1,250 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	prober "kmodules.xyz/prober/api/v1"
+)
+
+const (
+	// globalHookWait is how long a reconciliation waits for a running attempt before it requeues the session.
+	// Most of the hooks complete within it, so that they do not have to wait for the requeue.
+	globalHookWait = 2 * time.Second
+	// globalHookPollInterval is the interval the session is requeued at while an attempt is running.
+	globalHookPollInterval = 5 * time.Second
+)
+
+// GlobalHookState records the attempts made to execute a global hook. It is kept in the annotations of the session
+// between the reconciliations.
+type GlobalHookState struct {
+	Attempts    int         `json:"attempts,omitempty"`
+	Started     metav1.Time `json:"started,omitempty"`
+	NextAttempt metav1.Time `json:"nextAttempt,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// GlobalHookStates are the states of the global hooks of a session keyed by the hook type.
+type GlobalHookStates map[string]GlobalHookState
+
+// GlobalHookStatesFromAnnotations reads the states of the global hooks from the annotation.
+// No state is returned if the annotation is missing or can not be parsed.
+func GlobalHookStatesFromAnnotations(annotations map[string]string) GlobalHookStates {
+	states := GlobalHookStates{}
+	if val, ok := annotations[util.KeyGlobalHookState]; ok {
+		_ = json.Unmarshal([]byte(val), &states)
+	}
+	return states
+}
+
+// UpsertGlobalHookState writes the state of a global hook into the annotation. A nil state removes the hook from it.
+func UpsertGlobalHookState(annotations map[string]string, hookType string, state *GlobalHookState) map[string]string {
+	states := GlobalHookStatesFromAnnotations(annotations)
+	if state == nil {
+		delete(states, hookType)
+	} else {
+		states[hookType] = *state
+	}
+	if len(states) == 0 {
+		delete(annotations, util.KeyGlobalHookState)
+		return annotations
+	}
+	// the states consist of plain fields only, so marshaling them can not fail.
+	data, _ := json.Marshal(states)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[util.KeyGlobalHookState] = string(data)
+	return annotations
+}
+
+// GlobalHook is a global hook executed by the operator. The operator must never block its workers while a hook is
+// running or waiting for a retry. So, every attempt runs in its own goroutine and Step is called on every reconciliation
+// of the session to check on it.
+type GlobalHook struct {
+	// Key identifies the session the hook is executed for.
+	Key         string
+	HookType    string
+	TraceCtx    context.Context
+	Config      *rest.Config
+	Handler     *prober.Handler
+	ExecutorPod kmapi.ObjectReference
+	Summary     *api_v1beta1.Summary
+	// Annotations are the annotations of the invoker holding the settings of the hook.
+	Annotations map[string]string
+	State       GlobalHookState
+
+	// execute replaces the execution of the handler in the tests
+	execute func() error
+}
+
+// GlobalHookResult reports the progress made by GlobalHook.Step.
+type GlobalHookResult struct {
+	// Completed is true once the hook has succeeded or its retries have been exhausted. Exec and Err hold the outcome then.
+	Completed bool
+	Exec      Execution
+	Err       error
+	// State is the new state of the hook to store in the session. It is nil if the state has not changed.
+	State *GlobalHookState
+	// RequeueAfter is the time after which the session must be reconciled again while the hook has not completed.
+	RequeueAfter time.Duration
+}
+
+type globalHookRun struct {
+	started time.Time
+	done    chan struct{}
+	err     error
+	// timedOut is set once the attempt has been reported as timed out. The run is forgotten as soon as it returns.
+	timedOut bool
+}
+
+// globalHookRuns are the attempts running in the operator keyed by the session and the hook type.
+// A timed out attempt is kept here until it returns, so that the hook never runs twice at the same time.
+var globalHookRuns = struct {
+	sync.Mutex
+	runs map[string]*globalHookRun
+}{runs: map[string]*globalHookRun{}}
+
+// Step starts an attempt to execute the hook or checks on the running attempt. It does not wait for more than a few seconds.
+// A failed attempt is retried after the backoff of the settings. A timed out attempt is never retried as the hook may still be running.
+func (h GlobalHook) Step(now time.Time) GlobalHookResult {
+	settings, err := util.GlobalHookSettingsFor(h.Annotations, h.HookType)
+	if err != nil {
+		return GlobalHookResult{Completed: true, Err: err}
+	}
+	key := h.Key + "/" + h.HookType
+
+	globalHookRuns.Lock()
+	run := globalHookRuns.runs[key]
+	started := false
+	if run == nil {
+		if wait := h.State.NextAttempt.Sub(now); wait > 0 {
+			globalHookRuns.Unlock()
+			return GlobalHookResult{RequeueAfter: wait}
+		}
+		run = h.start(key, now)
+		state := h.State
+		state.Attempts++
+		state.NextAttempt = metav1.Time{}
+		if state.Started.IsZero() {
+			state.Started = metav1.NewTime(now)
+		}
+		h.State = state
+		started = true
+	}
+	globalHookRuns.Unlock()
+
+	wait := globalHookWait
+	if settings.Timeout > 0 {
+		if remaining := settings.Timeout - now.Sub(run.started); remaining < wait {
+			wait = remaining
+		}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-run.done:
+		globalHookRuns.Lock()
+		delete(globalHookRuns.runs, key)
+		globalHookRuns.Unlock()
+		return h.finish(settings, run.err, time.Now())
+	case <-timer.C:
+	}
+
+	if settings.Timeout > 0 && time.Since(run.started) >= settings.Timeout {
+		globalHookRuns.Lock()
+		select {
+		case <-run.done:
+			// it has returned in the meantime
+			delete(globalHookRuns.runs, key)
+		default:
+			run.timedOut = true
+		}
+		globalHookRuns.Unlock()
+		return GlobalHookResult{
+			Completed: true,
+			Exec:      h.execution(time.Now()),
+			Err:       fmt.Errorf("%w within %s. It won't be retried as the hook may still be running", errTimeout, settings.Timeout),
+		}
+	}
+	result := GlobalHookResult{RequeueAfter: globalHookPollInterval}
+	if started {
+		state := h.State
+		result.State = &state
+	}
+	return result
+}
+
+// start runs an attempt in a goroutine. It must be called with globalHookRuns locked.
+func (h GlobalHook) start(key string, now time.Time) *globalHookRun {
+	run := &globalHookRun{
+		started: now,
+		done:    make(chan struct{}),
+	}
+	globalHookRuns.runs[key] = run
+	attempt := h.State.Attempts + 1
+	execute := h.execute
+	if execute == nil {
+		execute = func() error {
+			return executeHandler(h.Config, h.Handler, h.ExecutorPod, h.Summary, 0)
+		}
+	}
+	go func() {
+		_, err := traceHook(h.TraceCtx, h.HookType, "", func(_ context.Context) (Execution, error) {
+			return Execution{Attempts: attempt}, execute()
+		})
+		globalHookRuns.Lock()
+		run.err = err
+		close(run.done)
+		if run.timedOut {
+			delete(globalHookRuns.runs, key)
+		}
+		globalHookRuns.Unlock()
+	}()
+	return run
+}
+
+// finish decides whether the hook has completed once an attempt has returned.
+func (h GlobalHook) finish(settings util.HookSettings, err error, now time.Time) GlobalHookResult {
+	if err == nil || h.State.Attempts > settings.MaxRetries {
+		return GlobalHookResult{Completed: true, Exec: h.execution(now), Err: err}
+	}
+	backoff := backoffAfter(settings, h.State.Attempts)
+	state := h.State
+	state.NextAttempt = metav1.NewTime(now.Add(backoff))
+	state.Error = err.Error()
+	return GlobalHookResult{State: &state, RequeueAfter: backoff}
+}
+
+func (h GlobalHook) execution(now time.Time) Execution {
+	exec := Execution{Attempts: h.State.Attempts}
+	if !h.State.Started.IsZero() {
+		exec.Duration = now.Sub(h.State.Started.Time)
+	}
+	return exec
+}
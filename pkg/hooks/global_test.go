@@ -0,0 +1,125 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGlobalHookStep(t *testing.T) {
+	annotations := map[string]string{
+		util.KeyGlobalPreBackupHookSettings: `{"maxRetries": 1, "backoff": "1m", "timeout": "100ms"}`,
+	}
+	hook := GlobalHook{
+		Key:         "demo/failing",
+		HookType:    apis.PreBackupHook,
+		Annotations: annotations,
+		execute:     func() error { return errors.New("hook failed") },
+	}
+
+	// a failed attempt is scheduled for a retry instead of sleeping
+	now := time.Now()
+	result := hook.Step(now)
+	if result.Completed || result.State == nil || result.State.Attempts != 1 || result.RequeueAfter != time.Minute {
+		t.Fatalf("expected a retry after the backoff, got %+v", result)
+	}
+
+	// the retry is not attempted before the backoff has passed
+	hook.State = *result.State
+	if result := hook.Step(now.Add(time.Second)); result.Completed || result.State != nil || result.RequeueAfter <= 0 {
+		t.Fatalf("expected to wait for the backoff, got %+v", result)
+	}
+
+	// the retries are exhausted
+	result = hook.Step(hook.State.NextAttempt.Time)
+	if !result.Completed || result.Err == nil || result.Exec.Attempts != 2 {
+		t.Fatalf("expected the hook to fail after 2 attempts, got %+v", result)
+	}
+
+	// a timed out attempt is never retried
+	release := make(chan struct{})
+	defer close(release)
+	hook = GlobalHook{
+		Key:         "demo/hanging",
+		HookType:    apis.PreBackupHook,
+		Annotations: annotations,
+		execute: func() error {
+			<-release
+			return nil
+		},
+	}
+	result = hook.Step(time.Now())
+	if !result.Completed || !errors.Is(result.Err, errTimeout) {
+		t.Fatalf("expected the hook to time out, got %+v", result)
+	}
+}
+
+func TestGlobalHookStepDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	hook := GlobalHook{
+		Key:      "demo/slow",
+		HookType: apis.PostBackupHook,
+		execute: func() error {
+			<-release
+			return nil
+		},
+	}
+	start := time.Now()
+	result := hook.Step(start)
+	if result.Completed || result.State == nil || result.RequeueAfter != globalHookPollInterval {
+		t.Fatalf("expected the session to be requeued while the hook is running, got %+v", result)
+	}
+	if waited := time.Since(start); waited > 2*globalHookWait {
+		t.Errorf("expected Step to return without waiting for the hook, it took %s", waited)
+	}
+
+	// the running attempt is checked on instead of starting a new one
+	hook.State = *result.State
+	close(release)
+	result = hook.Step(time.Now())
+	if !result.Completed || result.Err != nil || result.Exec.Attempts != 1 {
+		t.Fatalf("expected the hook to succeed at the first attempt, got %+v", result)
+	}
+}
+
+func TestUpsertGlobalHookState(t *testing.T) {
+	state := &GlobalHookState{Attempts: 2, NextAttempt: metav1.NewTime(time.Now().Truncate(time.Second))}
+	annotations := UpsertGlobalHookState(nil, apis.PreBackupHook, state)
+	if got := GlobalHookStatesFromAnnotations(annotations)[apis.PreBackupHook]; got.Attempts != 2 || !got.NextAttempt.Equal(&state.NextAttempt) {
+		t.Errorf("expected %+v, got %+v", *state, got)
+	}
+	annotations = UpsertGlobalHookState(annotations, apis.PreBackupHook, nil)
+	if _, ok := annotations[util.KeyGlobalHookState]; ok {
+		t.Errorf("expected the annotation to be removed with the last state")
+	}
+}
+
+func TestHookSettingsMaxRetries(t *testing.T) {
+	annotations := map[string]string{
+		util.KeyGlobalPreBackupHookSettings: `{"maxRetries": 1000}`,
+	}
+	if _, err := util.GlobalHookSettingsFor(annotations, apis.PreBackupHook); err == nil {
+		t.Errorf("expected maxRetries beyond %d to be rejected", util.MaxHookRetries)
+	}
+}
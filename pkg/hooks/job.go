@@ -0,0 +1,337 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/executor"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	meta_util "kmodules.xyz/client-go/meta"
+	prober "kmodules.xyz/prober/api/v1"
+)
+
+const (
+	// environment variables injected into the Job hooks along with the session variables defined in apis
+	EnvTargetHost  = "TARGET_HOST"
+	EnvTargetPhase = "TARGET_PHASE"
+	EnvTargetError = "TARGET_ERROR"
+	EnvRetryLeft   = "RETRY_LEFT"
+
+	// jobHookRequestGracePeriod is the time given to the operator to create a requested Job hook
+	jobHookRequestGracePeriod = 2 * time.Minute
+)
+
+// hookRunner executes the handler of a hook followed by its Job hook. The whole sequence is retried
+// according to the settings of the hook. The timeout of the settings applies to the handler only,
+// the Job hook is bounded by its own timeout.
+type hookRunner struct {
+	config      *rest.Config
+	kubeClient  kubernetes.Interface
+	handler     *prober.Handler
+	executorPod kmapi.ObjectReference
+	jobHook     *util.JobHook
+	settings    util.HookSettings
+	hookType    string
+	host        string
+	labels      map[string]string
+	owner       *metav1.OwnerReference
+	// traceCtx carries the trace of the session the hook is executed for
+	traceCtx context.Context
+	// updateRequests is set when the executor is not allowed to create Jobs. The Job hook is then requested
+	// from the operator in the annotations of the session and the executor only waits for it to complete.
+	updateRequests func(transform func(map[string]string) map[string]string) error
+}
+
+func (r *hookRunner) run(summary *api_v1beta1.Summary) (Execution, error) {
+	return traceHook(r.traceCtx, r.hookType, r.host, func(ctx context.Context) (Execution, error) {
+		return retry(r.hookType, r.settings, func(attempt int) error {
+			return r.runOnce(ctx, summary, attempt)
+		})
+	})
+}
+
+func (r *hookRunner) runOnce(ctx context.Context, summary *api_v1beta1.Summary, attempt int) error {
+	if r.handler != nil {
+		if err := executeHandler(r.config, r.handler, r.executorPod, summary, r.settings.Timeout); err != nil {
+			return err
+		}
+	}
+	if r.jobHook == nil {
+		return nil
+	}
+
+	// every attempt runs a new Job so that the failed ones are kept for inspection
+	job := executor.HookJob{
+		KubeClient: r.kubeClient,
+		Meta: metav1.ObjectMeta{
+			Name:      JobHookName(r.hookType, summary.Name, r.host, attempt),
+			Namespace: summary.Namespace,
+			Labels:    r.labels,
+		},
+		Owner:    r.owner,
+		Template: r.jobHook.Template,
+		Env:      append(sessionEnv(r.hookType, r.host, summary), tracing.EnvVars(ctx)...),
+		Timeout:  r.jobHook.Timeout,
+	}
+	if r.updateRequests == nil {
+		return job.Run()
+	}
+
+	req := JobHookRequest{
+		HookType: r.hookType,
+		Host:     r.host,
+		Attempt:  attempt,
+		Env:      job.Env,
+	}
+	err := r.updateRequests(func(in map[string]string) map[string]string {
+		return UpsertJobHookRequest(in, job.Meta.Name, &req)
+	})
+	if err != nil {
+		return err
+	}
+	return job.Wait(r.jobHook.Timeout + jobHookRequestGracePeriod)
+}
+
+// JobHookName returns the name of the Job that runs an attempt of the Job hook of a host.
+func JobHookName(hookType, sessionName, host string, attempt int) string {
+	suffix := host
+	if attempt > 1 {
+		suffix = fmt.Sprintf("%s-%d", host, attempt)
+	}
+	return meta_util.ValidNameWithPrefixNSuffix(strings.ToLower(hookType), sessionName, suffix)
+}
+
+// JobHookRequest asks the operator to run an attempt of a Job hook for an executor that is not allowed to create Jobs.
+type JobHookRequest struct {
+	HookType string        `json:"hookType"`
+	Host     string        `json:"host"`
+	Attempt  int           `json:"attempt"`
+	Env      []core.EnvVar `json:"env,omitempty"`
+}
+
+// JobHookRequests holds the pending requests indexed by the name of the Job.
+type JobHookRequests map[string]JobHookRequest
+
+// JobHookRequestsFromAnnotations reads the pending Job hook requests from the annotation.
+// A malformed annotation is treated as no request at all.
+func JobHookRequestsFromAnnotations(annotations map[string]string) JobHookRequests {
+	reqs := JobHookRequests{}
+	if val, ok := annotations[util.KeyJobHookRequests]; ok {
+		_ = json.Unmarshal([]byte(val), &reqs)
+	}
+	return reqs
+}
+
+// UpsertJobHookRequest writes a Job hook request into the annotation. A nil request removes it from the annotation.
+func UpsertJobHookRequest(annotations map[string]string, name string, req *JobHookRequest) map[string]string {
+	reqs := JobHookRequestsFromAnnotations(annotations)
+	if req == nil {
+		delete(reqs, name)
+	} else {
+		reqs[name] = *req
+	}
+	if len(reqs) == 0 {
+		delete(annotations, util.KeyJobHookRequests)
+		return annotations
+	}
+	data, err := json.Marshal(reqs)
+	if err != nil {
+		return annotations
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[util.KeyJobHookRequests] = string(data)
+	return annotations
+}
+
+// RequestedJobHooks creates the Jobs requested in the annotations of a session. The templates are always taken from
+// the annotations of the invoker, so an executor can only choose the session variables of the Jobs.
+type RequestedJobHooks struct {
+	KubeClient  kubernetes.Interface
+	Requests    JobHookRequests
+	HookTypes   []string
+	SessionName string
+	Namespace   string
+	Annotations map[string]string
+	Labels      map[string]string
+	Owner       *metav1.OwnerReference
+}
+
+// Run creates the requested Jobs that do not exist yet. It returns the names of the handled requests, including the
+// rejected ones, which should be removed from the annotations of the session.
+func (h RequestedJobHooks) Run() ([]string, error) {
+	var handled []string
+	var errs []error
+	for name, req := range h.Requests {
+		if err := h.ensure(name, req); err != nil {
+			errs = append(errs, fmt.Errorf("failed to run the requested Job hook %s. Reason: %w", name, err))
+		}
+		handled = append(handled, name)
+	}
+	return handled, errors.NewAggregate(errs)
+}
+
+func (h RequestedJobHooks) ensure(name string, req JobHookRequest) error {
+	if !h.expects(req.HookType) {
+		return fmt.Errorf("unexpected hook type %q", req.HookType)
+	}
+	if name != JobHookName(req.HookType, h.SessionName, req.Host, req.Attempt) {
+		return fmt.Errorf("name does not match the hook type, host and attempt of the request")
+	}
+	jobHook, err := util.JobHookFor(h.Annotations, req.HookType)
+	if err != nil {
+		return err
+	}
+	if jobHook == nil {
+		return fmt.Errorf("no Job hook has been specified for %s", req.HookType)
+	}
+	settings, err := util.HookSettingsFor(h.Annotations, req.HookType)
+	if err != nil {
+		return err
+	}
+	if req.Attempt < 1 || req.Attempt > settings.MaxRetries+1 {
+		return fmt.Errorf("attempt %d exceeds the retries of %s", req.Attempt, req.HookType)
+	}
+
+	_, err = h.KubeClient.BatchV1().Jobs(h.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !kerr.IsNotFound(err) {
+		return err
+	}
+	job := executor.HookJob{
+		KubeClient: h.KubeClient,
+		Meta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: h.Namespace,
+			Labels:    h.Labels,
+		},
+		Owner:    h.Owner,
+		Template: jobHook.Template,
+		Env:      plainEnv(req.Env),
+		Timeout:  jobHook.Timeout,
+	}
+	_, _, err = job.Ensure()
+	return err
+}
+
+func (h RequestedJobHooks) expects(hookType string) bool {
+	for _, t := range h.HookTypes {
+		if t == hookType {
+			return true
+		}
+	}
+	return false
+}
+
+// plainEnv drops the variables that refer to other objects, as the operator would read them on behalf of the executor.
+func plainEnv(env []core.EnvVar) []core.EnvVar {
+	out := make([]core.EnvVar, 0, len(env))
+	for _, e := range env {
+		if e.ValueFrom == nil {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// ExecuteJobHook runs the Job hook of the given hook type specified in the annotations of the invoker.
+// It does nothing if no Job hook has been specified. It is used by the executors that execute the
+// handlers of the hooks directly without tracking the hook execution conditions.
+func ExecuteJobHook(kubeClient kubernetes.Interface, inv invoker.MetadataHandler, hookType, host string, summary *api_v1beta1.Summary, owner *metav1.OwnerReference) error {
+	jobHook, err := util.JobHookFor(inv.GetObjectMeta().Annotations, hookType)
+	if err != nil || jobHook == nil {
+		return err
+	}
+	settings, err := util.HookSettingsFor(inv.GetObjectMeta().Annotations, hookType)
+	if err != nil {
+		return err
+	}
+	runner := hookRunner{
+		kubeClient: kubeClient,
+		jobHook:    jobHook,
+		settings:   settings,
+		hookType:   hookType,
+		host:       host,
+		labels:     inv.GetLabels(),
+		owner:      owner,
+		traceCtx:   tracing.SessionContext(inv.GetObjectMeta().Annotations),
+	}
+	_, err = runner.run(summary)
+	return err
+}
+
+// sessionEnv returns the session variables that are injected into the containers of a Job hook.
+func sessionEnv(hookType, host string, summary *api_v1beta1.Summary) []core.EnvVar {
+	sessionKey := apis.RestoreSession
+	if hookType == apis.PreBackupHook || hookType == apis.PostBackupHook {
+		sessionKey = apis.BackupSession
+	}
+	env := []core.EnvVar{
+		{Name: apis.HookType, Value: hookType},
+		{Name: apis.Namespace, Value: summary.Namespace},
+		{Name: sessionKey, Value: summary.Name},
+		{Name: apis.InvokerKind, Value: summary.Invoker.Kind},
+		{Name: apis.InvokerName, Value: summary.Invoker.Name},
+		{Name: apis.TargetAPIVersion, Value: summary.Target.APIVersion},
+		{Name: apis.TargetKind, Value: summary.Target.Kind},
+		{Name: apis.TargetName, Value: summary.Target.Name},
+		{Name: apis.TargetNamespace, Value: summary.Target.Namespace},
+		{Name: EnvTargetHost, Value: host},
+		{Name: EnvTargetPhase, Value: summary.Status.Phase},
+		{Name: EnvRetryLeft, Value: strconv.Itoa(int(summary.RetryLeft))},
+	}
+	if summary.Status.Error != "" {
+		env = append(env, core.EnvVar{Name: EnvTargetError, Value: summary.Status.Error})
+	}
+	return env
+}
+
+func getExecutionPolicyWithDefault(executionPolicy api_v1beta1.HookExecutionPolicy) api_v1beta1.HookExecutionPolicy {
+	if executionPolicy == "" {
+		return api_v1beta1.ExecuteAlways
+	}
+	return executionPolicy
+}
+
+func skipReason(hookType string, executionPolicy api_v1beta1.HookExecutionPolicy, summary *api_v1beta1.Summary) string {
+	return fmt.Sprintf("Skipping executing %s. Reason: executionPolicy is %q but phase is %q.",
+		hookType,
+		getExecutionPolicyWithDefault(executionPolicy),
+		summary.Status.Phase,
+	)
+}
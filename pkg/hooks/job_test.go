@@ -0,0 +1,201 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSessionEnv(t *testing.T) {
+	summary := &api_v1beta1.Summary{
+		Name:      "sample-1234",
+		Namespace: "demo",
+		Invoker:   core.TypedLocalObjectReference{Kind: api_v1beta1.ResourceKindBackupConfiguration, Name: "sample"},
+		Target: api_v1beta1.TargetRef{
+			APIVersion: "apps/v1",
+			Kind:       "StatefulSet",
+			Name:       "db",
+			Namespace:  "demo",
+		},
+		RetryLeft: 2,
+	}
+	cases := []struct {
+		name       string
+		hookType   string
+		phase      string
+		err        string
+		sessionKey string
+		targetErr  string
+	}{
+		{name: "pre backup", hookType: apis.PreBackupHook, sessionKey: apis.BackupSession},
+		{name: "failed backup", hookType: apis.PostBackupHook, phase: "Failed", err: "repository is locked", sessionKey: apis.BackupSession, targetErr: "repository is locked"},
+		{name: "pre restore", hookType: apis.PreRestoreHook, sessionKey: apis.RestoreSession},
+		{name: "post restore", hookType: apis.PostRestoreHook, phase: "Succeeded", sessionKey: apis.RestoreSession},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := *summary
+			s.Status.Phase = c.phase
+			s.Status.Error = c.err
+			env := map[string]string{}
+			for _, e := range sessionEnv(c.hookType, "host-1", &s) {
+				env[e.Name] = e.Value
+			}
+			expected := map[string]string{
+				apis.HookType:         c.hookType,
+				apis.Namespace:        "demo",
+				c.sessionKey:          "sample-1234",
+				apis.InvokerKind:      api_v1beta1.ResourceKindBackupConfiguration,
+				apis.InvokerName:      "sample",
+				apis.TargetAPIVersion: "apps/v1",
+				apis.TargetKind:       "StatefulSet",
+				apis.TargetName:       "db",
+				apis.TargetNamespace:  "demo",
+				EnvTargetHost:         "host-1",
+				EnvTargetPhase:        c.phase,
+				EnvRetryLeft:          "2",
+			}
+			if c.targetErr != "" {
+				expected[EnvTargetError] = c.targetErr
+			}
+			if !reflect.DeepEqual(env, expected) {
+				t.Errorf("expected %v, found %v", expected, env)
+			}
+		})
+	}
+}
+
+func TestUpsertJobHookRequest(t *testing.T) {
+	req := JobHookRequest{HookType: apis.PreBackupHook, Host: "host-0", Attempt: 1}
+	annotations := UpsertJobHookRequest(nil, "pre-backup-hook-sample-host-0", &req)
+	expected := JobHookRequests{"pre-backup-hook-sample-host-0": req}
+	if found := JobHookRequestsFromAnnotations(annotations); !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected %v, found %v", expected, found)
+	}
+	annotations = UpsertJobHookRequest(annotations, "pre-backup-hook-sample-host-0", nil)
+	if _, ok := annotations[util.KeyJobHookRequests]; ok {
+		t.Errorf("expected the annotation to be removed with the last request, found %v", annotations)
+	}
+}
+
+func TestRequestedJobHooks(t *testing.T) {
+	annotations := map[string]string{
+		util.KeyPreBackupJobHook:      `{"spec":{"containers":[{"name":"hook","image":"busybox"}]}}`,
+		util.KeyPreBackupHookSettings: `{"maxRetries":1}`,
+	}
+	cases := []struct {
+		name     string
+		jobName  string
+		req      JobHookRequest
+		existing bool
+		// expected
+		created   bool
+		expectErr bool
+	}{
+		{
+			name:    "first attempt",
+			jobName: JobHookName(apis.PreBackupHook, "sample-1234", "host-0", 1),
+			req:     JobHookRequest{HookType: apis.PreBackupHook, Host: "host-0", Attempt: 1},
+			created: true,
+		},
+		{
+			name:    "retry",
+			jobName: JobHookName(apis.PreBackupHook, "sample-1234", "host-0", 2),
+			req:     JobHookRequest{HookType: apis.PreBackupHook, Host: "host-0", Attempt: 2},
+			created: true,
+		},
+		{
+			name:     "already created",
+			jobName:  JobHookName(apis.PreBackupHook, "sample-1234", "host-0", 1),
+			req:      JobHookRequest{HookType: apis.PreBackupHook, Host: "host-0", Attempt: 1},
+			existing: true,
+		},
+		{
+			name:      "attempt exceeds retries",
+			jobName:   JobHookName(apis.PreBackupHook, "sample-1234", "host-0", 3),
+			req:       JobHookRequest{HookType: apis.PreBackupHook, Host: "host-0", Attempt: 3},
+			expectErr: true,
+		},
+		{
+			name:      "hook without template",
+			jobName:   JobHookName(apis.PostBackupHook, "sample-1234", "host-0", 1),
+			req:       JobHookRequest{HookType: apis.PostBackupHook, Host: "host-0", Attempt: 1},
+			expectErr: true,
+		},
+		{
+			name:      "restore hook",
+			jobName:   JobHookName(apis.PreRestoreHook, "sample-1234", "host-0", 1),
+			req:       JobHookRequest{HookType: apis.PreRestoreHook, Host: "host-0", Attempt: 1},
+			expectErr: true,
+		},
+		{
+			name:      "arbitrary name",
+			jobName:   "db-migration",
+			req:       JobHookRequest{HookType: apis.PreBackupHook, Host: "host-0", Attempt: 1},
+			expectErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			if c.existing {
+				_, err := kubeClient.BatchV1().Jobs("demo").Create(context.TODO(), &batch.Job{
+					ObjectMeta: metav1.ObjectMeta{Name: c.jobName, Namespace: "demo"},
+				}, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			handled, err := RequestedJobHooks{
+				KubeClient:  kubeClient,
+				Requests:    JobHookRequests{c.jobName: c.req},
+				HookTypes:   []string{apis.PreBackupHook, apis.PostBackupHook},
+				SessionName: "sample-1234",
+				Namespace:   "demo",
+				Annotations: annotations,
+			}.Run()
+			if (err != nil) != c.expectErr {
+				t.Fatalf("expected error %v, found %v", c.expectErr, err)
+			}
+			if !reflect.DeepEqual(handled, []string{c.jobName}) {
+				t.Errorf("expected the request to be handled, found %v", handled)
+			}
+			job, err := kubeClient.BatchV1().Jobs("demo").Get(context.TODO(), c.jobName, metav1.GetOptions{})
+			if c.created {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(job.Spec.Template.Spec.Containers) != 1 {
+					t.Errorf("expected the template of the invoker, found %+v", job.Spec.Template.Spec)
+				}
+			} else if err == nil && !c.existing {
+				t.Errorf("expected no Job, found %s", job.Name)
+			}
+		})
+	}
+}
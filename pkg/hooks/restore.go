@@ -0,0 +1,114 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"fmt"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/apimachinery/pkg/conditions"
+	stashHooks "stash.appscode.dev/apimachinery/pkg/hooks"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+// RestoreHookExecutor executes a restore hook along with the Job hook specified for it in the invoker annotations.
+// It follows the same rules as the BackupHookExecutor and reports to the hook execution conditions of the restore invoker.
+type RestoreHookExecutor struct {
+	stashHooks.RestoreHookExecutor
+	KubeClient kubernetes.Interface
+	Host       string
+	// RequestJobHooks makes the operator create the Job hook, as the executor is not allowed to create Jobs.
+	// The request is written into the annotations of the invoker using the StashClient.
+	RequestJobHooks bool
+	StashClient     cs.Interface
+}
+
+func (e *RestoreHookExecutor) Execute() error {
+	invMeta := e.Invoker.GetObjectMeta()
+	jobHook, err := util.JobHookFor(invMeta.Annotations, e.HookType)
+	if err != nil {
+		return err
+	}
+	if e.Hook == nil && jobHook == nil {
+		return nil
+	}
+	settings, err := util.HookSettingsFor(invMeta.Annotations, e.HookType)
+	if err != nil {
+		return err
+	}
+
+	summary := e.Invoker.GetSummary(e.Target, kmapi.ObjectReference{
+		Namespace: invMeta.Namespace,
+		Name:      invMeta.Name,
+	})
+
+	if !stashHooks.IsAllowedByExecutionPolicy(e.ExecutionPolicy, summary) {
+		reason := skipReason(e.HookType, e.ExecutionPolicy, summary)
+		klog.Infoln(reason)
+		return conditions.SetPostRestoreHookExecutionSucceededToTrueWithMsg(e.Invoker, reason)
+	}
+
+	condType := api_v1beta1.PostRestoreHookExecutionSucceeded
+	if e.HookType == apis.PreRestoreHook {
+		condType = api_v1beta1.PreRestoreHookExecutionSucceeded
+	}
+	_, cond, err := e.Invoker.GetCondition(&e.Target, condType)
+	if err != nil {
+		return err
+	}
+	if cond != nil {
+		klog.Infof("Skipping executing %s. Reason: It has been executed already....", e.HookType)
+		if cond.Status == metav1.ConditionFalse {
+			return fmt.Errorf("%s hook failed to execute. Reason: %s", e.HookType, cond.Message)
+		}
+		return nil
+	}
+
+	runner := hookRunner{
+		config:      e.Config,
+		kubeClient:  e.KubeClient,
+		handler:     e.Hook,
+		executorPod: e.ExecutorPod,
+		jobHook:     jobHook,
+		settings:    settings,
+		hookType:    e.HookType,
+		host:        e.Host,
+		labels:      e.Invoker.GetLabels(),
+		owner:       e.Invoker.GetOwnerRef(),
+		traceCtx:    tracing.SessionContext(invMeta.Annotations),
+	}
+	if e.RequestJobHooks {
+		runner.updateRequests = func(transform func(map[string]string) map[string]string) error {
+			return util.UpdateRestoreInvokerAnnotations(e.StashClient, e.Invoker, transform)
+		}
+	}
+	exec, err := runner.run(summary)
+	if err != nil {
+		condErr := setRestoreHookCondition(e.Invoker, e.HookType, exec, err)
+		return errors.NewAggregate([]error{err, condErr})
+	}
+	return setRestoreHookCondition(e.Invoker, e.HookType, exec, nil)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	stashHooks "stash.appscode.dev/apimachinery/pkg/hooks"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	prober "kmodules.xyz/prober/api/v1"
+)
+
+// Execution reports the attempts made to execute a hook and the total time they took.
+type Execution struct {
+	Attempts int
+	Duration time.Duration
+}
+
+func (e Execution) String() string {
+	return fmt.Sprintf("Attempts: %d, duration: %s", e.Attempts, e.Duration.Round(time.Millisecond))
+}
+
+// errTimeout is returned when a hook handler does not complete within the timeout.
+var errTimeout = errors.New("hook did not complete")
+
+// retry calls fn until it succeeds or the retries of the settings have been exhausted. It sleeps between the attempts,
+// so it is used only in the executor pods. The global hooks executed by the operator are retried by GlobalHook.Step.
+// A timed out attempt is never retried as the hook may still be running in the executor pod.
+func retry(hookType string, settings util.HookSettings, fn func(attempt int) error) (Execution, error) {
+	var exec Execution
+	start := time.Now()
+	for {
+		exec.Attempts++
+		err := fn(exec.Attempts)
+		exec.Duration = time.Since(start)
+		if err == nil || exec.Attempts > settings.MaxRetries {
+			return exec, err
+		}
+		if errors.Is(err, errTimeout) {
+			klog.Infof("Attempt %d to execute %s hook has timed out. It won't be retried as the hook may still be running. Reason: %v", exec.Attempts, hookType, err)
+			return exec, err
+		}
+
+		backoff := backoffAfter(settings, exec.Attempts)
+		klog.Infof("Attempt %d to execute %s hook has failed. Retrying after %s. Reason: %v", exec.Attempts, hookType, backoff, err)
+		time.Sleep(backoff)
+	}
+}
+
+// backoffAfter returns the backoff after the given number of failed attempts. It is doubled on every retry up to util.MaxHookBackoff.
+func backoffAfter(settings util.HookSettings, attempts int) time.Duration {
+	backoff := settings.Backoff
+	for i := 1; i < attempts && backoff < util.MaxHookBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > util.MaxHookBackoff {
+		backoff = util.MaxHookBackoff
+	}
+	return backoff
+}
+
+// withTimeout returns errTimeout if fn does not return within the timeout. The call of fn itself
+// can not be interrupted, its result is discarded once the timeout has passed.
+func withTimeout(timeout time.Duration, fn func() error) error {
+	if timeout <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w within %s", errTimeout, timeout)
+	}
+}
+
+// executeHandler executes a hook handler once honoring the timeout of the settings.
+// A copy of the handler is used as its templates are rendered in place.
+func executeHandler(config *rest.Config, handler *prober.Handler, executorPod kmapi.ObjectReference, summary *api_v1beta1.Summary, timeout time.Duration) error {
+	hookExecutor := stashHooks.HookExecutor{
+		Config:      config,
+		Hook:        handler.DeepCopy(),
+		ExecutorPod: executorPod,
+		Summary:     summary,
+	}
+	return withTimeout(timeout, hookExecutor.Execute)
+}
+
+// traceHook runs fn in a span of the hook. The span records the number of attempts made to execute the hook.
+func traceHook(parent context.Context, hookType, host string, fn func(ctx context.Context) (Execution, error)) (Execution, error) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	attrs := []attribute.KeyValue{tracing.KeyHookType.String(hookType)}
+	if host != "" {
+		attrs = append(attrs, tracing.KeyHost.String(host))
+	}
+	ctx, span := tracing.Start(parent, "Execute "+hookType+" hook", attrs...)
+	exec, err := fn(ctx)
+	span.SetAttributes(tracing.KeyAttempts.Int(exec.Attempts))
+	tracing.End(span, err)
+	return exec, err
+}
@@ -0,0 +1,89 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hooks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/stash/pkg/util"
+)
+
+func TestRetry(t *testing.T) {
+	testCases := []struct {
+		description      string
+		maxRetries       int
+		failures         int
+		expectedAttempts int
+		expectErr        bool
+	}{
+		{description: "Succeeds at first attempt", maxRetries: 2, failures: 0, expectedAttempts: 1},
+		{description: "Succeeds after retries", maxRetries: 2, failures: 2, expectedAttempts: 3},
+		{description: "Retries exhausted", maxRetries: 2, failures: 5, expectedAttempts: 3, expectErr: true},
+		{description: "No retry", maxRetries: 0, failures: 1, expectedAttempts: 1, expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			settings := util.HookSettings{MaxRetries: tc.maxRetries, Backoff: time.Millisecond}
+			exec, err := retry(apis.PreBackupHook, settings, func(attempt int) error {
+				if attempt <= tc.failures {
+					return errors.New("hook failed")
+				}
+				return nil
+			})
+			if (err != nil) != tc.expectErr {
+				t.Errorf("expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if exec.Attempts != tc.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", tc.expectedAttempts, exec.Attempts)
+			}
+		})
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	err := withTimeout(10*time.Millisecond, func() error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	if !errors.Is(err, errTimeout) {
+		t.Errorf("expected the hook to time out, got: %v", err)
+	}
+
+	hookErr := errors.New("hook failed")
+	if err := withTimeout(time.Second, func() error { return hookErr }); err != hookErr {
+		t.Errorf("expected the error of the hook, got: %v", err)
+	}
+}
+
+func TestRetryAfterTimeout(t *testing.T) {
+	settings := util.HookSettings{Timeout: 10 * time.Millisecond, MaxRetries: 2, Backoff: time.Millisecond}
+	exec, err := retry(apis.PreBackupHook, settings, func(_ int) error {
+		return withTimeout(settings.Timeout, func() error {
+			time.Sleep(time.Second)
+			return nil
+		})
+	})
+	if !errors.Is(err, errTimeout) {
+		t.Errorf("expected the hook to time out, got: %v", err)
+	}
+	if exec.Attempts != 1 {
+		t.Errorf("expected a timed out hook not to be retried, got %d attempts", exec.Attempts)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notification
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+)
+
+// Event describes a phase transition of a BackupSession or a restore invoker. It is the data of the notification templates.
+type Event struct {
+	Kind        string         `json:"kind"`
+	Name        string         `json:"name"`
+	Namespace   string         `json:"namespace"`
+	InvokerKind string         `json:"invokerKind"`
+	InvokerName string         `json:"invokerName"`
+	Phase       string         `json:"phase"`
+	Duration    string         `json:"duration,omitempty"`
+	Targets     []TargetStatus `json:"targets,omitempty"`
+	Time        time.Time      `json:"time"`
+}
+
+// TargetStatus is the status of a target of the session. Error holds the errors of the failed hosts.
+type TargetStatus struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Phase     string `json:"phase,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NewBackupEvent returns the event of the current phase of a BackupSession.
+func NewBackupEvent(bs *api_v1beta1.BackupSession, now time.Time) Event {
+	e := Event{
+		Kind:        api_v1beta1.ResourceKindBackupSession,
+		Name:        bs.Name,
+		Namespace:   bs.Namespace,
+		InvokerKind: bs.Spec.Invoker.Kind,
+		InvokerName: bs.Spec.Invoker.Name,
+		Phase:       string(bs.Status.Phase),
+		Duration:    bs.Status.SessionDuration,
+		Time:        now,
+	}
+	for _, target := range bs.Status.Targets {
+		var errs []string
+		for _, host := range target.Stats {
+			if host.Error != "" {
+				errs = append(errs, fmt.Sprintf("%s: %s", host.Hostname, host.Error))
+			}
+		}
+		e.Targets = append(e.Targets, TargetStatus{
+			Kind:      target.Ref.Kind,
+			Name:      target.Ref.Name,
+			Namespace: target.Ref.Namespace,
+			Phase:     string(target.Phase),
+			Error:     strings.Join(errs, "; "),
+		})
+	}
+	return e
+}
+
+// NewRestoreEvent returns the event of the current phase of a restore invoker.
+func NewRestoreEvent(kind, name, namespace string, phase api_v1beta1.RestorePhase, duration string, members []api_v1beta1.RestoreMemberStatus, now time.Time) Event {
+	e := Event{
+		Kind:        kind,
+		Name:        name,
+		Namespace:   namespace,
+		InvokerKind: kind,
+		InvokerName: name,
+		Phase:       string(phase),
+		Duration:    duration,
+		Time:        now,
+	}
+	for _, member := range members {
+		var errs []string
+		for _, host := range member.Stats {
+			if host.Error != "" {
+				errs = append(errs, fmt.Sprintf("%s: %s", host.Hostname, host.Error))
+			}
+		}
+		e.Targets = append(e.Targets, TargetStatus{
+			Kind:      member.Ref.Kind,
+			Name:      member.Ref.Name,
+			Namespace: member.Ref.Namespace,
+			Phase:     string(member.Phase),
+			Error:     strings.Join(errs, "; "),
+		})
+	}
+	return e
+}
+
+// Subject returns a one line summary of the event.
+func (e Event) Subject() string {
+	return fmt.Sprintf("[Stash] %s %s/%s %s", e.Kind, e.Namespace, e.Name, e.Phase)
+}
+
+// Message returns a human readable description of the event. It is used when the notifier does not specify a template.
+func (e Event) Message() string {
+	var sb strings.Builder
+	if e.Kind == e.InvokerKind && e.Name == e.InvokerName {
+		fmt.Fprintf(&sb, "%s %s/%s has %s.", e.Kind, e.Namespace, e.Name, phaseVerb(e.Phase))
+	} else {
+		fmt.Fprintf(&sb, "%s %s/%s of %s %s has %s.", e.Kind, e.Namespace, e.Name, e.InvokerKind, e.InvokerName, phaseVerb(e.Phase))
+	}
+	if e.Duration != "" {
+		fmt.Fprintf(&sb, " Duration: %s.", e.Duration)
+	}
+	for _, t := range e.Targets {
+		fmt.Fprintf(&sb, "\n- %s %s: %s", t.Kind, t.Name, t.Phase)
+		if t.Error != "" {
+			fmt.Fprintf(&sb, " (%s)", t.Error)
+		}
+	}
+	return sb.String()
+}
+
+func phaseVerb(phase string) string {
+	switch phase {
+	case "Pending", "Running":
+		return "become " + phase
+	case "Skipped":
+		return "been skipped"
+	case "Unknown":
+		return "completed with unknown status"
+	default:
+		return strings.ToLower(phase)
+	}
+}
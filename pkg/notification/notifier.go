@@ -0,0 +1,263 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notification
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	core_listers "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// LabelNotifier marks a Secret as a notifier. A notifier with the value "true" notifies about the invokers of its own namespace.
+	// A notifier with the value "cluster" notifies about the invokers of every namespace. It is honored only in the operator namespace.
+	LabelNotifier = api_v1beta1.StashKey + "/notifier"
+
+	ScopeNamespace = "true"
+	ScopeCluster   = "cluster"
+
+	TypeWebhook = "webhook"
+	TypeSlack   = "slack"
+	TypeSMTP    = "smtp"
+
+	// keys of the notifier Secret
+	keyType            = "type"
+	keyInvokerSelector = "invokerSelector"
+	keyPhases          = "phases"
+	keyMaxRetries      = "maxRetries"
+	keyURL             = "url"
+	keyBody            = "body"
+	keyContentType     = "contentType"
+	keyHost            = "host"
+	keyPort            = "port"
+	keyUsername        = "username"
+	keyPassword        = "password"
+	keyFrom            = "from"
+	keyTo              = "to"
+	keySubject         = "subject"
+
+	DefaultMaxRetries = 5
+	// MaxRetriesLimit bounds the retries of a notifier, so that a failing notifier gives up in a few hours.
+	MaxRetriesLimit = 20
+)
+
+// allowedHosts are the hosts the namespace scoped notifiers may deliver to. No host is allowed if it is empty.
+var allowedHosts []string
+
+// SetAllowedHosts sets the hosts the namespace scoped notifiers may deliver to. An entry starting with a dot
+// allows the subdomains of the domain, i.e. ".example.com" allows "hooks.example.com".
+// The notifications are sent from the operator, so the list keeps the tenants from reaching the internal network of the operator.
+// The namespace scoped notifiers are disabled if the list is empty.
+func SetAllowedHosts(hosts []string) {
+	allowedHosts = hosts
+}
+
+// defaultPhases are the phases notified when the notifier does not specify any.
+var defaultPhases = []string{
+	string(api_v1beta1.BackupSessionSucceeded),
+	string(api_v1beta1.BackupSessionFailed),
+	string(api_v1beta1.BackupSessionSkipped),
+	string(api_v1beta1.RestoreSucceeded),
+	string(api_v1beta1.RestoreFailed),
+	string(api_v1beta1.RestorePhaseUnknown),
+}
+
+// Notifier delivers the notifications of the selected invokers. It is read from a Secret labeled with LabelNotifier.
+type Notifier struct {
+	Namespace string
+	Name      string
+	Type      string
+	// Cluster is true for the cluster scoped notifiers. They are written by the cluster admin, so any host is allowed.
+	Cluster bool
+	// Selector selects the invokers by their labels
+	Selector   labels.Selector
+	Phases     []string
+	MaxRetries int
+	Data       map[string]string
+}
+
+// Key identifies the notifier in the notification state.
+func (n *Notifier) Key() string {
+	return n.Namespace + "/" + n.Name
+}
+
+// Subscribed returns true if the notifier should be notified about the given phase of the invoker.
+func (n *Notifier) Subscribed(invokerLabels map[string]string, phase string) bool {
+	if !n.Selector.Matches(labels.Set(invokerLabels)) {
+		return false
+	}
+	for _, p := range n.Phases {
+		if strings.EqualFold(p, phase) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNotifier parses a notifier Secret.
+func NewNotifier(secret *core.Secret) (*Notifier, error) {
+	data := make(map[string]string, len(secret.Data)+len(secret.StringData))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	for k, v := range secret.StringData {
+		data[k] = v
+	}
+
+	n := &Notifier{
+		Namespace:  secret.Namespace,
+		Name:       secret.Name,
+		Type:       data[keyType],
+		Cluster:    secret.Labels[LabelNotifier] == ScopeCluster,
+		Selector:   labels.Everything(),
+		Phases:     defaultPhases,
+		MaxRetries: DefaultMaxRetries,
+		Data:       data,
+	}
+	switch n.Type {
+	case TypeWebhook, TypeSlack:
+		if data[keyURL] == "" {
+			return nil, fmt.Errorf("notifier %s must have %q", n.Key(), keyURL)
+		}
+	case TypeSMTP:
+		for _, key := range []string{keyHost, keyFrom, keyTo} {
+			if data[key] == "" {
+				return nil, fmt.Errorf("notifier %s must have %q", n.Key(), key)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("notifier %s has unknown type %q. Supported types are %q, %q and %q", n.Key(), n.Type, TypeWebhook, TypeSlack, TypeSMTP)
+	}
+
+	if val := data[keyInvokerSelector]; val != "" {
+		selector, err := labels.Parse(val)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s has invalid %q. Reason: %v", n.Key(), keyInvokerSelector, err)
+		}
+		n.Selector = selector
+	}
+	if val := data[keyPhases]; val != "" {
+		n.Phases = splitList(val)
+	}
+	if val := data[keyMaxRetries]; val != "" {
+		retries, err := strconv.Atoi(val)
+		if err != nil || retries < 0 || retries > MaxRetriesLimit {
+			return nil, fmt.Errorf("notifier %s must have an integer %q between 0 and %d", n.Key(), keyMaxRetries, MaxRetriesLimit)
+		}
+		n.MaxRetries = retries
+	}
+	if !n.Cluster {
+		if err := n.checkDestination(); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// checkDestination verifies that the notifier delivers to one of the allowed hosts.
+func (n *Notifier) checkDestination() error {
+	var host string
+	switch n.Type {
+	case TypeWebhook, TypeSlack:
+		u, err := url.Parse(n.Data[keyURL])
+		if err != nil {
+			return fmt.Errorf("notifier %s has invalid %q. Reason: %v", n.Key(), keyURL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("notifier %s must have a http or https %q", n.Key(), keyURL)
+		}
+		host = u.Hostname()
+	case TypeSMTP:
+		host = n.Data[keyHost]
+	}
+	if len(allowedHosts) == 0 {
+		return fmt.Errorf("notifier %s is not allowed as the operator does not allow any host for the namespace scoped notifiers", n.Key())
+	}
+	if !hostAllowed(host, allowedHosts) {
+		return fmt.Errorf("notifier %s delivers to host %q which is not allowed by the operator", n.Key(), host)
+	}
+	return nil
+}
+
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if host == entry || (strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListNotifiers returns the notifiers of the given namespace and the cluster scoped notifiers of the operator namespace.
+// The notifiers are read from the Secret lister and sorted by their key. A notifier that can not be parsed is reported
+// in the returned error, the rest of the notifiers are returned anyway.
+func ListNotifiers(lister core_listers.SecretLister, namespace, operatorNamespace string) ([]*Notifier, error) {
+	var notifiers []*Notifier
+	var errs []string
+
+	list := func(ns string, scope string) error {
+		secrets, err := lister.Secrets(ns).List(labels.SelectorFromSet(labels.Set{LabelNotifier: scope}))
+		if err != nil {
+			return err
+		}
+		for _, secret := range secrets {
+			n, err := NewNotifier(secret)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			notifiers = append(notifiers, n)
+		}
+		return nil
+	}
+
+	if err := list(namespace, ScopeNamespace); err != nil {
+		return nil, err
+	}
+	if operatorNamespace != "" {
+		if err := list(operatorNamespace, ScopeCluster); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(notifiers, func(i, j int) bool {
+		return notifiers[i].Key() < notifiers[j].Key()
+	})
+	if len(errs) != 0 {
+		return notifiers, fmt.Errorf("invalid notifiers: %s", strings.Join(errs, "; "))
+	}
+	return notifiers, nil
+}
+
+func splitList(val string) []string {
+	var out []string
+	for _, s := range strings.Split(val, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notification
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewNotifierAllowedHosts(t *testing.T) {
+	SetAllowedHosts([]string{"chat.example.com", ".example.org"})
+	defer SetAllowedHosts(nil)
+
+	cases := []struct {
+		name    string
+		scope   string
+		data    map[string]string
+		wantErr bool
+	}{
+		{name: "allowed host", scope: ScopeNamespace, data: map[string]string{keyType: TypeWebhook, keyURL: "https://chat.example.com/hook"}},
+		{name: "allowed subdomain", scope: ScopeNamespace, data: map[string]string{keyType: TypeSlack, keyURL: "https://hooks.example.org/x"}},
+		{name: "allowed smtp host", scope: ScopeNamespace, data: map[string]string{keyType: TypeSMTP, keyHost: "mail.example.org", keyFrom: "a@example.org", keyTo: "b@example.org"}},
+		{name: "internal host", scope: ScopeNamespace, data: map[string]string{keyType: TypeWebhook, keyURL: "http://10.0.0.1/"}, wantErr: true},
+		{name: "suffix without dot", scope: ScopeNamespace, data: map[string]string{keyType: TypeWebhook, keyURL: "https://evilexample.org/"}, wantErr: true},
+		{name: "unsupported scheme", scope: ScopeNamespace, data: map[string]string{keyType: TypeWebhook, keyURL: "file:///etc/passwd"}, wantErr: true},
+		{name: "cluster notifier", scope: ScopeCluster, data: map[string]string{keyType: TypeWebhook, keyURL: "http://alertmanager.monitoring.svc"}},
+		{name: "too many retries", scope: ScopeNamespace, data: map[string]string{keyType: TypeWebhook, keyURL: "https://chat.example.com", keyMaxRetries: "1000"}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			secret := &core.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "demo",
+					Name:      "notifier",
+					Labels:    map[string]string{LabelNotifier: c.scope},
+				},
+				StringData: c.data,
+			}
+			if _, err := NewNotifier(secret); (err != nil) != c.wantErr {
+				t.Errorf("expected error: %v, found %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewNotifierWithoutAllowedHosts(t *testing.T) {
+	SetAllowedHosts(nil)
+	for _, scope := range []string{ScopeNamespace, ScopeCluster} {
+		secret := &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "demo",
+				Name:      "notifier",
+				Labels:    map[string]string{LabelNotifier: scope},
+			},
+			StringData: map[string]string{keyType: TypeWebhook, keyURL: "https://chat.example.com/hook"},
+		}
+		_, err := NewNotifier(secret)
+		if wantErr := scope == ScopeNamespace; (err != nil) != wantErr {
+			t.Errorf("scope %q: expected error: %v, found %v", scope, wantErr, err)
+		}
+	}
+}
+
+func TestRenderIsHermetic(t *testing.T) {
+	if out, err := render(`{{ .Phase | lower }}`, Event{Phase: "Succeeded"}); err != nil || out != "succeeded" {
+		t.Errorf("expected %q, found %q, error: %v", "succeeded", out, err)
+	}
+	for _, text := range []string{
+		`{{ env "HOME" }}`,
+		`{{ expandenv "$HOME" }}`,
+		`{{ repeat 1000000000 "x" }}`,
+		`{{ range until 1000000000 }}{{ end }}`,
+		`{{ range untilStep 0 1000000000 1 }}{{ end }}`,
+		`{{ seq 1000000000 }}`,
+		`{{ genPrivateKey "rsa" }}`,
+		`{{ printf "%1000000s" "" }}`,
+	} {
+		if _, err := render(text, Event{}); err == nil {
+			t.Errorf("expected %s to be rejected", text)
+		}
+	}
+}
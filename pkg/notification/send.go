@@ -0,0 +1,315 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notification
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	sprig "github.com/Masterminds/sprig/v3"
+)
+
+const (
+	defaultSMTPPort = "587"
+	requestTimeout  = 30 * time.Second
+	maxRedirects    = 10
+)
+
+var (
+	// clusterClient delivers the notifications of the cluster scoped notifiers. They are written by the cluster admin.
+	clusterClient = &http.Client{Timeout: requestTimeout}
+	// namespaceClient delivers the notifications of the namespace scoped notifiers. They are written by the tenants,
+	// so it connects only to the public addresses, whatever the allowed hosts resolve to.
+	namespaceClient = &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			DialContext:         publicDialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: checkRedirect,
+	}
+	publicDialer  = &net.Dialer{Timeout: requestTimeout, Control: dialPublicOnly}
+	clusterDialer = &net.Dialer{Timeout: requestTimeout}
+
+	// sharedAddressSpace is used by the carrier-grade NATs and the pod networks of some clusters (RFC 6598).
+	sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+)
+
+// dialPublicOnly refuses to connect to the loopback, link-local, private and other non-public addresses.
+// It is checked after the DNS resolution, so a public host name resolving to an internal address is refused too.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("address %s is not allowed for the namespace scoped notifiers", host)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// checkRedirect follows a redirect of a namespace scoped notifier only to the allowed hosts.
+// Otherwise, an allowed host could redirect the notification to any host reachable from the operator.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if (req.URL.Scheme != "http" && req.URL.Scheme != "https") || !hostAllowed(req.URL.Hostname(), allowedHosts) {
+		return fmt.Errorf("redirect to host %q is not allowed", req.URL.Hostname())
+	}
+	return nil
+}
+
+// httpClient returns the client delivering the notifications of the notifier.
+func (n *Notifier) httpClient() *http.Client {
+	if n.Cluster {
+		return clusterClient
+	}
+	return namespaceClient
+}
+
+// dialer returns the dialer connecting to the SMTP server of the notifier.
+func (n *Notifier) dialer() *net.Dialer {
+	if n.Cluster {
+		return clusterDialer
+	}
+	return publicDialer
+}
+
+// Send delivers the event through the notifier.
+func (n *Notifier) Send(e Event) error {
+	switch n.Type {
+	case TypeWebhook:
+		return n.sendWebhook(e)
+	case TypeSlack:
+		return n.sendSlack(e)
+	case TypeSMTP:
+		return n.sendEmail(e)
+	}
+	return fmt.Errorf("notifier %s has unknown type %q", n.Key(), n.Type)
+}
+
+// sendWebhook posts the rendered body to the URL. Without a body template, the event is posted as JSON.
+func (n *Notifier) sendWebhook(e Event) error {
+	var body []byte
+	if tpl := n.Data[keyBody]; tpl != "" {
+		rendered, err := render(tpl, e)
+		if err != nil {
+			return err
+		}
+		body = []byte(rendered)
+	} else {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		body = data
+	}
+	contentType := n.Data[keyContentType]
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return post(n.httpClient(), n.Data[keyURL], contentType, body)
+}
+
+// sendSlack posts a message to a Slack compatible incoming webhook.
+func (n *Notifier) sendSlack(e Event) error {
+	text := e.Message()
+	if tpl := n.Data[keyBody]; tpl != "" {
+		rendered, err := render(tpl, e)
+		if err != nil {
+			return err
+		}
+		text = rendered
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return post(n.httpClient(), n.Data[keyURL], "application/json", body)
+}
+
+// sendEmail sends a plain text email. The connection is upgraded with STARTTLS when the server supports it.
+func (n *Notifier) sendEmail(e Event) error {
+	subject := e.Subject()
+	if tpl := n.Data[keySubject]; tpl != "" {
+		rendered, err := render(tpl, e)
+		if err != nil {
+			return err
+		}
+		subject = rendered
+	}
+	text := e.Message()
+	if tpl := n.Data[keyBody]; tpl != "" {
+		rendered, err := render(tpl, e)
+		if err != nil {
+			return err
+		}
+		text = rendered
+	}
+
+	port := n.Data[keyPort]
+	if port == "" {
+		port = defaultSMTPPort
+	}
+	var auth smtp.Auth
+	if username := n.Data[keyUsername]; username != "" {
+		auth = smtp.PlainAuth("", username, n.Data[keyPassword], n.Data[keyHost])
+	}
+	to := splitList(n.Data[keyTo])
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.Data[keyFrom])
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.ReplaceAll(subject, "\n", " "))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+
+	return sendMail(n.dialer(), net.JoinHostPort(n.Data[keyHost], port), n.Data[keyHost], auth, n.Data[keyFrom], to, msg.Bytes())
+}
+
+// sendMail does the same as smtp.SendMail but the whole conversation with the server is bounded by the request timeout.
+func sendMail(dialer *net.Dialer, addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(requestTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func post(client *http.Client, url, contentType string, body []byte) error {
+	resp, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected response %q: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// maxRenderedSize limits the size of a rendered template.
+const maxRenderedSize = 64 * 1024
+
+// templateFuncs are the functions available to the templates of the notifiers. The templates are written by the users,
+// but they are rendered inside the operator. So, only the hermetic functions are available. They can not read the
+// environment of the operator. The functions that let a single template exhaust the memory or the CPU of the operator
+// with a few characters are removed as well.
+var templateFuncs = func() template.FuncMap {
+	funcs := sprig.HermeticTxtFuncMap()
+	for _, name := range []string{
+		"repeat", "until", "untilStep", "seq",
+		"bcrypt", "htpasswd", "derivePassword", "genPrivateKey", "buildCustomCert",
+		"genCA", "genCAWithKey", "genSelfSignedCert", "genSelfSignedCertWithKey", "genSignedCert", "genSignedCertWithKey",
+	} {
+		delete(funcs, name)
+	}
+	return funcs
+}()
+
+// limitedBuffer fails the rendering once the output exceeds the limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("rendered template exceeds %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// render renders the template of a notifier with templateFuncs. The output is limited to maxRenderedSize.
+func render(text string, e Event) (string, error) {
+	tpl, err := template.New("notification").
+		Funcs(templateFuncs).
+		Option("missingkey=default").
+		Parse(text)
+	if err != nil {
+		return "", err
+	}
+	buf := &limitedBuffer{limit: maxRenderedSize}
+	if err := tpl.Execute(buf, e); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
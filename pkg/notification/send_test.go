@@ -0,0 +1,112 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notification
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendToInternalAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cases := []struct {
+		name    string
+		cluster bool
+		wantErr bool
+	}{
+		{name: "namespace notifier", cluster: false, wantErr: true},
+		{name: "cluster notifier", cluster: true, wantErr: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := &Notifier{
+				Namespace: "demo",
+				Name:      "notifier",
+				Type:      TypeWebhook,
+				Cluster:   c.cluster,
+				Data:      map[string]string{keyURL: srv.URL},
+			}
+			err := n.Send(Event{Phase: "Succeeded"})
+			if (err != nil) != c.wantErr {
+				t.Errorf("expected error: %v, found %v", c.wantErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "is not allowed") {
+				t.Errorf("expected the address to be refused, found %v", err)
+			}
+		})
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	cases := []struct {
+		ip     string
+		public bool
+	}{
+		{ip: "93.184.216.34", public: true},
+		{ip: "2606:2800:220:1::", public: true},
+		{ip: "127.0.0.1", public: false},
+		{ip: "::1", public: false},
+		{ip: "10.96.0.1", public: false},
+		{ip: "172.16.0.10", public: false},
+		{ip: "192.168.1.1", public: false},
+		{ip: "169.254.169.254", public: false},
+		{ip: "fe80::1", public: false},
+		{ip: "fd00::1", public: false},
+		{ip: "100.64.0.1", public: false},
+		{ip: "0.0.0.0", public: false},
+	}
+	for _, c := range cases {
+		if public := isPublicIP(net.ParseIP(c.ip)); public != c.public {
+			t.Errorf("%s: expected public %v, found %v", c.ip, c.public, public)
+		}
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	SetAllowedHosts([]string{"chat.example.com", ".example.org"})
+	defer SetAllowedHosts(nil)
+
+	cases := []struct {
+		name    string
+		url     string
+		via     int
+		wantErr bool
+	}{
+		{name: "allowed host", url: "https://chat.example.com/hook", via: 1},
+		{name: "allowed subdomain", url: "https://hooks.example.org/hook", via: 1},
+		{name: "internal host", url: "http://10.0.0.1/", via: 1, wantErr: true},
+		{name: "metadata service", url: "http://169.254.169.254/latest/meta-data", via: 1, wantErr: true},
+		{name: "unsupported scheme", url: "ftp://chat.example.com/", via: 1, wantErr: true},
+		{name: "too many redirects", url: "https://chat.example.com/hook", via: maxRedirects, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, c.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := checkRedirect(req, make([]*http.Request, c.via)); (err != nil) != c.wantErr {
+				t.Errorf("expected error: %v, found %v", c.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notification
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	initialRetryInterval = 30 * time.Second
+	maxRetryInterval     = 10 * time.Minute
+)
+
+// State is kept in the annotations of the session. It makes sure that every phase is notified only once
+// and that the failed deliveries are retried with an exponential backoff.
+type State struct {
+	// Phase is the last phase of the session that has been dispatched to the notifiers.
+	Phase      string     `json:"phase,omitempty"`
+	Deliveries []Delivery `json:"deliveries,omitempty"`
+}
+
+// Delivery records the delivery of the current phase to a notifier.
+type Delivery struct {
+	Notifier    string      `json:"notifier"`
+	Attempts    int         `json:"attempts,omitempty"`
+	LastAttempt metav1.Time `json:"lastAttempt,omitempty"`
+	Delivered   bool        `json:"delivered,omitempty"`
+	// GaveUp is set when the retries have been exhausted or the notifier has been removed.
+	GaveUp bool   `json:"gaveUp,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StateFromAnnotations reads the notification state from the annotation.
+// An empty state is returned if the annotation is missing or can not be parsed.
+func StateFromAnnotations(annotations map[string]string, key string) State {
+	var state State
+	if val, ok := annotations[key]; ok {
+		_ = json.Unmarshal([]byte(val), &state)
+	}
+	return state
+}
+
+// UpsertState writes the notification state into the annotation.
+func UpsertState(annotations map[string]string, key string, state State) map[string]string {
+	// State consists of plain fields only, so marshaling it can not fail.
+	data, _ := json.Marshal(state)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = string(data)
+	return annotations
+}
+
+// HasPendingWork returns true if the phase has not been dispatched yet or a delivery is waiting for a retry.
+func (s State) HasPendingWork(phase string) bool {
+	if phase == "" {
+		return false
+	}
+	if s.Phase != phase {
+		return true
+	}
+	for _, d := range s.Deliveries {
+		if !d.Delivered && !d.GaveUp {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatch sends the event to the notifiers subscribed to it and returns the updated state. A new phase
+// starts a new round of deliveries, otherwise only the failed deliveries whose backoff has passed are retried.
+// The returned duration is the time after which the next retry is due. It is zero if nothing needs to be retried.
+func Dispatch(state State, e Event, invokerLabels map[string]string, notifiers []*Notifier, send func(*Notifier, Event) error, now time.Time) (State, time.Duration) {
+	if state.Phase != e.Phase {
+		state = State{Phase: e.Phase}
+		for _, n := range notifiers {
+			if n.Subscribed(invokerLabels, e.Phase) {
+				state.Deliveries = append(state.Deliveries, Delivery{Notifier: n.Key()})
+			}
+		}
+	}
+
+	var retryAfter time.Duration
+	due := func(d time.Duration) {
+		if retryAfter == 0 || d < retryAfter {
+			retryAfter = d
+		}
+	}
+	var pending []int
+	var targets []*Notifier
+	for i := range state.Deliveries {
+		d := &state.Deliveries[i]
+		if d.Delivered || d.GaveUp {
+			continue
+		}
+		n := findNotifier(notifiers, d.Notifier)
+		if n == nil {
+			d.GaveUp = true
+			continue
+		}
+		if d.Attempts > 0 {
+			if wait := retryInterval(d.Attempts) - now.Sub(d.LastAttempt.Time); wait > 0 {
+				due(wait)
+				continue
+			}
+		}
+		pending = append(pending, i)
+		targets = append(targets, n)
+	}
+
+	// the notifiers are independent of each other, so a slow notifier must not delay the rest
+	errs := make([]error, len(pending))
+	var wg sync.WaitGroup
+	for j := range pending {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			errs[j] = send(targets[j], e)
+		}(j)
+	}
+	wg.Wait()
+
+	for j, i := range pending {
+		d := &state.Deliveries[i]
+		d.Attempts++
+		d.LastAttempt = metav1.NewTime(now)
+		if errs[j] == nil {
+			d.Delivered = true
+			d.Error = ""
+			continue
+		}
+		d.Error = errs[j].Error()
+		if d.Attempts > targets[j].MaxRetries {
+			d.GaveUp = true
+			continue
+		}
+		due(retryInterval(d.Attempts))
+	}
+	return state, retryAfter
+}
+
+// retryInterval returns the backoff after the given number of failed attempts.
+func retryInterval(attempts int) time.Duration {
+	interval := initialRetryInterval
+	for i := 1; i < attempts && interval < maxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxRetryInterval {
+		interval = maxRetryInterval
+	}
+	return interval
+}
+
+func findNotifier(notifiers []*Notifier, key string) *Notifier {
+	for _, n := range notifiers {
+		if n.Key() == key {
+			return n
+		}
+	}
+	return nil
+}
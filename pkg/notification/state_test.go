@@ -0,0 +1,133 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notification
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestDispatch(t *testing.T) {
+	selector, err := labels.Parse("team=db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := &Notifier{Namespace: "demo", Name: "chat", Selector: labels.Everything(), Phases: defaultPhases, MaxRetries: 1}
+	mail := &Notifier{Namespace: "demo", Name: "mail", Selector: selector, Phases: defaultPhases, MaxRetries: 1}
+	notifiers := []*Notifier{chat, mail}
+	invokerLabels := map[string]string{"app": "demo"}
+
+	sent := map[string]int{}
+	failing := map[string]bool{}
+	send := func(n *Notifier, e Event) error {
+		sent[n.Key()]++
+		if failing[n.Key()] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	now := time.Now()
+	event := Event{Phase: "Succeeded"}
+
+	// only the notifiers selecting the invoker are notified
+	state, retryAfter := Dispatch(State{}, event, invokerLabels, notifiers, send, now)
+	if len(state.Deliveries) != 1 || !state.Deliveries[0].Delivered || retryAfter != 0 {
+		t.Fatalf("unexpected state after the first dispatch: %+v, retry after %s", state, retryAfter)
+	}
+	if state.HasPendingWork(event.Phase) {
+		t.Errorf("expected no pending work after a successful delivery")
+	}
+
+	// the same phase is not notified twice
+	state, _ = Dispatch(state, event, invokerLabels, notifiers, send, now)
+	if sent[chat.Key()] != 1 {
+		t.Errorf("expected a single delivery to %s, got %d", chat.Key(), sent[chat.Key()])
+	}
+
+	// a failed delivery is retried after the backoff and given up once the retries are exhausted
+	failing[chat.Key()] = true
+	event.Phase = "Failed"
+	state, retryAfter = Dispatch(state, event, invokerLabels, notifiers, send, now)
+	if retryAfter != initialRetryInterval || !state.HasPendingWork(event.Phase) {
+		t.Fatalf("expected a retry after %s, got %s", initialRetryInterval, retryAfter)
+	}
+	state, _ = Dispatch(state, event, invokerLabels, notifiers, send, now.Add(time.Second))
+	if sent[chat.Key()] != 2 {
+		t.Errorf("expected no delivery before the backoff has passed, got %d deliveries", sent[chat.Key()])
+	}
+	state, retryAfter = Dispatch(state, event, invokerLabels, notifiers, send, now.Add(initialRetryInterval))
+	if sent[chat.Key()] != 3 || !state.Deliveries[0].GaveUp || retryAfter != 0 {
+		t.Errorf("expected the delivery to be given up, got %+v", state.Deliveries[0])
+	}
+	if state.HasPendingWork(event.Phase) {
+		t.Errorf("expected no pending work after giving up")
+	}
+}
+
+func TestRetryInterval(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: initialRetryInterval},
+		{attempts: 2, expected: 2 * initialRetryInterval},
+		{attempts: 3, expected: 4 * initialRetryInterval},
+		{attempts: 20, expected: maxRetryInterval},
+	}
+	for _, tc := range testCases {
+		if got := retryInterval(tc.attempts); got != tc.expected {
+			t.Errorf("retryInterval(%d) = %s, expected %s", tc.attempts, got, tc.expected)
+		}
+	}
+}
+
+func TestDispatchConcurrently(t *testing.T) {
+	var notifiers []*Notifier
+	for _, name := range []string{"chat", "hook", "mail"} {
+		notifiers = append(notifiers, &Notifier{Namespace: "demo", Name: name, Selector: labels.Everything(), Phases: defaultPhases})
+	}
+
+	// every delivery waits until all of them have started, so the dispatch would block if they were sent one by one
+	var started sync.WaitGroup
+	started.Add(len(notifiers))
+	send := func(n *Notifier, e Event) error {
+		started.Done()
+		started.Wait()
+		return nil
+	}
+
+	done := make(chan State)
+	go func() {
+		state, _ := Dispatch(State{}, Event{Phase: "Succeeded"}, nil, notifiers, send, time.Now())
+		done <- state
+	}()
+	select {
+	case state := <-done:
+		for _, d := range state.Deliveries {
+			if !d.Delivered {
+				t.Errorf("expected the notification to be delivered to %s", d.Notifier)
+			}
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the notifications have not been sent concurrently")
+	}
+}
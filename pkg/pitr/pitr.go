@@ -0,0 +1,180 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pitr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/util"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Selection is the snapshots selected for a host of a restore target.
+type Selection struct {
+	Target      string   `json:"target,omitempty"`
+	Hostname    string   `json:"hostname"`
+	RestoreTime string   `json:"restoreTime"`
+	Snapshots   []string `json:"snapshots"`
+}
+
+// Select returns the newest snapshot of each path taken by the host at or before the point in time.
+// Any host matches if host is empty.
+func Select(snapshots []restic.Snapshot, host string, paths []string, pit util.PointInTime) ([]string, error) {
+	var ids []string
+	for _, path := range paths {
+		var selected *restic.Snapshot
+		for i := range snapshots {
+			s := &snapshots[i]
+			if host != "" && s.Hostname != host {
+				continue
+			}
+			if s.Time.After(pit.Time) || !contains(s.Paths, path) || !hasTags(s.Tags, pit.Tags) {
+				continue
+			}
+			if selected == nil || s.Time.After(selected.Time) {
+				selected = s
+			}
+		}
+		if selected == nil {
+			return nil, fmt.Errorf("no snapshot of path %q of host %q has been taken at or before %s", path, host, pit.Time.Format(time.RFC3339))
+		}
+		id := selected.ID
+		if len(id) > apis.SnapshotIDLength {
+			id = id[:apis.SnapshotIDLength]
+		}
+		if !contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Resolve replaces the restore paths of opt with the snapshots selected for them. It reports whether the
+// snapshots have been selected. They are not if no point in time has been specified or the snapshots have
+// been specified explicitly.
+func Resolve(setupOpt restic.SetupOptions, opt restic.RestoreOptions, pit *util.PointInTime) (restic.RestoreOptions, bool, error) {
+	if pit == nil || len(opt.Snapshots) != 0 || len(opt.RestorePaths) == 0 {
+		return opt, false, nil
+	}
+	w, err := restic.NewResticWrapper(setupOpt)
+	if err != nil {
+		return opt, false, err
+	}
+	snapshots, err := w.ListSnapshots(nil)
+	if err != nil {
+		return opt, false, err
+	}
+	ids, err := Select(snapshots, opt.SourceHost, opt.RestorePaths, *pit)
+	if err != nil {
+		return opt, false, err
+	}
+	opt.Snapshots = ids
+	return opt, true, nil
+}
+
+// Record stores the snapshots selected for the host in the annotations of the restore invoker and writes an event.
+func Record(stashClient cs.Interface, inv invoker.RestoreInvoker, targetRef api_v1beta1.TargetRef, eventSource string, pit util.PointInTime, opt restic.RestoreOptions) error {
+	sel := Selection{
+		Target:      progress.TargetKey(targetRef),
+		Hostname:    opt.Host,
+		RestoreTime: pit.Time.Format(time.RFC3339),
+		Snapshots:   opt.Snapshots,
+	}
+	update := func(annotations map[string]string) map[string]string {
+		annotations, err := Upsert(annotations, sel)
+		if err != nil {
+			klog.Errorln(err)
+		}
+		return annotations
+	}
+	if err := util.UpdateRestoreInvokerAnnotations(stashClient, inv, update); err != nil {
+		return err
+	}
+	return inv.CreateEvent(
+		core.EventTypeNormal,
+		eventSource,
+		eventer.EventReasonSnapshotsSelected,
+		fmt.Sprintf("Selected snapshot(s) %s taken at or before %s for host %q", strings.Join(sel.Snapshots, ","), sel.RestoreTime, sel.Hostname),
+	)
+}
+
+// FromAnnotations returns the snapshots selected for the hosts of a restore invoker.
+func FromAnnotations(annotations map[string]string) ([]Selection, error) {
+	val, ok := annotations[util.KeySelectedSnapshots]
+	if !ok {
+		return nil, nil
+	}
+	var selections []Selection
+	if err := json.Unmarshal([]byte(val), &selections); err != nil {
+		return nil, err
+	}
+	return selections, nil
+}
+
+// Upsert adds or replaces the selection of the host of a target in the annotations.
+func Upsert(annotations map[string]string, sel Selection) (map[string]string, error) {
+	selections, _ := FromAnnotations(annotations)
+	found := false
+	for i := range selections {
+		if selections[i].Target == sel.Target && selections[i].Hostname == sel.Hostname {
+			selections[i] = sel
+			found = true
+		}
+	}
+	if !found {
+		selections = append(selections, sel)
+	}
+	data, err := json.Marshal(selections)
+	if err != nil {
+		return annotations, err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[util.KeySelectedSnapshots] = string(data)
+	return annotations, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTags(tags, required []string) bool {
+	for _, t := range required {
+		if !contains(tags, t) {
+			return false
+		}
+	}
+	return true
+}
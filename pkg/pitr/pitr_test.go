@@ -0,0 +1,86 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pitr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/util"
+)
+
+func TestSelect(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2021, time.March, 14, hour, 0, 0, 0, time.UTC)
+	}
+	snapshots := []restic.Snapshot{
+		{ID: "aaaaaaaa11", Time: at(10), Hostname: "host-0", Paths: []string{"/data"}},
+		{ID: "bbbbbbbb22", Time: at(12), Hostname: "host-0", Paths: []string{"/data"}, Tags: []string{"verified"}},
+		{ID: "cccccccc33", Time: at(14), Hostname: "host-0", Paths: []string{"/data"}},
+		{ID: "dddddddd44", Time: at(13), Hostname: "host-1", Paths: []string{"/data"}},
+		{ID: "eeeeeeee55", Time: at(11), Hostname: "host-0", Paths: []string{"/logs"}},
+	}
+	cases := []struct {
+		name    string
+		host    string
+		paths   []string
+		pit     util.PointInTime
+		want    []string
+		wantErr bool
+	}{
+		{name: "newest before", host: "host-0", paths: []string{"/data"}, pit: util.PointInTime{Time: at(13)}, want: []string{"bbbbbbbb"}},
+		{name: "at the time", host: "host-0", paths: []string{"/data"}, pit: util.PointInTime{Time: at(14)}, want: []string{"cccccccc"}},
+		{name: "tags", host: "host-0", paths: []string{"/data"}, pit: util.PointInTime{Time: at(15), Tags: []string{"verified"}}, want: []string{"bbbbbbbb"}},
+		{name: "any host", paths: []string{"/data"}, pit: util.PointInTime{Time: at(13)}, want: []string{"dddddddd"}},
+		{name: "multiple paths", host: "host-0", paths: []string{"/data", "/logs"}, pit: util.PointInTime{Time: at(12)}, want: []string{"bbbbbbbb", "eeeeeeee"}},
+		{name: "too early", host: "host-0", paths: []string{"/data"}, pit: util.PointInTime{Time: at(9)}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Select(snapshots, c.host, c.paths, c.pit)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("expected %v, found %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	annotations, err := Upsert(nil, Selection{Target: "StatefulSet/db", Hostname: "host-0", Snapshots: []string{"aaaaaaaa"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations, _ = Upsert(annotations, Selection{Target: "StatefulSet/db", Hostname: "host-1", Snapshots: []string{"bbbbbbbb"}})
+	annotations, _ = Upsert(annotations, Selection{Target: "StatefulSet/db", Hostname: "host-0", Snapshots: []string{"cccccccc"}})
+
+	got, err := FromAnnotations(annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Selection{
+		{Target: "StatefulSet/db", Hostname: "host-0", Snapshots: []string{"cccccccc"}},
+		{Target: "StatefulSet/db", Hostname: "host-1", Snapshots: []string{"bbbbbbbb"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, found %v", want, got)
+	}
+}
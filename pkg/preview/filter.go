@@ -0,0 +1,110 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preview
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Some of the code of this file has been copied from restic/restic repository.
+// ref: https://github.com/restic/restic/blob/v0.13.1/internal/filter/filter.go
+
+// match returns true if the pattern matches the path the same way the "--include" and "--exclude"
+// flags of "restic restore" do. A pattern not starting with a "/" may match anywhere in the path,
+// and a pattern matching a directory matches everything inside it.
+func match(pattern, path string) bool {
+	if pattern == "" {
+		return false
+	}
+	pattern = filepath.Clean(pattern)
+	path = filepath.Clean(path)
+
+	patterns := strings.Split(pattern, "/")
+	if pattern[0] != '/' {
+		// relative patterns may match at any depth
+		patterns = append([]string{"**"}, patterns...)
+	}
+	strs := strings.Split(path, "/")
+	return matchParts(patterns, strs)
+}
+
+func hasDoubleWildcard(patterns []string) (bool, int) {
+	for i, p := range patterns {
+		if p == "**" {
+			return true, i
+		}
+	}
+	return false, 0
+}
+
+func matchParts(patterns, strs []string) bool {
+	if ok, pos := hasDoubleWildcard(patterns); ok {
+		// gradually expand '**' into separate wildcards
+		for i := 0; i <= len(strs)-len(patterns)+1; i++ {
+			newPat := make([]string, 0, len(patterns)+i)
+			newPat = append(newPat, patterns[:pos]...)
+			for k := 0; k < i; k++ {
+				newPat = append(newPat, "*")
+			}
+			newPat = append(newPat, patterns[pos+1:]...)
+			if matchParts(newPat, strs) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(patterns) == 0 && len(strs) == 0 {
+		return true
+	}
+	if len(patterns) <= len(strs) {
+		maxOffset := len(strs) - len(patterns)
+		// absolute patterns are only matched from the root
+		if patterns[0] == "" {
+			maxOffset = 0
+		}
+	outer:
+		for offset := maxOffset; offset >= 0; offset-- {
+			for i := len(patterns) - 1; i >= 0; i-- {
+				if ok, err := filepath.Match(patterns[i], strs[offset+i]); err != nil || !ok {
+					continue outer
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Selected returns true if a file is restored with the given include and exclude patterns.
+func Selected(path string, includes, excludes []string) bool {
+	for _, p := range excludes {
+		if match(p, path) {
+			return false
+		}
+	}
+	if len(includes) == 0 {
+		return true
+	}
+	for _, p := range includes {
+		if match(p, path) {
+			return true
+		}
+	}
+	return false
+}
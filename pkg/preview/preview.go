@@ -0,0 +1,385 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preview
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	stash "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/pitr"
+	"stash.appscode.dev/stash/pkg/util"
+
+	shell "gomodules.xyz/go-sh"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// DefaultSamplePaths is the number of restored paths reported for each host.
+	DefaultSamplePaths = 5
+	// DefaultListTimeout bounds the time taken to list the files of a host, so that an unreachable backend
+	// does not block the reconciliation of the restore invoker.
+	DefaultListTimeout = 5 * time.Minute
+	// maxPathLength and maxErrorLength bound the size of the preview published in the annotations.
+	maxPathLength  = 256
+	maxErrorLength = 512
+)
+
+// ErrLocalBackend is returned for the repositories using a local backend. The volume of the backend
+// is mounted into the workloads only, so the operator can not list the snapshots.
+var ErrLocalBackend = errors.New("restore preview is not supported for the local backend")
+
+type Options struct {
+	Repository *stash.Repository
+	Secret     *core.Secret
+	Rules      []api_v1beta1.Rule
+	// Hosts are the hosts of the restore target. The rules are resolved for each of them.
+	Hosts []string
+	// PointInTime selects the snapshots by time instead of using the latest ones, if specified.
+	PointInTime *util.PointInTime
+	// Timeout bounds the time taken to list the files of each host. DefaultListTimeout is used if it is zero.
+	Timeout time.Duration
+}
+
+// HostPreview summarizes the files that would be restored into a host.
+type HostPreview struct {
+	Hostname   string `json:"hostname"`
+	SourceHost string `json:"sourceHost,omitempty"`
+	// Snapshots are the IDs of the snapshots the files would be restored from.
+	Snapshots []string `json:"snapshots,omitempty"`
+	Files     int64    `json:"files"`
+	TotalSize uint64   `json:"totalSize"`
+	// SamplePaths are the first few files that would be restored.
+	SamplePaths []string `json:"samplePaths,omitempty"`
+	// Error is set when the files of the host could not be listed.
+	Error string `json:"error,omitempty"`
+}
+
+// Result is the preview of the hosts of a restore target.
+type Result struct {
+	Target string        `json:"target,omitempty"`
+	Hosts  []HostPreview `json:"hosts"`
+}
+
+// Summary returns a human readable summary of the preview of the host.
+func (p HostPreview) Summary() string {
+	if p.Error != "" {
+		return fmt.Sprintf("host %s: %s", p.Hostname, p.Error)
+	}
+	if p.Files == 0 {
+		return fmt.Sprintf("host %s: no file will be restored", p.Hostname)
+	}
+	return fmt.Sprintf("host %s: %d files (%s) from snapshot(s) %s of host %s, e.g. %s",
+		p.Hostname,
+		p.Files,
+		resource.NewQuantity(int64(p.TotalSize), resource.BinarySI).String(),
+		strings.Join(p.Snapshots, ","),
+		p.SourceHost,
+		strings.Join(p.SamplePaths, ", "),
+	)
+}
+
+// Supported returns an error if the files of the repository can not be previewed.
+func Supported(repository *stash.Repository) error {
+	if repository.Spec.Backend.Local != nil {
+		return ErrLocalBackend
+	}
+	return nil
+}
+
+// Run resolves the restore rules for each host and lists the files of the selected snapshots
+// using "restic ls". Nothing is written into the restore target.
+func Run(opt Options) ([]HostPreview, error) {
+	if err := Supported(opt.Repository); err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "stash-preview")
+	if err != nil {
+		return nil, err
+	}
+	// cleanup whole tempDir dir at the end
+	defer os.RemoveAll(tempDir)
+
+	setupOpt, err := util.SetupOptionsForRepository(*opt.Repository, util.ExtraOptions{
+		StorageSecret: opt.Secret,
+		EnableCache:   false,
+		ScratchDir:    tempDir,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("setup option for repository failed, reason: %s", err)
+	}
+	// the wrapper only configures the environment of the session. the "restic ls" output
+	// can be huge, so it is streamed instead of being collected by the session.
+	sh := shell.NewSession()
+	w, err := restic.NewResticWrapperFromShell(setupOpt, sh)
+	if err != nil {
+		return nil, err
+	}
+	lister := &resticLister{
+		cmd:     restic.ResticCMD,
+		dir:     tempDir,
+		env:     os.Environ(),
+		timeout: opt.Timeout,
+	}
+	if lister.timeout <= 0 {
+		lister.timeout = DefaultListTimeout
+	}
+	for k, v := range sh.Env {
+		lister.env = append(lister.env, k+"="+v)
+	}
+	if w.GetCaPath() != "" {
+		lister.flags = append(lister.flags, "--cacert", w.GetCaPath())
+	}
+	if setupOpt.InsecureTLS {
+		lister.flags = append(lister.flags, "--insecure-tls")
+	}
+
+	var snapshots []restic.Snapshot
+	if opt.PointInTime != nil {
+		if snapshots, err = w.ListSnapshots(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	previews := make([]HostPreview, 0, len(opt.Hosts))
+	for _, host := range opt.Hosts {
+		restoreOpt := util.RestoreOptionsForHost(host, opt.Rules)
+		if opt.PointInTime != nil && len(restoreOpt.Snapshots) == 0 && len(restoreOpt.RestorePaths) != 0 {
+			ids, err := pitr.Select(snapshots, restoreOpt.SourceHost, restoreOpt.RestorePaths, *opt.PointInTime)
+			if err != nil {
+				previews = append(previews, HostPreview{Hostname: host, SourceHost: restoreOpt.SourceHost, Error: truncate(err.Error(), maxErrorLength)})
+				continue
+			}
+			restoreOpt.Snapshots = ids
+		}
+		previews = append(previews, previewHost(lister, restoreOpt))
+	}
+	return previews, nil
+}
+
+func previewHost(lister *resticLister, opt restic.RestoreOptions) HostPreview {
+	p := HostPreview{
+		Hostname:   opt.Host,
+		SourceHost: opt.SourceHost,
+	}
+	var queries [][]string
+	if len(opt.Snapshots) != 0 {
+		// if snapshot is specified then host and path does not matter.
+		for _, snapshot := range opt.Snapshots {
+			queries = append(queries, []string{snapshot})
+		}
+	} else {
+		for _, path := range opt.RestorePaths {
+			queries = append(queries, []string{"latest", "--host", opt.SourceHost, "--path", path})
+		}
+	}
+	if len(queries) == 0 {
+		p.Error = "no rule selects any snapshot or path for this host"
+		return p
+	}
+
+	acc := &accumulator{
+		preview:  &p,
+		includes: opt.Include,
+		excludes: opt.Exclude,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lister.timeout)
+	defer cancel()
+	for _, query := range queries {
+		if err := lister.list(ctx, query, acc.add); err != nil {
+			p.Error = truncate(err.Error(), maxErrorLength)
+			return p
+		}
+		if !acc.found {
+			p.Error = fmt.Sprintf("no snapshot found for %q", strings.Join(query, " "))
+			return p
+		}
+		acc.found = false
+	}
+	return p
+}
+
+// node is a line of the output of "restic ls --json". The first line describes the snapshot,
+// the rest of the lines describe the nodes of the snapshot.
+type node struct {
+	StructType string `json:"struct_type"`
+	// snapshot fields
+	ShortID  string `json:"short_id,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	// node fields
+	Type string `json:"type,omitempty"`
+	Path string `json:"path,omitempty"`
+	Size uint64 `json:"size,omitempty"`
+}
+
+type accumulator struct {
+	preview  *HostPreview
+	includes []string
+	excludes []string
+	// found reports whether the snapshot line of the current query has been seen
+	found bool
+}
+
+func (a *accumulator) add(n node) {
+	switch n.StructType {
+	case "snapshot":
+		a.found = true
+		a.preview.Snapshots = append(a.preview.Snapshots, n.ShortID)
+		if n.Hostname != "" {
+			a.preview.SourceHost = n.Hostname
+		}
+	case "node":
+		if n.Type != "file" || !Selected(n.Path, a.includes, a.excludes) {
+			return
+		}
+		a.preview.Files++
+		a.preview.TotalSize += n.Size
+		if len(a.preview.SamplePaths) < DefaultSamplePaths {
+			a.preview.SamplePaths = append(a.preview.SamplePaths, truncate(n.Path, maxPathLength))
+		}
+	}
+}
+
+// Totals returns the number of files and their total size over the hosts, and the number of hosts that failed.
+func Totals(hosts []HostPreview) (files int64, size uint64, failed int) {
+	for _, p := range hosts {
+		if p.Error != "" {
+			failed++
+			continue
+		}
+		files += p.Files
+		size += p.TotalSize
+	}
+	return files, size, failed
+}
+
+// FromAnnotations returns the previews of the targets of a restore invoker.
+func FromAnnotations(annotations map[string]string) ([]Result, error) {
+	val, ok := annotations[util.KeyRestorePreviewResult]
+	if !ok {
+		return nil, nil
+	}
+	var results []Result
+	if err := json.Unmarshal([]byte(val), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Upsert adds or replaces the preview of a target in the annotations.
+func Upsert(annotations map[string]string, result Result) (map[string]string, error) {
+	results, _ := FromAnnotations(annotations)
+	found := false
+	for i := range results {
+		if results[i].Target == result.Target {
+			results[i] = result
+			found = true
+		}
+	}
+	if !found {
+		results = append(results, result)
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return annotations, err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[util.KeyRestorePreviewResult] = string(data)
+	return annotations, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+type resticLister struct {
+	cmd   string
+	dir   string
+	env   []string
+	flags []string
+	// timeout bounds the listing of the files of a host
+	timeout time.Duration
+}
+
+// list streams the output of "restic ls" into fn. The command is killed once ctx is done.
+func (l *resticLister) list(ctx context.Context, query []string, fn func(node)) error {
+	args := append([]string{"ls", "--json", "--no-cache"}, query...)
+	args = append(args, l.flags...)
+	cmd := exec.CommandContext(ctx, l.cmd, args...)
+	cmd.Dir = l.dir
+	cmd.Env = l.env
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	if err = decode(stdout, fn); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if ctx.Err() != nil {
+			return l.timeoutError()
+		}
+		return err
+	}
+	if err = cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return l.timeoutError()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("failed to list files, reason: %s", msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *resticLister) timeoutError() error {
+	return fmt.Errorf("failed to list files, reason: timed out after %s", l.timeout)
+}
+
+func decode(r io.Reader, fn func(node)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var n node
+		if err := dec.Decode(&n); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fn(n)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preview
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+)
+
+func TestSelected(t *testing.T) {
+	cases := []struct {
+		path     string
+		includes []string
+		excludes []string
+		want     bool
+	}{
+		{path: "/data/a.txt", want: true},
+		{path: "/data/a.txt", includes: []string{"/data"}, want: true},
+		{path: "/data/a.txt", includes: []string{"/var"}, want: false},
+		{path: "/data/logs/a.log", includes: []string{"logs"}, want: true},
+		{path: "/data/logs/a.log", includes: []string{"/logs"}, want: false},
+		{path: "/data/logs/a.log", excludes: []string{"*.log"}, want: false},
+		{path: "/data/x/y/z.db", includes: []string{"/data/**/z.db"}, want: true},
+		{path: "/data/a.txt", includes: []string{"/data"}, excludes: []string{"a.*"}, want: false},
+	}
+	for _, c := range cases {
+		if got := Selected(c.path, c.includes, c.excludes); got != c.want {
+			t.Errorf("Selected(%q, %v, %v): expected %v, found %v", c.path, c.includes, c.excludes, c.want, got)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	out := `{"struct_type":"snapshot","short_id":"4b0d4c8f","hostname":"host-0","paths":["/data"]}
+{"struct_type":"node","type":"dir","path":"/data"}
+{"struct_type":"node","type":"file","path":"/data/a.txt","size":1024}
+{"struct_type":"node","type":"file","path":"/data/b.log","size":10}
+{"struct_type":"node","type":"file","path":"/data/c.txt","size":1024}
+`
+	p := HostPreview{Hostname: "host-1"}
+	acc := &accumulator{preview: &p, excludes: []string{"*.log"}}
+	if err := decode(strings.NewReader(out), acc.add); err != nil {
+		t.Fatal(err)
+	}
+	want := HostPreview{
+		Hostname:    "host-1",
+		SourceHost:  "host-0",
+		Snapshots:   []string{"4b0d4c8f"},
+		Files:       2,
+		TotalSize:   2048,
+		SamplePaths: []string{"/data/a.txt", "/data/c.txt"},
+	}
+	if !acc.found || !reflect.DeepEqual(p, want) {
+		t.Errorf("expected %+v, found %+v", want, p)
+	}
+	if got := p.Summary(); got != "host host-1: 2 files (2Ki) from snapshot(s) 4b0d4c8f of host host-0, e.g. /data/a.txt, /data/c.txt" {
+		t.Errorf("unexpected summary %q", got)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	annotations, err := Upsert(nil, Result{Target: "StatefulSet/sample", Hosts: []HostPreview{{Hostname: "host-0", Files: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations, err = Upsert(annotations, Result{Target: "StatefulSet/sample", Hosts: []HostPreview{{Hostname: "host-0", Files: 2}, {Hostname: "host-1", Error: "failed"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := FromAnnotations(annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Hosts) != 2 {
+		t.Fatalf("expected the preview of the target to be replaced, found %+v", results)
+	}
+	if files, _, failed := Totals(results[0].Hosts); files != 2 || failed != 1 {
+		t.Errorf("expected 2 files and 1 failed host, found %d files and %d failed hosts", files, failed)
+	}
+}
+
+func TestSamplePathsAreBounded(t *testing.T) {
+	p := HostPreview{}
+	acc := &accumulator{preview: &p}
+	for i := 0; i < 2*DefaultSamplePaths; i++ {
+		acc.add(node{StructType: "node", Type: "file", Path: "/" + strings.Repeat("x", 2*maxPathLength)})
+	}
+	if p.Files != 2*DefaultSamplePaths || len(p.SamplePaths) != DefaultSamplePaths {
+		t.Fatalf("expected %d files and %d sample paths, found %d and %d", 2*DefaultSamplePaths, DefaultSamplePaths, p.Files, len(p.SamplePaths))
+	}
+	for _, path := range p.SamplePaths {
+		if len(path) > maxPathLength+len("...") {
+			t.Errorf("expected the sample paths to be truncated, found %d bytes", len(path))
+		}
+	}
+}
+
+func TestPreviewHostTimeout(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name   string
+		script string
+		// expected
+		files int64
+		err   string
+	}{
+		{
+			name:   "listed",
+			script: `echo '{"struct_type":"snapshot","short_id":"abc","hostname":"host-0"}'; echo '{"struct_type":"node","type":"file","path":"/data/a","size":3}'`,
+			files:  1,
+		},
+		{name: "failed", script: "echo 'repository not found' >&2; exit 1", err: "repository not found"},
+		{name: "timed out", script: "exec sleep 10", err: "timed out after 200ms"},
+		{name: "timed out while streaming", script: `echo '{"struct_type":"snapshot","short_id":"abc"}'; exec sleep 10`, err: "timed out after 200ms"},
+	}
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := filepath.Join(dir, "restic-"+string(rune('a'+i)))
+			if err := os.WriteFile(cmd, []byte("#!/bin/sh\n"+c.script+"\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			lister := &resticLister{cmd: cmd, dir: dir, timeout: 200 * time.Millisecond}
+
+			start := time.Now()
+			p := previewHost(lister, restic.RestoreOptions{Host: "host-0", Snapshots: []string{"abc"}})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the listing to be bounded by the timeout, took %s", elapsed)
+			}
+			if c.err == "" && p.Error != "" {
+				t.Fatalf("expected no error, found %q", p.Error)
+			}
+			if !strings.Contains(p.Error, c.err) {
+				t.Fatalf("expected error containing %q, found %q", c.err, p.Error)
+			}
+			if p.Files != c.files {
+				t.Errorf("expected %d files, found %d", c.files, p.Files)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/apimachinery/pkg/metrics"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupReporter records the progress of a host in the annotations of the running BackupSession
+// and pushes it to the Pushgateway.
+type BackupReporter struct {
+	StashClient cs.Interface
+	Session     metav1.ObjectMeta
+	TargetRef   api_v1beta1.TargetRef
+	Host        string
+	InvokerKind string
+	InvokerName string
+	Metrics     metrics.MetricsOptions
+}
+
+// TargetKey returns the key used to identify a target in the progress annotation.
+func TargetKey(ref api_v1beta1.TargetRef) string {
+	return fmt.Sprintf("%s/%s", ref.Kind, ref.Name)
+}
+
+// Report writes the progress of the host into the BackupSession and updates the progress gauges.
+func (r *BackupReporter) Report(status Status) error {
+	p := status.ToHostProgress(TargetKey(r.TargetRef), r.Host)
+	_, err := v1beta1_util.TryUpdateBackupSession(
+		context.TODO(),
+		r.StashClient.StashV1beta1(),
+		r.Session,
+		func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+			in.Annotations = UpsertHostProgress(in.Annotations, util.KeyBackupProgress, p)
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		return err
+	}
+	if r.Metrics.Enabled {
+		return r.pushMetrics("stash_backup_host_progress", p)
+	}
+	return nil
+}
+
+// ClearBackupProgress removes the progress annotation from a completed BackupSession.
+// The final stats of the hosts are available in the status of the BackupSession.
+func ClearBackupProgress(stashClient cs.Interface, session metav1.ObjectMeta) error {
+	if _, ok := session.Annotations[util.KeyBackupProgress]; !ok {
+		return nil
+	}
+	_, err := v1beta1_util.TryUpdateBackupSession(
+		context.TODO(),
+		stashClient.StashV1beta1(),
+		session,
+		func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+			delete(in.Annotations, util.KeyBackupProgress)
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	return err
+}
+
+func (r *BackupReporter) pushMetrics(prefix string, p HostProgress) error {
+	if r.Metrics.PushgatewayURL == "" {
+		return nil
+	}
+	labels := prometheus.Labels{
+		metrics.MetricLabelInvokerKind: r.InvokerKind,
+		metrics.MetricLabelInvokerName: r.InvokerName,
+		metrics.MetricsLabelNamespace:  r.Session.Namespace,
+		metrics.MetricsLabelKind:       r.TargetRef.Kind,
+		metrics.MetricsLabelName:       r.TargetRef.Name,
+		metrics.MetricLabelHostname:    r.Host,
+	}
+	return pushProgressMetrics(r.Metrics, prefix, labels, p)
+}
+
+// pushProgressMetrics pushes the progress gauges into a separate group for each host,
+// so that the hosts backing up in parallel do not overwrite each other's progress.
+func pushProgressMetrics(opt metrics.MetricsOptions, prefix string, labels prometheus.Labels, p HostProgress) error {
+	newGauge := func(name, help string, value float64) prometheus.Gauge {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        prefix + "_" + name,
+			Help:        help,
+			ConstLabels: labels,
+		})
+		g.Set(value)
+		return g
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		newGauge("percent", "Percentage of the data that has been processed", p.PercentDone),
+		newGauge("bytes_done", "Amount of data that has been processed in bytes", float64(p.BytesDone)),
+		newGauge("bytes_total", "Total amount of data to process in bytes", float64(p.BytesTotal)),
+		newGauge("files_done", "Number of files that have been processed", float64(p.FilesDone)),
+		newGauge("files_total", "Total number of files to process", float64(p.FilesTotal)),
+	)
+	return push.New(opt.PushgatewayURL, opt.JobName).
+		Grouping(metrics.MetricLabelHostname, labels[metrics.MetricLabelHostname]).
+		Gatherer(registry).
+		Add()
+}
+
+// UpsertHostProgress inserts or updates the progress of a host in the progress annotation.
+func UpsertHostProgress(annotations map[string]string, key string, p HostProgress) map[string]string {
+	entries, _ := HostProgressFromAnnotations(annotations, key)
+	found := false
+	for i := range entries {
+		if entries[i].Target == p.Target && entries[i].Hostname == p.Hostname {
+			entries[i] = p
+			found = true
+			break
+		}
+	}
+	if !found {
+		entries = append(entries, p)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return annotations
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = string(data)
+	return annotations
+}
+
+// HostProgressFromAnnotations returns the progress of the hosts recorded in the progress annotation.
+func HostProgressFromAnnotations(annotations map[string]string, key string) ([]HostProgress, error) {
+	val, ok := annotations[key]
+	if !ok || val == "" {
+		return nil, nil
+	}
+	var entries []HostProgress
+	if err := json.Unmarshal([]byte(val), &entries); err != nil {
+		return nil, fmt.Errorf("invalid value for annotation %q. Reason: %v", key, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,443 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package progress
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+
+	shell "gomodules.xyz/go-sh"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// CommandName is the name of the hidden stash command that runs restic and records its progress.
+	CommandName = "restic-progress"
+	// DefaultFileName is the name of the file inside the scratch directory where the latest progress is written.
+	DefaultFileName = "progress.json"
+	// DefaultReportInterval is the minimum interval between two progress reports.
+	DefaultReportInterval = 30 * time.Second
+
+	// progressFPS is the number of status messages per second restic is asked to print.
+	progressFPS              = "0.2"
+	messageTypeStatus        = "status"
+	messageTypeSummary       = "summary"
+	messageTypeVerboseStatus = "verbose_status"
+	actionUpdated            = "updated"
+)
+
+// Status is a status message printed by restic when it runs with "--json".
+// Backup and restore use different field names for the processed files and bytes.
+type Status struct {
+	MessageType      string  `json:"message_type"`
+	SecondsElapsed   uint64  `json:"seconds_elapsed,omitempty"`
+	SecondsRemaining uint64  `json:"seconds_remaining,omitempty"`
+	PercentDone      float64 `json:"percent_done"`
+	TotalFiles       uint64  `json:"total_files,omitempty"`
+	FilesDone        uint64  `json:"files_done,omitempty"`
+	FilesRestored    uint64  `json:"files_restored,omitempty"`
+	TotalBytes       uint64  `json:"total_bytes,omitempty"`
+	BytesDone        uint64  `json:"bytes_done,omitempty"`
+	BytesRestored    uint64  `json:"bytes_restored,omitempty"`
+	ErrorCount       uint64  `json:"error_count,omitempty"`
+}
+
+// RestoreSummary is the summary message printed by "restic restore --json" once the restore has completed.
+type RestoreSummary struct {
+	MessageType   string `json:"message_type"`
+	TotalFiles    uint64 `json:"total_files,omitempty"`
+	FilesRestored uint64 `json:"files_restored,omitempty"`
+	FilesSkipped  uint64 `json:"files_skipped,omitempty"`
+	FilesDeleted  uint64 `json:"files_deleted,omitempty"`
+	// FilesUpdated is not printed by restic. It is counted from the verbose status messages of the overwritten files,
+	// which restic prints with "--verbose=2". The overwritten files are counted in FilesRestored as well.
+	FilesUpdated uint64 `json:"files_updated,omitempty"`
+}
+
+// HostProgress is the progress of a single host of a running session.
+type HostProgress struct {
+	Target      string  `json:"target,omitempty"`
+	Hostname    string  `json:"hostname"`
+	BytesDone   uint64  `json:"bytesDone"`
+	BytesTotal  uint64  `json:"bytesTotal"`
+	FilesDone   uint64  `json:"filesDone"`
+	FilesTotal  uint64  `json:"filesTotal"`
+	PercentDone float64 `json:"percentDone"`
+	ETA         string  `json:"eta,omitempty"`
+	Elapsed     string  `json:"elapsed,omitempty"`
+	UpdatedAt   string  `json:"updatedAt"`
+}
+
+// ToHostProgress converts a restic status message into the progress of the given host.
+func (s Status) ToHostProgress(target, host string) HostProgress {
+	p := HostProgress{
+		Target:      target,
+		Hostname:    host,
+		BytesDone:   s.BytesDone + s.BytesRestored,
+		BytesTotal:  s.TotalBytes,
+		FilesDone:   s.FilesDone + s.FilesRestored,
+		FilesTotal:  s.TotalFiles,
+		PercentDone: s.PercentDone * 100,
+		Elapsed:     (time.Duration(s.SecondsElapsed) * time.Second).String(),
+		UpdatedAt:   time.Now().UTC().Format(time.RFC3339),
+	}
+	if s.SecondsRemaining > 0 {
+		p.ETA = (time.Duration(s.SecondsRemaining) * time.Second).String()
+	}
+	return p
+}
+
+// NewShell returns a shell session that runs restic through the "restic-progress" command of the current binary.
+// The latest status message of the running restic command is written into progressFile. The restic wrapper
+// should be created with restic.NewResticWrapperFromShell() using this session.
+func NewShell(progressFile string) (*shell.Session, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	sh := shell.NewSession()
+	sh.Alias(restic.ResticCMD, self, CommandName, "--progress-file", progressFile, "--", restic.ResticCMD)
+	// nice and ionice wrap the restic command. so, they need to go through the progress command as well.
+	for _, name := range []string{"nice", "ionice"} {
+		if path, err := exec.LookPath(name); err == nil {
+			sh.Alias(path, self, CommandName, "--progress-file", progressFile, "--", path)
+		}
+	}
+	return sh, nil
+}
+
+// NewResticWrapper returns a restic wrapper that reports the progress of the restic commands it runs
+// through report. The returned stop function must be called once the restic commands are done. It reports
+// the final progress and waits for the tracker to exit, so that no report arrives after the caller has
+// written the final status of the host.
+func NewResticWrapper(opt restic.SetupOptions, report func(Status) error) (*restic.ResticWrapper, func(), error) {
+	file := filepath.Join(opt.ScratchDir, DefaultFileName)
+	// remove the progress and the restore summaries of any previous run
+	for _, f := range []string{file, SummaryFile(file)} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+	sh, err := NewShell(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, err := restic.NewResticWrapperFromShell(opt, sh)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &Tracker{
+		File:     file,
+		Interval: DefaultReportInterval,
+		Report:   report,
+	}
+	stopCh := make(chan struct{})
+	done := t.Start(stopCh)
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(stopCh)
+			<-done
+		})
+	}
+	return w, stop, nil
+}
+
+// Run executes the given command and forwards its stdout to out except the restic status messages.
+// The latest status message is written into progressFile so that the caller can report it.
+func Run(progressFile string, command string, args []string, out io.Writer) error {
+	subcommand := resticSubcommand(command, args)
+	switch subcommand {
+	case "backup":
+		args = dropQuietFlag(args)
+	case "restore":
+		// the restic wrapper does not parse the output of restore. so, it is safe to ask for json output.
+		args = append(args, "--json")
+	}
+	cmd := exec.Command(command, args...)
+	cmd.Env = append(os.Environ(), "RESTIC_PROGRESS_FPS="+progressFPS)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	last, summary, err := scanOutput(stdout, progressFile, out)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	if summary != nil && subcommand == "restore" {
+		if err := appendSummary(SummaryFile(progressFile), summary); err != nil {
+			return err
+		}
+	}
+
+	// restic does not print a status message for the completion. so, record it ourselves.
+	switch subcommand {
+	case "backup", "restore":
+		data, err := json.Marshal(completed(last, subcommand))
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(progressFile, data); err != nil {
+			klog.Warningf("failed to write progress. Reason: %v", err)
+		}
+	}
+	return nil
+}
+
+// scanOutput forwards the output of restic to out except the status messages, which are written into progressFile,
+// and the verbose status messages. It returns the last status message and the restore summary, if any.
+func scanOutput(stdout io.Reader, progressFile string, out io.Writer) (*Status, *RestoreSummary, error) {
+	var (
+		last    *Status
+		summary *RestoreSummary
+		updated uint64
+	)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var msg struct {
+			MessageType string `json:"message_type"`
+			Action      string `json:"action"`
+		}
+		if json.Unmarshal(line, &msg) == nil {
+			switch msg.MessageType {
+			case messageTypeStatus:
+				var status Status
+				if json.Unmarshal(line, &status) == nil {
+					if err := writeFileAtomic(progressFile, line); err != nil {
+						klog.Warningf("failed to write progress. Reason: %v", err)
+					}
+					last = &status
+				}
+				continue
+			case messageTypeVerboseStatus:
+				// there is one message per file. so, they are counted instead of being forwarded.
+				if msg.Action == actionUpdated {
+					updated++
+				}
+				continue
+			case messageTypeSummary:
+				s := &RestoreSummary{}
+				if json.Unmarshal(line, s) == nil {
+					summary = s
+				}
+			}
+		}
+		if _, err := fmt.Fprintf(out, "%s\n", line); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		klog.Warningf("failed to read command output. Reason: %v", err)
+	}
+	if summary != nil {
+		summary.FilesUpdated = updated
+	}
+	return last, summary, nil
+}
+
+// SummaryFile returns the file where the summaries of the restic restore commands are appended to, one per line.
+func SummaryFile(progressFile string) string {
+	return progressFile + ".summary"
+}
+
+func appendSummary(file string, summary *RestoreSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadRestoreSummaries returns the summaries of the restic restore commands run since the summary file has been removed.
+func ReadRestoreSummaries(progressFile string) ([]RestoreSummary, error) {
+	data, err := os.ReadFile(SummaryFile(progressFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var summaries []RestoreSummary
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var s RestoreSummary
+		if err := json.Unmarshal([]byte(line), &s); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, nil
+}
+
+// completed returns the status of a successfully finished restic command from its last status message.
+func completed(last *Status, subcommand string) Status {
+	s := Status{MessageType: messageTypeStatus}
+	if last != nil {
+		s = *last
+	}
+	s.PercentDone = 1
+	s.SecondsRemaining = 0
+	if subcommand == "restore" {
+		s.FilesRestored = s.TotalFiles
+		s.BytesRestored = s.TotalBytes
+	} else {
+		s.FilesDone = s.TotalFiles
+		s.BytesDone = s.TotalBytes
+	}
+	return s
+}
+
+// resticSubcommand returns the restic subcommand of a restic command that may be wrapped by nice or ionice.
+func resticSubcommand(command string, args []string) string {
+	if command == restic.ResticCMD && len(args) > 0 {
+		return args[0]
+	}
+	for i := range args {
+		if args[i] == restic.ResticCMD && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// dropQuietFlag removes the "--quiet" flag added by the restic wrapper as it suppresses the status messages.
+func dropQuietFlag(args []string) []string {
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "--quiet" {
+			result = append(result, arg)
+		}
+	}
+	return result
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadStatus reads the latest status message from the progress file.
+// It returns nil if no status has been written yet.
+func ReadStatus(progressFile string) (*Status, error) {
+	data, err := os.ReadFile(progressFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	status := &Status{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Tracker periodically reads the progress file and reports the latest status.
+type Tracker struct {
+	File     string
+	Interval time.Duration
+	Report   func(Status) error
+}
+
+// Start reports the progress every interval until stopCh is closed. Once stopCh is closed, it reports the
+// latest progress one last time, removes the progress file and closes the returned channel.
+func (t *Tracker) Start(stopCh <-chan struct{}) <-chan struct{} {
+	interval := t.Interval
+	if interval <= 0 {
+		interval = DefaultReportInterval
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			_ = os.Remove(t.File)
+		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last *Status
+		report := func() {
+			status, err := ReadStatus(t.File)
+			if err != nil {
+				klog.Warningf("failed to read progress. Reason: %v", err)
+				return
+			}
+			if status == nil || (last != nil && *last == *status) {
+				return
+			}
+			if err := t.Report(*status); err != nil {
+				klog.Warningf("failed to report progress. Reason: %v", err)
+				return
+			}
+			last = status
+		}
+		for {
+			select {
+			case <-stopCh:
+				report()
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,118 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackerReportsOnStop(t *testing.T) {
+	file := filepath.Join(t.TempDir(), DefaultFileName)
+	data, err := json.Marshal(completed(&Status{MessageType: messageTypeStatus, PercentDone: 0.4, TotalFiles: 10, FilesDone: 4}, "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported []Status
+	tracker := &Tracker{
+		File:     file,
+		Interval: time.Hour,
+		Report: func(s Status) error {
+			reported = append(reported, s)
+			return nil
+		},
+	}
+	stopCh := make(chan struct{})
+	done := tracker.Start(stopCh)
+	close(stopCh)
+	<-done
+
+	if len(reported) != 1 {
+		t.Fatalf("expected a final report, got %d reports", len(reported))
+	}
+	p := reported[0].ToHostProgress("", "host-0")
+	if p.PercentDone != 100 || p.FilesDone != 10 {
+		t.Errorf("expected the completed progress, got %+v", p)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("expected the progress file to be removed, got %v", err)
+	}
+}
+
+func TestCompleted(t *testing.T) {
+	last := &Status{MessageType: messageTypeStatus, PercentDone: 0.5, SecondsRemaining: 20, TotalFiles: 8, FilesRestored: 4, TotalBytes: 1024, BytesRestored: 512}
+	s := completed(last, "restore")
+	if s.PercentDone != 1 || s.SecondsRemaining != 0 || s.FilesRestored != 8 || s.BytesRestored != 1024 || s.FilesDone != 0 {
+		t.Errorf("unexpected completed restore status %+v", s)
+	}
+	if s := completed(nil, "backup"); s.PercentDone != 1 || s.MessageType != messageTypeStatus {
+		t.Errorf("unexpected completed status without progress %+v", s)
+	}
+}
+
+func TestScanOutputRestoreSummary(t *testing.T) {
+	progressFile := filepath.Join(t.TempDir(), DefaultFileName)
+	output := strings.Join([]string{
+		`{"message_type":"status","percent_done":0.5,"total_files":4,"files_restored":2}`,
+		`{"message_type":"verbose_status","action":"restored","item":"/data/a"}`,
+		`{"message_type":"verbose_status","action":"updated","item":"/data/b"}`,
+		`{"message_type":"verbose_status","action":"unchanged","item":"/data/c"}`,
+		`{"message_type":"verbose_status","action":"deleted","item":"/data/d"}`,
+		`{"message_type":"summary","total_files":4,"files_restored":2,"files_skipped":1,"files_deleted":1}`,
+		`plain output`,
+	}, "\n")
+	var out bytes.Buffer
+	last, summary, err := scanOutput(strings.NewReader(output), progressFile, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.FilesRestored != 2 {
+		t.Errorf("expected the last status, found %+v", last)
+	}
+	expected := RestoreSummary{MessageType: messageTypeSummary, TotalFiles: 4, FilesRestored: 2, FilesSkipped: 1, FilesDeleted: 1, FilesUpdated: 1}
+	if summary == nil || *summary != expected {
+		t.Errorf("expected summary %+v, found %+v", expected, summary)
+	}
+	if strings.Contains(out.String(), "verbose_status") || strings.Contains(out.String(), `"status"`) {
+		t.Errorf("expected the status messages not to be forwarded, found %q", out.String())
+	}
+	if !strings.Contains(out.String(), "plain output") || !strings.Contains(out.String(), `"summary"`) {
+		t.Errorf("expected the other lines to be forwarded, found %q", out.String())
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := appendSummary(SummaryFile(progressFile), summary); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summaries, err := ReadRestoreSummaries(progressFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries[1] != expected {
+		t.Errorf("expected two summaries, found %+v", summaries)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package progress
+
+import (
+	"fmt"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/apimachinery/pkg/metrics"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// eventStep is the progress in percent between two progress events.
+const eventStep = 10
+
+// RestoreReporter records the progress of a host in the status and the annotations of the restore invoker.
+// It also writes an event every 10% and pushes the progress to the Pushgateway.
+type RestoreReporter struct {
+	StashClient cs.Interface
+	Invoker     invoker.RestoreInvoker
+	TargetRef   api_v1beta1.TargetRef
+	Host        string
+	EventSource string
+	Metrics     metrics.MetricsOptions
+
+	lastEventStep int
+}
+
+// Report writes the progress of the host into the restore invoker and updates the progress gauges.
+func (r *RestoreReporter) Report(status Status) error {
+	p := status.ToHostProgress(TargetKey(r.TargetRef), r.Host)
+
+	// mark the host as running so that the status shows the restore has not been stuck
+	err := r.Invoker.UpdateStatus(invoker.RestoreInvokerStatus{
+		TargetStatus: []api_v1beta1.RestoreMemberStatus{
+			{
+				Ref: r.TargetRef,
+				Stats: []api_v1beta1.HostRestoreStats{
+					{
+						Hostname: r.Host,
+						Phase:    api_v1beta1.HostRestoreRunning,
+						Duration: p.Elapsed,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.updateAnnotation(p); err != nil {
+		return err
+	}
+
+	if step := int(p.PercentDone) / eventStep; step > r.lastEventStep {
+		r.lastEventStep = step
+		if err := r.Invoker.CreateEvent(
+			core.EventTypeNormal,
+			r.EventSource,
+			eventer.EventReasonHostRestoreProgress,
+			fmt.Sprintf("Restored %.0f%% of host %q: %s", p.PercentDone, p.Hostname, Summary(p)),
+		); err != nil {
+			return err
+		}
+	}
+
+	if r.Metrics.Enabled && r.Metrics.PushgatewayURL != "" {
+		labels := prometheus.Labels{
+			metrics.MetricLabelInvokerKind: r.Invoker.GetTypeMeta().Kind,
+			metrics.MetricLabelInvokerName: r.Invoker.GetObjectMeta().Name,
+			metrics.MetricsLabelNamespace:  r.Invoker.GetObjectMeta().Namespace,
+			metrics.MetricsLabelKind:       r.TargetRef.Kind,
+			metrics.MetricsLabelName:       r.TargetRef.Name,
+			metrics.MetricLabelHostname:    r.Host,
+		}
+		return pushProgressMetrics(r.Metrics, "stash_restore_host_progress", labels, p)
+	}
+	return nil
+}
+
+func (r *RestoreReporter) updateAnnotation(p HostProgress) error {
+	return util.UpdateRestoreInvokerAnnotations(r.StashClient, r.Invoker, func(in map[string]string) map[string]string {
+		return UpsertHostProgress(in, util.KeyRestoreProgress, p)
+	})
+}
+
+// ClearRestoreProgress removes the progress annotation from a completed restore invoker.
+// The final stats of the hosts are available in the status of the invoker.
+func ClearRestoreProgress(stashClient cs.Interface, inv invoker.RestoreInvoker) error {
+	if _, ok := inv.GetObjectMeta().Annotations[util.KeyRestoreProgress]; !ok {
+		return nil
+	}
+	return util.UpdateRestoreInvokerAnnotations(stashClient, inv, func(in map[string]string) map[string]string {
+		delete(in, util.KeyRestoreProgress)
+		return in
+	})
+}
+
+// Summary returns a human readable summary of the progress of a host.
+func Summary(p HostProgress) string {
+	s := fmt.Sprintf("%s/%s, %d/%d files",
+		formatBytes(p.BytesDone),
+		formatBytes(p.BytesTotal),
+		p.FilesDone,
+		p.FilesTotal,
+	)
+	if p.ETA != "" {
+		s += fmt.Sprintf(", ETA %s", p.ETA)
+	}
+	return s
+}
+
+func formatBytes(b uint64) string {
+	return resource.NewQuantity(int64(b), resource.BinarySI).String()
+}
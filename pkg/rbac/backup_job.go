@@ -59,6 +59,11 @@ func (opt *Options) EnsureBackupJobRBAC() error {
 		return err
 	}
 
+	err = opt.ensureJobHookRBAC(true)
+	if err != nil {
+		return err
+	}
+
 	return opt.ensureLicenseReaderClusterRoleBinding()
 }
 
@@ -59,29 +59,39 @@ func (opt *Options) ensureClusterRoleBindingDeleted() error {
 }
 
 func (opt *Options) ensureCrossNamespaceRBACResourcesDeleted() error {
-	if opt.crossNamespaceResources == nil {
-		return nil
+	if opt.crossNamespaceResources != nil {
+		if err := opt.ensureRBACResourcesDeletedFromNamespace(opt.crossNamespaceResources.Namespace); err != nil {
+			return err
+		}
 	}
+	for namespace := range opt.crossNamespaceSnapshots {
+		if err := opt.ensureRBACResourcesDeletedFromNamespace(namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	rolebindings, err := opt.kubeClient.RbacV1().RoleBindings(opt.crossNamespaceResources.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: labels.SelectorFromSet(opt.offshootLabels).String()})
+func (opt *Options) ensureRBACResourcesDeletedFromNamespace(namespace string) error {
+	rolebindings, err := opt.kubeClient.RbacV1().RoleBindings(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: labels.SelectorFromSet(opt.offshootLabels).String()})
 	if err != nil {
 		return err
 	}
 
 	for i := range rolebindings.Items {
-		err = opt.kubeClient.RbacV1().RoleBindings(opt.crossNamespaceResources.Namespace).Delete(context.TODO(), rolebindings.Items[i].Name, metav1.DeleteOptions{})
+		err = opt.kubeClient.RbacV1().RoleBindings(namespace).Delete(context.TODO(), rolebindings.Items[i].Name, metav1.DeleteOptions{})
 		if err != nil {
 			return err
 		}
 	}
 
-	roles, err := opt.kubeClient.RbacV1().Roles(opt.crossNamespaceResources.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: labels.SelectorFromSet(opt.offshootLabels).String()})
+	roles, err := opt.kubeClient.RbacV1().Roles(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: labels.SelectorFromSet(opt.offshootLabels).String()})
 	if err != nil {
 		return err
 	}
 
 	for i := range roles.Items {
-		err = opt.kubeClient.RbacV1().Roles(opt.crossNamespaceResources.Namespace).Delete(context.TODO(), roles.Items[i].Name, metav1.DeleteOptions{})
+		err = opt.kubeClient.RbacV1().Roles(namespace).Delete(context.TODO(), roles.Items[i].Name, metav1.DeleteOptions{})
 		if err != nil {
 			return err
 		}
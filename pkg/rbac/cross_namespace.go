@@ -23,6 +23,7 @@ import (
 	"stash.appscode.dev/apimachinery/apis"
 	api_v1alpha1 "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
 	core "k8s.io/api/core/v1"
 	rbac "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -94,6 +95,50 @@ func (opt *Options) ensureCrossNamespaceRoleBinding() error {
 	return err
 }
 
+func (opt *Options) ensureCrossNamespaceSnapshotRBAC() error {
+	for namespace, snapshots := range opt.crossNamespaceSnapshots {
+		meta := metav1.ObjectMeta{
+			Name:      opt.getCrossNamespaceSnapshotRoleName(),
+			Namespace: namespace,
+			Labels:    opt.offshootLabels,
+		}
+		_, _, err := rbac_util.CreateOrPatchRole(context.TODO(), opt.kubeClient, meta, func(in *rbac.Role) *rbac.Role {
+			in.Rules = []rbac.PolicyRule{
+				{
+					APIGroups:     []string{vsapi.GroupName},
+					Resources:     []string{"volumesnapshots"},
+					Verbs:         []string{"get"},
+					ResourceNames: snapshots,
+				},
+			}
+			return in
+		}, metav1.PatchOptions{})
+		if err != nil {
+			return err
+		}
+
+		_, _, err = rbac_util.CreateOrPatchRoleBinding(context.TODO(), opt.kubeClient, meta, func(in *rbac.RoleBinding) *rbac.RoleBinding {
+			in.RoleRef = rbac.RoleRef{
+				APIGroup: rbac.GroupName,
+				Kind:     apis.KindRole,
+				Name:     opt.getCrossNamespaceSnapshotRoleName(),
+			}
+			in.Subjects = []rbac.Subject{
+				{
+					Kind:      rbac.ServiceAccountKind,
+					Name:      opt.serviceAccount.Name,
+					Namespace: opt.serviceAccount.Namespace,
+				},
+			}
+			return in
+		}, metav1.PatchOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (opt *Options) getRoleBindingName() string {
 	return meta_util.ValidNameWithPrefixNSuffix(strings.ToLower(opt.invOpts.Kind), opt.invOpts.Name, opt.suffix)
 }
@@ -105,3 +150,13 @@ func (opt *Options) getCrossNamespaceRoleName() string {
 		opt.suffix,
 	)
 }
+
+// getCrossNamespaceSnapshotRoleName returns the name of the Role that grants access to the VolumeSnapshots of another namespace.
+// It differs from the cross namespace Role of the Repository as both can reside in the same namespace.
+func (opt *Options) getCrossNamespaceSnapshotRoleName() string {
+	return meta_util.ValidNameWithPrefixNSuffix(
+		opt.invOpts.Namespace,
+		strings.Join([]string{strings.ToLower(opt.invOpts.Kind), opt.invOpts.Name, "snapshot"}, "-"),
+		opt.suffix,
+	)
+}
@@ -51,6 +51,11 @@ func (opt *Options) EnsureRestoreInitContainerRBAC() error {
 		return err
 	}
 
+	err = opt.ensureJobHookRBAC(false)
+	if err != nil {
+		return err
+	}
+
 	return opt.ensureCrossNamespaceRBAC()
 }
 
@@ -0,0 +1,115 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"context"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/stash/pkg/util"
+
+	core "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	core_util "kmodules.xyz/client-go/core/v1"
+	meta_util "kmodules.xyz/client-go/meta"
+	rbac_util "kmodules.xyz/client-go/rbac/v1"
+)
+
+// ensureJobHookRBAC allows the executor to run the Job hooks specified in the annotations of the invoker.
+// The Role is namespaced as the Job hooks always run in the namespace of the invoker. Only the executors running
+// with their own ServiceAccount may create the Jobs. The sidecar and the restore init-container run with the
+// ServiceAccount of the workload, so they just wait for the Jobs that the operator creates on their request.
+// The Role is removed once the invoker does not have any Job hook anymore.
+func (opt *Options) ensureJobHookRBAC(createJobs bool) error {
+	if !util.HasAnyJobHook(opt.invOpts.Annotations) {
+		return opt.ensureJobHookRBACDeleted()
+	}
+
+	jobVerbs := []string{"get"}
+	if createJobs {
+		jobVerbs = append(jobVerbs, "create", "patch", "delete")
+	}
+	meta := metav1.ObjectMeta{
+		Name:      opt.getJobHookRoleName(),
+		Namespace: opt.invOpts.Namespace,
+		Labels:    opt.offshootLabels,
+	}
+	_, _, err := rbac_util.CreateOrPatchRole(context.TODO(), opt.kubeClient, meta, func(in *rbac.Role) *rbac.Role {
+		core_util.EnsureOwnerReference(&in.ObjectMeta, opt.owner)
+
+		in.Rules = []rbac.PolicyRule{
+			{
+				APIGroups: []string{batch.GroupName},
+				Resources: []string{"jobs"},
+				Verbs:     jobVerbs,
+			},
+			{
+				APIGroups: []string{core.GroupName},
+				Resources: []string{"pods"},
+				Verbs:     []string{"get", "list"},
+			},
+		}
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+
+	_, _, err = rbac_util.CreateOrPatchRoleBinding(context.TODO(), opt.kubeClient, meta, func(in *rbac.RoleBinding) *rbac.RoleBinding {
+		core_util.EnsureOwnerReference(&in.ObjectMeta, opt.owner)
+
+		in.RoleRef = rbac.RoleRef{
+			APIGroup: rbac.GroupName,
+			Kind:     apis.KindRole,
+			Name:     opt.getJobHookRoleName(),
+		}
+		in.Subjects = []rbac.Subject{
+			{
+				Kind:      rbac.ServiceAccountKind,
+				Name:      opt.serviceAccount.Name,
+				Namespace: opt.serviceAccount.Namespace,
+			},
+		}
+		return in
+	}, metav1.PatchOptions{})
+	return err
+}
+
+func (opt *Options) ensureJobHookRBACDeleted() error {
+	name := opt.getJobHookRoleName()
+	err := opt.kubeClient.RbacV1().RoleBindings(opt.invOpts.Namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	err = opt.kubeClient.RbacV1().Roles(opt.invOpts.Namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (opt *Options) getJobHookRoleName() string {
+	return meta_util.ValidNameWithPrefixNSuffix(
+		"stash-job-hook",
+		strings.Join([]string{strings.ToLower(opt.invOpts.Kind), opt.invOpts.Name}, "-"),
+		opt.suffix,
+	)
+}
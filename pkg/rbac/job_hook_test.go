@@ -0,0 +1,94 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestJobHookRBAC(t *testing.T) {
+	template := `{"spec":{"containers":[{"name":"hook","image":"busybox"}]}}`
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		createJobs  bool
+		// expected
+		jobVerbs []string
+	}{
+		{name: "sidecar", annotations: map[string]string{util.KeyPreBackupJobHook: template}, jobVerbs: []string{"get"}},
+		{name: "job", annotations: map[string]string{util.KeyPreBackupJobHook: template}, createJobs: true, jobVerbs: []string{"get", "create", "patch", "delete"}},
+		{name: "hook removed", annotations: nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			opt := &Options{
+				kubeClient: kubeClient,
+				owner: &metav1.OwnerReference{
+					APIVersion: api_v1beta1.SchemeGroupVersion.String(),
+					Kind:       api_v1beta1.ResourceKindBackupConfiguration,
+					Name:       "sample-backup",
+					UID:        "uid",
+				},
+				invOpts: invokerOptions{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "sample-backup",
+						Namespace:   "demo",
+						Annotations: map[string]string{util.KeyPreBackupJobHook: template},
+					},
+					TypeMeta: metav1.TypeMeta{Kind: api_v1beta1.ResourceKindBackupConfiguration},
+				},
+				serviceAccount: metav1.ObjectMeta{Name: "sample-sa", Namespace: "demo"},
+			}
+			// the Role of a previous Job hook must not be left behind
+			if err := opt.ensureJobHookRBAC(true); err != nil {
+				t.Fatal(err)
+			}
+			opt.invOpts.Annotations = c.annotations
+			if err := opt.ensureJobHookRBAC(c.createJobs); err != nil {
+				t.Fatal(err)
+			}
+
+			name := opt.getJobHookRoleName()
+			role, err := kubeClient.RbacV1().Roles("demo").Get(context.TODO(), name, metav1.GetOptions{})
+			if c.jobVerbs == nil {
+				if !kerr.IsNotFound(err) {
+					t.Fatalf("expected Role %s to be deleted, found error %v", name, err)
+				}
+				if _, err := kubeClient.RbacV1().RoleBindings("demo").Get(context.TODO(), name, metav1.GetOptions{}); !kerr.IsNotFound(err) {
+					t.Fatalf("expected RoleBinding %s to be deleted, found error %v", name, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(role.Rules[0].Verbs, c.jobVerbs) {
+				t.Errorf("expected verbs %v on jobs, found %v", c.jobVerbs, role.Rules[0].Verbs)
+			}
+		})
+	}
+}
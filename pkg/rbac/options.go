@@ -23,6 +23,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	ofst "kmodules.xyz/offshoot-api/api/v1"
 )
@@ -35,6 +36,7 @@ type Options struct {
 	pspNames                []string
 	serviceAccount          metav1.ObjectMeta
 	crossNamespaceResources *crossNamespaceResources
+	crossNamespaceSnapshots map[string][]string
 	suffix                  string
 }
 
@@ -83,6 +85,14 @@ func NewRBACOptions(
 	return rbacOptions, nil
 }
 
+// SetCrossNamespaceSnapshots sets the VolumeSnapshots of other namespaces that the restore job reads.
+func (opt *Options) SetCrossNamespaceSnapshots(snapshots []types.NamespacedName) {
+	opt.crossNamespaceSnapshots = make(map[string][]string)
+	for _, vs := range snapshots {
+		opt.crossNamespaceSnapshots[vs.Namespace] = append(opt.crossNamespaceSnapshots[vs.Namespace], vs.Name)
+	}
+}
+
 func (opt *Options) SetPSPNames(pspNames []string) {
 	opt.pspNames = pspNames
 }
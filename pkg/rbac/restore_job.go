@@ -61,6 +61,11 @@ func (opt *Options) EnsureRestoreJobRBAC() error {
 		return err
 	}
 
+	err = opt.ensureJobHookRBAC(true)
+	if err != nil {
+		return err
+	}
+
 	return opt.ensureLicenseReaderClusterRoleBinding()
 }
 
@@ -50,6 +50,11 @@ func (opt *Options) EnsureSideCarRBAC() error {
 		return err
 	}
 
+	err = opt.ensureJobHookRBAC(false)
+	if err != nil {
+		return err
+	}
+
 	return opt.ensureCrossNamespaceRBAC()
 }
 
@@ -109,7 +114,7 @@ func (opt *Options) ensureSidecarClusterRole() error {
 			{
 				APIGroups: []string{batch.GroupName},
 				Resources: []string{"jobs"},
-				Verbs:     []string{"create", "get"},
+				Verbs:     []string{"get"},
 			},
 			{
 				APIGroups: []string{rbac.GroupName},
@@ -24,6 +24,7 @@ import (
 	api_v1alpha1 "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 
+	vgsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumegroupsnapshot/v1alpha1"
 	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
 	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
@@ -36,6 +37,10 @@ import (
 	rbac_util "kmodules.xyz/client-go/rbac/v1"
 )
 
+// SnapshotContentReaderClusterRole is the ClusterRole that allows the VolumeSnapshot job to read the
+// VolumeSnapshotContents, the VolumeGroupSnapshotContents and the PersistentVolumes.
+const SnapshotContentReaderClusterRole = "stash-snapshot-content-reader"
+
 func (opt *Options) EnsureVolumeSnapshotterJobRBAC() error {
 	if opt.serviceAccount.Name == "" {
 		opt.serviceAccount.Name = meta.ValidNameWithPrefixNSuffix(strings.ToLower(opt.invOpts.Kind), opt.invOpts.Name, opt.suffix)
@@ -56,7 +61,19 @@ func (opt *Options) EnsureVolumeSnapshotterJobRBAC() error {
 		return err
 	}
 
-	return nil
+	// ensure snapshot content reader ClusterRole for VolumeSnapshot job
+	err = opt.ensureSnapshotContentReaderClusterRole()
+	if err != nil {
+		return err
+	}
+
+	// ensure snapshot content reader ClusterRoleBinding for VolumeSnapshot job
+	err = opt.ensureSnapshotContentReaderClusterRoleBinding()
+	if err != nil {
+		return err
+	}
+
+	return opt.ensureJobHookRBAC(true)
 }
 
 func (opt *Options) ensureVolumeSnapshotterJobClusterRole() error {
@@ -84,7 +101,7 @@ func (opt *Options) ensureVolumeSnapshotterJobClusterRole() error {
 			{
 				APIGroups: []string{core.GroupName},
 				Resources: []string{"pods"},
-				Verbs:     []string{"get"},
+				Verbs:     []string{"get", "list"},
 			},
 			{
 				APIGroups: []string{core.GroupName},
@@ -106,6 +123,16 @@ func (opt *Options) ensureVolumeSnapshotterJobClusterRole() error {
 				Resources: []string{"volumesnapshots", "volumesnapshotcontents", "volumesnapshotclasses"},
 				Verbs:     []string{"create", "get", "list", "watch", "patch", "delete"},
 			},
+			{
+				APIGroups: []string{vgsapi.GroupName},
+				Resources: []string{"volumegroupsnapshots"},
+				Verbs:     []string{"create", "get", "list", "watch", "delete"},
+			},
+			{
+				APIGroups: []string{core.GroupName},
+				Resources: []string{"persistentvolumeclaims"},
+				Verbs:     []string{"get", "patch"},
+			},
 		}
 		return in
 	}, metav1.PatchOptions{})
@@ -170,7 +197,13 @@ func (opt *Options) EnsureVolumeSnapshotRestorerJobRBAC() error {
 		return err
 	}
 
-	return nil
+	err = opt.ensureJobHookRBAC(true)
+	if err != nil {
+		return err
+	}
+
+	// ensure Role and RoleBinding for the VolumeSnapshots of other namespaces
+	return opt.ensureCrossNamespaceSnapshotRBAC()
 }
 
 func (opt *Options) ensureVolumeSnapshotRestorerJobClusterRole() error {
@@ -195,6 +228,11 @@ func (opt *Options) ensureVolumeSnapshotRestorerJobClusterRole() error {
 				Resources: []string{"persistentvolumeclaims"},
 				Verbs:     []string{"get", "list", "watch", "create", "patch"},
 			},
+			{
+				APIGroups: []string{core.GroupName},
+				Resources: []string{"pods"},
+				Verbs:     []string{"get", "create", "delete"},
+			},
 			{
 				APIGroups: []string{storage_api_v1.GroupName},
 				Resources: []string{"storageclasses"},
@@ -203,7 +241,7 @@ func (opt *Options) ensureVolumeSnapshotRestorerJobClusterRole() error {
 			{
 				APIGroups: []string{vsapi.GroupName},
 				Resources: []string{"volumesnapshots"},
-				Verbs:     []string{"get"},
+				Verbs:     []string{"get", "list"},
 			},
 		}
 		return in
@@ -285,3 +323,58 @@ func (opt *Options) ensureStorageClassReaderClusterRoleBinding() error {
 	}, metav1.PatchOptions{})
 	return err
 }
+
+// ensureSnapshotContentReaderClusterRole ensures the ClusterRole for the cluster scoped resources that the
+// VolumeSnapshot job reads. It has to be bound with a ClusterRoleBinding as a RoleBinding does not grant them.
+func (opt *Options) ensureSnapshotContentReaderClusterRole() error {
+	meta := metav1.ObjectMeta{
+		Name:   SnapshotContentReaderClusterRole,
+		Labels: opt.offshootLabels,
+	}
+	_, _, err := rbac_util.CreateOrPatchClusterRole(context.TODO(), opt.kubeClient, meta, func(in *rbac.ClusterRole) *rbac.ClusterRole {
+		in.Rules = []rbac.PolicyRule{
+			{
+				APIGroups: []string{vsapi.GroupName},
+				Resources: []string{"volumesnapshotcontents"},
+				Verbs:     []string{"get", "list"},
+			},
+			{
+				APIGroups: []string{vgsapi.GroupName},
+				Resources: []string{"volumegroupsnapshotcontents", "volumegroupsnapshotclasses"},
+				Verbs:     []string{"get", "list"},
+			},
+			{
+				APIGroups: []string{core.GroupName},
+				Resources: []string{"persistentvolumes"},
+				Verbs:     []string{"get"},
+			},
+		}
+		return in
+	}, metav1.PatchOptions{})
+	return err
+}
+
+func (opt *Options) ensureSnapshotContentReaderClusterRoleBinding() error {
+	meta := metav1.ObjectMeta{
+		Name:   meta_util.ValidCronJobNameWithSuffix(opt.invOpts.Namespace+"-"+opt.getRoleBindingName(), SnapshotContentReaderClusterRole),
+		Labels: opt.offshootLabels,
+	}
+	_, _, err := rbac_util.CreateOrPatchClusterRoleBinding(context.TODO(), opt.kubeClient, meta, func(in *rbac.ClusterRoleBinding) *rbac.ClusterRoleBinding {
+		core_util.EnsureOwnerReference(&in.ObjectMeta, opt.owner)
+
+		in.RoleRef = rbac.RoleRef{
+			APIGroup: rbac.GroupName,
+			Kind:     apis.KindClusterRole,
+			Name:     SnapshotContentReaderClusterRole,
+		}
+		in.Subjects = []rbac.Subject{
+			{
+				Kind:      rbac.ServiceAccountKind,
+				Name:      opt.serviceAccount.Name,
+				Namespace: opt.serviceAccount.Namespace,
+			},
+		}
+		return in
+	}, metav1.PatchOptions{})
+	return err
+}
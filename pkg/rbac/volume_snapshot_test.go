@@ -0,0 +1,113 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	vgsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumegroupsnapshot/v1alpha1"
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	core "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVolumeSnapshotterJobRBACGrantsClusterScopedReads(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	opt := &Options{
+		kubeClient: kubeClient,
+		owner: &metav1.OwnerReference{
+			APIVersion: api_v1beta1.SchemeGroupVersion.String(),
+			Kind:       api_v1beta1.ResourceKindBackupConfiguration,
+			Name:       "sample-backup",
+			UID:        "uid",
+		},
+		invOpts: invokerOptions{
+			ObjectMeta: metav1.ObjectMeta{Name: "sample-backup", Namespace: "demo"},
+			TypeMeta:   metav1.TypeMeta{Kind: api_v1beta1.ResourceKindBackupConfiguration},
+		},
+		offshootLabels: map[string]string{"app": "stash"},
+		serviceAccount: metav1.ObjectMeta{Name: "sample-sa", Namespace: "demo"},
+		suffix:         "0",
+	}
+	if err := opt.EnsureVolumeSnapshotterJobRBAC(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cluster scoped resources are granted only through the ClusterRoleBindings
+	var rules []rbac.PolicyRule
+	bindings, err := kubeClient.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range bindings.Items {
+		if !hasSubject(b.Subjects, opt.serviceAccount) {
+			continue
+		}
+		role, err := kubeClient.RbacV1().ClusterRoles().Get(context.TODO(), b.RoleRef.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, role.Rules...)
+	}
+
+	cases := []struct {
+		group    string
+		resource string
+		verb     string
+	}{
+		{group: vsapi.GroupName, resource: "volumesnapshotcontents", verb: "get"},
+		{group: vgsapi.GroupName, resource: "volumegroupsnapshotcontents", verb: "get"},
+		{group: vgsapi.GroupName, resource: "volumegroupsnapshotclasses", verb: "list"},
+		{group: core.GroupName, resource: "persistentvolumes", verb: "get"},
+	}
+	for _, c := range cases {
+		if !allows(rules, c.group, c.resource, c.verb) {
+			t.Errorf("ServiceAccount is not allowed to %s the cluster scoped %s", c.verb, c.resource)
+		}
+	}
+}
+
+func hasSubject(subjects []rbac.Subject, sa metav1.ObjectMeta) bool {
+	for _, s := range subjects {
+		if s.Kind == rbac.ServiceAccountKind && s.Name == sa.Name && s.Namespace == sa.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func allows(rules []rbac.PolicyRule, group, resource, verb string) bool {
+	contains := func(values []string, v string) bool {
+		for _, value := range values {
+			if value == v || value == rbac.ResourceAll {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range rules {
+		if contains(r.APIGroups, group) && contains(r.Resources, resource) && contains(r.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"context"
+	"strings"
+
+	repov1alpha1 "stash.appscode.dev/apimachinery/apis/repositories/v1alpha1"
+	"stash.appscode.dev/stash/pkg/history"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/client-go/kubernetes"
+	restconfig "k8s.io/client-go/rest"
+)
+
+// REST serves the durable backup history of the invokers. It is read-only; the history is written by
+// the operator when a BackupSession completes and is kept in ConfigMaps.
+type REST struct {
+	kubeClient kubernetes.Interface
+	convertor  rest.TableConvertor
+}
+
+var (
+	_ rest.Scoper                   = &REST{}
+	_ rest.Storage                  = &REST{}
+	_ rest.Getter                   = &REST{}
+	_ rest.Lister                   = &REST{}
+	_ rest.GroupVersionKindProvider = &REST{}
+	_ rest.CategoriesProvider       = &REST{}
+	_ rest.SingularNameProvider     = &REST{}
+)
+
+func NewREST(config *restconfig.Config) *REST {
+	return &REST{
+		kubeClient: kubernetes.NewForConfigOrDie(config),
+		convertor: NewCustomTableConvertor(schema.GroupResource{
+			Group:    repov1alpha1.SchemeGroupVersion.Group,
+			Resource: history.ResourcePluralBackupHistory,
+		}),
+	}
+}
+
+func (r *REST) NamespaceScoped() bool {
+	return true
+}
+
+func (r *REST) New() runtime.Object {
+	return &history.BackupHistory{}
+}
+
+func (r *REST) Destroy() {}
+
+func (r *REST) GroupVersionKind(containingGV schema.GroupVersion) schema.GroupVersionKind {
+	return repov1alpha1.SchemeGroupVersion.WithKind(history.ResourceKindBackupHistory)
+}
+
+func (r *REST) Categories() []string {
+	return []string{"storage", "appscode", "all"}
+}
+
+func (r *REST) GetSingularName() string {
+	return strings.ToLower(history.ResourceKindBackupHistory)
+}
+
+func (r *REST) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	ns, ok := apirequest.NamespaceFrom(ctx)
+	if !ok {
+		return nil, apierrors.NewBadRequest("missing namespace")
+	}
+
+	cm, err := r.kubeClient.CoreV1().ConfigMaps(ns).Get(ctx, history.ConfigMapName(name), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, apierrors.NewNotFound(repov1alpha1.Resource(history.ResourceSingularBackupHistory), name)
+		}
+		return nil, apierrors.NewInternalError(err)
+	}
+	if cm.Labels[history.LabelBackupHistory] != "true" {
+		return nil, apierrors.NewNotFound(repov1alpha1.Resource(history.ResourceSingularBackupHistory), name)
+	}
+
+	h, err := history.FromConfigMap(cm)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	return h, nil
+}
+
+func (r *REST) NewList() runtime.Object {
+	return &history.BackupHistoryList{}
+}
+
+// List returns the backup history of the invokers. The invoker labels (i.e. "stash.appscode.com/invoker-name")
+// can be used to select the history of particular invokers.
+func (r *REST) List(ctx context.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	ns, ok := apirequest.NamespaceFrom(ctx)
+	if !ok {
+		return nil, apierrors.NewBadRequest("missing namespace")
+	}
+
+	selector := history.Selector()
+	if options != nil && options.LabelSelector != nil {
+		requirements, _ := options.LabelSelector.Requirements()
+		selector = selector.Add(requirements...)
+	}
+	cms, err := r.kubeClient.CoreV1().ConfigMaps(ns).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+
+	list := &history.BackupHistoryList{
+		Items: make([]history.BackupHistory, 0, len(cms.Items)),
+	}
+	list.ResourceVersion = cms.ResourceVersion
+	for i := range cms.Items {
+		h, err := history.FromConfigMap(&cms.Items[i])
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		list.Items = append(list.Items, *h)
+	}
+	return list, nil
+}
+
+func (r *REST) ConvertToTable(ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	return r.convertor.ConvertToTable(ctx, object, tableOptions)
+}
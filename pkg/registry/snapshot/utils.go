@@ -20,12 +20,14 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"stash.appscode.dev/apimachinery/apis"
 	"stash.appscode.dev/apimachinery/apis/repositories"
 	stash "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/retention"
 	"stash.appscode.dev/stash/pkg/util"
 
 	core "k8s.io/api/core/v1"
@@ -159,6 +161,53 @@ func (r *REST) forgetSnapshotsFromBackend(opt Options) error {
 	return err
 }
 
+// EvaluateRetentionPolicy evaluates a candidate retention policy against the snapshots of the Repository.
+// No snapshot is removed. Like restic, the snapshots are grouped by host and paths and the policy is applied on each group.
+func (r *REST) EvaluateRetentionPolicy(opt Options, policy stash.RetentionPolicy, within retention.KeepWithin) ([]retention.Report, error) {
+	snapshots, err := r.GetSnapshotsFromBackned(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		hostname  string
+		paths     []string
+		snapshots []retention.Snapshot
+	}
+	groups := make(map[string]*group)
+	for _, s := range snapshots {
+		paths := append([]string(nil), s.Status.Paths...)
+		sort.Strings(paths)
+		key := s.Status.Hostname + "\x00" + strings.Join(paths, "\x00")
+		g, ok := groups[key]
+		if !ok {
+			g = &group{hostname: s.Status.Hostname, paths: paths}
+			groups[key] = g
+		}
+		g.snapshots = append(g.snapshots, retention.Snapshot{
+			Name: s.Name,
+			Time: s.CreationTimestamp.Time,
+			Tags: s.Status.Tags,
+		})
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	reports := make([]retention.Report, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		report := retention.Apply(policy, within, g.snapshots)
+		report.Hostname = g.hostname
+		report.Paths = g.paths
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
 func repoNotFound(repo string, err error) bool {
 	repoNotFoundMessage := fmt.Sprintf("exit status 1, reason: %s", repo)
 
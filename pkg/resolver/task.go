@@ -206,33 +206,37 @@ func resolveWithInputs(obj interface{}, inputs map[string]string) error {
 
 func (tr *taskResolver) setHookOptions(r *TaskOptions) {
 	if r.Backup != nil {
-		tr.setBackupHookOptions(r.Backup.TargetInfo.Hooks)
+		tr.setBackupHookOptions(r.Backup.TargetInfo.Hooks, r.Backup.Invoker.GetObjectMeta().Annotations)
 	} else {
-		tr.setRestoreHookOptions(r.Restore.TargetInfo.Hooks)
+		tr.setRestoreHookOptions(r.Restore.TargetInfo.Hooks, r.Restore.Invoker.GetObjectMeta().Annotations)
 	}
 }
 
-func (tr *taskResolver) setBackupHookOptions(hooks *v1beta1_api.BackupHooks) {
-	if hooks != nil && hooks.PreBackup != nil {
+func (tr *taskResolver) setBackupHookOptions(hooks *v1beta1_api.BackupHooks, annotations map[string]string) {
+	if (hooks != nil && hooks.PreBackup != nil) ||
+		util.HasJobHook(annotations, apis.PreBackupHook) {
 		tr.preTaskHookInput = make(map[string]string)
 		tr.preTaskHookInput[apis.HookType] = apis.PreBackupHook
 	}
-	if hooks != nil &&
+	if (hooks != nil &&
 		hooks.PostBackup != nil &&
-		hooks.PostBackup.Handler != nil {
+		hooks.PostBackup.Handler != nil) ||
+		util.HasJobHook(annotations, apis.PostBackupHook) {
 		tr.postTaskHookInput = make(map[string]string)
 		tr.postTaskHookInput[apis.HookType] = apis.PostBackupHook
 	}
 }
 
-func (tr *taskResolver) setRestoreHookOptions(hooks *v1beta1_api.RestoreHooks) {
-	if hooks != nil && hooks.PreRestore != nil {
+func (tr *taskResolver) setRestoreHookOptions(hooks *v1beta1_api.RestoreHooks, annotations map[string]string) {
+	if (hooks != nil && hooks.PreRestore != nil) ||
+		util.HasJobHook(annotations, apis.PreRestoreHook) {
 		tr.preTaskHookInput = make(map[string]string)
 		tr.preTaskHookInput[apis.HookType] = apis.PreRestoreHook
 	}
-	if hooks != nil &&
+	if (hooks != nil &&
 		hooks.PostRestore != nil &&
-		hooks.PostRestore.Handler != nil {
+		hooks.PostRestore.Handler != nil) ||
+		util.HasJobHook(annotations, apis.PostRestoreHook) {
 		tr.postTaskHookInput = make(map[string]string)
 		tr.postTaskHookInput[apis.HookType] = apis.PostRestoreHook
 	}
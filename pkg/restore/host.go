@@ -0,0 +1,132 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/apimachinery/pkg/metrics"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/checksum"
+	"stash.appscode.dev/stash/pkg/conflict"
+	"stash.appscode.dev/stash/pkg/pitr"
+	"stash.appscode.dev/stash/pkg/progress"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"k8s.io/klog/v2"
+)
+
+// HostRestore restores the data of a host of a restore target with restic.
+// It is shared by the restore init-container, the restore job and the restore-pvc command.
+type HostRestore struct {
+	StashClient cs.Interface
+	Invoker     invoker.RestoreInvoker
+	TargetRef   api_v1beta1.TargetRef
+	EventSource string
+	Metrics     metrics.MetricsOptions
+	SetupOpt    restic.SetupOptions
+	// RestoreOpt holds the host and the paths to restore. The snapshots are resolved from the point in time, if specified.
+	RestoreOpt restic.RestoreOptions
+}
+
+// Run restores the host. The progress of the restore is reported to the invoker while it is running.
+// The snapshots of the point in time of the invoker are restored with its conflict policy. The restored files
+// are verified against the checksum manifests, if enabled. The conflict stats of the host are recorded in the invoker.
+func (h HostRestore) Run() (*restic.RestoreOutput, error) {
+	annotations := h.Invoker.GetObjectMeta().Annotations
+	host := h.RestoreOpt.Host
+
+	reporter := &progress.RestoreReporter{
+		StashClient: h.StashClient,
+		Invoker:     h.Invoker,
+		TargetRef:   h.TargetRef,
+		Host:        host,
+		EventSource: h.EventSource,
+		Metrics:     h.Metrics,
+	}
+	w, stopProgress, err := progress.NewResticWrapper(h.SetupOpt, reporter.Report)
+	if err != nil {
+		return nil, err
+	}
+	// stop reporting the progress before the final status of the host is written
+	defer stopProgress()
+
+	// restore the newest snapshots taken at or before the point in time instead of the latest ones, if specified.
+	pit, err := util.PointInTimeFor(annotations)
+	if err != nil {
+		return nil, err
+	}
+	restoreOpt, selected, err := pitr.Resolve(h.SetupOpt, h.RestoreOpt, pit)
+	if err != nil {
+		return nil, err
+	}
+	if selected {
+		if err := pitr.Record(h.StashClient, h.Invoker, h.TargetRef, h.EventSource, *pit, restoreOpt); err != nil {
+			return nil, err
+		}
+	}
+	// restic overwrites the existing files. the other conflict policies are enforced with the restic restore flags.
+	policy, err := util.ConflictPolicyFor(annotations)
+	if err != nil {
+		return nil, err
+	}
+	conflicts, err := conflict.NewRestorer(w, policy, restoreOpt, h.SetupOpt.ScratchDir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := tracing.Start(
+		tracing.SessionContext(annotations),
+		"restic restore",
+		tracing.TargetAttributes(h.TargetRef.Kind, h.TargetRef.Name, host)...,
+	)
+	var (
+		restoreOutput *restic.RestoreOutput
+		stats         conflict.Stats
+		locate        func(string) string
+	)
+	if conflicts != nil {
+		restoreOutput, stats, err = conflicts.Restore(w, h.TargetRef)
+		locate = conflicts.Locate
+	} else {
+		restoreOutput, err = w.RunRestore(restoreOpt, h.TargetRef)
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	// verify the restored files against the checksum manifests stored during backup. a mismatch fails the host.
+	// the combination with a policy keeping the existing files is rejected by the RestoreSession validation.
+	// this only warns about the invokers admitted before.
+	if util.IsAnnotationTrue(annotations, util.KeyChecksumManifest) && policy.KeepsExistingFiles() {
+		klog.Warningf("Skipping checksum verification. Reason: the files kept by the %s conflict policy do not match the checksum manifest.", policy)
+	} else if util.IsAnnotationTrue(annotations, util.KeyChecksumManifest) {
+		if err := checksum.VerifyRestore(h.SetupOpt, restoreOpt, locate); err != nil {
+			return nil, err
+		}
+	}
+	if conflicts != nil {
+		stats.Target = progress.TargetKey(h.TargetRef)
+		stats.Hostname = host
+		if err := conflict.Record(h.StashClient, h.Invoker, h.EventSource, stats); err != nil {
+			return nil, err
+		}
+	}
+	return restoreOutput, nil
+}
@@ -29,6 +29,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/hooks"
 	"stash.appscode.dev/stash/pkg/status"
 	"stash.appscode.dev/stash/pkg/util"
 
@@ -137,9 +138,7 @@ func (opt *Options) electRestoreLeader(inv invoker.RestoreInvoker, targetInfo in
 func (opt *Options) restoreHost(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) error {
 	// execute at the end of restore. no matter if the restore succeed or fail.
 	defer func() {
-		if targetInfo.Hooks != nil &&
-			targetInfo.Hooks.PostRestore != nil &&
-			targetInfo.Hooks.PostRestore.Handler != nil {
+		if hasPostRestoreHook(inv, targetInfo) {
 			err := opt.executePostRestoreHook(inv, targetInfo)
 			if err != nil {
 				klog.Infoln("failed to execute postRestore hook. Reason: ", err)
@@ -147,7 +146,7 @@ func (opt *Options) restoreHost(inv invoker.RestoreInvoker, targetInfo invoker.R
 		}
 	}()
 
-	if targetInfo.Hooks != nil && targetInfo.Hooks.PreRestore != nil {
+	if hasPreRestoreHook(inv, targetInfo) {
 		err := opt.executePreRestoreHook(inv, targetInfo)
 		if err != nil {
 			return err
@@ -228,14 +227,22 @@ func (opt *Options) runRestore(inv invoker.RestoreInvoker, targetInfo invoker.Re
 		return nil, nil
 	}
 
-	// setup restic wrapper
-	w, err := restic.NewResticWrapper(opt.SetupOpt)
-	if err != nil {
-		return nil, err
+	eventSource := eventer.EventSourceRestoreInitContainer
+	if opt.RestoreModel == RestoreModelJob {
+		eventSource = eventer.EventSourceRestoreJob
 	}
 	restoreOptions := util.RestoreOptionsForHost(opt.Host, targetInfo.Target.Rules)
 	restoreOptions.Args = targetInfo.Target.Args
-	return w.RunRestore(restoreOptions, targetInfo.Target.Ref)
+
+	return HostRestore{
+		StashClient: opt.StashClient,
+		Invoker:     inv,
+		TargetRef:   targetInfo.Target.Ref,
+		EventSource: eventSource,
+		Metrics:     opt.Metrics,
+		SetupOpt:    opt.SetupOpt,
+		RestoreOpt:  restoreOptions,
+	}.Run()
 }
 
 func (opt *Options) updateHostRestoreStatus(restoreOutput *restic.RestoreOutput, inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) error {
@@ -273,32 +280,62 @@ func (opt *Options) isRestoredForThisHost(inv invoker.RestoreInvoker, targetInfo
 }
 
 func (opt *Options) executePreRestoreHook(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) error {
-	hookExecutor := stashHooks.RestoreHookExecutor{
-		Config:  opt.Config,
-		Invoker: inv,
-		Target:  targetInfo.Target.Ref,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: opt.Namespace,
-			Name:      meta.PodName(),
+	hookExecutor := hooks.RestoreHookExecutor{
+		RestoreHookExecutor: stashHooks.RestoreHookExecutor{
+			Config:  opt.Config,
+			Invoker: inv,
+			Target:  targetInfo.Target.Ref,
+			ExecutorPod: kmapi.ObjectReference{
+				Namespace: opt.Namespace,
+				Name:      meta.PodName(),
+			},
+			HookType: apis.PreRestoreHook,
 		},
-		Hook:     targetInfo.Hooks.PreRestore,
-		HookType: apis.PreRestoreHook,
+		KubeClient: opt.KubeClient,
+		Host:       opt.Host,
+		// the init-container runs with the ServiceAccount of the workload
+		RequestJobHooks: opt.RestoreModel == RestoreModelInitContainer,
+		StashClient:     opt.StashClient,
+	}
+	if targetInfo.Hooks != nil {
+		hookExecutor.Hook = targetInfo.Hooks.PreRestore
 	}
 	return hookExecutor.Execute()
 }
 
 func (opt *Options) executePostRestoreHook(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) error {
-	hookExecutor := stashHooks.RestoreHookExecutor{
-		Config:  opt.Config,
-		Invoker: inv,
-		Target:  targetInfo.Target.Ref,
-		ExecutorPod: kmapi.ObjectReference{
-			Namespace: opt.Namespace,
-			Name:      meta.PodName(),
+	hookExecutor := hooks.RestoreHookExecutor{
+		RestoreHookExecutor: stashHooks.RestoreHookExecutor{
+			Config:  opt.Config,
+			Invoker: inv,
+			Target:  targetInfo.Target.Ref,
+			ExecutorPod: kmapi.ObjectReference{
+				Namespace: opt.Namespace,
+				Name:      meta.PodName(),
+			},
+			HookType: apis.PostRestoreHook,
 		},
-		Hook:            targetInfo.Hooks.PostRestore.Handler,
-		ExecutionPolicy: targetInfo.Hooks.PostRestore.ExecutionPolicy,
-		HookType:        apis.PostRestoreHook,
+		KubeClient: opt.KubeClient,
+		Host:       opt.Host,
+		// the init-container runs with the ServiceAccount of the workload
+		RequestJobHooks: opt.RestoreModel == RestoreModelInitContainer,
+		StashClient:     opt.StashClient,
+	}
+	if targetInfo.Hooks != nil && targetInfo.Hooks.PostRestore != nil {
+		hookExecutor.Hook = targetInfo.Hooks.PostRestore.Handler
+		hookExecutor.ExecutionPolicy = targetInfo.Hooks.PostRestore.ExecutionPolicy
 	}
 	return hookExecutor.Execute()
 }
+
+func hasPreRestoreHook(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) bool {
+	return (targetInfo.Hooks != nil && targetInfo.Hooks.PreRestore != nil) ||
+		util.HasJobHook(inv.GetObjectMeta().Annotations, apis.PreRestoreHook)
+}
+
+func hasPostRestoreHook(inv invoker.RestoreInvoker, targetInfo invoker.RestoreTargetInfo) bool {
+	return (targetInfo.Hooks != nil &&
+		targetInfo.Hooks.PostRestore != nil &&
+		targetInfo.Hooks.PostRestore.Handler != nil) ||
+		util.HasJobHook(inv.GetObjectMeta().Annotations, apis.PostRestoreHook)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+)
+
+// Some of the code of this file has been copied from restic/restic repository.
+// ref: https://github.com/restic/restic/blob/604b18aa7426148a55f76307ca729e829ff6b61d/internal/restic/snapshot_policy.go#L152:6
+
+// Snapshot is a snapshot that the retention rules are evaluated against.
+type Snapshot struct {
+	Name string
+	Time time.Time
+	Tags []string
+}
+
+// Decision tells whether a snapshot is kept or removed along with the reasons.
+type Decision struct {
+	Snapshot string    `json:"snapshot"`
+	Time     time.Time `json:"time"`
+	Reasons  []string  `json:"reasons"`
+}
+
+// Report holds the snapshots of a group (i.e. a host) that are kept and removed by a retention policy.
+type Report struct {
+	Hostname string     `json:"hostname"`
+	Paths    []string   `json:"paths,omitempty"`
+	Keep     []Decision `json:"keep"`
+	Remove   []Decision `json:"remove"`
+}
+
+const reasonNotMatched = "not matched by any rule of the retention policy"
+
+// ymdh returns an integer in the form YYYYMMDDHH.
+func ymdh(d time.Time, _ int) int {
+	return d.Year()*1000000 + int(d.Month())*10000 + d.Day()*100 + d.Hour()
+}
+
+// ymd returns an integer in the form YYYYMMDD.
+func ymd(d time.Time, _ int) int {
+	return d.Year()*10000 + int(d.Month())*100 + d.Day()
+}
+
+// yw returns an integer in the form YYYYWW, where WW is the week number.
+func yw(d time.Time, _ int) int {
+	year, week := d.ISOWeek()
+	return year*100 + week
+}
+
+// ym returns an integer in the form YYYYMM.
+func ym(d time.Time, _ int) int {
+	return d.Year()*100 + int(d.Month())
+}
+
+// y returns the year of d.
+func y(d time.Time, _ int) int {
+	return d.Year()
+}
+
+// always returns a unique number for d.
+func always(d time.Time, nr int) int {
+	return nr
+}
+
+// Apply evaluates the retention policy and the duration based rules against the snapshots of a single group.
+// Like restic, the durations are counted back from the latest snapshot. The snapshots are not modified.
+func Apply(policy v1alpha1.RetentionPolicy, within KeepWithin, snapshots []Snapshot) Report {
+	list := make([]Snapshot, len(snapshots))
+	copy(list, snapshots)
+	// newer snapshots first
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time.After(list[j].Time)
+	})
+
+	buckets := [6]struct {
+		Count     int64
+		LastAdded func(d time.Time, nr int) int
+		Last      int
+		Reason    string
+	}{
+		{policy.KeepLast, always, -1, "last snapshot"},
+		{policy.KeepHourly, ymdh, -1, "hourly snapshot"},
+		{policy.KeepDaily, ymd, -1, "daily snapshot"},
+		{policy.KeepWeekly, yw, -1, "weekly snapshot"},
+		{policy.KeepMonthly, ym, -1, "monthly snapshot"},
+		{policy.KeepYearly, y, -1, "yearly snapshot"},
+	}
+	withinBuckets := [6]struct {
+		Within    Duration
+		LastAdded func(d time.Time, nr int) int
+		Last      int
+		Reason    string
+	}{
+		{within.Within, always, -1, "within %s"},
+		{within.Hourly, ymdh, -1, "hourly within %s"},
+		{within.Daily, ymd, -1, "daily within %s"},
+		{within.Weekly, yw, -1, "weekly within %s"},
+		{within.Monthly, ym, -1, "monthly within %s"},
+		{within.Yearly, y, -1, "yearly within %s"},
+	}
+	var latest time.Time
+	if len(list) > 0 {
+		latest = list[0].Time
+	}
+
+	report := Report{
+		Keep:   make([]Decision, 0),
+		Remove: make([]Decision, 0),
+	}
+	for nr, s := range list {
+		var reasons []string
+		for _, tags := range policy.KeepTags {
+			if hasTags(s, tags) {
+				reasons = append(reasons, fmt.Sprintf("has tags [%s]", tags))
+			}
+		}
+		for i, b := range buckets {
+			if b.Count > 0 {
+				val := b.LastAdded(s.Time, nr)
+				if val != b.Last {
+					reasons = append(reasons, b.Reason)
+					buckets[i].Last = val
+					buckets[i].Count--
+				}
+			}
+		}
+		for i, b := range withinBuckets {
+			if !b.Within.Zero() && s.Time.After(b.Within.Cutoff(latest)) {
+				val := b.LastAdded(s.Time, nr)
+				if val != b.Last {
+					reasons = append(reasons, fmt.Sprintf(b.Reason, b.Within))
+					withinBuckets[i].Last = val
+				}
+			}
+		}
+
+		if len(reasons) > 0 {
+			report.Keep = append(report.Keep, Decision{Snapshot: s.Name, Time: s.Time, Reasons: reasons})
+		} else {
+			report.Remove = append(report.Remove, Decision{Snapshot: s.Name, Time: s.Time, Reasons: []string{reasonNotMatched}})
+		}
+	}
+	return report
+}
+
+// hasTags returns true if the snapshot has all the tags of the comma separated tag list.
+func hasTags(s Snapshot, tagList string) bool {
+	if strings.TrimSpace(tagList) == "" {
+		return false
+	}
+	for _, tag := range strings.Split(tagList, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		found := false
+		for _, t := range s.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retention
+
+import (
+	"sort"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+)
+
+// Repository lists and removes the snapshots of a restic repository. It is satisfied by the restic wrapper.
+type Repository interface {
+	ListSnapshots(snapshotIDs []string) ([]restic.Snapshot, error)
+	DeleteSnapshots(snapshotIDs []string) ([]byte, error)
+}
+
+// ApplyToRepository applies the retention policy along with the duration based rules to the snapshots of a restic
+// repository. The restic wrapper does not support the "--keep-within*" flags of "restic forget". So, the snapshots are
+// evaluated here, grouped by host and paths like restic does, and the removed ones are forgotten by their IDs.
+// The forgotten snapshots are always pruned. Nothing is removed if the policy is a dry run.
+func ApplyToRepository(repo Repository, policy v1alpha1.RetentionPolicy, within KeepWithin) (*restic.RepositoryStats, error) {
+	snapshots, err := repo.ListSnapshots(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]Snapshot)
+	for _, s := range snapshots {
+		paths := append([]string(nil), s.Paths...)
+		sort.Strings(paths)
+		key := s.Hostname + "\x00" + strings.Join(paths, "\x00")
+		groups[key] = append(groups[key], Snapshot{
+			Name: s.ID,
+			Time: s.Time,
+			Tags: s.Tags,
+		})
+	}
+
+	stats := &restic.RepositoryStats{}
+	var removed []string
+	for _, group := range groups {
+		report := Apply(policy, within, group)
+		stats.SnapshotCount += int64(len(report.Keep))
+		for _, d := range report.Remove {
+			removed = append(removed, d.Snapshot)
+		}
+	}
+	stats.SnapshotsRemovedOnLastCleanup = int64(len(removed))
+	if len(removed) == 0 || policy.DryRun {
+		return stats, nil
+	}
+	sort.Strings(removed)
+	if _, err := repo.DeleteSnapshots(removed); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
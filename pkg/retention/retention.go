@@ -0,0 +1,122 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retention
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a calendar duration in the format accepted by the "--keep-within*" flags of restic, i.e. "1y5m7d2h".
+// Years, months and days are applied on the calendar so that "1m" always means one month regardless of its length.
+type Duration struct {
+	Years  int
+	Months int
+	Days   int
+	Hours  int
+}
+
+// ParseDuration parses a duration in the format "1y5m7d2h". Each unit can be omitted.
+func ParseDuration(s string) (Duration, error) {
+	var d Duration
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return d, fmt.Errorf("empty duration")
+	}
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return Duration{}, fmt.Errorf("invalid duration %q: expected a number before %q", s, rest)
+		}
+		if i == len(rest) {
+			return Duration{}, fmt.Errorf("invalid duration %q: missing unit after %q", s, rest)
+		}
+		num, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return Duration{}, fmt.Errorf("invalid duration %q: %v", s, err)
+		}
+		switch rest[i] {
+		case 'y':
+			d.Years = num
+		case 'm':
+			d.Months = num
+		case 'd':
+			d.Days = num
+		case 'h':
+			d.Hours = num
+		default:
+			return Duration{}, fmt.Errorf("invalid duration %q: unknown unit %q", s, rest[i])
+		}
+		rest = rest[i+1:]
+	}
+	return d, nil
+}
+
+// Zero returns true if the duration does not cover any time.
+func (d Duration) Zero() bool {
+	return d.Years == 0 && d.Months == 0 && d.Days == 0 && d.Hours == 0
+}
+
+func (d Duration) String() string {
+	var s string
+	if d.Years != 0 {
+		s += fmt.Sprintf("%dy", d.Years)
+	}
+	if d.Months != 0 {
+		s += fmt.Sprintf("%dm", d.Months)
+	}
+	if d.Days != 0 {
+		s += fmt.Sprintf("%dd", d.Days)
+	}
+	if d.Hours != 0 {
+		s += fmt.Sprintf("%dh", d.Hours)
+	}
+	return s
+}
+
+// Cutoff returns the time the duration points to when counted back from latest.
+// Like restic, the durations are relative to the latest snapshot instead of the current time.
+func (d Duration) Cutoff(latest time.Time) time.Time {
+	return latest.AddDate(-d.Years, -d.Months, -d.Days).Add(-time.Duration(d.Hours) * time.Hour)
+}
+
+// KeepWithin holds the duration based rules of a retention policy.
+// Within keeps every snapshot taken within the duration. The other fields keep
+// the latest snapshot of each hour, day, week, month or year within the duration.
+type KeepWithin struct {
+	Within  Duration
+	Hourly  Duration
+	Daily   Duration
+	Weekly  Duration
+	Monthly Duration
+	Yearly  Duration
+}
+
+// IsEmpty returns true if none of the durations has been set.
+func (k KeepWithin) IsEmpty() bool {
+	return k.Within.Zero() &&
+		k.Hourly.Zero() &&
+		k.Daily.Zero() &&
+		k.Weekly.Zero() &&
+		k.Monthly.Zero() &&
+		k.Yearly.Zero()
+}
@@ -0,0 +1,131 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		name      string
+		in        string
+		expected  Duration
+		expectErr bool
+	}{
+		{name: "years", in: "2y", expected: Duration{Years: 2}},
+		{name: "months", in: "5m", expected: Duration{Months: 5}},
+		{name: "weeks are given in days", in: "14d", expected: Duration{Days: 14}},
+		{name: "days", in: "7d", expected: Duration{Days: 7}},
+		{name: "hours", in: "36h", expected: Duration{Hours: 36}},
+		{name: "all units", in: "1y5m7d2h", expected: Duration{Years: 1, Months: 5, Days: 7, Hours: 2}},
+		{name: "units in any order", in: "2h1y", expected: Duration{Years: 1, Hours: 2}},
+		{name: "surrounding spaces", in: " 3d ", expected: Duration{Days: 3}},
+		{name: "zero", in: "0d", expected: Duration{}},
+		{name: "empty", in: "", expectErr: true},
+		{name: "blank", in: "  ", expectErr: true},
+		{name: "missing unit", in: "7", expectErr: true},
+		{name: "missing number", in: "d", expectErr: true},
+		{name: "unknown unit", in: "2w", expectErr: true},
+		{name: "go duration", in: "1h30m0s", expectErr: true},
+		{name: "negative", in: "-1d", expectErr: true},
+		{name: "inner space", in: "1y 2m", expectErr: true},
+		{name: "overflow", in: "99999999999999999999d", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := ParseDuration(c.in)
+			if (err != nil) != c.expectErr {
+				t.Fatalf("expected error %v, found %v", c.expectErr, err)
+			}
+			if d != c.expected {
+				t.Errorf("expected %+v, found %+v", c.expected, d)
+			}
+		})
+	}
+}
+
+func TestDurationCutoff(t *testing.T) {
+	latest := time.Date(2020, 3, 31, 10, 0, 0, 0, time.UTC)
+	d := Duration{Months: 1, Hours: 10}
+	expected := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	if cutoff := d.Cutoff(latest); !cutoff.Equal(expected) {
+		t.Errorf("expected %s, found %s", expected, cutoff)
+	}
+}
+
+type fakeRepository struct {
+	snapshots []restic.Snapshot
+	deleted   []string
+}
+
+func (r *fakeRepository) ListSnapshots(_ []string) ([]restic.Snapshot, error) {
+	return r.snapshots, nil
+}
+
+func (r *fakeRepository) DeleteSnapshots(snapshotIDs []string) ([]byte, error) {
+	r.deleted = append(r.deleted, snapshotIDs...)
+	return nil, nil
+}
+
+func TestApplyToRepository(t *testing.T) {
+	latest := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	snapshots := []restic.Snapshot{
+		{ID: "a1", Hostname: "host-0", Paths: []string{"/data"}, Time: latest},
+		{ID: "a2", Hostname: "host-0", Paths: []string{"/data"}, Time: latest.Add(-24 * time.Hour)},
+		{ID: "a3", Hostname: "host-0", Paths: []string{"/data"}, Time: latest.Add(-72 * time.Hour)},
+		// the durations are counted back from the latest snapshot of each group
+		{ID: "b1", Hostname: "host-1", Paths: []string{"/data"}, Time: latest.Add(-96 * time.Hour)},
+		{ID: "b2", Hostname: "host-1", Paths: []string{"/data"}, Time: latest.Add(-168 * time.Hour)},
+		{ID: "c1", Hostname: "host-0", Paths: []string{"/logs"}, Time: latest.Add(-240 * time.Hour)},
+	}
+	cases := []struct {
+		name   string
+		policy v1alpha1.RetentionPolicy
+		within KeepWithin
+		// expected
+		kept    int64
+		deleted []string
+	}{
+		{name: "within", within: KeepWithin{Within: Duration{Days: 2}}, kept: 4, deleted: []string{"a3", "b2"}},
+		{name: "within and keep last", policy: v1alpha1.RetentionPolicy{KeepLast: 3}, within: KeepWithin{Within: Duration{Days: 1}}, kept: 6},
+		{name: "dry run", policy: v1alpha1.RetentionPolicy{DryRun: true}, within: KeepWithin{Within: Duration{Days: 2}}, kept: 4},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			repo := &fakeRepository{snapshots: snapshots}
+			stats, err := ApplyToRepository(repo, c.policy, c.within)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.SnapshotCount != c.kept {
+				t.Errorf("expected %d snapshots to be kept, found %d", c.kept, stats.SnapshotCount)
+			}
+			if stats.SnapshotsRemovedOnLastCleanup != int64(len(snapshots))-c.kept {
+				t.Errorf("expected %d snapshots to be removed, found %d", int64(len(snapshots))-c.kept, stats.SnapshotsRemovedOnLastCleanup)
+			}
+			if !reflect.DeepEqual(repo.deleted, c.deleted) {
+				t.Errorf("expected %v to be deleted, found %v", c.deleted, repo.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	api "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	stash_listers "stash.appscode.dev/apimachinery/client/listers/stash/v1alpha1"
+	stash_listers_v1beta1 "stash.appscode.dev/apimachinery/client/listers/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+const (
+	// BackupStale indicates whether the last successful backup of a BackupConfiguration is older than its RPO.
+	BackupStale = "BackupStale"
+
+	// LastBackupTooOld indicates that the last successful backup is older than the RPO.
+	LastBackupTooOld = "LastBackupTooOld"
+	// NoSuccessfulBackup indicates that no backup has succeeded within the RPO since the BackupConfiguration was created.
+	NoSuccessfulBackup = "NoSuccessfulBackup"
+	// LastBackupWithinRPO indicates that the last successful backup is within the RPO.
+	LastBackupWithinRPO = "LastBackupWithinRPO"
+)
+
+// thresholds caches the RPOs derived from the schedules, as deriving them requires walking through the activations.
+var thresholds sync.Map
+
+// Threshold returns the RPO of a BackupConfiguration. The RPO specified in the annotations takes precedence.
+// Otherwise, it is twice the longest interval between the scheduled backups, so that a single missed run is tolerated.
+// Zero means the RPO is not monitored.
+func Threshold(annotations map[string]string, schedule string) (time.Duration, error) {
+	if d, ok, err := util.RPOFor(annotations); ok || err != nil {
+		return d, err
+	}
+	if schedule == "" {
+		return 0, nil
+	}
+	if d, ok := thresholds.Load(schedule); ok {
+		return d.(time.Duration), nil
+	}
+	interval, err := maxInterval(schedule, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	thresholds.Store(schedule, 2*interval)
+	return 2 * interval, nil
+}
+
+// LastSuccess returns the time the last successful backup of an invoker has completed. Along with the Succeeded
+// BackupSessions, the last backup time of the Repository is considered, as the old BackupSessions are cleaned up.
+func LastSuccess(kind, name string, sessions []*api_v1beta1.BackupSession, repo *api.Repository) time.Time {
+	var last time.Time
+	for _, s := range sessions {
+		if s.Spec.Invoker.Kind != kind || s.Spec.Invoker.Name != name || s.Status.Phase != api_v1beta1.BackupSessionSucceeded {
+			continue
+		}
+		completedAt := s.CreationTimestamp.Time
+		if d, err := time.ParseDuration(s.Status.SessionDuration); err == nil {
+			completedAt = completedAt.Add(d)
+		}
+		if completedAt.After(last) {
+			last = completedAt
+		}
+	}
+	if repo != nil && repo.Status.LastBackupTime != nil && repo.Status.LastBackupTime.After(last) {
+		last = repo.Status.LastBackupTime.Time
+	}
+	return last
+}
+
+// Status is the result of checking a BackupConfiguration against its RPO.
+type Status struct {
+	Threshold   time.Duration
+	LastSuccess time.Time
+	// Age is the time passed since the last successful backup, or since the creation
+	// of the BackupConfiguration if no backup has succeeded yet.
+	Age   time.Duration
+	Stale bool
+}
+
+// Evaluate checks the last successful backup against the RPO. Until the first backup succeeds,
+// the age is counted from the creation of the BackupConfiguration.
+func Evaluate(threshold time.Duration, lastSuccess, created, now time.Time) Status {
+	since := lastSuccess
+	if since.IsZero() {
+		since = created
+	}
+	age := now.Sub(since)
+	return Status{
+		Threshold:   threshold,
+		LastSuccess: lastSuccess,
+		Age:         age,
+		Stale:       age > threshold,
+	}
+}
+
+// ForBackupConfiguration checks a BackupConfiguration against its RPO using the objects found in the informer cache.
+// It returns false if the RPO of the BackupConfiguration is not monitored.
+func ForBackupConfiguration(
+	bc *api_v1beta1.BackupConfiguration,
+	sessionLister stash_listers_v1beta1.BackupSessionLister,
+	repoLister stash_listers.RepositoryLister,
+	now time.Time,
+) (Status, bool, error) {
+	threshold, err := Threshold(bc.Annotations, bc.Spec.Schedule)
+	if err != nil || threshold == 0 {
+		return Status{}, false, err
+	}
+
+	sessions, err := sessionLister.BackupSessions(bc.Namespace).List(labels.Everything())
+	if err != nil {
+		return Status{}, false, err
+	}
+	repoNamespace := bc.Spec.Repository.Namespace
+	if repoNamespace == "" {
+		repoNamespace = bc.Namespace
+	}
+	repo, err := repoLister.Repositories(repoNamespace).Get(bc.Spec.Repository.Name)
+	if err != nil && !kerr.IsNotFound(err) {
+		return Status{}, false, err
+	}
+
+	last := LastSuccess(api_v1beta1.ResourceKindBackupConfiguration, bc.Name, sessions, repo)
+	return Evaluate(threshold, last, bc.CreationTimestamp.Time, now), true, nil
+}
+
+// Condition returns the BackupStale condition reflecting the status. The message does not include
+// the age, so that the condition only changes when the status does.
+func Condition(status Status) kmapi.Condition {
+	cond := kmapi.Condition{
+		Type:               BackupStale,
+		Status:             metav1.ConditionFalse,
+		Reason:             LastBackupWithinRPO,
+		LastTransitionTime: metav1.Now(),
+	}
+	switch {
+	case status.Stale && status.LastSuccess.IsZero():
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = NoSuccessfulBackup
+		cond.Message = fmt.Sprintf("No backup has succeeded within the RPO of %s since the BackupConfiguration was created.", status.Threshold)
+	case status.Stale:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = LastBackupTooOld
+		cond.Message = fmt.Sprintf("The last successful backup completed at %s, exceeding the RPO of %s.",
+			status.LastSuccess.UTC().Format(time.RFC3339), status.Threshold)
+	case status.LastSuccess.IsZero():
+		cond.Message = fmt.Sprintf("No backup has succeeded yet. RPO: %s.", status.Threshold)
+	default:
+		cond.Message = fmt.Sprintf("The last successful backup completed at %s, within the RPO of %s.",
+			status.LastSuccess.UTC().Format(time.RFC3339), status.Threshold)
+	}
+	return cond
+}
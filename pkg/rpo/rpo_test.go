@@ -0,0 +1,121 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpo
+
+import (
+	"testing"
+	"time"
+
+	api "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestThreshold(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		schedule    string
+		want        time.Duration
+		wantErr     bool
+	}{
+		{name: "every 5 minutes", schedule: "*/5 * * * *", want: 10 * time.Minute},
+		{name: "daily", schedule: "@daily", want: 48 * time.Hour},
+		{name: "weekdays", schedule: "30 2 * * 1-5", want: 6 * 24 * time.Hour},
+		{name: "list of hours", schedule: "0 0,6 * * *", want: 36 * time.Hour},
+		{name: "every duration", schedule: "@every 2h", want: 4 * time.Hour},
+		{name: "time zone", schedule: "CRON_TZ=Asia/Dhaka 0 * * * *", want: 2 * time.Hour},
+		{name: "annotation", annotations: map[string]string{util.KeyRPO: "26h"}, schedule: "@daily", want: 26 * time.Hour},
+		{name: "disabled", annotations: map[string]string{util.KeyRPO: "0"}, schedule: "@daily", want: 0},
+		{name: "no schedule", want: 0},
+		{name: "invalid annotation", annotations: map[string]string{util.KeyRPO: "1 day"}, wantErr: true},
+		{name: "invalid schedule", schedule: "0 0 * *", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Threshold(c.annotations, c.schedule)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("expected %s, found %s", c.want, got)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2021, time.January, 30, 10, 7, 0, 0, time.UTC)
+	cases := []struct {
+		schedule string
+		want     time.Time
+	}{
+		{"*/15 * * * *", time.Date(2021, time.January, 30, 10, 15, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		// day of month and day of week are OR-ed
+		{"0 0 15 * sun", time.Date(2021, time.January, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := parseSchedule(c.schedule)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", c.schedule, err)
+		}
+		if got := s.next(from); !got.Equal(c.want) {
+			t.Errorf("%q: expected %s, found %s", c.schedule, c.want, got)
+		}
+	}
+}
+
+func TestLastSuccess(t *testing.T) {
+	now := time.Date(2021, time.January, 30, 10, 0, 0, 0, time.UTC)
+	session := func(name string, phase api_v1beta1.BackupSessionPhase, created time.Time) *api_v1beta1.BackupSession {
+		return &api_v1beta1.BackupSession{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+			Spec: api_v1beta1.BackupSessionSpec{
+				Invoker: api_v1beta1.BackupInvokerRef{Kind: api_v1beta1.ResourceKindBackupConfiguration, Name: "sample"},
+			},
+			Status: api_v1beta1.BackupSessionStatus{Phase: phase, SessionDuration: "5m0s"},
+		}
+	}
+	sessions := []*api_v1beta1.BackupSession{
+		session("a", api_v1beta1.BackupSessionSucceeded, now.Add(-30*time.Hour)),
+		session("b", api_v1beta1.BackupSessionFailed, now.Add(-2*time.Hour)),
+	}
+
+	last := LastSuccess(api_v1beta1.ResourceKindBackupConfiguration, "sample", sessions, nil)
+	if want := now.Add(-30*time.Hour + 5*time.Minute); !last.Equal(want) {
+		t.Errorf("expected %s, found %s", want, last)
+	}
+	if status := Evaluate(26*time.Hour, last, now.Add(-100*time.Hour), now); !status.Stale {
+		t.Errorf("expected the backup to be stale, age: %s", status.Age)
+	}
+
+	// the old sessions may have been cleaned up, so the repository is considered too
+	repo := &api.Repository{Status: api.RepositoryStatus{LastBackupTime: &metav1.Time{Time: now.Add(-time.Hour)}}}
+	last = LastSuccess(api_v1beta1.ResourceKindBackupConfiguration, "sample", sessions, repo)
+	if status := Evaluate(26*time.Hour, last, now.Add(-100*time.Hour), now); status.Stale || status.Age != time.Hour {
+		t.Errorf("expected the backup to be within the RPO, age: %s", status.Age)
+	}
+
+	// until the first backup succeeds, the age is counted from the creation of the BackupConfiguration
+	if status := Evaluate(26*time.Hour, time.Time{}, now.Add(-time.Hour), now); status.Stale {
+		t.Errorf("expected a new BackupConfiguration not to be stale")
+	}
+}
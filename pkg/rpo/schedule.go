@@ -0,0 +1,216 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// schedule is a parsed standard cron schedule as accepted by the Kubernetes CronJobs.
+// Every field is a bit set of the values it matches.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	// day of month and day of week are OR-ed if both of them are restricted
+	domStar, dowStar bool
+	every            time.Duration
+}
+
+func parseSchedule(spec string) (*schedule, error) {
+	spec = strings.TrimSpace(spec)
+	// the time zone does not change the intervals between the runs
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		if i := strings.IndexAny(spec, " \t"); i > 0 {
+			spec = strings.TrimSpace(spec[i:])
+		}
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q", spec)
+		}
+		return &schedule{every: d}, nil
+	}
+	if v, ok := descriptors[spec]; ok {
+		spec = v
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q. Expected 5 fields, found %d", spec, len(fields))
+	}
+	var (
+		s   schedule
+		err error
+	)
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+	// both 0 and 7 are Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+	return &s, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps (i.e. "*/15", "1-5", "0,30").
+func parseField(field string, lo, hi int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		start, end := lo, hi
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if start, err = parseValue(bounds[0], lo, hi, names); err != nil {
+				return 0, err
+			}
+			if end, err = parseValue(bounds[1], lo, hi, names); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := parseValue(rng, lo, hi, names)
+			if err != nil {
+				return 0, err
+			}
+			start = v
+			// "5/10" runs from 5 to the end of the range
+			if !strings.Contains(part, "/") {
+				end = v
+			}
+		}
+		if start > end {
+			return 0, fmt.Errorf("invalid range %q", part)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, lo, hi int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("invalid value %q. It must be between %d and %d", s, lo, hi)
+	}
+	return v, nil
+}
+
+// next returns the first activation of the schedule after t. The zero time is returned if the
+// schedule never activates, i.e. "0 0 30 2 *".
+func (s *schedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// maxInterval returns the longest interval between the next activations of the schedule after t.
+// Enough activations are inspected to cover the gaps over the weekends and the months.
+func maxInterval(spec string, t time.Time) (time.Duration, error) {
+	s, err := parseSchedule(spec)
+	if err != nil {
+		return 0, err
+	}
+	var longest time.Duration
+	prev := s.next(t)
+	if prev.IsZero() {
+		return 0, fmt.Errorf("schedule %q never activates", spec)
+	}
+	limit := prev.AddDate(0, 0, 35)
+	for i := 0; i < 1000 && prev.Before(limit); i++ {
+		cur := s.next(prev)
+		if cur.IsZero() {
+			break
+		}
+		if d := cur.Sub(prev); d > longest {
+			longest = d
+		}
+		prev = cur
+	}
+	return longest, nil
+}
@@ -35,9 +35,15 @@ type InstantScheduler struct {
 }
 
 func (s *InstantScheduler) Ensure() error {
+	_, err := s.EnsureSession()
+	return err
+}
+
+// EnsureSession creates a new BackupSession for the invoker and returns it.
+func (s *InstantScheduler) EnsureSession() (*api_v1beta1.BackupSession, error) {
 	session := s.Invoker.NewSession()
 
-	_, _, err := v1beta1_util.CreateOrPatchBackupSession(
+	bs, _, err := v1beta1_util.CreateOrPatchBackupSession(
 		context.TODO(),
 		s.StashClient.StashV1beta1(),
 		session.ObjectMeta,
@@ -50,5 +56,5 @@ func (s *InstantScheduler) Ensure() error {
 		},
 		metav1.PatchOptions{},
 	)
-	return err
+	return bs, err
 }
@@ -27,7 +27,10 @@ import (
 	api "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	"stash.appscode.dev/stash/pkg/controller"
 	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/history"
+	historyregistry "stash.appscode.dev/stash/pkg/registry/history"
 	snapregistry "stash.appscode.dev/stash/pkg/registry/snapshot"
+	"stash.appscode.dev/stash/pkg/tracing"
 
 	admission "k8s.io/api/admission/v1beta1"
 	core "k8s.io/api/core/v1"
@@ -40,6 +43,7 @@ import (
 	"k8s.io/apiserver/pkg/registry/rest"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/component-base/metrics/legacyregistry"
 	reg_util "kmodules.xyz/client-go/admissionregistration/v1"
 	dynamic_util "kmodules.xyz/client-go/dynamic"
 	"kmodules.xyz/client-go/meta"
@@ -59,6 +63,7 @@ var (
 
 func init() {
 	install.Install(Scheme)
+	utilruntime.Must(history.AddToScheme(Scheme))
 	utilruntime.Must(admission.AddToScheme(Scheme))
 
 	// we need to add the options to empty v1
@@ -91,6 +96,12 @@ func (op *StashServer) Run(stopCh <-chan struct{}) error {
 	if err := op.Controller.MigrateObservedGeneration(); err != nil {
 		return fmt.Errorf("failed  to migrate observedGeneration to int64 for existing objects. Reason: %v", err)
 	}
+	shutdown, err := tracing.Setup("stash-operator")
+	if err != nil {
+		return fmt.Errorf("failed to setup tracing. Reason: %v", err)
+	}
+	defer shutdown()
+
 	// sync cache
 	go op.Controller.Run(stopCh)
 	return op.GenericAPIServer.PrepareRun().Run(stopCh)
@@ -131,6 +142,8 @@ func (c completedConfig) New() (*StashServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	// serve the backup, restore and repository metrics from the /metrics endpoint of the operator
+	legacyregistry.Registerer().MustRegister(ctrl.NewMetricsCollector())
 
 	var admissionHooks []hooks.AdmissionHook
 	if c.ExtraConfig.EnableValidatingWebhook {
@@ -262,6 +275,7 @@ func (c completedConfig) New() (*StashServer, error) {
 		apiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(repositories.GroupName, Scheme, metav1.ParameterCodec, Codecs)
 		v1alpha1storage := map[string]rest.Storage{}
 		v1alpha1storage[v1alpha1.ResourcePluralSnapshot] = snapregistry.NewREST(c.ExtraConfig.ClientConfig)
+		v1alpha1storage[history.ResourcePluralBackupHistory] = historyregistry.NewREST(c.ExtraConfig.ClientConfig)
 		apiGroupInfo.VersionedResourcesStorageMap["v1alpha1"] = v1alpha1storage
 
 		if err := s.GenericAPIServer.InstallAPIGroup(&apiGroupInfo); err != nil {
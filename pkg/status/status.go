@@ -32,6 +32,9 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/metrics"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/retention"
+	"stash.appscode.dev/stash/pkg/tracing"
+	"stash.appscode.dev/stash/pkg/util"
 
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -108,6 +111,17 @@ func (o UpdateStatusOptions) UpdatePostBackupStatus(backupOutput *restic.BackupO
 		return err
 	}
 
+	_, span := tracing.Start(
+		tracing.SessionContext(backupSession.Annotations),
+		"Update backup status",
+		tracing.TargetAttributes(o.TargetRef.Kind, o.TargetRef.Name, "")...,
+	)
+	err = o.updatePostBackupStatus(backupSession, backupOutput)
+	tracing.End(span, err)
+	return err
+}
+
+func (o UpdateStatusOptions) updatePostBackupStatus(backupSession *v1beta1.BackupSession, backupOutput *restic.BackupOutput) error {
 	session := invoker.NewBackupSessionHandler(o.StashClient, backupSession)
 
 	inv, err := session.GetInvoker()
@@ -197,6 +211,11 @@ func (o UpdateStatusOptions) UpdatePostRestoreStatus(restoreOutput *restic.Resto
 		inv.GetObjectMeta().Namespace,
 		targetInfo.Target.Ref.Name,
 	)
+	_, span := tracing.Start(
+		tracing.SessionContext(inv.GetObjectMeta().Annotations),
+		"Update restore status",
+		tracing.TargetAttributes(targetInfo.Target.Ref.Kind, targetInfo.Target.Ref.Name, "")...,
+	)
 	err = inv.UpdateStatus(invoker.RestoreInvokerStatus{
 		TargetStatus: []v1beta1.RestoreMemberStatus{
 			{
@@ -205,6 +224,7 @@ func (o UpdateStatusOptions) UpdatePostRestoreStatus(restoreOutput *restic.Resto
 			},
 		},
 	})
+	tracing.End(span, err)
 	if err != nil {
 		return err
 	}
@@ -298,11 +318,23 @@ func (o *UpdateStatusOptions) executePostBackupActionsForTarget(inv invoker.Back
 func (o UpdateStatusOptions) applyRetentionPolicy(inv invoker.BackupInvoker, session *invoker.BackupSessionHandler) (*restic.RepositoryStats, error) {
 	if !isRetentionPolicyApplied(session) {
 		klog.Infoln("Applying retention policy.....")
+		within, err := util.RetentionKeepWithin(inv.GetObjectMeta().Annotations)
+		if err != nil {
+			return nil, err
+		}
 		w, err := restic.NewResticWrapper(o.SetupOpt)
 		if err != nil {
 			return nil, err
 		}
-		res, err := w.ApplyRetentionPolicies(inv.GetRetentionPolicy())
+		_, span := tracing.Start(tracing.SessionContext(session.GetObjectMeta().Annotations), "restic forget")
+		var res *restic.RepositoryStats
+		if within.IsEmpty() {
+			res, err = w.ApplyRetentionPolicies(inv.GetRetentionPolicy())
+		} else {
+			// the restic wrapper does not support the duration based rules
+			res, err = retention.ApplyToRepository(w, inv.GetRetentionPolicy(), within)
+		}
+		tracing.End(span, err)
 		if err != nil {
 			return nil, err
 		}
@@ -322,7 +354,9 @@ func (o UpdateStatusOptions) verifyRepositoryIntegrity(session *invoker.BackupSe
 		if err != nil {
 			return nil, err
 		}
+		_, span := tracing.Start(tracing.SessionContext(session.GetObjectMeta().Annotations), "restic check")
 		res, err := w.VerifyRepositoryIntegrity()
+		tracing.End(span, err)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,246 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"stash.appscode.dev/stash/pkg/util"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// environment variables used to pass the tracing configuration of the operator to the jobs, the sidecars and the
+	// init-containers. The exporter options are never read from the annotations, which the users are allowed to edit.
+	EnvOTLPEndpoint = "STASH_OTLP_ENDPOINT"
+	EnvOTLPInsecure = "STASH_OTLP_INSECURE"
+	EnvSampleRatio  = "STASH_TRACE_SAMPLE_RATIO"
+	// EnvTraceParent holds the W3C traceparent of the span that the spans of a pod are children of
+	EnvTraceParent = "TRACEPARENT"
+
+	instrumentationName = "stash.appscode.dev/stash"
+	traceParentHeader   = "traceparent"
+	shutdownTimeout     = 10 * time.Second
+)
+
+// attributes attached to the spans
+const (
+	KeyNamespace   = attribute.Key("k8s.namespace.name")
+	KeySessionKind = attribute.Key("stash.session.kind")
+	KeySessionName = attribute.Key("stash.session.name")
+	KeyTargetKind  = attribute.Key("stash.target.kind")
+	KeyTargetName  = attribute.Key("stash.target.name")
+	KeyHost        = attribute.Key("stash.host")
+	KeyHookType    = attribute.Key("stash.hook.type")
+	KeyAttempts    = attribute.Key("stash.hook.attempts")
+)
+
+// Options configures the OTLP exporter. Tracing is disabled if no endpoint has been specified.
+type Options struct {
+	// Endpoint is the host:port of the OTLP gRPC receiver
+	Endpoint    string  `json:"endpoint"`
+	Insecure    bool    `json:"insecure,omitempty"`
+	SampleRatio float64 `json:"sampleRatio"`
+}
+
+var (
+	options Options
+
+	mu sync.Mutex
+	// provider is the installed tracer provider and service is the name it has been or will be installed for
+	provider *sdktrace.TracerProvider
+	service  string
+)
+
+// SetOptions sets the tracing options used by Setup and propagated to the pods by EnvVars.
+func SetOptions(opts Options) {
+	options = opts
+}
+
+func Enabled() bool {
+	return options.Endpoint != ""
+}
+
+// Setup installs the OTLP tracer provider for the service. The returned function flushes the pending spans
+// and must be called before the process exits. Nothing is installed if tracing is disabled.
+func Setup(serviceName string) (func(), error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	mu.Lock()
+	defer mu.Unlock()
+	service = serviceName
+	if err := install(); err != nil {
+		return nil, err
+	}
+	return shutdown, nil
+}
+
+func install() error {
+	if !Enabled() || provider != nil {
+		return nil
+	}
+	clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(options.Endpoint)}
+	if options.Insecure {
+		clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), clientOpts...)
+	if err != nil {
+		return err
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// shutdown flushes the pending spans of the installed tracer provider, if any.
+func shutdown() {
+	mu.Lock()
+	defer mu.Unlock()
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		klog.Warningf("Failed to flush the spans. Reason: %v", err)
+	}
+}
+
+// SetupFromEnv installs the tracer provider from the options passed by the operator through the environment variables.
+// Failures are logged only, tracing must never fail a backup or restore.
+func SetupFromEnv(serviceName string) func() {
+	opts := Options{
+		Endpoint:    os.Getenv(EnvOTLPEndpoint),
+		SampleRatio: 1,
+	}
+	opts.Insecure, _ = strconv.ParseBool(os.Getenv(EnvOTLPInsecure))
+	if v, err := strconv.ParseFloat(os.Getenv(EnvSampleRatio), 64); err == nil {
+		opts.SampleRatio = v
+	}
+	SetOptions(opts)
+
+	fn, err := Setup(serviceName)
+	if err != nil {
+		klog.Warningf("Failed to setup tracing. Reason: %v", err)
+		return shutdown
+	}
+	return fn
+}
+
+// Annotations returns the annotations that record the trace context of a session.
+func Annotations(traceParent string) map[string]string {
+	return map[string]string{
+		util.KeyTraceContext: traceParent,
+	}
+}
+
+// Start starts a span as a child of the span found in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// NewSessionTrace starts the trace of a BackupSession or RestoreSession and returns its traceparent
+// to be stored in the KeyTraceContext annotation of the session. The root span is ended right away,
+// the spans of the operator and the pods working on the session become its children.
+func NewSessionTrace(kind, namespace, name string) string {
+	ctx, span := Start(context.Background(), kind, KeySessionKind.String(kind), KeyNamespace.String(namespace), KeySessionName.String(name))
+	span.End()
+	return TraceParent(ctx)
+}
+
+// SessionContext returns a context carrying the trace of a session. The trace context is read from the
+// annotations of the session. Otherwise, the one passed to the pod through the environment is used.
+func SessionContext(annotations map[string]string) context.Context {
+	tp := annotations[util.KeyTraceContext]
+	if tp == "" {
+		tp = os.Getenv(EnvTraceParent)
+	}
+	if tp == "" {
+		return context.Background()
+	}
+	return propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{traceParentHeader: tp})
+}
+
+// TraceParent returns the W3C traceparent of the span found in ctx.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get(traceParentHeader)
+}
+
+// EnvVars returns the environment variables that pass the tracing options and the trace context found in ctx
+// to a pod. No variable is returned if tracing is disabled.
+func EnvVars(ctx context.Context) []core.EnvVar {
+	env := OptionEnvVars()
+	if env == nil {
+		return nil
+	}
+	if tp := TraceParent(ctx); tp != "" {
+		env = append(env, core.EnvVar{Name: EnvTraceParent, Value: tp})
+	}
+	return env
+}
+
+// OptionEnvVars returns the environment variables that pass the tracing options only. They are used for the sidecars
+// and the init-containers, which read the trace context from the annotations of the session they work on.
+// No variable is returned if tracing is disabled.
+func OptionEnvVars() []core.EnvVar {
+	if !Enabled() {
+		return nil
+	}
+	return []core.EnvVar{
+		{Name: EnvOTLPEndpoint, Value: options.Endpoint},
+		{Name: EnvOTLPInsecure, Value: strconv.FormatBool(options.Insecure)},
+		{Name: EnvSampleRatio, Value: strconv.FormatFloat(options.SampleRatio, 'f', -1, 64)},
+	}
+}
+
+// TargetAttributes returns the attributes identifying the target and the host a span works on.
+func TargetAttributes(kind, name, host string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{KeyTargetKind.String(kind), KeyTargetName.String(name)}
+	if host != "" {
+		attrs = append(attrs, KeyHost.String(host))
+	}
+	return attrs
+}
@@ -0,0 +1,122 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"stash.appscode.dev/stash/pkg/util"
+
+	core "k8s.io/api/core/v1"
+)
+
+const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestSessionContext(t *testing.T) {
+	testCases := []struct {
+		description string
+		annotations map[string]string
+		env         string
+		expected    string
+	}{
+		{
+			description: "Trace context from the annotation",
+			annotations: map[string]string{util.KeyTraceContext: traceParent},
+			expected:    traceParent,
+		},
+		{
+			description: "Trace context from the environment",
+			env:         traceParent,
+			expected:    traceParent,
+		},
+		{
+			description: "No trace context",
+		},
+		{
+			description: "Invalid trace context",
+			annotations: map[string]string{util.KeyTraceContext: "invalid"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			t.Setenv(EnvTraceParent, tc.env)
+			if got := TraceParent(SessionContext(tc.annotations)); got != tc.expected {
+				t.Errorf("expected traceparent %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	defer SetOptions(Options{})
+
+	SetOptions(Options{})
+	if env := EnvVars(SessionContext(map[string]string{util.KeyTraceContext: traceParent})); env != nil {
+		t.Errorf("expected no env when tracing is disabled, got %v", env)
+	}
+
+	SetOptions(Options{Endpoint: "otel-collector.monitoring:4317", Insecure: true, SampleRatio: 0.5})
+	expected := map[string]string{
+		EnvOTLPEndpoint: "otel-collector.monitoring:4317",
+		EnvOTLPInsecure: "true",
+		EnvSampleRatio:  "0.5",
+		EnvTraceParent:  traceParent,
+	}
+	got := toMap(EnvVars(SessionContext(map[string]string{util.KeyTraceContext: traceParent})))
+	if len(got) != len(expected) {
+		t.Fatalf("expected env %v, got %v", expected, got)
+	}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Errorf("expected %s=%q, got %q", k, v, got[k])
+		}
+	}
+
+	if _, ok := toMap(EnvVars(context.Background()))[EnvTraceParent]; ok {
+		t.Errorf("expected no %s without a trace context", EnvTraceParent)
+	}
+}
+
+func toMap(env []core.EnvVar) map[string]string {
+	out := map[string]string{}
+	for _, e := range env {
+		out[e.Name] = e.Value
+	}
+	return out
+}
+
+func TestAnnotations(t *testing.T) {
+	defer SetOptions(Options{})
+
+	SetOptions(Options{Endpoint: "otel-collector.monitoring:4317", Insecure: true, SampleRatio: 0.5})
+	annotations := Annotations(traceParent)
+	// the exporter options are passed through the environment only
+	expected := map[string]string{util.KeyTraceContext: traceParent}
+	if !reflect.DeepEqual(annotations, expected) {
+		t.Errorf("expected annotations %v, got %v", expected, annotations)
+	}
+
+	env := toMap(OptionEnvVars())
+	if env[EnvOTLPEndpoint] != "otel-collector.monitoring:4317" || env[EnvOTLPInsecure] != "true" || env[EnvSampleRatio] != "0.5" {
+		t.Errorf("unexpected tracing options %v", env)
+	}
+	if _, ok := env[EnvTraceParent]; ok {
+		t.Errorf("expected no %s in the tracing options", EnvTraceParent)
+	}
+}
@@ -0,0 +1,756 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/retention"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	ofst "kmodules.xyz/offshoot-api/api/v1"
+	prober "kmodules.xyz/prober/api/v1"
+)
+
+const (
+	// KeyDependsOn holds a comma separated list of BackupConfigurations (<namespace>/<name> or <name>)
+	// that must have a fresh successful BackupSession before a backup of the annotated invoker can start.
+	KeyDependsOn = api_v1beta1.StashKey + "/depends-on"
+	// KeyDependencyFreshness specifies how old the successful BackupSession of a dependency can be.
+	KeyDependencyFreshness = api_v1beta1.StashKey + "/dependency-freshness"
+
+	DefaultDependencyFreshness = 24 * time.Hour
+
+	// KeyBackupOnRollout enables taking an instant backup whenever the pod template of the target workload changes.
+	KeyBackupOnRollout = api_v1beta1.StashKey + "/backup-on-rollout"
+	// KeyHoldRolloutForBackup holds the rollout of a target Deployment or StatefulSet until the pre-rollout backup succeeds.
+	// A Deployment is paused. The partition of a StatefulSet is raised to its replicas and restored afterwards. StatefulSets
+	// using the OnDelete update strategy are not held. If the backup fails, the rollout stays on hold until the user resumes it.
+	KeyHoldRolloutForBackup = api_v1beta1.StashKey + "/hold-rollout-for-backup"
+
+	// The following annotations are maintained by Stash on the target workload to track the pre-rollout backups.
+	KeyRolloutTemplateHash   = api_v1beta1.StashKey + "/rollout-template-hash"
+	KeyRolloutBackupSessions = api_v1beta1.StashKey + "/rollout-backup-sessions"
+	KeyRolloutHeld           = api_v1beta1.StashKey + "/rollout-held"
+	KeyRolloutHeldPartition  = api_v1beta1.StashKey + "/rollout-held-partition"
+	KeyRolloutHoldPartition  = api_v1beta1.StashKey + "/rollout-hold-partition"
+
+	// KeyBackupProgress is maintained by Stash on a running BackupSession. It holds the progress of each host as JSON.
+	KeyBackupProgress = api_v1beta1.StashKey + "/backup-progress"
+	// KeyVolumeSnapshotStats is set by Stash on a BackupSession that took VolumeSnapshots. It holds the restore size,
+	// the readiness time and the bound VolumeSnapshotContent of each VolumeSnapshot as JSON.
+	KeyVolumeSnapshotStats = api_v1beta1.StashKey + "/volume-snapshot-stats"
+	// KeyRetentionDryRunReport is set by Stash on a BackupSession whose VolumeSnapshots have been evaluated against a dry run
+	// retention policy. It holds the VolumeSnapshots that would be kept and removed for each host as JSON.
+	KeyRetentionDryRunReport = api_v1beta1.StashKey + "/retention-dry-run-report"
+	// KeyRestoreProgress is maintained by Stash on a running restore invoker. It holds the progress of each host as JSON.
+	KeyRestoreProgress = api_v1beta1.StashKey + "/restore-progress"
+
+	// KeyChecksumManifest enables storing a checksum manifest of the backed up files along with each snapshot
+	// when set on a backup invoker. With "true" or "snapshot", the manifest is generated from the content of the snapshot, so every
+	// backed up file is downloaded from the repository once more after each backup. With "local", it is generated from
+	// the local files right after the backup, which is cheaper but only accurate if the files do not change during the
+	// backup (i.e. the backups from a VolumeSnapshot). When set to "true" on a restore invoker, the restored files are
+	// verified against the manifest of the restored snapshots if it exists. It can not be used on a restore invoker
+	// with the "SkipExisting" or "OverwriteIfNewer" conflict policy as the files kept by these policies would not match.
+	KeyChecksumManifest = api_v1beta1.StashKey + "/checksum-manifest"
+
+	// KeyVerifyEvery enables restore verification for every Nth successful BackupSession of the annotated invoker.
+	// The restore verification is supported only for the workload volumes backed up by the Restic driver.
+	KeyVerifyEvery = api_v1beta1.StashKey + "/verify-every"
+	// KeyVerifyInterval enables restore verification when the last verification is older than the given duration.
+	KeyVerifyInterval = api_v1beta1.StashKey + "/verify-interval"
+	// KeyVerifyVolumeClaimTemplate holds the template (JSON) of the scratch PVC where the backed up data is restored.
+	KeyVerifyVolumeClaimTemplate = api_v1beta1.StashKey + "/verify-volume-claim-template"
+	// KeyVerifyHook holds a hook (JSON) that is executed against the restored data to verify it.
+	KeyVerifyHook = api_v1beta1.StashKey + "/verify-hook"
+	// KeyVerifyTimeout specifies the maximum duration of a verification restore.
+	KeyVerifyTimeout = api_v1beta1.StashKey + "/verify-timeout"
+	// KeyVerificationState is maintained by Stash on the invoker to decide when the next verification is due.
+	KeyVerificationState = api_v1beta1.StashKey + "/verification-state"
+	// KeyVerificationDecision is set by Stash on a succeeded BackupSession once it has decided whether to verify it.
+	// The value is either "Due" or "Skipped". The decision is made exactly once per BackupSession.
+	KeyVerificationDecision = api_v1beta1.StashKey + "/verification-decision"
+
+	// KeyBackupFromSnapshot makes the Restic driver backup a PVC target from a CSI VolumeSnapshot instead of the live volume.
+	// A temporary PVC is provisioned from the VolumeSnapshot and both of them are deleted when the BackupSession completes.
+	// It is rejected on the BackupConfigurations targeting a workload. Those are still backed up by the sidecar.
+	KeyBackupFromSnapshot = api_v1beta1.StashKey + "/backup-from-snapshot"
+	// KeyVolumeGroupSnapshotClass specifies the VolumeGroupSnapshotClass used to snapshot all the PVCs of a target at the same instant.
+	// The default VolumeGroupSnapshotClass is used if it is not set. The PVCs are snapshotted independently when neither exists.
+	// The backup fails if it is set but the cluster serves the VolumeGroupSnapshot API only in a version unsupported by Stash.
+	KeyVolumeGroupSnapshotClass = api_v1beta1.StashKey + "/volume-group-snapshot-class"
+
+	// KeyUsagePolicy holds the usage policy (JSON) of the VolumeSnapshots taken for the annotated invoker. It follows the
+	// same rules as the usage policy of a Repository and is copied into the annotations of each VolumeSnapshot.
+	KeyUsagePolicy = api_v1beta1.StashKey + "/usage-policy"
+	// KeyCrossNamespaceDataSource makes the restored PVCs refer to the VolumeSnapshots of other namespaces directly.
+	// It requires the CrossNamespaceVolumeDataSource feature and the ReferenceGrant API of the cluster. Stash creates a ReferenceGrant in the namespace of
+	// each VolumeSnapshot. Without this annotation, the VolumeSnapshots are bound into the namespace of the PVCs.
+	KeyCrossNamespaceDataSource = api_v1beta1.StashKey + "/cross-namespace-data-source"
+
+	// KeyVerifyConsumerPod makes the VolumeSnapshot restorer schedule a short-lived pod that consumes each restored PVC
+	// whose StorageClass uses the "WaitForFirstConsumer" binding mode so that the restore can be verified.
+	KeyVerifyConsumerPod = api_v1beta1.StashKey + "/verify-consumer-pod"
+	// KeyVerifyConsumerProbe holds a shell command that is executed by the consumer pod against the restored volume (i.e. "ls /restored-data").
+	KeyVerifyConsumerProbe = api_v1beta1.StashKey + "/verify-consumer-probe"
+	// KeyVerifyConsumerTimeout specifies the maximum duration to wait for the consumer pod to complete.
+	KeyVerifyConsumerTimeout = api_v1beta1.StashKey + "/verify-consumer-timeout"
+
+	DefaultConsumerPodTimeout = 10 * time.Minute
+
+	// KeyPreBackupJobHook holds a pod template (JSON) that is run as a Job to completion before the backup of a target.
+	// The Job is executed after the handler of the respective hook, if any, as part of the same hook execution.
+	// The Job hooks run with the default ServiceAccount of the namespace. So, the template must not set serviceAccountName.
+	// The Job hooks of the sidecar and the restore init-container are created by the operator on their request, as they
+	// run with the ServiceAccount of the workload which is not allowed to create Jobs.
+	KeyPreBackupJobHook = api_v1beta1.StashKey + "/pre-backup-job-hook"
+	// KeyPostBackupJobHook holds a pod template (JSON) that is run as a Job to completion after the backup of a target.
+	// It follows the executionPolicy of the postBackup hook.
+	KeyPostBackupJobHook = api_v1beta1.StashKey + "/post-backup-job-hook"
+	// KeyPreRestoreJobHook holds a pod template (JSON) that is run as a Job to completion before the restore of a target.
+	KeyPreRestoreJobHook = api_v1beta1.StashKey + "/pre-restore-job-hook"
+	// KeyPostRestoreJobHook holds a pod template (JSON) that is run as a Job to completion after the restore of a target.
+	// It follows the executionPolicy of the postRestore hook.
+	KeyPostRestoreJobHook = api_v1beta1.StashKey + "/post-restore-job-hook"
+	// KeyJobHookTimeout specifies the maximum duration of a Job hook. The Job is deleted if it does not complete in time.
+	KeyJobHookTimeout = api_v1beta1.StashKey + "/job-hook-timeout"
+
+	DefaultJobHookTimeout = 30 * time.Minute
+
+	// KeyJobHookRequests is maintained by the sidecar on the BackupSessions and by the restore init-container on the
+	// restore invokers. It holds the Job hooks (JSON) that the operator should create for them.
+	KeyJobHookRequests = api_v1beta1.StashKey + "/job-hook-requests"
+
+	// KeyPreBackupHookSettings holds the execution settings (JSON) of the preBackup hook of the targets,
+	// i.e. {"timeout": "2m", "maxRetries": 3, "backoff": "10s"}. The timeout applies to every attempt
+	// of the hook handler. A failed attempt is retried after the backoff which is doubled on every retry.
+	// A timed out attempt is not retried as the hook can not be interrupted and may still be running.
+	KeyPreBackupHookSettings = api_v1beta1.StashKey + "/pre-backup-hook-settings"
+	// KeyPostBackupHookSettings holds the execution settings (JSON) of the postBackup hook of the targets.
+	KeyPostBackupHookSettings = api_v1beta1.StashKey + "/post-backup-hook-settings"
+	// KeyPreRestoreHookSettings holds the execution settings (JSON) of the preRestore hook of the targets.
+	KeyPreRestoreHookSettings = api_v1beta1.StashKey + "/pre-restore-hook-settings"
+	// KeyPostRestoreHookSettings holds the execution settings (JSON) of the postRestore hook of the targets.
+	KeyPostRestoreHookSettings = api_v1beta1.StashKey + "/post-restore-hook-settings"
+	// KeyGlobalPreBackupHookSettings holds the execution settings (JSON) of the global preBackup hook.
+	KeyGlobalPreBackupHookSettings = api_v1beta1.StashKey + "/global-pre-backup-hook-settings"
+	// KeyGlobalPostBackupHookSettings holds the execution settings (JSON) of the global postBackup hook.
+	KeyGlobalPostBackupHookSettings = api_v1beta1.StashKey + "/global-post-backup-hook-settings"
+	// KeyGlobalPreRestoreHookSettings holds the execution settings (JSON) of the global preRestore hook.
+	KeyGlobalPreRestoreHookSettings = api_v1beta1.StashKey + "/global-pre-restore-hook-settings"
+	// KeyGlobalPostRestoreHookSettings holds the execution settings (JSON) of the global postRestore hook.
+	KeyGlobalPostRestoreHookSettings = api_v1beta1.StashKey + "/global-post-restore-hook-settings"
+
+	DefaultHookBackoff = 10 * time.Second
+	MaxHookBackoff     = 5 * time.Minute
+	MaxHookRetries     = 10
+
+	// KeyGlobalHookState is maintained by Stash on the BackupSessions and the restore invokers. It records the attempts
+	// made to execute the global hooks in the operator, so that the retries are scheduled without blocking a worker.
+	KeyGlobalHookState = api_v1beta1.StashKey + "/global-hook-state"
+
+	// KeyFreeze freezes the filesystems of the target with fsfreeze while the VolumeSnapshots are taken ("true").
+	// It is rejected for the restic backups as they read the files for the whole backup.
+	// The container executing fsfreeze needs the fsfreeze binary and the privilege to run it.
+	KeyFreeze = api_v1beta1.StashKey + "/freeze"
+	// KeyFreezeContainer specifies the container executing fsfreeze, i.e. a privileged helper. It must mount the volumes of the target.
+	// By default, the container that mounts the respective volume is used.
+	KeyFreezeContainer = api_v1beta1.StashKey + "/freeze-container"
+	// KeyFreezeTimeout specifies the maximum duration the filesystems are kept frozen. Once it passes, they are
+	// thawed by a watchdog running inside the container, even if Stash has crashed. The backup fails then.
+	KeyFreezeTimeout = api_v1beta1.StashKey + "/freeze-timeout"
+
+	DefaultFreezeTimeout = 5 * time.Minute
+
+	// KeyNotificationState is maintained by Stash on the BackupSessions and the restore invokers.
+	// It records the deliveries of the notifications of the current phase so that every phase is notified only once.
+	KeyNotificationState = api_v1beta1.StashKey + "/notification-state"
+
+	// KeyTraceContext is set by Stash on the BackupSessions and RestoreSessions when tracing is enabled.
+	// It holds the W3C traceparent of the trace that the spans of the operator, the jobs and the sidecars join.
+	KeyTraceContext = api_v1beta1.StashKey + "/trace-context"
+
+	// KeyRPO specifies the recovery point objective of a BackupConfiguration (i.e. "26h"). When the last successful
+	// backup is older than it, the BackupConfiguration is marked as stale. If not specified, it is derived from the schedule.
+	// "0" disables the monitoring.
+	KeyRPO = api_v1beta1.StashKey + "/rpo"
+
+	// KeyBackupHistoryRecords specifies the number of completed sessions kept in the durable backup history of an invoker.
+	// Unlike the BackupSessions, they are not removed according to the backup history limit. "0" disables the history.
+	KeyBackupHistoryRecords = api_v1beta1.StashKey + "/backup-history-records"
+
+	DefaultBackupHistoryRecords = 400
+
+	// KeyRestorePreview can be set to "true" on a RestoreSession or a RestoreBatch to preview the restore.
+	// Stash resolves the rules for each host and reports the files that would be restored in the status
+	// without touching the target. Remove it to run the actual restore.
+	KeyRestorePreview = api_v1beta1.StashKey + "/preview"
+	// KeyRestorePreviewResult is maintained by Stash on a restore invoker using KeyRestorePreview. It holds the number of files,
+	// their total size and a few sample paths for each host of each target as JSON.
+	KeyRestorePreviewResult = api_v1beta1.StashKey + "/preview-result"
+
+	// KeyRestoreTime selects the newest snapshot taken at or before the given RFC3339 time (i.e. "2021-03-14T14:05:00Z")
+	// for each host and path of a restore invoker instead of the latest one. Rules with explicit snapshots are not affected.
+	KeyRestoreTime = api_v1beta1.StashKey + "/restore-time"
+	// KeyRestoreTags limits the snapshots selected by KeyRestoreTime to the ones having all of the comma separated tags.
+	KeyRestoreTags = api_v1beta1.StashKey + "/restore-tags"
+	// KeySelectedSnapshots is maintained by Stash on a restore invoker using KeyRestoreTime. It holds the snapshots
+	// selected for each host as JSON.
+	KeySelectedSnapshots = api_v1beta1.StashKey + "/selected-snapshots"
+
+	// KeyConflictPolicy specifies how a restore invoker treats the files that already exist in the target.
+	// One of "Overwrite" (default), "SkipExisting", "OverwriteIfNewer", "Sibling" or "Mirror".
+	KeyConflictPolicy = api_v1beta1.StashKey + "/conflict-policy"
+	// KeyRestoreConflicts is maintained by Stash on a restore invoker using a conflict policy other than "Overwrite".
+	// It holds the number of restored, overwritten, skipped and deleted files of each host as JSON.
+	KeyRestoreConflicts = api_v1beta1.StashKey + "/restore-conflicts"
+
+	// KeyKeepWithin keeps every snapshot taken within the given duration (i.e. "14d") of the latest snapshot.
+	// The duration based rules are combined with the retention policy of the invoker. The restic snapshots
+	// removed by them are always pruned.
+	KeyKeepWithin = api_v1beta1.StashKey + "/keep-within"
+	// KeyKeepWithinHourly keeps the latest snapshot of each hour within the given duration of the latest snapshot.
+	KeyKeepWithinHourly = api_v1beta1.StashKey + "/keep-within-hourly"
+	// KeyKeepWithinDaily keeps the latest snapshot of each day within the given duration of the latest snapshot.
+	KeyKeepWithinDaily = api_v1beta1.StashKey + "/keep-within-daily"
+	// KeyKeepWithinWeekly keeps the latest snapshot of each week within the given duration of the latest snapshot.
+	KeyKeepWithinWeekly = api_v1beta1.StashKey + "/keep-within-weekly"
+	// KeyKeepWithinMonthly keeps the latest snapshot of each month within the given duration of the latest snapshot.
+	KeyKeepWithinMonthly = api_v1beta1.StashKey + "/keep-within-monthly"
+	// KeyKeepWithinYearly keeps the latest snapshot of each year within the given duration of the latest snapshot.
+	KeyKeepWithinYearly = api_v1beta1.StashKey + "/keep-within-yearly"
+)
+
+// BackupDependencies returns the BackupConfigurations that the annotated invoker depends on.
+// References without a namespace are resolved against the invoker namespace.
+func BackupDependencies(annotations map[string]string, namespace string) ([]kmapi.ObjectReference, error) {
+	val, ok := annotations[KeyDependsOn]
+	if !ok || strings.TrimSpace(val) == "" {
+		return nil, nil
+	}
+
+	var deps []kmapi.ObjectReference
+	for _, ref := range strings.Split(val, ",") {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		parts := strings.Split(ref, "/")
+		switch len(parts) {
+		case 1:
+			deps = append(deps, kmapi.ObjectReference{Namespace: namespace, Name: parts[0]})
+		case 2:
+			if parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid dependency %q in annotation %q", ref, KeyDependsOn)
+			}
+			deps = append(deps, kmapi.ObjectReference{Namespace: parts[0], Name: parts[1]})
+		default:
+			return nil, fmt.Errorf("invalid dependency %q in annotation %q", ref, KeyDependsOn)
+		}
+	}
+	return deps, nil
+}
+
+// DependencyFreshness returns the window within which a dependency must have succeeded.
+func DependencyFreshness(annotations map[string]string) (time.Duration, error) {
+	val, ok := annotations[KeyDependencyFreshness]
+	if !ok || val == "" {
+		return DefaultDependencyFreshness, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for annotation %q. Reason: %v", val, KeyDependencyFreshness, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("annotation %q must be a positive duration", KeyDependencyFreshness)
+	}
+	return d, nil
+}
+
+// IsAnnotationTrue returns true if the annotation is present and set to "true".
+func IsAnnotationTrue(annotations map[string]string, key string) bool {
+	val, ok := annotations[key]
+	return ok && strings.EqualFold(strings.TrimSpace(val), "true")
+}
+
+// RestoreVerificationPolicy specifies when and how the backups of an invoker are verified by restoring them.
+type RestoreVerificationPolicy struct {
+	// EverySessions verifies every Nth successful BackupSession. Zero disables it.
+	EverySessions int
+	// Interval verifies a successful BackupSession when the last verification is older than it. Zero disables it.
+	Interval            time.Duration
+	Timeout             *metav1.Duration
+	VolumeClaimTemplate ofst.PersistentVolumeClaim
+	Hook                *prober.Handler
+}
+
+// RestoreVerificationState records the verification history of an invoker.
+type RestoreVerificationState struct {
+	LastSession               string      `json:"lastSession,omitempty"`
+	LastVerifiedSession       string      `json:"lastVerifiedSession,omitempty"`
+	LastVerificationTime      metav1.Time `json:"lastVerificationTime,omitempty"`
+	SessionsSinceVerification int         `json:"sessionsSinceVerification,omitempty"`
+}
+
+// VerificationPolicy returns the restore verification policy of the annotated invoker.
+// It returns nil if the verification has not been enabled.
+func VerificationPolicy(annotations map[string]string) (*RestoreVerificationPolicy, error) {
+	every, everyFound := annotations[KeyVerifyEvery]
+	interval, intervalFound := annotations[KeyVerifyInterval]
+	if !everyFound && !intervalFound {
+		return nil, nil
+	}
+
+	policy := &RestoreVerificationPolicy{}
+	if everyFound {
+		n, err := strconv.Atoi(every)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("annotation %q must be a positive integer", KeyVerifyEvery)
+		}
+		policy.EverySessions = n
+	}
+	if intervalFound {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("annotation %q must be a positive duration", KeyVerifyInterval)
+		}
+		policy.Interval = d
+	}
+	if val, ok := annotations[KeyVerifyTimeout]; ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("annotation %q must be a positive duration", KeyVerifyTimeout)
+		}
+		policy.Timeout = &metav1.Duration{Duration: d}
+	}
+
+	val, ok := annotations[KeyVerifyVolumeClaimTemplate]
+	if !ok {
+		return nil, fmt.Errorf("annotation %q is required to verify the backups", KeyVerifyVolumeClaimTemplate)
+	}
+	if err := json.Unmarshal([]byte(val), &policy.VolumeClaimTemplate); err != nil {
+		return nil, fmt.Errorf("invalid value for annotation %q. Reason: %v", KeyVerifyVolumeClaimTemplate, err)
+	}
+	if val, ok := annotations[KeyVerifyHook]; ok {
+		policy.Hook = &prober.Handler{}
+		if err := json.Unmarshal([]byte(val), policy.Hook); err != nil {
+			return nil, fmt.Errorf("invalid value for annotation %q. Reason: %v", KeyVerifyHook, err)
+		}
+	}
+	return policy, nil
+}
+
+// IsVerificationDue returns true if a BackupSession that succeeded at now should be verified
+// given the verification history of the invoker.
+func (p RestoreVerificationPolicy) IsVerificationDue(state RestoreVerificationState, now time.Time) bool {
+	if p.EverySessions > 0 && state.SessionsSinceVerification+1 >= p.EverySessions {
+		return true
+	}
+	if p.Interval > 0 && (state.LastVerificationTime.IsZero() || now.Sub(state.LastVerificationTime.Time) >= p.Interval) {
+		return true
+	}
+	return false
+}
+
+// VerificationState returns the verification history recorded in the annotations of an invoker.
+func VerificationState(annotations map[string]string) RestoreVerificationState {
+	var state RestoreVerificationState
+	if val, ok := annotations[KeyVerificationState]; ok {
+		// a corrupted state only resets the history
+		_ = json.Unmarshal([]byte(val), &state)
+	}
+	return state
+}
+
+// UpsertVerificationState records the verification history in the annotations of an invoker.
+func UpsertVerificationState(annotations map[string]string, state RestoreVerificationState) (map[string]string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return annotations, err
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[KeyVerificationState] = string(data)
+	return annotations, nil
+}
+
+// ConsumerPodPolicy specifies how a restored PVC with "WaitForFirstConsumer" binding mode is verified.
+type ConsumerPodPolicy struct {
+	// Probe is a shell command executed by the consumer pod with the restored volume mounted read-only.
+	// If it is empty, the pod only mounts the volume so that it gets bound.
+	Probe string
+	// Timeout is the maximum duration to wait for the consumer pod to complete.
+	Timeout time.Duration
+}
+
+// VerificationConsumerPodPolicy returns the consumer pod policy of the annotated restore invoker.
+// It returns nil if the consumer pod has not been enabled.
+func VerificationConsumerPodPolicy(annotations map[string]string) (*ConsumerPodPolicy, error) {
+	if !IsAnnotationTrue(annotations, KeyVerifyConsumerPod) {
+		return nil, nil
+	}
+	policy := &ConsumerPodPolicy{
+		Probe:   annotations[KeyVerifyConsumerProbe],
+		Timeout: DefaultConsumerPodTimeout,
+	}
+	if val, ok := annotations[KeyVerifyConsumerTimeout]; ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("annotation %q must be a positive duration", KeyVerifyConsumerTimeout)
+		}
+		policy.Timeout = d
+	}
+	return policy, nil
+}
+
+var jobHookKeys = map[string]string{
+	apis.PreBackupHook:   KeyPreBackupJobHook,
+	apis.PostBackupHook:  KeyPostBackupJobHook,
+	apis.PreRestoreHook:  KeyPreRestoreJobHook,
+	apis.PostRestoreHook: KeyPostRestoreJobHook,
+}
+
+// JobHook is a hook that runs a user provided pod template as a Job to completion.
+type JobHook struct {
+	Template core.PodTemplateSpec
+	Timeout  time.Duration
+}
+
+// HasJobHook returns true if a Job hook has been specified for the given hook type in the annotations of an invoker.
+func HasJobHook(annotations map[string]string, hookType string) bool {
+	key, ok := jobHookKeys[hookType]
+	if !ok {
+		return false
+	}
+	_, ok = annotations[key]
+	return ok
+}
+
+// HasAnyJobHook returns true if a Job hook has been specified for any hook type in the annotations of an invoker.
+func HasAnyJobHook(annotations map[string]string) bool {
+	for hookType := range jobHookKeys {
+		if HasJobHook(annotations, hookType) {
+			return true
+		}
+	}
+	return false
+}
+
+// JobHookFor returns the Job hook of the given hook type specified in the annotations of an invoker.
+// It returns nil if no Job hook has been specified for the hook type.
+func JobHookFor(annotations map[string]string, hookType string) (*JobHook, error) {
+	if !HasJobHook(annotations, hookType) {
+		return nil, nil
+	}
+	key := jobHookKeys[hookType]
+	hook := &JobHook{
+		Timeout: DefaultJobHookTimeout,
+	}
+	if err := json.Unmarshal([]byte(annotations[key]), &hook.Template); err != nil {
+		return nil, fmt.Errorf("invalid value for annotation %q. Reason: %v", key, err)
+	}
+	if len(hook.Template.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("annotation %q must have at least one container", key)
+	}
+	// a Job running as any ServiceAccount of the namespace would let the executor act as that ServiceAccount
+	if hook.Template.Spec.ServiceAccountName != "" || hook.Template.Spec.DeprecatedServiceAccount != "" {
+		return nil, fmt.Errorf("annotation %q must not set serviceAccountName", key)
+	}
+	if val, ok := annotations[KeyJobHookTimeout]; ok {
+		d, err := time.ParseDuration(val)
+		// the deadline of a Job is set in seconds
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("annotation %q must be a duration of at least 1s", KeyJobHookTimeout)
+		}
+		hook.Timeout = d
+	}
+	return hook, nil
+}
+
+// ValidateJobHooks verifies the Job hooks of every hook type specified in the annotations of an invoker.
+func ValidateJobHooks(annotations map[string]string) error {
+	for hookType := range jobHookKeys {
+		if _, err := JobHookFor(annotations, hookType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var hookSettingsKeys = map[string]string{
+	apis.PreBackupHook:   KeyPreBackupHookSettings,
+	apis.PostBackupHook:  KeyPostBackupHookSettings,
+	apis.PreRestoreHook:  KeyPreRestoreHookSettings,
+	apis.PostRestoreHook: KeyPostRestoreHookSettings,
+}
+
+var globalHookSettingsKeys = map[string]string{
+	apis.PreBackupHook:   KeyGlobalPreBackupHookSettings,
+	apis.PostBackupHook:  KeyGlobalPostBackupHookSettings,
+	apis.PreRestoreHook:  KeyGlobalPreRestoreHookSettings,
+	apis.PostRestoreHook: KeyGlobalPostRestoreHookSettings,
+}
+
+// HookSettings controls how many times and for how long a hook is executed.
+// A zero Timeout means the hook handler is not interrupted by Stash.
+type HookSettings struct {
+	Timeout    time.Duration
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+// HookSettingsFor returns the execution settings of the given hook type of the targets specified in the annotations of an invoker.
+func HookSettingsFor(annotations map[string]string, hookType string) (HookSettings, error) {
+	return hookSettings(annotations, hookSettingsKeys[hookType])
+}
+
+// GlobalHookSettingsFor returns the execution settings of the given global hook type specified in the annotations of an invoker.
+func GlobalHookSettingsFor(annotations map[string]string, hookType string) (HookSettings, error) {
+	return hookSettings(annotations, globalHookSettingsKeys[hookType])
+}
+
+func hookSettings(annotations map[string]string, key string) (HookSettings, error) {
+	settings := HookSettings{
+		Backoff: DefaultHookBackoff,
+	}
+	val, ok := annotations[key]
+	if key == "" || !ok {
+		return settings, nil
+	}
+
+	var raw struct {
+		Timeout    string `json:"timeout,omitempty"`
+		MaxRetries int    `json:"maxRetries,omitempty"`
+		Backoff    string `json:"backoff,omitempty"`
+	}
+	if err := json.Unmarshal([]byte(val), &raw); err != nil {
+		return settings, fmt.Errorf("invalid value for annotation %q. Reason: %v", key, err)
+	}
+	if raw.Timeout != "" {
+		d, err := time.ParseDuration(raw.Timeout)
+		if err != nil || d <= 0 {
+			return settings, fmt.Errorf("annotation %q must have a positive duration as timeout", key)
+		}
+		settings.Timeout = d
+	}
+	if raw.MaxRetries < 0 || raw.MaxRetries > MaxHookRetries {
+		return settings, fmt.Errorf("annotation %q must have a maxRetries between 0 and %d", key, MaxHookRetries)
+	}
+	settings.MaxRetries = raw.MaxRetries
+	if raw.Backoff != "" {
+		d, err := time.ParseDuration(raw.Backoff)
+		if err != nil || d <= 0 {
+			return settings, fmt.Errorf("annotation %q must have a positive duration as backoff", key)
+		}
+		settings.Backoff = d
+	}
+	return settings, nil
+}
+
+// FreezeOptions specifies how the filesystems of a target are frozen.
+type FreezeOptions struct {
+	Container string
+	Timeout   time.Duration
+}
+
+// FreezeOptionsFor returns the freeze options specified in the annotations of an invoker.
+// It returns nil if the filesystems should not be frozen.
+func FreezeOptionsFor(annotations map[string]string) (*FreezeOptions, error) {
+	val, ok := annotations[KeyFreeze]
+	if !ok {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(val)
+	if err != nil {
+		return nil, fmt.Errorf("annotation %q must be a boolean", KeyFreeze)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	opt := &FreezeOptions{
+		Container: annotations[KeyFreezeContainer],
+		Timeout:   DefaultFreezeTimeout,
+	}
+	if val, ok := annotations[KeyFreezeTimeout]; ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("annotation %q must be a duration of at least 1s", KeyFreezeTimeout)
+		}
+		opt.Timeout = d
+	}
+	return opt, nil
+}
+
+// RetentionKeepWithin returns the duration based retention rules specified in the annotations of an invoker.
+// They are applied along with the count based rules of the RetentionPolicy of the invoker.
+func RetentionKeepWithin(annotations map[string]string) (retention.KeepWithin, error) {
+	var within retention.KeepWithin
+	for _, rule := range []struct {
+		key string
+		d   *retention.Duration
+	}{
+		{KeyKeepWithin, &within.Within},
+		{KeyKeepWithinHourly, &within.Hourly},
+		{KeyKeepWithinDaily, &within.Daily},
+		{KeyKeepWithinWeekly, &within.Weekly},
+		{KeyKeepWithinMonthly, &within.Monthly},
+		{KeyKeepWithinYearly, &within.Yearly},
+	} {
+		val, ok := annotations[rule.key]
+		if !ok {
+			continue
+		}
+		d, err := retention.ParseDuration(val)
+		if err != nil {
+			return retention.KeepWithin{}, fmt.Errorf("invalid value for annotation %q. Reason: %v", rule.key, err)
+		}
+		*rule.d = d
+	}
+	return within, nil
+}
+
+// RPOFor returns the recovery point objective specified in the annotations of a BackupConfiguration.
+// The second value reports whether the annotation is present, as "0" disables the monitoring.
+func RPOFor(annotations map[string]string) (time.Duration, bool, error) {
+	val, ok := annotations[KeyRPO]
+	if !ok {
+		return 0, false, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return 0, true, fmt.Errorf("annotation %q must be a non-negative duration", KeyRPO)
+	}
+	return d, true, nil
+}
+
+const (
+	// ChecksumFromSnapshot generates the checksum manifests from the content of the snapshots.
+	ChecksumFromSnapshot = "snapshot"
+	// ChecksumFromLocal generates the checksum manifests from the backed up local files.
+	ChecksumFromLocal = "local"
+)
+
+// ChecksumManifestSource returns where the checksum manifests of the annotated backup invoker are generated from.
+// It returns an empty string if the checksum manifests are disabled.
+func ChecksumManifestSource(annotations map[string]string) (string, error) {
+	val, ok := annotations[KeyChecksumManifest]
+	if !ok {
+		return "", nil
+	}
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "true", ChecksumFromSnapshot:
+		return ChecksumFromSnapshot, nil
+	case ChecksumFromLocal:
+		return ChecksumFromLocal, nil
+	case "false":
+		return "", nil
+	}
+	return "", fmt.Errorf("annotation %q must be either %q, %q, %q or %q", KeyChecksumManifest, "true", ChecksumFromSnapshot, ChecksumFromLocal, "false")
+}
+
+// BackupHistoryRecords returns the number of completed sessions kept in the durable backup history of an invoker.
+func BackupHistoryRecords(annotations map[string]string) (int, error) {
+	val, ok := annotations[KeyBackupHistoryRecords]
+	if !ok {
+		return DefaultBackupHistoryRecords, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("annotation %q must be a non-negative integer", KeyBackupHistoryRecords)
+	}
+	return n, nil
+}
+
+// PointInTime selects the snapshots to restore by time instead of by ID.
+type PointInTime struct {
+	// Time is the newest time a selected snapshot may have been taken at.
+	Time time.Time
+	// Tags are the tags a selected snapshot must have.
+	Tags []string
+}
+
+// PointInTimeFor returns the point in time specified in the annotations of a restore invoker.
+// It returns nil if the restore should use the latest snapshots.
+func PointInTimeFor(annotations map[string]string) (*PointInTime, error) {
+	val, ok := annotations[KeyRestoreTime]
+	if !ok {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(val))
+	if err != nil {
+		return nil, fmt.Errorf("annotation %q must be a RFC3339 time", KeyRestoreTime)
+	}
+	pit := &PointInTime{Time: t}
+	for _, tag := range strings.Split(annotations[KeyRestoreTags], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			pit.Tags = append(pit.Tags, tag)
+		}
+	}
+	return pit, nil
+}
+
+// ConflictPolicy specifies how a restore treats the files that already exist in the target.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyOverwrite overwrites the existing files. This is how restic restores.
+	ConflictPolicyOverwrite ConflictPolicy = "Overwrite"
+	// ConflictPolicySkipExisting keeps the existing files and restores only the missing ones.
+	ConflictPolicySkipExisting ConflictPolicy = "SkipExisting"
+	// ConflictPolicyOverwriteIfNewer overwrites an existing file only if the restored one has been modified later.
+	ConflictPolicyOverwriteIfNewer ConflictPolicy = "OverwriteIfNewer"
+	// ConflictPolicySibling restores each path into a sibling directory ("<path>.restored") leaving the path untouched.
+	ConflictPolicySibling ConflictPolicy = "Sibling"
+	// ConflictPolicyMirror overwrites the existing files and deletes the files that are not in the snapshot.
+	ConflictPolicyMirror ConflictPolicy = "Mirror"
+)
+
+// KeepsExistingFiles returns true if the policy may keep an existing file instead of the one from the snapshot.
+func (p ConflictPolicy) KeepsExistingFiles() bool {
+	return p == ConflictPolicySkipExisting || p == ConflictPolicyOverwriteIfNewer
+}
+
+// ConflictPolicyFor returns the conflict policy specified in the annotations of a restore invoker.
+func ConflictPolicyFor(annotations map[string]string) (ConflictPolicy, error) {
+	val, ok := annotations[KeyConflictPolicy]
+	if !ok {
+		return ConflictPolicyOverwrite, nil
+	}
+	for _, p := range []ConflictPolicy{
+		ConflictPolicyOverwrite,
+		ConflictPolicySkipExisting,
+		ConflictPolicyOverwriteIfNewer,
+		ConflictPolicySibling,
+		ConflictPolicyMirror,
+	} {
+		if strings.EqualFold(strings.TrimSpace(val), string(p)) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("annotation %q must be one of Overwrite, SkipExisting, OverwriteIfNewer, Sibling or Mirror", KeyConflictPolicy)
+}
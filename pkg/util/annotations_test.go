@@ -0,0 +1,265 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+func TestVerificationPolicy(t *testing.T) {
+	claim := `{"metadata":{"name":"scratch"},"spec":{"accessModes":["ReadWriteOnce"]}}`
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		// expected
+		enabled  bool
+		every    int
+		interval time.Duration
+		timeout  time.Duration
+		hook     bool
+		err      bool
+	}{
+		{name: "disabled", annotations: map[string]string{KeyVerifyVolumeClaimTemplate: claim}},
+		{name: "every", annotations: map[string]string{KeyVerifyEvery: "3", KeyVerifyVolumeClaimTemplate: claim}, enabled: true, every: 3},
+		{name: "interval with timeout", annotations: map[string]string{KeyVerifyInterval: "24h", KeyVerifyTimeout: "30m", KeyVerifyVolumeClaimTemplate: claim}, enabled: true, interval: 24 * time.Hour, timeout: 30 * time.Minute},
+		{name: "hook", annotations: map[string]string{KeyVerifyEvery: "1", KeyVerifyVolumeClaimTemplate: claim, KeyVerifyHook: `{"exec":{"command":["true"]}}`}, enabled: true, every: 1, hook: true},
+		{name: "zero every", annotations: map[string]string{KeyVerifyEvery: "0", KeyVerifyVolumeClaimTemplate: claim}, err: true},
+		{name: "invalid interval", annotations: map[string]string{KeyVerifyInterval: "1 day", KeyVerifyVolumeClaimTemplate: claim}, err: true},
+		{name: "negative timeout", annotations: map[string]string{KeyVerifyEvery: "1", KeyVerifyTimeout: "-1m", KeyVerifyVolumeClaimTemplate: claim}, err: true},
+		{name: "missing volume claim template", annotations: map[string]string{KeyVerifyEvery: "1"}, err: true},
+		{name: "invalid volume claim template", annotations: map[string]string{KeyVerifyEvery: "1", KeyVerifyVolumeClaimTemplate: "scratch"}, err: true},
+		{name: "invalid hook", annotations: map[string]string{KeyVerifyEvery: "1", KeyVerifyVolumeClaimTemplate: claim, KeyVerifyHook: "true"}, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy, err := VerificationPolicy(c.annotations)
+			if (err != nil) != c.err {
+				t.Fatalf("expected error %v, found %v", c.err, err)
+			}
+			if c.err {
+				return
+			}
+			if (policy != nil) != c.enabled {
+				t.Fatalf("expected enabled %v, found %+v", c.enabled, policy)
+			}
+			if policy == nil {
+				return
+			}
+			if policy.EverySessions != c.every || policy.Interval != c.interval {
+				t.Errorf("expected every %d and interval %s, found %d and %s", c.every, c.interval, policy.EverySessions, policy.Interval)
+			}
+			if (c.timeout == 0) != (policy.Timeout == nil) || (policy.Timeout != nil && policy.Timeout.Duration != c.timeout) {
+				t.Errorf("expected timeout %s, found %v", c.timeout, policy.Timeout)
+			}
+			if (policy.Hook != nil) != c.hook {
+				t.Errorf("expected hook %v, found %v", c.hook, policy.Hook)
+			}
+			if policy.VolumeClaimTemplate.Name != "scratch" {
+				t.Errorf("expected the volume claim template to be parsed, found %+v", policy.VolumeClaimTemplate)
+			}
+		})
+	}
+}
+
+func TestVerificationConsumerPodPolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    *ConsumerPodPolicy
+		err         bool
+	}{
+		{name: "no annotations"},
+		{name: "disabled", annotations: map[string]string{KeyVerifyConsumerPod: "false", KeyVerifyConsumerProbe: "ls /restored-data"}},
+		{name: "enabled", annotations: map[string]string{KeyVerifyConsumerPod: "true"}, expected: &ConsumerPodPolicy{Timeout: DefaultConsumerPodTimeout}},
+		{name: "enabled case insensitively", annotations: map[string]string{KeyVerifyConsumerPod: " True "}, expected: &ConsumerPodPolicy{Timeout: DefaultConsumerPodTimeout}},
+		{
+			name:        "probe with timeout",
+			annotations: map[string]string{KeyVerifyConsumerPod: "true", KeyVerifyConsumerProbe: "ls /restored-data", KeyVerifyConsumerTimeout: "2m"},
+			expected:    &ConsumerPodPolicy{Probe: "ls /restored-data", Timeout: 2 * time.Minute},
+		},
+		{name: "zero timeout", annotations: map[string]string{KeyVerifyConsumerPod: "true", KeyVerifyConsumerTimeout: "0s"}, err: true},
+		{name: "negative timeout", annotations: map[string]string{KeyVerifyConsumerPod: "true", KeyVerifyConsumerTimeout: "-1m"}, err: true},
+		{name: "invalid timeout", annotations: map[string]string{KeyVerifyConsumerPod: "true", KeyVerifyConsumerTimeout: "ten minutes"}, err: true},
+		{name: "invalid timeout while disabled", annotations: map[string]string{KeyVerifyConsumerTimeout: "ten minutes"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy, err := VerificationConsumerPodPolicy(c.annotations)
+			if (err != nil) != c.err {
+				t.Fatalf("expected error %v, found %v", c.err, err)
+			}
+			if !reflect.DeepEqual(policy, c.expected) {
+				t.Errorf("expected %+v, found %+v", c.expected, policy)
+			}
+		})
+	}
+}
+
+func TestChecksumManifestSource(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+		err         bool
+	}{
+		{name: "not set"},
+		{name: "from snapshot", annotations: map[string]string{KeyChecksumManifest: "true"}, expected: ChecksumFromSnapshot},
+		{name: "from local files", annotations: map[string]string{KeyChecksumManifest: "Local"}, expected: ChecksumFromLocal},
+		{name: "disabled", annotations: map[string]string{KeyChecksumManifest: "false"}},
+		{name: "explicitly from snapshot", annotations: map[string]string{KeyChecksumManifest: "snapshot"}, expected: ChecksumFromSnapshot},
+		{name: "invalid", annotations: map[string]string{KeyChecksumManifest: "remote"}, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			source, err := ChecksumManifestSource(c.annotations)
+			if (err != nil) != c.err {
+				t.Fatalf("expected error %v, found %v", c.err, err)
+			}
+			if source != c.expected {
+				t.Errorf("expected %q, found %q", c.expected, source)
+			}
+		})
+	}
+}
+
+func TestIsVerificationDue(t *testing.T) {
+	now := time.Date(2021, time.March, 14, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		policy   RestoreVerificationPolicy
+		state    RestoreVerificationState
+		expected bool
+	}{
+		{name: "every session", policy: RestoreVerificationPolicy{EverySessions: 1}, expected: true},
+		{name: "every third session, first one", policy: RestoreVerificationPolicy{EverySessions: 3}, expected: false},
+		{name: "every third session, third one", policy: RestoreVerificationPolicy{EverySessions: 3}, state: RestoreVerificationState{SessionsSinceVerification: 2}, expected: true},
+		{name: "interval, never verified", policy: RestoreVerificationPolicy{Interval: time.Hour}, expected: true},
+		{name: "interval, verified recently", policy: RestoreVerificationPolicy{Interval: time.Hour}, state: RestoreVerificationState{LastVerificationTime: metav1.NewTime(now.Add(-time.Minute))}, expected: false},
+		{name: "interval, verified long ago", policy: RestoreVerificationPolicy{Interval: time.Hour}, state: RestoreVerificationState{LastVerificationTime: metav1.NewTime(now.Add(-time.Hour))}, expected: true},
+		{
+			name:     "either of them",
+			policy:   RestoreVerificationPolicy{EverySessions: 5, Interval: time.Hour},
+			state:    RestoreVerificationState{SessionsSinceVerification: 1, LastVerificationTime: metav1.NewTime(now.Add(-2 * time.Hour))},
+			expected: true,
+		},
+		{name: "disabled", state: RestoreVerificationState{SessionsSinceVerification: 10}, expected: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if due := c.policy.IsVerificationDue(c.state, now); due != c.expected {
+				t.Errorf("expected due %v, found %v", c.expected, due)
+			}
+		})
+	}
+}
+
+func TestJobHookFor(t *testing.T) {
+	template := `{"spec":{"containers":[{"name":"hook","image":"busybox"}]}}`
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		// expected
+		found     bool
+		timeout   time.Duration
+		expectErr bool
+	}{
+		{name: "no hook", annotations: map[string]string{KeyPostBackupJobHook: template}, found: false},
+		{name: "default timeout", annotations: map[string]string{KeyPreBackupJobHook: template}, found: true, timeout: DefaultJobHookTimeout},
+		{name: "timeout", annotations: map[string]string{KeyPreBackupJobHook: template, KeyJobHookTimeout: "90s"}, found: true, timeout: 90 * time.Second},
+		{name: "one second timeout", annotations: map[string]string{KeyPreBackupJobHook: template, KeyJobHookTimeout: "1s"}, found: true, timeout: time.Second},
+		{name: "sub-second timeout", annotations: map[string]string{KeyPreBackupJobHook: template, KeyJobHookTimeout: "500ms"}, expectErr: true},
+		{name: "invalid timeout", annotations: map[string]string{KeyPreBackupJobHook: template, KeyJobHookTimeout: "soon"}, expectErr: true},
+		{name: "invalid template", annotations: map[string]string{KeyPreBackupJobHook: "busybox"}, expectErr: true},
+		{name: "no container", annotations: map[string]string{KeyPreBackupJobHook: `{"spec":{}}`}, expectErr: true},
+		{
+			name:        "service account",
+			annotations: map[string]string{KeyPreBackupJobHook: `{"spec":{"serviceAccountName":"admin","containers":[{"name":"hook","image":"busybox"}]}}`},
+			expectErr:   true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hook, err := JobHookFor(c.annotations, apis.PreBackupHook)
+			if (err != nil) != c.expectErr {
+				t.Fatalf("expected error %v, found %v", c.expectErr, err)
+			}
+			if (hook != nil) != c.found {
+				t.Fatalf("expected hook %v, found %+v", c.found, hook)
+			}
+			if hook != nil && hook.Timeout != c.timeout {
+				t.Errorf("expected timeout %s, found %s", c.timeout, hook.Timeout)
+			}
+		})
+	}
+}
+
+func TestBackupDependencies(t *testing.T) {
+	cases := []struct {
+		name      string
+		value     string
+		expected  []kmapi.ObjectReference
+		expectErr bool
+	}{
+		{name: "empty", value: " "},
+		{name: "same namespace", value: "db", expected: []kmapi.ObjectReference{{Namespace: "demo", Name: "db"}}},
+		{
+			name:     "list",
+			value:    "db, storage/minio ,",
+			expected: []kmapi.ObjectReference{{Namespace: "demo", Name: "db"}, {Namespace: "storage", Name: "minio"}},
+		},
+		{name: "missing name", value: "storage/", expectErr: true},
+		{name: "missing namespace", value: "/minio", expectErr: true},
+		{name: "too many parts", value: "a/b/c", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := BackupDependencies(map[string]string{KeyDependsOn: c.value}, "demo")
+			if (err != nil) != c.expectErr {
+				t.Fatalf("expected error %v, found %v", c.expectErr, err)
+			}
+			if !reflect.DeepEqual(deps, c.expected) {
+				t.Errorf("expected %v, found %v", c.expected, deps)
+			}
+		})
+	}
+}
+
+func TestDependencyFreshness(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		expected    time.Duration
+		expectErr   bool
+	}{
+		{expected: DefaultDependencyFreshness},
+		{annotations: map[string]string{KeyDependencyFreshness: "2h"}, expected: 2 * time.Hour},
+		{annotations: map[string]string{KeyDependencyFreshness: "0s"}, expectErr: true},
+		{annotations: map[string]string{KeyDependencyFreshness: "a day"}, expectErr: true},
+	}
+	for _, c := range cases {
+		d, err := DependencyFreshness(c.annotations)
+		if (err != nil) != c.expectErr || d != c.expected {
+			t.Errorf("expected %s (error %v) for %v, found %s (%v)", c.expected, c.expectErr, c.annotations, d, err)
+		}
+	}
+}
@@ -162,6 +162,8 @@ func pvcBackupFunction(image docker.Docker) *api_v1beta1.Function {
 				"--retention-prune=${RETENTION_PRUNE:=false}",
 				"--retention-dry-run=${RETENTION_DRY_RUN:=false}",
 				"--output-dir=${outputDir:=}",
+				"--metrics-enabled=true",
+				"--pushgateway-url=${PROMETHEUS_PUSHGATEWAY_URL:=}",
 				fmt.Sprintf("--scratch-dir=%s", restic.DefaultScratchDir),
 			},
 			VolumeMounts: []core.VolumeMount{
@@ -205,6 +207,8 @@ func pvcRestoreFunction(image docker.Docker) *api_v1beta1.Function {
 				"--target-kind=${TARGET_KIND:=}",
 				"--target-name=${TARGET_NAME:=}",
 				"--target-namespace=${TARGET_NAMESPACE:=}",
+				"--metrics-enabled=true",
+				"--pushgateway-url=${PROMETHEUS_PUSHGATEWAY_URL:=}",
 				fmt.Sprintf("--scratch-dir=%s", restic.DefaultScratchDir),
 			},
 			VolumeMounts: []core.VolumeMount{
@@ -27,6 +27,7 @@ import (
 	v1beta1_api "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 
 	"gomodules.xyz/pointer"
+	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -290,6 +291,53 @@ func WaitUntilPVCReady(c kubernetes.Interface, meta metav1.ObjectMeta) error {
 	})
 }
 
+// WaitUntilPodCompleted waits for the pod to succeed or fail and returns its final phase.
+func WaitUntilPodCompleted(c kubernetes.Interface, meta metav1.ObjectMeta, timeout time.Duration) (core.PodPhase, error) {
+	var phase core.PodPhase
+	err := wait.PollUntilContextTimeout(context.Background(), apis.RetryInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		if obj, err := c.CoreV1().Pods(meta.Namespace).Get(ctx, meta.Name, metav1.GetOptions{}); err == nil {
+			phase = obj.Status.Phase
+			return phase == core.PodSucceeded || phase == core.PodFailed, nil
+		}
+		return false, nil
+	})
+	return phase, err
+}
+
+// WaitUntilJobCompleted waits for the Job to succeed or fail and returns its final state.
+func WaitUntilJobCompleted(c kubernetes.Interface, meta metav1.ObjectMeta, timeout time.Duration) (*batch.Job, error) {
+	var job *batch.Job
+	err := wait.PollUntilContextTimeout(context.Background(), apis.RetryInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		obj, err := c.BatchV1().Jobs(meta.Namespace).Get(ctx, meta.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		job = obj
+		return IsJobCompleted(job), nil
+	})
+	return job, err
+}
+
+// IsJobCompleted returns true if the Job has either succeeded or failed.
+func IsJobCompleted(job *batch.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if (cond.Type == batch.JobComplete || cond.Type == batch.JobFailed) && cond.Status == core.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// IsJobSucceeded returns true if the Job has completed successfully.
+func IsJobSucceeded(job *batch.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batch.JobComplete && cond.Status == core.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
 func CheckIfNamespaceExists(kubeClient kubernetes.Interface, ns string) error {
 	if ns == "" {
 		return nil
@@ -17,11 +17,15 @@ limitations under the License.
 package util
 
 import (
+	"context"
 	"reflect"
 	"sort"
 
 	v1beta1_api "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
 	v1beta1_listers "stash.appscode.dev/apimachinery/client/listers/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -99,11 +103,13 @@ func FindRestoreSession(lister v1beta1_listers.RestoreSessionLister, targetRef v
 	}
 
 	result := make([]unstructured.Unstructured, 0)
-	// keep only those RestoreSession that has this workload as target
+	// keep only those RestoreSession that has this workload as target. the previewed RestoreSessions
+	// do not restore anything, so they are skipped.
 	for _, rs := range restoreSessions {
 		if rs.DeletionTimestamp == nil &&
 			IsRestoreTarget(rs.Spec.Target, targetRef, rs.Namespace) &&
-			rs.Spec.Driver == v1beta1_api.ResticSnapshotter {
+			rs.Spec.Driver == v1beta1_api.ResticSnapshotter &&
+			!IsAnnotationTrue(rs.Annotations, KeyRestorePreview) {
 			rs.GetObjectKind().SetGroupVersionKind(v1beta1_api.SchemeGroupVersion.WithKind(v1beta1_api.ResourceKindRestoreSession))
 			u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(rs)
 			if err != nil {
@@ -137,3 +143,34 @@ func RestoreSessionEqual(old, new *v1beta1_api.RestoreSession) bool {
 	// user may update existing RestoreSession spec. so, we need to compare new and old specification
 	return reflect.DeepEqual(oldSpec, newSpec)
 }
+
+// UpdateRestoreInvokerAnnotations updates the annotations of a RestoreSession or a RestoreBatch.
+func UpdateRestoreInvokerAnnotations(stashClient cs.Interface, inv invoker.RestoreInvoker, transform func(map[string]string) map[string]string) error {
+	objMeta := inv.GetObjectMeta()
+	var err error
+	switch inv.GetTypeMeta().Kind {
+	case v1beta1_api.ResourceKindRestoreSession:
+		_, err = v1beta1_util.TryUpdateRestoreSession(
+			context.TODO(),
+			stashClient.StashV1beta1(),
+			objMeta,
+			func(in *v1beta1_api.RestoreSession) *v1beta1_api.RestoreSession {
+				in.Annotations = transform(in.Annotations)
+				return in
+			},
+			metav1.UpdateOptions{},
+		)
+	case v1beta1_api.ResourceKindRestoreBatch:
+		_, err = v1beta1_util.TryUpdateRestoreBatch(
+			context.TODO(),
+			stashClient.StashV1beta1(),
+			objMeta,
+			func(in *v1beta1_api.RestoreBatch) *v1beta1_api.RestoreBatch {
+				in.Annotations = transform(in.Annotations)
+				return in
+			},
+			metav1.UpdateOptions{},
+		)
+	}
+	return err
+}
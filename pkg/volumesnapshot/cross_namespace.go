@@ -0,0 +1,187 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumesnapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	api_v1alpha1 "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/stash/pkg/util"
+
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"kmodules.xyz/client-go/meta"
+)
+
+const kindVolumeSnapshot = "VolumeSnapshot"
+
+// CrossNamespaceSources returns the VolumeSnapshots of other namespaces that the PVCs refer to through their dataSourceRef.
+func CrossNamespaceSources(pvcs []core.PersistentVolumeClaim, namespace string) []types.NamespacedName {
+	var sources []types.NamespacedName
+	found := make(map[types.NamespacedName]bool)
+	for _, pvc := range pvcs {
+		if src, ok := crossNamespaceSource(pvc, namespace); ok && !found[src] {
+			found[src] = true
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+func crossNamespaceSource(pvc core.PersistentVolumeClaim, namespace string) (types.NamespacedName, bool) {
+	ref := pvc.Spec.DataSourceRef
+	if ref == nil || ref.Kind != kindVolumeSnapshot || ref.Namespace == nil || *ref.Namespace == "" || *ref.Namespace == namespace {
+		return types.NamespacedName{}, false
+	}
+	if ref.APIGroup == nil || *ref.APIGroup != vsapi.GroupName {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: *ref.Namespace, Name: ref.Name}, true
+}
+
+// UsageAllowed returns true if the VolumeSnapshot can be restored in the given namespace. The usage policy of the
+// VolumeSnapshot is read from its annotation and follows the same rules as the usage policy of a Repository.
+// A VolumeSnapshot without a usage policy can only be restored in its own namespace.
+func UsageAllowed(vs *vsapi.VolumeSnapshot, namespace *core.Namespace) (bool, error) {
+	repo := &api_v1alpha1.Repository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      vs.Name,
+			Namespace: vs.Namespace,
+		},
+	}
+	if val, ok := vs.Annotations[util.KeyUsagePolicy]; ok {
+		repo.Spec.UsagePolicy = &api_v1alpha1.UsagePolicy{}
+		if err := json.Unmarshal([]byte(val), repo.Spec.UsagePolicy); err != nil {
+			return false, fmt.Errorf("invalid value for annotation %q of VolumeSnapshot %s/%s. Reason: %v", util.KeyUsagePolicy, vs.Namespace, vs.Name, err)
+		}
+	}
+	return repo.UsageAllowed(namespace), nil
+}
+
+// BindingName returns the name of the VolumeSnapshot that binds the source VolumeSnapshot into another namespace.
+func BindingName(source types.NamespacedName) string {
+	return meta.ValidNameWithPrefixNSuffix("stash", source.Namespace, source.Name)
+}
+
+// bindingContentName returns the name of the pre-provisioned VolumeSnapshotContent of a binding.
+// It includes the namespace of the binding as the VolumeSnapshotContents are cluster scoped.
+func bindingContentName(source types.NamespacedName, namespace string) string {
+	return meta.ValidNameWithPrefixNSuffix("stash-"+namespace, source.Namespace, source.Name)
+}
+
+// EnsureCrossNamespaceBinding makes the source VolumeSnapshot available in the given namespace. A pre-provisioned
+// VolumeSnapshotContent is created for the snapshot handle of the source and a VolumeSnapshot is bound to it.
+// The VolumeSnapshotContent retains the snapshot so that removing the binding never deletes the source data.
+func EnsureCrossNamespaceBinding(vsClient vscs.Interface, source types.NamespacedName, namespace string, owner *metav1.OwnerReference, labels map[string]string) error {
+	vs, err := vsClient.SnapshotV1().VolumeSnapshots(source.Namespace).Get(context.TODO(), source.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if vs.Status == nil || vs.Status.ReadyToUse == nil || !*vs.Status.ReadyToUse || vs.Status.BoundVolumeSnapshotContentName == nil {
+		return fmt.Errorf("VolumeSnapshot %s/%s is not ready to use", source.Namespace, source.Name)
+	}
+	content, err := vsClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if content.Status == nil || content.Status.SnapshotHandle == nil {
+		return fmt.Errorf("VolumeSnapshotContent %s has no snapshot handle", content.Name)
+	}
+
+	name := BindingName(source)
+	binding := &vsapi.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   bindingContentName(source, namespace),
+			Labels: labels,
+		},
+		Spec: vsapi.VolumeSnapshotContentSpec{
+			VolumeSnapshotRef: core.ObjectReference{
+				Namespace: namespace,
+				Name:      name,
+			},
+			DeletionPolicy:          vsapi.VolumeSnapshotContentRetain,
+			Driver:                  content.Spec.Driver,
+			VolumeSnapshotClassName: content.Spec.VolumeSnapshotClassName,
+			Source: vsapi.VolumeSnapshotContentSource{
+				SnapshotHandle: content.Status.SnapshotHandle,
+			},
+			SourceVolumeMode: content.Spec.SourceVolumeMode,
+		},
+	}
+	_, err = vsClient.SnapshotV1().VolumeSnapshotContents().Create(context.TODO(), binding, metav1.CreateOptions{})
+	if err != nil && !kerr.IsAlreadyExists(err) {
+		return err
+	}
+
+	bound := &vsapi.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: vsapi.VolumeSnapshotSpec{
+			VolumeSnapshotClassName: content.Spec.VolumeSnapshotClassName,
+			Source: vsapi.VolumeSnapshotSource{
+				VolumeSnapshotContentName: &binding.Name,
+			},
+		},
+	}
+	if owner != nil {
+		bound.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	_, err = vsClient.SnapshotV1().VolumeSnapshots(namespace).Create(context.TODO(), bound, metav1.CreateOptions{})
+	if err != nil && !kerr.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// DeleteCrossNamespaceBinding removes the VolumeSnapshot and the pre-provisioned VolumeSnapshotContent created by
+// EnsureCrossNamespaceBinding. The VolumeSnapshotContent retains the snapshot, so the source data is kept.
+func DeleteCrossNamespaceBinding(vsClient vscs.Interface, source types.NamespacedName, namespace string) error {
+	err := vsClient.SnapshotV1().VolumeSnapshots(namespace).Delete(context.TODO(), BindingName(source), metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	err = vsClient.SnapshotV1().VolumeSnapshotContents().Delete(context.TODO(), bindingContentName(source, namespace), metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// ResolveCrossNamespaceDataSource replaces a VolumeSnapshot of another namespace in the dataSourceRef of the PVC
+// with the VolumeSnapshot that binds it into the namespace of the PVC.
+func ResolveCrossNamespaceDataSource(pvc *core.PersistentVolumeClaim) {
+	source, ok := crossNamespaceSource(*pvc, pvc.Namespace)
+	if !ok {
+		return
+	}
+	apiGroup := vsapi.GroupName
+	pvc.Spec.DataSource = &core.TypedLocalObjectReference{
+		APIGroup: &apiGroup,
+		Kind:     kindVolumeSnapshot,
+		Name:     BindingName(source),
+	}
+	pvc.Spec.DataSourceRef = nil
+}
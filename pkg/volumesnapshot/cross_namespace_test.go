@@ -0,0 +1,176 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumesnapshot
+
+import (
+	"context"
+	"testing"
+
+	"stash.appscode.dev/stash/pkg/util"
+
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	vs_fake "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned/fake"
+	type_util "gomodules.xyz/pointer"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamic_fake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestUsageAllowed(t *testing.T) {
+	testCases := []struct {
+		description string
+		policy      string
+		namespace   core.Namespace
+		allowed     bool
+	}{
+		{
+			description: "No policy, same namespace",
+			namespace:   core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod"}},
+			allowed:     true,
+		},
+		{
+			description: "No policy, other namespace",
+			namespace:   core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}},
+			allowed:     false,
+		},
+		{
+			description: "All namespaces",
+			policy:      `{"allowedNamespaces":{"from":"All"}}`,
+			namespace:   core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}},
+			allowed:     true,
+		},
+		{
+			description: "Selected namespace",
+			policy:      `{"allowedNamespaces":{"from":"Selector","selector":{"matchLabels":{"env":"staging"}}}}`,
+			namespace:   core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging", Labels: map[string]string{"env": "staging"}}},
+			allowed:     true,
+		},
+		{
+			description: "Not selected namespace",
+			policy:      `{"allowedNamespaces":{"from":"Selector","selector":{"matchLabels":{"env":"staging"}}}}`,
+			namespace:   core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}},
+			allowed:     false,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.description, func(t *testing.T) {
+			vs := &vsapi.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Name: "data-db-0-1700000000", Namespace: "prod"}}
+			if test.policy != "" {
+				vs.Annotations = map[string]string{util.KeyUsagePolicy: test.policy}
+			}
+			allowed, err := UsageAllowed(vs, &test.namespace)
+			if err != nil {
+				t.Fatalf("Failed to evaluate usage policy. Reason: %v", err)
+			}
+			if allowed != test.allowed {
+				t.Errorf("Expected allowed: %v but found: %v", test.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestResolveCrossNamespaceDataSource(t *testing.T) {
+	pvc := core.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data-db-0", Namespace: "staging"},
+		Spec: core.PersistentVolumeClaimSpec{
+			DataSourceRef: &core.TypedObjectReference{
+				APIGroup:  type_util.StringP(vsapi.GroupName),
+				Kind:      "VolumeSnapshot",
+				Name:      "data-db-0-1700000000",
+				Namespace: type_util.StringP("prod"),
+			},
+		},
+	}
+
+	sources := CrossNamespaceSources([]core.PersistentVolumeClaim{pvc, pvc}, pvc.Namespace)
+	if len(sources) != 1 || sources[0].Namespace != "prod" || sources[0].Name != "data-db-0-1700000000" {
+		t.Fatalf("Unexpected cross namespace sources: %v", sources)
+	}
+
+	ResolveCrossNamespaceDataSource(&pvc)
+	if pvc.Spec.DataSourceRef != nil {
+		t.Errorf("dataSourceRef should be removed")
+	}
+	if pvc.Spec.DataSource == nil || pvc.Spec.DataSource.Name != BindingName(sources[0]) {
+		t.Errorf("Expected dataSource %s but found %v", BindingName(sources[0]), pvc.Spec.DataSource)
+	}
+}
+
+func TestDeleteCrossNamespaceBinding(t *testing.T) {
+	source := types.NamespacedName{Namespace: "prod", Name: "data-db-0-1700000000"}
+	vsClient := vs_fake.NewSimpleClientset(
+		&vsapi.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Name: BindingName(source), Namespace: "staging"}},
+		&vsapi.VolumeSnapshotContent{ObjectMeta: metav1.ObjectMeta{Name: bindingContentName(source, "staging")}},
+	)
+
+	if err := DeleteCrossNamespaceBinding(vsClient, source, "staging"); err != nil {
+		t.Fatalf("Failed to delete the binding. Reason: %v", err)
+	}
+	contents, err := vsClient.SnapshotV1().VolumeSnapshotContents().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := vsClient.SnapshotV1().VolumeSnapshots("staging").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents.Items) != 0 || len(snapshots.Items) != 0 {
+		t.Errorf("Expected the binding to be removed but found %d VolumeSnapshotContents and %d VolumeSnapshots", len(contents.Items), len(snapshots.Items))
+	}
+
+	// deleting a removed binding is a no-op
+	if err := DeleteCrossNamespaceBinding(vsClient, source, "staging"); err != nil {
+		t.Errorf("Failed to delete the removed binding. Reason: %v", err)
+	}
+}
+
+func TestEnsureReferenceGrant(t *testing.T) {
+	source := types.NamespacedName{Namespace: "prod", Name: "data-db-0-1700000000"}
+	dc := dynamic_fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		ReferenceGrantResource: "ReferenceGrantList",
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := EnsureReferenceGrant(dc, source, "staging", nil); err != nil {
+			t.Fatalf("Failed to ensure ReferenceGrant. Reason: %v", err)
+		}
+	}
+	grant, err := dc.Resource(ReferenceGrantResource).Namespace("prod").Get(context.TODO(), ReferenceGrantName(source, "staging"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get ReferenceGrant. Reason: %v", err)
+	}
+	from, _, _ := unstructured.NestedSlice(grant.Object, "spec", "from")
+	to, _, _ := unstructured.NestedSlice(grant.Object, "spec", "to")
+	if len(from) != 1 || from[0].(map[string]interface{})["namespace"] != "staging" {
+		t.Errorf("Unexpected ReferenceGrant from: %v", from)
+	}
+	if len(to) != 1 || to[0].(map[string]interface{})["name"] != source.Name {
+		t.Errorf("Unexpected ReferenceGrant to: %v", to)
+	}
+
+	if err := DeleteReferenceGrant(dc, source, "staging"); err != nil {
+		t.Fatalf("Failed to delete ReferenceGrant. Reason: %v", err)
+	}
+	if err := DeleteReferenceGrant(dc, source, "staging"); err != nil {
+		t.Errorf("Failed to delete the removed ReferenceGrant. Reason: %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumesnapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	vgsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumegroupsnapshot/v1alpha1"
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	kutil "kmodules.xyz/client-go"
+)
+
+const (
+	// LabelVolumeGroupSnapshot selects the PVCs that are snapshotted together. It is also added to the
+	// VolumeSnapshots of a VolumeGroupSnapshot so that they are handled as a group.
+	LabelVolumeGroupSnapshot = api_v1beta1.StashKey + "/volume-group-snapshot"
+	// LabelSourcePVC holds the name of the PVC that a VolumeSnapshot of a VolumeGroupSnapshot has been taken from.
+	LabelSourcePVC = api_v1beta1.StashKey + "/source-pvc"
+
+	// KindVolumeGroupSnapshot can be used as the kind of the dataSource of a restored PVC to restore it from its VolumeSnapshot in the group.
+	KindVolumeGroupSnapshot = "VolumeGroupSnapshot"
+
+	// DefaultGroupSnapshotTimeout is used when the invoker does not set a timeout.
+	DefaultGroupSnapshotTimeout = 2 * time.Hour
+
+	annDefaultGroupSnapshotClass = "groupsnapshot.storage.kubernetes.io/is-default-class"
+	resourceVolumeGroupSnapshots = "volumegroupsnapshots"
+)
+
+// IsGroupSnapshotSupported returns true if the cluster serves the VolumeGroupSnapshot API in the version used by Stash.
+// The API is detected by its group and resource, so that an error is returned if the cluster serves it only in other versions
+// instead of reporting it as missing.
+func IsGroupSnapshotSupported(vsClient vscs.Interface) (bool, error) {
+	versions, err := groupSnapshotVersions(vsClient.Discovery())
+	if err != nil {
+		return false, err
+	}
+	if len(versions) == 0 {
+		return false, nil
+	}
+	for _, v := range versions {
+		if v == vgsapi.SchemeGroupVersion.Version {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("the cluster serves %s of %s API group in versions %v, but only %s is supported", resourceVolumeGroupSnapshots, vgsapi.GroupName, versions, vgsapi.SchemeGroupVersion.Version)
+}
+
+// groupSnapshotVersions returns the versions of the VolumeGroupSnapshot API served by the cluster.
+func groupSnapshotVersions(client discovery.DiscoveryInterface) ([]string, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, group := range groups.Groups {
+		if group.Name != vgsapi.GroupName {
+			continue
+		}
+		for _, gv := range group.Versions {
+			resources, err := client.ServerResourcesForGroupVersion(gv.GroupVersion)
+			if err != nil {
+				if kerr.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			for _, r := range resources.APIResources {
+				if r.Name == resourceVolumeGroupSnapshots {
+					versions = append(versions, gv.Version)
+					break
+				}
+			}
+		}
+	}
+	return versions, nil
+}
+
+// DefaultGroupSnapshotClass returns the name of the default VolumeGroupSnapshotClass. It returns an empty string if there is none.
+func DefaultGroupSnapshotClass(vsClient vscs.Interface) (string, error) {
+	classes, err := vsClient.GroupsnapshotV1alpha1().VolumeGroupSnapshotClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, c := range classes.Items {
+		if c.Annotations[annDefaultGroupSnapshotClass] == "true" {
+			return c.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// GroupSnapshotOptions specifies a set of PVCs that should be snapshotted at the same instant.
+type GroupSnapshotOptions struct {
+	KubeClient     kubernetes.Interface
+	SnapshotClient vscs.Interface
+	Namespace      string
+	Name           string
+	ClassName      string
+	PVCNames       []string
+	// Annotations are added to the VolumeSnapshots of the group.
+	Annotations map[string]string
+	// OnTaken is called once the point-in-time snapshot of the group has been taken, before it is ready to use.
+	OnTaken func()
+	// Timeout bounds the wait for the group to be ready to use. DefaultGroupSnapshotTimeout is used if it is zero.
+	Timeout time.Duration
+}
+
+// Create takes a VolumeGroupSnapshot of the PVCs and waits for it to be ready to use.
+// It returns the name of the VolumeSnapshot of each PVC. The VolumeSnapshots are labeled with the group and the source PVC.
+func (opt GroupSnapshotOptions) Create() (map[string]string, error) {
+	// the PVCs are selected by a label unique to this group. the label is removed once the group has been snapshotted.
+	for _, pvc := range opt.PVCNames {
+		if err := opt.patchPVCLabel(pvc, fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, LabelVolumeGroupSnapshot, opt.Name)); err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		for _, pvc := range opt.PVCNames {
+			if err := opt.patchPVCLabel(pvc, fmt.Sprintf(`{"metadata":{"labels":{%q:null}}}`, LabelVolumeGroupSnapshot)); err != nil {
+				klog.Warningf("Failed to remove label %s from PVC %s/%s. Reason: %v", LabelVolumeGroupSnapshot, opt.Namespace, pvc, err)
+			}
+		}
+	}()
+
+	group := &vgsapi.VolumeGroupSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opt.Name,
+			Namespace: opt.Namespace,
+		},
+		Spec: vgsapi.VolumeGroupSnapshotSpec{
+			Source: vgsapi.VolumeGroupSnapshotSource{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{LabelVolumeGroupSnapshot: opt.Name},
+				},
+			},
+		},
+	}
+	if opt.ClassName != "" {
+		group.Spec.VolumeGroupSnapshotClassName = &opt.ClassName
+	}
+	_, err := opt.SnapshotClient.GroupsnapshotV1alpha1().VolumeGroupSnapshots(opt.Namespace).Create(context.TODO(), group, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	group, err = opt.waitUntilReady()
+	if err != nil {
+		return nil, err
+	}
+	return opt.labelMembers(group)
+}
+
+func (opt GroupSnapshotOptions) patchPVCLabel(name, patch string) error {
+	_, err := opt.KubeClient.CoreV1().PersistentVolumeClaims(opt.Namespace).Patch(context.TODO(), name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+func (opt GroupSnapshotOptions) waitUntilReady() (*vgsapi.VolumeGroupSnapshot, error) {
+	timeout := opt.Timeout
+	if timeout == 0 {
+		timeout = DefaultGroupSnapshotTimeout
+	}
+	var group *vgsapi.VolumeGroupSnapshot
+	err := wait.PollUntilContextTimeout(context.TODO(), kutil.RetryInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		obj, err := opt.SnapshotClient.GroupsnapshotV1alpha1().VolumeGroupSnapshots(opt.Namespace).Get(ctx, opt.Name, metav1.GetOptions{})
+		if err != nil {
+			if isTransient(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if obj.Status == nil {
+			return false, nil
+		}
+		if obj.Status.Error != nil && obj.Status.Error.Message != nil {
+			return false, fmt.Errorf("failed to take VolumeGroupSnapshot %s/%s. Reason: %s", opt.Namespace, opt.Name, *obj.Status.Error.Message)
+		}
+		group = obj
+		if obj.Status.CreationTime != nil && opt.OnTaken != nil {
+			opt.OnTaken()
+			opt.OnTaken = nil
+		}
+		return obj.Status.ReadyToUse != nil && *obj.Status.ReadyToUse, nil
+	})
+	if wait.Interrupted(err) {
+		return nil, fmt.Errorf("VolumeGroupSnapshot %s/%s is not ready to use within %s", opt.Namespace, opt.Name, timeout)
+	}
+	return group, err
+}
+
+// isTransient returns true if the error of a request may go away on retry. The errors that do not come
+// from the API server (i.e. a broken connection) are treated as transient as well.
+func isTransient(err error) bool {
+	var status kerr.APIStatus
+	if !errors.As(err, &status) {
+		return true
+	}
+	// the VolumeGroupSnapshot may not have reached the cache of the API server yet
+	return kerr.IsNotFound(err) ||
+		kerr.IsTimeout(err) ||
+		kerr.IsServerTimeout(err) ||
+		kerr.IsTooManyRequests(err) ||
+		kerr.IsInternalError(err) ||
+		kerr.IsServiceUnavailable(err) ||
+		kerr.IsUnexpectedServerError(err)
+}
+
+// labelMembers finds out the source PVC of each VolumeSnapshot of the group. The VolumeSnapshots of a group refer to
+// their VolumeSnapshotContent instead of the PVC. So, the PVC is found from the volume handle of the content.
+func (opt GroupSnapshotOptions) labelMembers(group *vgsapi.VolumeGroupSnapshot) (map[string]string, error) {
+	claims, err := opt.claimsByVolumeHandle()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make(map[string]string)
+	for _, ref := range group.Status.VolumeSnapshotRefList {
+		vs, err := opt.SnapshotClient.SnapshotV1().VolumeSnapshots(opt.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pvc := ""
+		if vs.Spec.Source.PersistentVolumeClaimName != nil {
+			pvc = *vs.Spec.Source.PersistentVolumeClaimName
+		} else if vs.Status != nil && vs.Status.BoundVolumeSnapshotContentName != nil {
+			content, err := opt.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			if content.Spec.Source.VolumeHandle != nil {
+				pvc = claims[*content.Spec.Source.VolumeHandle]
+			}
+		}
+		if pvc == "" {
+			klog.Warningf("Unable to find the source PVC of VolumeSnapshot %s/%s of VolumeGroupSnapshot %s", vs.Namespace, vs.Name, opt.Name)
+			continue
+		}
+
+		metadata := map[string]any{
+			"labels": map[string]string{
+				LabelVolumeGroupSnapshot: opt.Name,
+				LabelSourcePVC:           pvc,
+			},
+		}
+		if len(opt.Annotations) > 0 {
+			metadata["annotations"] = opt.Annotations
+		}
+		patch, err := json.Marshal(map[string]any{"metadata": metadata})
+		if err != nil {
+			return nil, err
+		}
+		_, err = opt.SnapshotClient.SnapshotV1().VolumeSnapshots(opt.Namespace).Patch(context.TODO(), vs.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return nil, err
+		}
+		snapshots[pvc] = vs.Name
+	}
+	return snapshots, nil
+}
+
+// claimsByVolumeHandle returns the name of the PVC bound to each CSI volume of the group.
+func (opt GroupSnapshotOptions) claimsByVolumeHandle() (map[string]string, error) {
+	claims := make(map[string]string)
+	for _, name := range opt.PVCNames {
+		pvc, err := opt.KubeClient.CoreV1().PersistentVolumeClaims(opt.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if pvc.Spec.VolumeName == "" {
+			continue
+		}
+		pv, err := opt.KubeClient.CoreV1().PersistentVolumes().Get(context.TODO(), pvc.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			if kerr.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if pv.Spec.CSI != nil {
+			claims[pv.Spec.CSI.VolumeHandle] = name
+		}
+	}
+	return claims, nil
+}
+
+// FindGroupMember returns the VolumeSnapshot of the given PVC in a VolumeGroupSnapshot.
+func FindGroupMember(vsClient vscs.Interface, namespace, group, pvc string) (string, error) {
+	list, err := vsClient.SnapshotV1().VolumeSnapshots(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s", LabelVolumeGroupSnapshot, group, LabelSourcePVC, pvc),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(list.Items) == 0 {
+		return "", kerr.NewNotFound(core.Resource("volumesnapshots"), fmt.Sprintf("%s of %s %s", pvc, KindVolumeGroupSnapshot, group))
+	}
+	return list.Items[0].Name, nil
+}
+
+// ResolveGroupDataSource replaces a VolumeGroupSnapshot dataSource of the PVC with the VolumeSnapshot of the respective PVC in the group.
+// The VolumeSnapshot taken from the PVC with the same name is used unless the PVC is annotated with the source PVC name.
+func ResolveGroupDataSource(vsClient vscs.Interface, pvc *core.PersistentVolumeClaim) error {
+	ds := pvc.Spec.DataSource
+	if ds == nil || ds.Kind != KindVolumeGroupSnapshot || ds.APIGroup == nil || *ds.APIGroup != vgsapi.GroupName {
+		return nil
+	}
+	source := pvc.Name
+	if name, ok := pvc.Annotations[LabelSourcePVC]; ok && name != "" {
+		source = name
+	}
+	vs, err := FindGroupMember(vsClient, pvc.Namespace, ds.Name, source)
+	if err != nil {
+		return err
+	}
+	apiGroup := vsapi.GroupName
+	pvc.Spec.DataSource = &core.TypedLocalObjectReference{
+		APIGroup: &apiGroup,
+		Kind:     "VolumeSnapshot",
+		Name:     vs,
+	}
+	pvc.Spec.DataSourceRef = nil
+	return nil
+}
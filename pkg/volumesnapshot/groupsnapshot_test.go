@@ -0,0 +1,294 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumesnapshot
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/stash/pkg/util"
+
+	vgsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumegroupsnapshot/v1alpha1"
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	vs_fake "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned/fake"
+	type_util "gomodules.xyz/pointer"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	kfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestIsGroupSnapshotSupported(t *testing.T) {
+	groupSnapshots := func(version string) *metav1.APIResourceList {
+		return &metav1.APIResourceList{
+			GroupVersion: vgsapi.GroupName + "/" + version,
+			APIResources: []metav1.APIResource{
+				{Name: "volumegroupsnapshots", Kind: KindVolumeGroupSnapshot, Namespaced: true},
+				{Name: "volumegroupsnapshots/status", Kind: KindVolumeGroupSnapshot, Namespaced: true},
+			},
+		}
+	}
+	cases := []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		supported bool
+		expectErr bool
+	}{
+		{name: "not served"},
+		{
+			name: "only the classes served",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: vgsapi.SchemeGroupVersion.String(),
+				APIResources: []metav1.APIResource{{Name: "volumegroupsnapshotclasses", Kind: "VolumeGroupSnapshotClass"}},
+			}},
+		},
+		{name: "v1alpha1", resources: []*metav1.APIResourceList{groupSnapshots("v1alpha1")}, supported: true},
+		{name: "v1alpha1 along with a newer version", resources: []*metav1.APIResourceList{groupSnapshots("v1beta1"), groupSnapshots("v1alpha1")}, supported: true},
+		{name: "only a newer version", resources: []*metav1.APIResourceList{groupSnapshots("v1beta1")}, expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			vsClient := vs_fake.NewSimpleClientset()
+			vsClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = c.resources
+			supported, err := IsGroupSnapshotSupported(vsClient)
+			if (err != nil) != c.expectErr {
+				t.Fatalf("expected error %v, found %v", c.expectErr, err)
+			}
+			if supported != c.supported {
+				t.Errorf("expected supported %v, found %v", c.supported, supported)
+			}
+		})
+	}
+}
+
+func TestLabelMembers(t *testing.T) {
+	const namespace = "demo"
+	pvc := func(name, volume string) runtime.Object {
+		return &core.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       core.PersistentVolumeClaimSpec{VolumeName: volume},
+		}
+	}
+	pv := func(name, handle string) runtime.Object {
+		return &core.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: core.PersistentVolumeSpec{
+				PersistentVolumeSource: core.PersistentVolumeSource{CSI: &core.CSIPersistentVolumeSource{VolumeHandle: handle}},
+			},
+		}
+	}
+	memberOf := func(name, content string) runtime.Object {
+		return &vsapi.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       vsapi.VolumeSnapshotSpec{Source: vsapi.VolumeSnapshotSource{VolumeSnapshotContentName: type_util.StringP(content)}},
+			Status:     &vsapi.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: type_util.StringP(content)},
+		}
+	}
+	content := func(name, handle string) runtime.Object {
+		return &vsapi.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       vsapi.VolumeSnapshotContentSpec{Source: vsapi.VolumeSnapshotContentSource{VolumeHandle: type_util.StringP(handle)}},
+		}
+	}
+
+	kubeClient := kfake.NewSimpleClientset(
+		pvc("data-0", "pv-0"), pv("pv-0", "handle-0"),
+		pvc("data-1", "pv-1"), pv("pv-1", "handle-1"),
+		// not bound yet
+		pvc("data-2", ""),
+	)
+	vsClient := vs_fake.NewSimpleClientset(
+		memberOf("snap-0", "content-0"), content("content-0", "handle-0"),
+		// a snapshot that still refers to its PVC
+		&vsapi.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "snap-1", Namespace: namespace},
+			Spec:       vsapi.VolumeSnapshotSpec{Source: vsapi.VolumeSnapshotSource{PersistentVolumeClaimName: type_util.StringP("data-1")}},
+		},
+		// a volume that is not one of the PVCs of the group
+		memberOf("snap-2", "content-2"), content("content-2", "handle-unknown"),
+	)
+	opt := GroupSnapshotOptions{
+		KubeClient:     kubeClient,
+		SnapshotClient: vsClient,
+		Namespace:      namespace,
+		Name:           "group-1",
+		PVCNames:       []string{"data-0", "data-1", "data-2"},
+		Annotations:    map[string]string{util.KeyUsagePolicy: `{"allowedNamespaces":{"from":"All"}}`},
+	}
+	group := &vgsapi.VolumeGroupSnapshot{
+		Status: &vgsapi.VolumeGroupSnapshotStatus{
+			VolumeSnapshotRefList: []core.ObjectReference{{Name: "snap-0"}, {Name: "snap-1"}, {Name: "snap-2"}},
+		},
+	}
+
+	snapshots, err := opt.labelMembers(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"data-0": "snap-0", "data-1": "snap-1"}
+	if !reflect.DeepEqual(snapshots, expected) {
+		t.Errorf("expected %v, found %v", expected, snapshots)
+	}
+
+	for pvc, name := range expected {
+		vs, err := vsClient.SnapshotV1().VolumeSnapshots(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vs.Labels[LabelVolumeGroupSnapshot] != "group-1" || vs.Labels[LabelSourcePVC] != pvc {
+			t.Errorf("%s: expected to be labeled with group %q and PVC %q, found %v", name, "group-1", pvc, vs.Labels)
+		}
+		if !reflect.DeepEqual(vs.Annotations, opt.Annotations) {
+			t.Errorf("%s: expected annotations %v, found %v", name, opt.Annotations, vs.Annotations)
+		}
+	}
+	unknown, err := vsClient.SnapshotV1().VolumeSnapshots(namespace).Get(context.TODO(), "snap-2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unknown.Labels) != 0 {
+		t.Errorf("snap-2: expected no label, found %v", unknown.Labels)
+	}
+}
+
+func TestWaitUntilReady(t *testing.T) {
+	const namespace = "demo"
+	resource := schema.GroupResource{Group: vgsapi.GroupName, Resource: resourceVolumeGroupSnapshots}
+	cases := []struct {
+		name   string
+		getErr error
+		ready  bool
+		// expected
+		errContains string
+	}{
+		{name: "ready", ready: true},
+		{name: "not ready", errContains: "is not ready to use within"},
+		{name: "forbidden", getErr: kerr.NewForbidden(resource, "group-1", nil), errContains: "forbidden"},
+		{name: "not found yet", getErr: kerr.NewNotFound(resource, "group-1"), errContains: "is not ready to use within"},
+		{name: "server overloaded", getErr: kerr.NewTooManyRequests("overloaded", 1), errContains: "is not ready to use within"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			vsClient := vs_fake.NewSimpleClientset(&vgsapi.VolumeGroupSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Name: "group-1", Namespace: namespace},
+				Status:     &vgsapi.VolumeGroupSnapshotStatus{ReadyToUse: type_util.BoolP(c.ready)},
+			})
+			if c.getErr != nil {
+				vsClient.PrependReactor("get", resourceVolumeGroupSnapshots, func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, c.getErr
+				})
+			}
+			opt := GroupSnapshotOptions{
+				SnapshotClient: vsClient,
+				Namespace:      namespace,
+				Name:           "group-1",
+				Timeout:        300 * time.Millisecond,
+			}
+			group, err := opt.waitUntilReady()
+			if c.errContains == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if group == nil {
+					t.Fatal("expected the VolumeGroupSnapshot, found nil")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("expected error containing %q, found %v", c.errContains, err)
+			}
+		})
+	}
+}
+
+func TestResolveGroupDataSource(t *testing.T) {
+	const namespace = "demo"
+	member := func(name, group, pvc string) runtime.Object {
+		return &vsapi.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{LabelVolumeGroupSnapshot: group, LabelSourcePVC: pvc},
+		}}
+	}
+	vsClient := vs_fake.NewSimpleClientset(
+		member("snap-0", "group-1", "data-0"),
+		member("snap-1", "group-1", "data-1"),
+		member("snap-2", "group-2", "data-0"),
+	)
+	groupSource := func(group string) *core.TypedLocalObjectReference {
+		return &core.TypedLocalObjectReference{APIGroup: type_util.StringP(vgsapi.GroupName), Kind: KindVolumeGroupSnapshot, Name: group}
+	}
+	snapshotSource := func(name string) *core.TypedLocalObjectReference {
+		return &core.TypedLocalObjectReference{APIGroup: type_util.StringP(vsapi.GroupName), Kind: "VolumeSnapshot", Name: name}
+	}
+
+	cases := []struct {
+		name        string
+		pvc         string
+		annotations map[string]string
+		dataSource  *core.TypedLocalObjectReference
+		expected    *core.TypedLocalObjectReference
+		expectErr   bool
+	}{
+		{name: "no data source", pvc: "data-0"},
+		{name: "VolumeSnapshot data source", pvc: "data-0", dataSource: snapshotSource("snap-9"), expected: snapshotSource("snap-9")},
+		{
+			name:       "other API group",
+			pvc:        "data-0",
+			dataSource: &core.TypedLocalObjectReference{APIGroup: type_util.StringP("example.com"), Kind: KindVolumeGroupSnapshot, Name: "group-1"},
+			expected:   &core.TypedLocalObjectReference{APIGroup: type_util.StringP("example.com"), Kind: KindVolumeGroupSnapshot, Name: "group-1"},
+		},
+		{name: "same PVC name", pvc: "data-1", dataSource: groupSource("group-1"), expected: snapshotSource("snap-1")},
+		{name: "other group", pvc: "data-0", dataSource: groupSource("group-2"), expected: snapshotSource("snap-2")},
+		{name: "source PVC annotation", pvc: "restored", annotations: map[string]string{LabelSourcePVC: "data-0"}, dataSource: groupSource("group-1"), expected: snapshotSource("snap-0")},
+		{name: "not a member", pvc: "data-1", dataSource: groupSource("group-2"), expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pvc := &core.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: c.pvc, Namespace: namespace, Annotations: c.annotations},
+				Spec: core.PersistentVolumeClaimSpec{
+					DataSource: c.dataSource,
+				},
+			}
+			if c.dataSource != nil {
+				pvc.Spec.DataSourceRef = &core.TypedObjectReference{APIGroup: c.dataSource.APIGroup, Kind: c.dataSource.Kind, Name: c.dataSource.Name}
+			}
+			err := ResolveGroupDataSource(vsClient, pvc)
+			if (err != nil) != c.expectErr {
+				t.Fatalf("expected error %v, found %v", c.expectErr, err)
+			}
+			if c.expectErr {
+				return
+			}
+			if !reflect.DeepEqual(pvc.Spec.DataSource, c.expected) {
+				t.Errorf("expected data source %+v, found %+v", c.expected, pvc.Spec.DataSource)
+			}
+			resolved := c.expected != nil && c.expected.Kind == "VolumeSnapshot" && c.dataSource.Kind == KindVolumeGroupSnapshot
+			if resolved && pvc.Spec.DataSourceRef != nil {
+				t.Errorf("expected the data source ref to be cleared, found %+v", pvc.Spec.DataSourceRef)
+			}
+		})
+	}
+}
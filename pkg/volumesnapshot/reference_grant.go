@@ -0,0 +1,91 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumesnapshot
+
+import (
+	"context"
+	"fmt"
+
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	meta_util "kmodules.xyz/client-go/meta"
+)
+
+// ReferenceGrantResource is the Gateway API resource that allows the PVCs of a namespace to refer to
+// the VolumeSnapshots of another namespace through their dataSourceRef.
+var ReferenceGrantResource = schema.GroupVersionResource{
+	Group:    "gateway.networking.k8s.io",
+	Version:  "v1beta1",
+	Resource: "referencegrants",
+}
+
+// ReferenceGrantName returns the name of the ReferenceGrant that allows the PVCs of the given namespace to refer to the source VolumeSnapshot.
+func ReferenceGrantName(source types.NamespacedName, namespace string) string {
+	return meta_util.ValidNameWithPrefixNSuffix("stash-"+namespace, source.Namespace, source.Name)
+}
+
+// EnsureReferenceGrant creates a ReferenceGrant in the namespace of the source VolumeSnapshot so that the PVCs of
+// the given namespace can use it as their dataSourceRef. An error is returned if the ReferenceGrant API is not installed.
+func EnsureReferenceGrant(dc dynamic.Interface, source types.NamespacedName, namespace string, labels map[string]string) error {
+	grant := &unstructured.Unstructured{}
+	grant.SetAPIVersion(ReferenceGrantResource.GroupVersion().String())
+	grant.SetKind("ReferenceGrant")
+	grant.SetName(ReferenceGrantName(source, namespace))
+	grant.SetNamespace(source.Namespace)
+	grant.SetLabels(labels)
+	grant.Object["spec"] = map[string]interface{}{
+		"from": []interface{}{
+			map[string]interface{}{
+				"group":     "",
+				"kind":      "PersistentVolumeClaim",
+				"namespace": namespace,
+			},
+		},
+		"to": []interface{}{
+			map[string]interface{}{
+				"group": vsapi.GroupName,
+				"kind":  kindVolumeSnapshot,
+				"name":  source.Name,
+			},
+		},
+	}
+
+	_, err := dc.Resource(ReferenceGrantResource).Namespace(source.Namespace).Create(context.TODO(), grant, metav1.CreateOptions{})
+	if err == nil || kerr.IsAlreadyExists(err) {
+		return nil
+	}
+	if kerr.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return fmt.Errorf("failed to create ReferenceGrant for VolumeSnapshot %s/%s. The %s API must be installed to restore from the VolumeSnapshots of other namespaces through dataSourceRef. Reason: %v",
+			source.Namespace, source.Name, ReferenceGrantResource.GroupResource(), err)
+	}
+	return err
+}
+
+// DeleteReferenceGrant deletes the ReferenceGrant created for the given namespace by EnsureReferenceGrant.
+func DeleteReferenceGrant(dc dynamic.Interface, source types.NamespacedName, namespace string) error {
+	err := dc.Resource(ReferenceGrantResource).Namespace(source.Namespace).Delete(context.TODO(), ReferenceGrantName(source, namespace), metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumesnapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/apimachinery/pkg/metrics"
+	"stash.appscode.dev/stash/pkg/util"
+
+	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	MetricsLabelVolumeSnapshot        = "volume_snapshot"
+	MetricsLabelVolumeSnapshotContent = "volume_snapshot_content"
+	MetricsLabelSnapshotHandle        = "snapshot_handle"
+)
+
+// SnapshotInfo describes a VolumeSnapshot that is ready to use.
+type SnapshotInfo struct {
+	Hostname       string `json:"hostname"`
+	VolumeSnapshot string `json:"volumeSnapshot"`
+	// Group is the VolumeGroupSnapshot that the VolumeSnapshot belongs to, if any.
+	Group          string `json:"volumeGroupSnapshot,omitempty"`
+	Content        string `json:"volumeSnapshotContent,omitempty"`
+	SnapshotHandle string `json:"snapshotHandle,omitempty"`
+	RestoreSize    int64  `json:"restoreSize,omitempty"`
+	// ReadyIn is the time taken by the VolumeSnapshot to become ready to use since it has been created.
+	ReadyIn metav1.Duration `json:"readyIn"`
+}
+
+// GetSnapshotInfo collects the information of a VolumeSnapshot that has been observed ready to use at readyAt.
+// Only a failure to read the VolumeSnapshot is returned. The fields that depend on the VolumeSnapshotContent
+// are left empty if it can not be read.
+func GetSnapshotInfo(vsClient vscs.Interface, namespace, name, hostname string, readyAt time.Time) (*SnapshotInfo, error) {
+	vs, err := vsClient.SnapshotV1().VolumeSnapshots(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	info := &SnapshotInfo{
+		Hostname:       hostname,
+		VolumeSnapshot: vs.Name,
+		Group:          vs.Labels[LabelVolumeGroupSnapshot],
+		ReadyIn:        metav1.Duration{Duration: readyAt.Sub(vs.CreationTimestamp.Time).Round(time.Second)},
+	}
+	if vs.Status == nil {
+		return info, nil
+	}
+	if vs.Status.RestoreSize != nil {
+		info.RestoreSize = vs.Status.RestoreSize.Value()
+	}
+	if vs.Status.BoundVolumeSnapshotContentName != nil {
+		info.Content = *vs.Status.BoundVolumeSnapshotContentName
+		content, err := vsClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), info.Content, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("Failed to read the snapshot handle of VolumeSnapshotContent %s. Reason: %v", info.Content, err)
+			return info, nil
+		}
+		if content.Status != nil && content.Status.SnapshotHandle != nil {
+			info.SnapshotHandle = *content.Status.SnapshotHandle
+		}
+	}
+	return info, nil
+}
+
+// RecordSnapshotInfo records the information of the VolumeSnapshots taken by a BackupSession in its annotations.
+func RecordSnapshotInfo(stashClient cs.Interface, session metav1.ObjectMeta, infos []SnapshotInfo) error {
+	if len(infos) == 0 {
+		return nil
+	}
+	_, err := v1beta1_util.TryUpdateBackupSession(
+		context.TODO(),
+		stashClient.StashV1beta1(),
+		session,
+		func(in *api_v1beta1.BackupSession) *api_v1beta1.BackupSession {
+			in.Annotations = upsertSnapshotInfo(in.Annotations, infos)
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	return err
+}
+
+// SnapshotInfoFromAnnotations returns the information of the VolumeSnapshots recorded in the annotations of a BackupSession.
+func SnapshotInfoFromAnnotations(annotations map[string]string) ([]SnapshotInfo, error) {
+	val, ok := annotations[util.KeyVolumeSnapshotStats]
+	if !ok || val == "" {
+		return nil, nil
+	}
+	var infos []SnapshotInfo
+	if err := json.Unmarshal([]byte(val), &infos); err != nil {
+		return nil, fmt.Errorf("invalid value for annotation %q. Reason: %v", util.KeyVolumeSnapshotStats, err)
+	}
+	return infos, nil
+}
+
+// upsertSnapshotInfo inserts or updates the information of the VolumeSnapshots in the annotations.
+// The jobs of the different targets of a BackupSession record their VolumeSnapshots in the same annotation.
+func upsertSnapshotInfo(annotations map[string]string, infos []SnapshotInfo) map[string]string {
+	entries, _ := SnapshotInfoFromAnnotations(annotations)
+	for _, info := range infos {
+		found := false
+		for i := range entries {
+			if entries[i].VolumeSnapshot == info.VolumeSnapshot {
+				entries[i] = info
+				found = true
+				break
+			}
+		}
+		if !found {
+			entries = append(entries, info)
+		}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return annotations
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[util.KeyVolumeSnapshotStats] = string(data)
+	return annotations
+}
+
+// WaitUntilSnapshotsTaken waits until the storage system has taken the point-in-time snapshots of the VolumeSnapshots.
+// The snapshots may not be ready to use yet. A failed VolumeSnapshot does not need to be waited for.
+func WaitUntilSnapshotsTaken(vsClient vscs.Interface, namespace string, names []string, timeout time.Duration) error {
+	return wait.PollUntilContextTimeout(context.TODO(), time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		for _, name := range names {
+			vs, err := vsClient.SnapshotV1().VolumeSnapshots(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			if vs.Status == nil || (vs.Status.CreationTime == nil && vs.Status.Error == nil) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// PushMetrics pushes the restore size and the readiness time of the VolumeSnapshots to the Pushgateway.
+// The metrics are labeled with the VolumeSnapshot, the bound VolumeSnapshotContent and the snapshot handle.
+func PushMetrics(opt metrics.MetricsOptions, labels prometheus.Labels, infos []SnapshotInfo) error {
+	if !opt.Enabled || opt.PushgatewayURL == "" || len(infos) == 0 {
+		return nil
+	}
+
+	sizes := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "stash_appscode_com",
+		Subsystem:   "backupsession",
+		Name:        "host_volume_snapshot_restore_size_bytes",
+		Help:        "Minimum size of the volume required to restore the VolumeSnapshot of a host",
+		ConstLabels: labels,
+	}, []string{metrics.MetricLabelHostname, MetricsLabelVolumeSnapshot, MetricsLabelVolumeSnapshotContent, MetricsLabelSnapshotHandle})
+	readyIn := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "stash_appscode_com",
+		Subsystem:   "backupsession",
+		Name:        "host_volume_snapshot_ready_seconds",
+		Help:        "Time taken by the VolumeSnapshot of a host to become ready to use",
+		ConstLabels: labels,
+	}, []string{metrics.MetricLabelHostname, MetricsLabelVolumeSnapshot, MetricsLabelVolumeSnapshotContent, MetricsLabelSnapshotHandle})
+
+	for _, i := range infos {
+		values := []string{i.Hostname, i.VolumeSnapshot, i.Content, i.SnapshotHandle}
+		sizes.WithLabelValues(values...).Set(float64(i.RestoreSize))
+		readyIn.WithLabelValues(values...).Set(i.ReadyIn.Duration.Seconds())
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(sizes, readyIn)
+	return push.New(opt.PushgatewayURL, opt.JobName).Gatherer(registry).Add()
+}
@@ -0,0 +1,81 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumesnapshot
+
+import (
+	"testing"
+	"time"
+
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	vsfake "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned/fake"
+	"gomodules.xyz/pointer"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSnapshotInfo(t *testing.T) {
+	created := time.Date(2021, time.March, 14, 10, 0, 0, 0, time.UTC)
+	size := resource.MustParse("1Gi")
+	vs := &vsapi.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "data-1615716000",
+			Namespace:         "demo",
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Status: &vsapi.VolumeSnapshotStatus{
+			BoundVolumeSnapshotContentName: pointer.StringP("snapcontent-1"),
+			ReadyToUse:                     pointer.TrueP(),
+			RestoreSize:                    &size,
+		},
+	}
+	content := &vsapi.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-1"},
+		Status: &vsapi.VolumeSnapshotContentStatus{
+			SnapshotHandle: pointer.StringP("handle-1"),
+		},
+	}
+
+	readyAt := created.Add(90 * time.Second)
+	info, err := GetSnapshotInfo(vsfake.NewSimpleClientset(vs, content), "demo", vs.Name, "data", readyAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ReadyIn.Duration != 90*time.Second || info.RestoreSize != size.Value() || info.SnapshotHandle != "handle-1" {
+		t.Errorf("unexpected snapshot info %+v", info)
+	}
+
+	// the VolumeSnapshotContent can not be read. only the snapshot handle is missing.
+	info, err = GetSnapshotInfo(vsfake.NewSimpleClientset(vs), "demo", vs.Name, "data", readyAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Content != "snapcontent-1" || info.SnapshotHandle != "" || info.RestoreSize != size.Value() {
+		t.Errorf("unexpected snapshot info without content %+v", info)
+	}
+}
+
+func TestUpsertSnapshotInfo(t *testing.T) {
+	annotations := upsertSnapshotInfo(nil, []SnapshotInfo{{Hostname: "a", VolumeSnapshot: "a-1"}})
+	annotations = upsertSnapshotInfo(annotations, []SnapshotInfo{{Hostname: "b", VolumeSnapshot: "b-1"}, {Hostname: "a", VolumeSnapshot: "a-1", RestoreSize: 10}})
+	infos, err := SnapshotInfoFromAnnotations(annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].RestoreSize != 10 || infos[1].VolumeSnapshot != "b-1" {
+		t.Errorf("unexpected snapshot infos %+v", infos)
+	}
+}
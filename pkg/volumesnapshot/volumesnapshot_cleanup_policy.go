@@ -18,11 +18,16 @@ package volumesnapshot
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"sort"
-	"time"
 
 	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/stash/pkg/retention"
+	"stash.appscode.dev/stash/pkg/util"
 
 	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
 	vscs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
@@ -32,9 +37,6 @@ import (
 	"k8s.io/klog/v2"
 )
 
-// Some of the code of this file has been copied from restic/restic repository.
-// ref: https://github.com/restic/restic/blob/604b18aa7426148a55f76307ca729e829ff6b61d/internal/restic/snapshot_policy.go#L152:6
-
 // isPolicyEmpty returns true if no policy has been configured (all values zero).
 func isPolicyEmpty(policy v1alpha1.RetentionPolicy) bool {
 	if policy.KeepLast > 0 ||
@@ -48,37 +50,6 @@ func isPolicyEmpty(policy v1alpha1.RetentionPolicy) bool {
 	return false
 }
 
-// ymdh returns an integer in the form YYYYMMDDHH.
-func ymdh(d time.Time, _ int) int {
-	return d.Year()*1000000 + int(d.Month())*10000 + d.Day()*100 + d.Hour()
-}
-
-// ymd returns an integer in the form YYYYMMDD.
-func ymd(d time.Time, _ int) int {
-	return d.Year()*10000 + int(d.Month())*100 + d.Day()
-}
-
-// yw returns an integer in the form YYYYWW, where WW is the week number.
-func yw(d time.Time, _ int) int {
-	year, week := d.ISOWeek()
-	return year*100 + week
-}
-
-// ym returns an integer in the form YYYYMM.
-func ym(d time.Time, _ int) int {
-	return d.Year()*100 + int(d.Month())
-}
-
-// y returns the year of d.
-func y(d time.Time, _ int) int {
-	return d.Year()
-}
-
-// always returns a unique number for d.
-func always(d time.Time, nr int) int {
-	return nr
-}
-
 type VolumeSnapshot struct {
 	VolumeSnap vsapi.VolumeSnapshot
 }
@@ -97,93 +68,234 @@ func (vs VolumeSnapshots) Swap(i, j int) {
 	vs[i], vs[j] = vs[j], vs[i]
 }
 
+// groupOf returns the VolumeGroupSnapshot that the named VolumeSnapshot belongs to.
+func (vs VolumeSnapshots) groupOf(name string) string {
+	for _, v := range vs {
+		if v.VolumeSnap.Name == name {
+			return v.VolumeSnap.Labels[LabelVolumeGroupSnapshot]
+		}
+	}
+	return ""
+}
+
+// sourcePVC returns the PVC that the VolumeSnapshot has been taken from.
+// The VolumeSnapshots of a VolumeGroupSnapshot do not refer to the PVC, so the label added by Stash is used for them.
+func sourcePVC(vs vsapi.VolumeSnapshot) string {
+	if vs.Spec.Source.PersistentVolumeClaimName != nil {
+		return *vs.Spec.Source.PersistentVolumeClaimName
+	}
+	return vs.Labels[LabelSourcePVC]
+}
+
 // ApplyRetentionPolicy do the following steps:
 // 1. sorts all the VolumeSnapshot according to CreationTimeStamp.
-// 2. then list that are to be kept and removed according to the policy.
-// 3. remove VolumeSnapshot that are not necessary according to RetentionPolicy
-func applyRetentionPolicy(policy v1alpha1.RetentionPolicy, volumeSnapshots VolumeSnapshots, namespace string, vsClient vscs.Interface) error {
+// 2. then list that are to be kept and removed according to the policy and the duration based rules.
+// 3. remove VolumeSnapshot that are not necessary according to RetentionPolicy unless it is a dry run.
+func applyRetentionPolicy(policy v1alpha1.RetentionPolicy, within retention.KeepWithin, volumeSnapshots VolumeSnapshots, namespace string, vsClient vscs.Interface) (retention.Report, error) {
 	// sorts the VolumeSnapshots according to CreationTimeStamp
 	sort.Sort(VolumeSnapshots(volumeSnapshots))
 
-	if !isPolicyEmpty(policy) {
-		return nil
+	snapshots := make([]retention.Snapshot, 0, len(volumeSnapshots))
+	for _, vs := range volumeSnapshots {
+		snapshots = append(snapshots, retention.Snapshot{
+			Name: vs.VolumeSnap.Name,
+			Time: vs.VolumeSnap.CreationTimestamp.Time,
+		})
 	}
 
-	buckets := [6]struct {
-		Count     int64
-		LastAdded func(d time.Time, nr int) int
-		Last      int
-	}{
-		{policy.KeepLast, always, -1},
-		{policy.KeepHourly, ymdh, -1},
-		{policy.KeepDaily, ymd, -1},
-		{policy.KeepWeekly, yw, -1},
-		{policy.KeepMonthly, ym, -1},
-		{policy.KeepYearly, y, -1},
-	}
-
-	var kept, removed VolumeSnapshots
-	for nr, vs := range volumeSnapshots {
-		var keepSnap bool
-		// keep VolumeSnapshot that are matched with the policy
-		for i, b := range buckets {
-			if b.Count > 0 {
-				val := b.LastAdded(vs.VolumeSnap.CreationTimestamp.Time, nr)
-				if val != b.Last {
-					keepSnap = true
-					buckets[i].Last = val
-					buckets[i].Count--
-				}
-			}
+	if !isPolicyEmpty(policy) && within.IsEmpty() {
+		report := retention.Report{Keep: make([]retention.Decision, 0), Remove: make([]retention.Decision, 0)}
+		for _, s := range snapshots {
+			report.Keep = append(report.Keep, retention.Decision{Snapshot: s.Name, Time: s.Time, Reasons: []string{"no retention rule specified"}})
 		}
+		return report, nil
+	}
 
-		if keepSnap {
-			kept = append(kept, vs)
-		} else {
-			removed = append(removed, vs)
-		}
+	report := retention.Apply(policy, within, snapshots)
+	if policy.DryRun {
+		klog.Infof("VolumeSnapshot to keep: %d to remove: %d (dry run)", len(report.Keep), len(report.Remove))
+		return report, nil
 	}
 
-	for _, vs := range removed {
-		err := vsClient.SnapshotV1().VolumeSnapshots(namespace).Delete(context.TODO(), vs.VolumeSnap.Name, metav1.DeleteOptions{})
+	for _, d := range report.Remove {
+		// the VolumeSnapshots of a VolumeGroupSnapshot are removed along with the group
+		if volumeSnapshots.groupOf(d.Snapshot) != "" {
+			continue
+		}
+		err := vsClient.SnapshotV1().VolumeSnapshots(namespace).Delete(context.TODO(), d.Snapshot, metav1.DeleteOptions{})
 		if err != nil {
 			if kerr.IsNotFound(err) {
-				return nil
+				return report, nil
 			}
-			return err
+			return report, err
 		}
 	}
 
-	klog.Infof("VolumeSnapshot kept: %d removed: %d", len(kept), len(removed))
-	return nil
+	klog.Infof("VolumeSnapshot kept: %d removed: %d", len(report.Keep), len(report.Remove))
+	return report, nil
 }
 
-func CleanupSnapshots(policy v1alpha1.RetentionPolicy, hostBackupStats []v1beta1.HostBackupStats, namespace string, vsClient vscs.Interface) error {
+// CleanupSnapshots applies the retention policy and the duration based rules on the VolumeSnapshots of each host separately.
+// It returns which VolumeSnapshots are kept and removed for each host. Nothing is deleted if the policy is a dry run.
+func CleanupSnapshots(policy v1alpha1.RetentionPolicy, within retention.KeepWithin, hostBackupStats []v1beta1.HostBackupStats, namespace string, vsClient vscs.Interface) ([]retention.Report, error) {
 	vsList, err := vsClient.SnapshotV1().VolumeSnapshots(namespace).List(context.TODO(), v1.ListOptions{})
 	if err != nil {
 		if kerr.IsNotFound(err) || len(vsList.Items) == 0 {
-			return nil
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
-	// filter VolumeSnapshots according to PVC source
-	// then apply RetentionPolicy rule
+	hostnames := make([]string, 0, len(hostBackupStats))
 	for _, host := range hostBackupStats {
-		var volumeSnapshots VolumeSnapshots
+		hostnames = append(hostnames, host.Hostname)
+	}
+	reports, err := applyRetentionPolicyPerHost(policy, within, hostnames, vsList.Items, namespace, vsClient)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.DryRun {
+		return reports, nil
+	}
+	if err := cleanupGroupSnapshots(vsList.Items, reports, namespace, vsClient); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// EvaluateRetentionPolicy reports which of the existing VolumeSnapshots of the given PVCs would be kept and removed
+// by a retention policy without taking a backup. The VolumeSnapshots of every PVC of the namespace are evaluated if
+// no PVC is given. Nothing is deleted regardless of the DryRun field of the policy.
+func EvaluateRetentionPolicy(policy v1alpha1.RetentionPolicy, within retention.KeepWithin, pvcs []string, namespace string, vsClient vscs.Interface) ([]retention.Report, error) {
+	vsList, err := vsClient.SnapshotV1().VolumeSnapshots(namespace).List(context.TODO(), v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(pvcs) == 0 {
+		seen := make(map[string]bool)
 		for _, vs := range vsList.Items {
-			var src string
-			if vs.Spec.Source.PersistentVolumeClaimName != nil {
-				src = *vs.Spec.Source.PersistentVolumeClaimName
+			if pvc := sourcePVC(vs); pvc != "" && !seen[pvc] {
+				seen[pvc] = true
+				pvcs = append(pvcs, pvc)
 			}
-			if host.Hostname == src {
+		}
+		sort.Strings(pvcs)
+	}
+	policy.DryRun = true
+	return applyRetentionPolicyPerHost(policy, within, pvcs, vsList.Items, namespace, vsClient)
+}
+
+// applyRetentionPolicyPerHost filters the VolumeSnapshots according to the source PVC, then applies the retention policy on
+// the VolumeSnapshots of each host.
+func applyRetentionPolicyPerHost(policy v1alpha1.RetentionPolicy, within retention.KeepWithin, hostnames []string, vsList []vsapi.VolumeSnapshot, namespace string, vsClient vscs.Interface) ([]retention.Report, error) {
+	reports := make([]retention.Report, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		var volumeSnapshots VolumeSnapshots
+		for _, vs := range vsList {
+			if hostname == sourcePVC(vs) {
 				volumeSnapshots = append(volumeSnapshots, VolumeSnapshot{VolumeSnap: vs})
 			}
 		}
-		err := applyRetentionPolicy(policy, volumeSnapshots, namespace, vsClient)
+		report, err := applyRetentionPolicy(policy, within, volumeSnapshots, namespace, vsClient)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		report.Hostname = hostname
+		reports = append(reports, report)
 	}
+	return reports, nil
+}
 
+// RecordRetentionReports records the result of a dry run retention policy in the annotations of a BackupSession.
+func RecordRetentionReports(stashClient cs.Interface, session metav1.ObjectMeta, reports []retention.Report) error {
+	if len(reports) == 0 {
+		return nil
+	}
+	_, err := v1beta1_util.TryUpdateBackupSession(
+		context.TODO(),
+		stashClient.StashV1beta1(),
+		session,
+		func(in *v1beta1.BackupSession) *v1beta1.BackupSession {
+			in.Annotations = upsertRetentionReports(in.Annotations, reports)
+			return in
+		},
+		metav1.UpdateOptions{},
+	)
+	return err
+}
+
+// RetentionReportsFromAnnotations returns the result of the dry run retention policy recorded in the annotations of a BackupSession.
+func RetentionReportsFromAnnotations(annotations map[string]string) ([]retention.Report, error) {
+	val, ok := annotations[util.KeyRetentionDryRunReport]
+	if !ok || val == "" {
+		return nil, nil
+	}
+	var reports []retention.Report
+	if err := json.Unmarshal([]byte(val), &reports); err != nil {
+		return nil, fmt.Errorf("invalid value for annotation %q. Reason: %v", util.KeyRetentionDryRunReport, err)
+	}
+	return reports, nil
+}
+
+// upsertRetentionReports inserts or updates the reports of the hosts in the annotations.
+// The jobs of the different targets of a BackupSession record their reports in the same annotation.
+func upsertRetentionReports(annotations map[string]string, reports []retention.Report) map[string]string {
+	entries, _ := RetentionReportsFromAnnotations(annotations)
+	for _, report := range reports {
+		found := false
+		for i := range entries {
+			if entries[i].Hostname == report.Hostname {
+				entries[i] = report
+				found = true
+				break
+			}
+		}
+		if !found {
+			entries = append(entries, report)
+		}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return annotations
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[util.KeyRetentionDryRunReport] = string(data)
+	return annotations
+}
+
+// cleanupGroupSnapshots deletes the VolumeGroupSnapshots whose VolumeSnapshots are all removed by the retention policy.
+// A group is kept as long as any of its VolumeSnapshots is kept, so that the PVCs can always be restored from the same instant.
+func cleanupGroupSnapshots(vsList []vsapi.VolumeSnapshot, reports []retention.Report, namespace string, vsClient vscs.Interface) error {
+	removed := make(map[string]bool)
+	for _, report := range reports {
+		for _, d := range report.Remove {
+			removed[d.Snapshot] = true
+		}
+	}
+
+	groups := make(map[string]bool)
+	for _, vs := range vsList {
+		group := vs.Labels[LabelVolumeGroupSnapshot]
+		if group == "" {
+			continue
+		}
+		if _, found := groups[group]; !found {
+			groups[group] = true
+		}
+		groups[group] = groups[group] && removed[vs.Name]
+	}
+
+	for group, remove := range groups {
+		if !remove {
+			continue
+		}
+		err := vsClient.GroupsnapshotV1alpha1().VolumeGroupSnapshots(namespace).Delete(context.TODO(), group, metav1.DeleteOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+		klog.Infof("VolumeGroupSnapshot %s/%s removed", namespace, group)
+	}
 	return nil
 }
@@ -24,7 +24,9 @@ import (
 
 	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/retention"
 
+	vgsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumegroupsnapshot/v1alpha1"
 	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
 	vsfake "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned/fake"
 	type_util "gomodules.xyz/pointer"
@@ -38,11 +40,13 @@ type snapInfo struct {
 	name         string
 	creationTime string
 	pvcName      string
+	group        string
 }
 
 type testInfo struct {
 	description       string
 	policy            v1alpha1.RetentionPolicy
+	within            retention.KeepWithin
 	hostBackupStats   []v1beta1.HostBackupStats
 	expectedSnapshots []string
 }
@@ -123,6 +127,77 @@ func TestCleanupSnapshots(t *testing.T) {
 		},
 	}
 
+	runCleanupSnapshotsTests(t, snapMeta, testCases)
+}
+
+func TestCleanupSnapshotsKeepWithin(t *testing.T) {
+	snapMeta := []snapInfo{
+		{name: "snap-1", creationTime: "2020-03-20T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-2", creationTime: "2020-03-20T08:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-3", creationTime: "2020-03-15T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-4", creationTime: "2020-03-07T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-5", creationTime: "2020-03-05T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-6", creationTime: "2020-02-20T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-7", creationTime: "2020-02-10T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-8", creationTime: "2020-01-15T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-9", creationTime: "2019-12-15T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-10", creationTime: "2019-03-10T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-11", creationTime: "2020-03-01T10:00:00Z", pvcName: "pvc-2"},
+		{name: "snap-12", creationTime: "2020-02-25T10:00:00Z", pvcName: "pvc-2"},
+		{name: "snap-13", creationTime: "2020-02-10T10:00:00Z", pvcName: "pvc-2"},
+	}
+
+	testCases := []testInfo{
+		{
+			description:       "KeepWithin",
+			within:            retention.KeepWithin{Within: retention.Duration{Days: 14}},
+			hostBackupStats:   []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}},
+			expectedSnapshots: []string{"snap-1", "snap-2", "snap-3", "snap-4", "snap-11", "snap-12"}, // durations are counted back from the latest snapshot of each claim
+		},
+		{
+			description:       "KeepWithinHourly",
+			within:            retention.KeepWithin{Hourly: retention.Duration{Days: 1}},
+			hostBackupStats:   []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}},
+			expectedSnapshots: []string{"snap-1", "snap-2", "snap-11"},
+		},
+		{
+			description:       "KeepWithinDaily",
+			within:            retention.KeepWithin{Daily: retention.Duration{Days: 14}},
+			hostBackupStats:   []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}},
+			expectedSnapshots: []string{"snap-1", "snap-3", "snap-4", "snap-11", "snap-12"},
+		},
+		{
+			description:       "KeepWithinWeekly",
+			within:            retention.KeepWithin{Weekly: retention.Duration{Months: 1}},
+			hostBackupStats:   []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}},
+			expectedSnapshots: []string{"snap-1", "snap-3", "snap-4", "snap-11", "snap-13"}, // snap-6 is taken exactly one month before the latest snapshot
+		},
+		{
+			description:       "KeepWithinMonthly",
+			within:            retention.KeepWithin{Monthly: retention.Duration{Years: 1}},
+			hostBackupStats:   []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}},
+			expectedSnapshots: []string{"snap-1", "snap-6", "snap-8", "snap-9", "snap-11", "snap-12"},
+		},
+		{
+			description:       "KeepLast & KeepWithinYearly",
+			policy:            v1alpha1.RetentionPolicy{KeepLast: 2},
+			within:            retention.KeepWithin{Yearly: retention.Duration{Years: 2}},
+			hostBackupStats:   []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}},
+			expectedSnapshots: []string{"snap-1", "snap-2", "snap-9", "snap-11", "snap-12"},
+		},
+		{
+			description:       "KeepWithin & KeepMonthly",
+			policy:            v1alpha1.RetentionPolicy{KeepMonthly: 12},
+			within:            retention.KeepWithin{Within: retention.Duration{Days: 14}},
+			hostBackupStats:   []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}},
+			expectedSnapshots: []string{"snap-1", "snap-2", "snap-3", "snap-4", "snap-6", "snap-8", "snap-9", "snap-10", "snap-11", "snap-12"}, // should keep everything from the last 14 days plus the monthly snapshots
+		},
+	}
+
+	runCleanupSnapshotsTests(t, snapMeta, testCases)
+}
+
+func runCleanupSnapshotsTests(t *testing.T, snapMeta []snapInfo, testCases []testInfo) {
 	for _, test := range testCases {
 		t.Run(test.description, func(t *testing.T) {
 			volumeSnasphots, err := getVolumeSnapshots(snapMeta)
@@ -148,7 +223,7 @@ func TestCleanupSnapshots(t *testing.T) {
 					},
 				},
 			}
-			err = CleanupSnapshots(test.policy, test.hostBackupStats, testNamespace, vsClient)
+			_, err = CleanupSnapshots(test.policy, test.within, test.hostBackupStats, testNamespace, vsClient)
 			if err != nil {
 				t.Errorf("Failed to cleanup VolumeSnapshots. Reason: %v", err)
 				return
@@ -181,6 +256,245 @@ func TestCleanupSnapshots(t *testing.T) {
 	}
 }
 
+func TestCleanupSnapshotsDryRun(t *testing.T) {
+	snapMeta := []snapInfo{
+		{name: "snap-1", creationTime: "2020-03-20T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-2", creationTime: "2020-03-20T08:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-3", creationTime: "2020-03-01T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-4", creationTime: "2020-02-10T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-5", creationTime: "2020-03-01T10:00:00Z", pvcName: "pvc-2"},
+	}
+	policy := v1alpha1.RetentionPolicy{KeepLast: 1, KeepMonthly: 2, DryRun: true}
+	within := retention.KeepWithin{Within: retention.Duration{Days: 7}}
+
+	volumeSnasphots, err := getVolumeSnapshots(snapMeta)
+	if err != nil {
+		t.Fatalf("Failed to generate VolumeSnasphots. Reason: %v", err)
+	}
+	vsClient := vsfake.NewSimpleClientset(volumeSnasphots...)
+	reports, err := CleanupSnapshots(policy, within, []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}}, testNamespace, vsClient)
+	if err != nil {
+		t.Fatalf("Failed to evaluate retention policy. Reason: %v", err)
+	}
+
+	vsList, err := vsClient.SnapshotV1().VolumeSnapshots(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list remaining VolumeSnapshots. Reason: %v", err)
+	}
+	if len(vsList.Items) != len(snapMeta) {
+		t.Errorf("Dry run should not delete any VolumeSnapshot. Expected: %d Found: %d", len(snapMeta), len(vsList.Items))
+	}
+
+	expected := []struct {
+		hostname string
+		keep     map[string][]string
+		remove   []string
+	}{
+		{
+			hostname: "pvc-1",
+			keep: map[string][]string{
+				"snap-1": {"last snapshot", "monthly snapshot", "within 7d"},
+				"snap-2": {"within 7d"},
+				"snap-4": {"monthly snapshot"},
+			},
+			remove: []string{"snap-3"},
+		},
+		{
+			hostname: "pvc-2",
+			keep: map[string][]string{
+				"snap-5": {"last snapshot", "monthly snapshot", "within 7d"},
+			},
+		},
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("Expected %d reports but found %d", len(expected), len(reports))
+	}
+	for i, want := range expected {
+		got := reports[i]
+		if got.Hostname != want.hostname {
+			t.Errorf("Expected report of %s but found %s", want.hostname, got.Hostname)
+			continue
+		}
+		if len(got.Keep) != len(want.keep) {
+			t.Errorf("%s: expected to keep %d VolumeSnapshots but found %d", want.hostname, len(want.keep), len(got.Keep))
+		}
+		for _, d := range got.Keep {
+			reasons, ok := want.keep[d.Snapshot]
+			if !ok {
+				t.Errorf("%s: VolumeSnapshot %s should be removed according to the retention-policy", want.hostname, d.Snapshot)
+				continue
+			}
+			if fmt.Sprint(d.Reasons) != fmt.Sprint(reasons) {
+				t.Errorf("%s: expected reasons %q for VolumeSnapshot %s but found %q", want.hostname, reasons, d.Snapshot, d.Reasons)
+			}
+		}
+		if len(got.Remove) != len(want.remove) {
+			t.Errorf("%s: expected to remove %d VolumeSnapshots but found %d", want.hostname, len(want.remove), len(got.Remove))
+		}
+		for _, d := range got.Remove {
+			if !strings.Contains(want.remove, d.Snapshot) {
+				t.Errorf("%s: VolumeSnapshot %s should be kept according to the retention-policy", want.hostname, d.Snapshot)
+			}
+		}
+	}
+}
+
+func TestEvaluateRetentionPolicy(t *testing.T) {
+	snapMeta := []snapInfo{
+		{name: "snap-1", creationTime: "2020-03-20T10:00:00Z", pvcName: "pvc-2"},
+		{name: "snap-2", creationTime: "2020-03-19T10:00:00Z", pvcName: "pvc-2"},
+		{name: "snap-3", creationTime: "2020-03-20T10:00:00Z", pvcName: "pvc-1"},
+		{name: "snap-4", creationTime: "2020-03-19T10:00:00Z", pvcName: "pvc-1"},
+	}
+	// the policy is not a dry run, yet nothing should be removed
+	policy := v1alpha1.RetentionPolicy{KeepLast: 1}
+
+	cases := []struct {
+		name     string
+		pvcs     []string
+		expected map[string][]string
+	}{
+		{
+			name: "all PVCs",
+			expected: map[string][]string{
+				"pvc-1": {"snap-4"},
+				"pvc-2": {"snap-2"},
+			},
+		},
+		{
+			name: "selected PVC",
+			pvcs: []string{"pvc-2"},
+			expected: map[string][]string{
+				"pvc-2": {"snap-2"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			volumeSnasphots, err := getVolumeSnapshots(snapMeta)
+			if err != nil {
+				t.Fatalf("Failed to generate VolumeSnasphots. Reason: %v", err)
+			}
+			vsClient := vsfake.NewSimpleClientset(volumeSnasphots...)
+			reports, err := EvaluateRetentionPolicy(policy, retention.KeepWithin{}, c.pvcs, testNamespace, vsClient)
+			if err != nil {
+				t.Fatalf("Failed to evaluate retention policy. Reason: %v", err)
+			}
+
+			vsList, err := vsClient.SnapshotV1().VolumeSnapshots(testNamespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("Failed to list remaining VolumeSnapshots. Reason: %v", err)
+			}
+			if len(vsList.Items) != len(snapMeta) {
+				t.Errorf("expected %d VolumeSnapshots to remain, found %d", len(snapMeta), len(vsList.Items))
+			}
+
+			if len(reports) != len(c.expected) {
+				t.Fatalf("expected %d reports, found %d", len(c.expected), len(reports))
+			}
+			for i := 1; i < len(reports); i++ {
+				if reports[i-1].Hostname > reports[i].Hostname {
+					t.Errorf("expected the reports to be sorted by PVC, found %s before %s", reports[i-1].Hostname, reports[i].Hostname)
+				}
+			}
+			for _, report := range reports {
+				remove := make([]string, 0, len(report.Remove))
+				for _, d := range report.Remove {
+					remove = append(remove, d.Snapshot)
+				}
+				if fmt.Sprint(remove) != fmt.Sprint(c.expected[report.Hostname]) {
+					t.Errorf("%s: expected to remove %v, found %v", report.Hostname, c.expected[report.Hostname], remove)
+				}
+			}
+		})
+	}
+}
+
+func TestUpsertRetentionReports(t *testing.T) {
+	annotations := upsertRetentionReports(nil, []retention.Report{{Hostname: "pvc-1", Remove: []retention.Decision{{Snapshot: "snap-1"}}}})
+	annotations = upsertRetentionReports(annotations, []retention.Report{{Hostname: "pvc-2"}, {Hostname: "pvc-1"}})
+	reports, err := RetentionReportsFromAnnotations(annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Hostname != "pvc-1" || len(reports[0].Remove) != 0 || reports[1].Hostname != "pvc-2" {
+		t.Errorf("unexpected retention reports %+v", reports)
+	}
+}
+
+func TestCleanupSnapshotsGroup(t *testing.T) {
+	snapMeta := []snapInfo{
+		{name: "snap-1", creationTime: "2020-03-20T10:00:00Z", pvcName: "pvc-1", group: "group-2"},
+		{name: "snap-2", creationTime: "2020-03-20T10:00:00Z", pvcName: "pvc-2", group: "group-2"},
+		{name: "snap-3", creationTime: "2020-03-19T10:00:00Z", pvcName: "pvc-1", group: "group-1"},
+		{name: "snap-4", creationTime: "2020-03-19T10:00:00Z", pvcName: "pvc-2", group: "group-1"},
+		{name: "snap-5", creationTime: "2020-03-18T10:00:00Z", pvcName: "pvc-1"},
+	}
+
+	testCases := []struct {
+		description     string
+		hostBackupStats []v1beta1.HostBackupStats
+		expectedGroups  []string
+	}{
+		{
+			description:     "All members removed",
+			hostBackupStats: []v1beta1.HostBackupStats{{Hostname: "pvc-1"}, {Hostname: "pvc-2"}},
+			expectedGroups:  []string{"group-2"},
+		},
+		{
+			description:     "Some members kept",
+			hostBackupStats: []v1beta1.HostBackupStats{{Hostname: "pvc-1"}},
+			expectedGroups:  []string{"group-1", "group-2"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.description, func(t *testing.T) {
+			objects, err := getVolumeSnapshots(snapMeta)
+			if err != nil {
+				t.Fatalf("Failed to generate VolumeSnasphots. Reason: %v", err)
+			}
+			for _, group := range []string{"group-1", "group-2"} {
+				objects = append(objects, &vgsapi.VolumeGroupSnapshot{
+					ObjectMeta: metav1.ObjectMeta{Name: group, Namespace: testNamespace},
+				})
+			}
+			vsClient := vsfake.NewSimpleClientset(objects...)
+			_, err = CleanupSnapshots(v1alpha1.RetentionPolicy{KeepLast: 1}, retention.KeepWithin{}, test.hostBackupStats, testNamespace, vsClient)
+			if err != nil {
+				t.Fatalf("Failed to cleanup VolumeSnapshots. Reason: %v", err)
+			}
+
+			// the members of a group must be deleted along with the group only
+			vsList, err := vsClient.SnapshotV1().VolumeSnapshots(testNamespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("Failed to list remaining VolumeSnapshots. Reason: %v", err)
+			}
+			for _, vs := range vsList.Items {
+				if vs.Name == "snap-5" {
+					t.Errorf("VolumeSnapshot snap-5 should be deleted according to retention-policy")
+				}
+			}
+			if len(vsList.Items) != 4 {
+				t.Errorf("Expected 4 remaining VolumeSnapshots but found %d", len(vsList.Items))
+			}
+
+			groups, err := vsClient.GroupsnapshotV1alpha1().VolumeGroupSnapshots(testNamespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("Failed to list remaining VolumeGroupSnapshots. Reason: %v", err)
+			}
+			if len(groups.Items) != len(test.expectedGroups) {
+				t.Fatalf("Expected %d remaining VolumeGroupSnapshots but found %d", len(test.expectedGroups), len(groups.Items))
+			}
+			for _, g := range groups.Items {
+				if !strings.Contains(test.expectedGroups, g.Name) {
+					t.Errorf("VolumeGroupSnapshot %s should be deleted according to retention-policy", g.Name)
+				}
+			}
+		})
+	}
+}
+
 func getVolumeSnapshots(snapMetas []snapInfo) ([]runtime.Object, error) {
 	snapshots := make([]runtime.Object, 0)
 	for i := range snapMetas {
@@ -201,7 +515,7 @@ func newSnapshot(snapMeta snapInfo) (*vsapi.VolumeSnapshot, error) {
 	}
 
 	snapshotContentName := fmt.Sprintf("snapshot-content-%s", snapMeta.name)
-	return &vsapi.VolumeSnapshot{
+	snapshot := &vsapi.VolumeSnapshot{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              snapMeta.name,
 			Namespace:         testNamespace,
@@ -221,5 +535,14 @@ func newSnapshot(snapMeta snapInfo) (*vsapi.VolumeSnapshot, error) {
 			ReadyToUse: type_util.TrueP(),
 			Error:      nil,
 		},
-	}, nil
+	}
+	if snapMeta.group != "" {
+		// the VolumeSnapshots of a group refer to their content only
+		snapshot.Spec.Source.PersistentVolumeClaimName = nil
+		snapshot.Labels = map[string]string{
+			LabelVolumeGroupSnapshot: snapMeta.group,
+			LabelSourcePVC:           snapMeta.pvcName,
+		}
+	}
+	return snapshot, nil
 }